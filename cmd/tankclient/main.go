@@ -0,0 +1,149 @@
+// tankclient 在没有窗口的环境中运行一个连接专用服务器的客户端，输入随机生成，
+// 用于测试服务器、增量快照和断线重连：
+//
+//	go run ./cmd/tankserver &
+//	go run ./cmd/tankclient -room test -duration 20s &
+//	go run ./cmd/tankclient -room test -duration 20s -drop 5s
+//
+// 结束时打印收到的快照数、增量编码前后的字节数和重新连接的次数。
+//
+// -spectate 以观战者身份加入已经存在的房间，-stream 把收到的快照写入观战流文件，
+// -watch 读取观战流文件并打印其中的内容：
+//
+//	go run ./cmd/tankclient -room test -spectate -duration 10s -stream test.tnkv
+//	go run ./cmd/tankclient -watch test.tnkv
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/server"
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+// randomInput 随机生成玩家的输入，每隔一段时间随机更换方向，模拟玩家的操作
+type randomInput struct {
+	rng   *rand.Rand
+	input sim.Input
+}
+
+func (r *randomInput) next() sim.Input {
+	if r.rng.Intn(20) == 0 {
+		r.input = sim.Input(1 << r.rng.Intn(4))
+	}
+	in := r.input
+	if r.rng.Intn(10) == 0 {
+		in |= sim.InputFire
+	}
+	return in
+}
+
+func main() {
+	addr := flag.String("addr", fmt.Sprintf("127.0.0.1:%d", server.DefaultPort), "服务器地址")
+	room := flag.String("room", "default", "加入的房间")
+	name := flag.String("name", "bot", "玩家名称")
+	duration := flag.Duration("duration", 10*time.Second, "运行的时间")
+	drop := flag.Duration("drop", 0, "运行这段时间后断开连接一次以测试重连，0 表示不断开")
+	spectate := flag.Bool("spectate", false, "以观战者身份加入")
+	streamFile := flag.String("stream", "", "把收到的快照写入观战流文件")
+	watch := flag.String("watch", "", "读取观战流文件并打印其中的内容，不连接服务器")
+	flag.Parse()
+
+	if *watch != "" {
+		if err := printStream(*watch); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var c *server.Client
+	var err error
+	if *spectate {
+		c, err = server.Spectate(*addr, *name, *room)
+	} else {
+		c, err = server.Dial(*addr, *name, *room)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *spectate {
+		log.Printf("watching room %q", c.Room())
+	} else {
+		log.Printf("joined room %q as player %d", c.Room(), c.Slot()+1)
+	}
+	if *streamFile != "" {
+		w, err := server.CreateStream(*streamFile, c.Room(), c.Config())
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.Record(w)
+		defer func() {
+			c.Record(nil)
+			if err := w.Close(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	input := &randomInput{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	start := time.Now()
+	dropped := *drop <= 0
+	ticker := time.NewTicker(time.Second / sim.TicksPerSecond)
+	defer ticker.Stop()
+	var last *server.State
+	for range ticker.C {
+		if err := c.Err(); err != nil {
+			log.Fatal(err)
+		}
+		elapsed := time.Since(start)
+		if elapsed >= *duration {
+			break
+		}
+		if !dropped && elapsed >= *drop {
+			log.Print("dropping the connection")
+			c.Drop()
+			dropped = true
+		}
+		if !*spectate {
+			c.SendInput(input.next())
+		}
+		if s := c.State(); s != nil {
+			last = s
+		}
+	}
+
+	stats := c.Stats()
+	c.Close()
+	if last == nil {
+		log.Fatal("no snapshot received")
+	}
+	for i, p := range last.Players {
+		if *spectate || i == c.Slot() {
+			fmt.Printf("player %d: stage %d, tick %d, lives %d, score %d\n", i+1, last.Stage+1, last.Tick, p.Lives, p.Score)
+		}
+	}
+	fmt.Printf("snapshots: %d, bytes: %d, without delta: %d, reconnects: %d\n",
+		stats.Snapshots, stats.Bytes, stats.FullBytes, stats.Reconnects)
+	if *drop > 0 && stats.Reconnects == 0 {
+		log.Fatal("did not reconnect")
+	}
+}
+
+// printStream 打印观战流文件中的快照数、帧数范围和最后的状态
+func printStream(path string) error {
+	s, err := server.LoadStream(path)
+	if err != nil {
+		return err
+	}
+	last := s.States[len(s.States)-1]
+	fmt.Printf("%s: room %q, %d players, %d snapshots, ticks %d-%d, stage %d\n",
+		path, s.Room, s.Config.Players, len(s.States), s.FirstTick(), s.LastTick(), last.Stage+1)
+	for i, p := range last.Players {
+		fmt.Printf("player %d: lives %d, score %d\n", i+1, p.Lives, p.Score)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+// tanknet 在没有窗口的环境中运行一名局域网对战玩家，输入随机生成，用于在
+// 一台机器上通过回环地址测试锁步同步：
+//
+//	go run ./cmd/tanknet -host -players 3 &
+//	go run ./cmd/tanknet -join 127.0.0.1:7777 &
+//	go run ./cmd/tanknet -discover 127.255.255.255:7777
+//
+// 每个实例结束时打印最终的帧数和状态哈希，检测到不同步时以状态码 1 退出。
+//
+// 双人对战的回滚同步可以通过本地的延迟代理测试，代理对每个方向的报文增加
+// 延迟和抖动并随机丢包，结束时会打印回滚的统计数据：
+//
+//	go run ./cmd/tanknet -host -rollback -ticks 1200 &
+//	go run ./cmd/tanknet -join 127.0.0.1:7777 -ticks 1200 -lag 50ms -jitter 20ms -loss 0.05
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/netplay"
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+// lingerTime 对局结束后继续收发报文的时间，让其他玩家收到最后的输入和哈希
+const lingerTime = time.Second
+
+// openLobby 根据命令行参数创建主机大厅、加入指定主机或查找局域网中的主机
+func openLobby(host bool, port int, join, discover, name string, players int) (*netplay.Lobby, error) {
+	switch {
+	case host:
+		return netplay.HostLobby(fmt.Sprintf(":%d", port), name, players)
+	case join != "":
+		return netplay.JoinLobby(join, name)
+	case discover != "":
+		hosts, err := netplay.Discover(discover, time.Second)
+		if err != nil {
+			return nil, err
+		}
+		if len(hosts) == 0 {
+			return nil, errors.New("no host found")
+		}
+		log.Printf("found host %s at %s (%d/%d)", hosts[0].Name, hosts[0].Addr, hosts[0].Players, hosts[0].MaxPlayers)
+		return netplay.JoinLobby(hosts[0].Addr.String(), name)
+	}
+	return nil, errors.New("one of -host, -join or -discover is required")
+}
+
+// waitStart 在大厅中准备，客户端等待对局开始，主机等到人数到齐且都准备好
+func waitStart(l *netplay.Lobby, players int) error {
+	for {
+		if err := l.Poll(); err != nil {
+			return err
+		}
+		if l.Session() != nil || l.Rollback() != nil {
+			return nil
+		}
+		if l.Slot() >= 0 && !l.Ready() {
+			l.SetReady(true)
+		}
+		if l.IsHost() && len(l.Players()) == players && l.AllReady() {
+			return nil
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// match 锁步同步和回滚同步的对局共有的方法
+type match interface {
+	Poll() error
+	Close()
+}
+
+// randomInput 随机生成玩家的输入，每隔一段时间随机更换方向，模拟玩家的操作
+type randomInput struct {
+	rng   *rand.Rand
+	input sim.Input
+}
+
+func (r *randomInput) next() sim.Input {
+	if r.rng.Intn(20) == 0 {
+		r.input = sim.Input(1 << r.rng.Intn(4))
+	}
+	in := r.input
+	if r.rng.Intn(10) == 0 {
+		in |= sim.InputFire
+	}
+	return in
+}
+
+// runLockstep 以锁步同步尽快模拟到 maxTicks 帧或对局结束
+func runLockstep(s *netplay.Session, w *sim.World, maxTicks, desyncAt int) {
+	input := &randomInput{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for s.Tick() < maxTicks && !w.Finished() {
+		if err := s.Poll(); err != nil {
+			abort(s, err)
+		}
+		s.AddInput(input.next())
+
+		inputs, ok := s.Next()
+		if !ok {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		w.Step(inputs)
+		if s.Tick() == desyncAt {
+			w.Players[s.Slot()].Score++
+		}
+		if s.Tick()%netplay.HashInterval == 0 {
+			s.ReportHash(s.Tick(), w.Hash())
+		}
+	}
+	s.ReportHash(s.Tick(), w.Hash())
+}
+
+// runRollback 以回滚同步按正常速度模拟到 maxTicks 帧或对局结束，并等待结果被确认
+func runRollback(r *netplay.Rollback, w *sim.World, maxTicks, delay, desyncAt int) {
+	input := &randomInput{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	ticker := time.NewTicker(time.Second / sim.TicksPerSecond)
+	defer ticker.Stop()
+
+	// 本地输入从第 delay 帧开始，只提交到第 maxTicks 帧为止，双方最终停在同一帧；
+	// 本地输入已经领先延迟帧数时 AddInput 会丢弃输入，这时不提交
+	added := delay
+	for r.Tick() < maxTicks && !w.Finished() || !r.Confirmed() {
+		<-ticker.C
+		if err := r.Poll(); err != nil {
+			abort(r, err)
+		}
+		if added < maxTicks && added <= r.Tick()+delay {
+			r.AddInput(input.next())
+			added++
+		}
+		r.Advance(w)
+		// 回滚可能撤销单次修改，因此之后的每一帧都修改
+		if desyncAt >= 0 && r.Tick() >= desyncAt {
+			w.Players[r.Slot()].Score++
+		}
+	}
+
+	st := r.Stats()
+	log.Printf("rollbacks %d, resimulated %d, max depth %d, stalls %d", st.Rollbacks, st.Resimulated, st.MaxDepth, st.Stalls)
+}
+
+// linger 对局结束或出错后继续收发一段时间，让其他玩家收到最后的输入、哈希和不同步通知，
+// 返回这段时间内检测到的不同步
+func linger(s match) error {
+	var desync *netplay.DesyncError
+	for end := time.Now().Add(lingerTime); time.Now().Before(end); time.Sleep(5 * time.Millisecond) {
+		if err := s.Poll(); desync == nil {
+			errors.As(err, &desync)
+		}
+	}
+	if desync != nil {
+		return desync
+	}
+	return nil
+}
+
+// abort 通知其他玩家后关闭连接并退出
+func abort(s match, err error) {
+	linger(s)
+	s.Close()
+	log.Fatal(err)
+}
+
+func main() {
+	host := flag.Bool("host", false, "创建主机大厅")
+	port := flag.Int("port", netplay.DefaultPort, "主机监听的 UDP 端口")
+	join := flag.String("join", "", "加入指定地址的主机")
+	discover := flag.String("discover", "", "向指定的广播地址查找主机并加入第一个")
+	name := flag.String("name", "", "玩家名称，为空时使用进程号")
+	players := flag.Int("players", 2, "主机等待的玩家人数")
+	seed := flag.Int64("seed", 1, "主机选定的随机种子")
+	delay := flag.Int("delay", -1, "输入延迟帧数，-1 表示使用同步方式的默认值")
+	maxTicks := flag.Int("ticks", 60*sim.TicksPerSecond, "最多模拟的帧数")
+	levelFile := flag.String("level", "", "关卡文件，为空时使用默认关卡")
+	desyncAt := flag.Int("desync-at", -1, "在指定帧故意修改本地状态，用于测试不同步检测")
+	rollback := flag.Bool("rollback", false, "主机使用回滚同步开始双人对局")
+	lag := flag.Duration("lag", 0, "加入主机时经过本地代理，为每个方向的报文增加的延迟")
+	jitter := flag.Duration("jitter", 0, "代理在延迟上随机增加的最大抖动")
+	loss := flag.Float64("loss", 0, "代理的丢包率")
+	flag.Parse()
+
+	if *name == "" {
+		*name = fmt.Sprintf("tanknet-%d", os.Getpid())
+	}
+	if *delay < 0 {
+		*delay = netplay.DefaultDelay
+		if *rollback {
+			*delay = netplay.DefaultRollbackDelay
+		}
+	}
+	if *rollback {
+		*players = 2
+	}
+
+	level := sim.DefaultLevel()
+	if *levelFile != "" {
+		var err error
+		if level, err = sim.LoadLevel(*levelFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *join != "" && (*lag > 0 || *jitter > 0 || *loss > 0) {
+		proxy, err := netplay.NewProxy("127.0.0.1:0", *join, *lag, *jitter, *loss)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer proxy.Close()
+		*join = proxy.Addr().String()
+	}
+
+	lobby, err := openLobby(*host, *port, *join, *discover, *name, *players)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := waitStart(lobby, *players); err != nil {
+		log.Fatal(err)
+	}
+
+	s, r := lobby.Session(), lobby.Rollback()
+	if lobby.IsHost() {
+		if *rollback {
+			r, err = lobby.StartRollback(*seed, sim.DefaultConfig(), "tanknet", *delay)
+		} else {
+			s, err = lobby.Start(*seed, sim.DefaultConfig(), "tanknet", *delay)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var m match
+	var w *sim.World
+	var slot, tick int
+	if r != nil {
+		log.Printf("rollback match started: slot %d, seed %d, delay %d", r.Slot(), r.Seed(), r.Stats().Delay)
+		w = sim.NewLevelWorld(r.Seed(), r.Config(), level)
+		runRollback(r, w, *maxTicks, r.Stats().Delay, *desyncAt)
+		m, slot, tick = r, r.Slot(), r.Tick()
+	} else {
+		log.Printf("match started: slot %d of %d, seed %d", s.Slot(), s.Players(), s.Seed())
+		w = sim.NewLevelWorld(s.Seed(), s.Config(), level)
+		runLockstep(s, w, *maxTicks, *desyncAt)
+		m, slot, tick = s, s.Slot(), s.Tick()
+	}
+
+	if err := linger(m); err != nil {
+		abort(m, err)
+	}
+	m.Close()
+
+	fmt.Printf("slot %d: ticks %d, result %d, hash %016x\n", slot, tick, w.Result, w.Hash())
+}
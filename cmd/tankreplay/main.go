@@ -0,0 +1,47 @@
+// tankreplay 在没有窗口的环境中重新模拟录像文件，并校验最终状态哈希：
+//
+//	go run ./cmd/tankreplay match.tnkr
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/replay"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s replay-file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		r, err := replay.Load(path)
+		if err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			failed = true
+			continue
+		}
+
+		got, ok := r.Verify()
+		if ok {
+			fmt.Printf("%s: OK (seed %d, %d frames, hash %016x)\n", path, r.Seed, len(r.Frames), got)
+		} else {
+			fmt.Printf("%s: MISMATCH (seed %d, %d frames, want %016x, got %016x)\n", path, r.Seed, len(r.Frames), r.FinalHash, got)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
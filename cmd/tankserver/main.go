@@ -0,0 +1,119 @@
+// tankserver 运行专用服务器：在没有窗口的环境中运行权威的模拟，客户端通过
+// TCP 加入房间，房间在第一名玩家加入时创建，所有玩家离开一段时间后关闭：
+//
+//	go run ./cmd/tankserver -addr :7778 -players 2
+//
+// 游戏以 -server 参数连接到服务器，也可以用 tankclient 运行无窗口的测试客户端。
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/server"
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+// loadCampaign 加载战役。指定了关卡文件时只包含这一关，
+// 战役文件不存在时使用默认战役。
+func loadCampaign(campaignFile, levelFile string) (*sim.Campaign, error) {
+	if levelFile != "" {
+		level, err := sim.LoadLevel(levelFile)
+		if err != nil {
+			return nil, err
+		}
+		return &sim.Campaign{Name: levelFile, Stages: []*sim.Level{level}}, nil
+	}
+
+	campaign, err := sim.LoadCampaign(campaignFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return sim.DefaultCampaign(), nil
+	}
+	return campaign, err
+}
+
+// loadEnemyTypes 从文件中加载敌方坦克的种类并用于战役的每一关，
+// 文件不存在时使用内置的种类
+func loadEnemyTypes(campaign *sim.Campaign, path string) error {
+	types, err := sim.LoadEnemyTypes(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return campaign.SetEnemyTypes(types)
+}
+
+func main() {
+	addr := flag.String("addr", fmt.Sprintf(":%d", server.DefaultPort), "监听的 TCP 地址")
+	campaignFile := flag.String("campaign", "levels/campaign.txt", "战役文件")
+	levelFile := flag.String("level", "", "只进行指定的关卡文件")
+	enemiesFile := flag.String("enemies", "levels/enemies.txt", "敌方坦克种类的定义文件，不存在时使用内置的种类")
+	players := flag.Int("players", 2, "每个房间的玩家人数")
+	maxRooms := flag.Int("max-rooms", 16, "同时存在的最多房间数，0 表示不限制")
+	friendlyFire := flag.Bool("friendly-fire", false, "玩家的子弹是否会伤害队友")
+	difficulty := flag.String("difficulty", "normal", "难度：easy、normal、hard 或 nightmare")
+	movement := flag.String("movement", "classic", "玩家坦克的移动方式：classic、eight 或 analog")
+	status := flag.Duration("status", time.Minute, "打印房间状态的间隔，0 表示不打印")
+	flag.Parse()
+
+	if *players < 1 || *players > sim.MaxPlayers {
+		log.Fatalf("players must be between 1 and %d", sim.MaxPlayers)
+	}
+	campaign, err := loadCampaign(*campaignFile, *levelFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := loadEnemyTypes(campaign, *enemiesFile); err != nil {
+		log.Fatal(err)
+	}
+	d, err := sim.ParseDifficulty(*difficulty)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg := d.Apply(sim.DefaultConfig())
+	if cfg.Movement, err = sim.ParseMovementMode(*movement); err != nil {
+		log.Fatal(err)
+	}
+	cfg.Players = *players
+	cfg.FriendlyFire = *friendlyFire
+	srv := server.NewServer(campaign, cfg)
+	srv.MaxRooms = *maxRooms
+	srv.Logf = log.Printf
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("serving %q on %s, %d players per room", campaign.Name, ln.Addr(), *players)
+
+	if *status > 0 {
+		go func() {
+			for range time.Tick(*status) {
+				for _, r := range srv.Rooms() {
+					log.Printf("room %q: stage %d, tick %d, players %d/%d (%d online)",
+						r.Name, r.Stage+1, r.Tick, r.Players, r.MaxPlayers, r.Online)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt)
+		<-stop
+		srv.Close()
+	}()
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, server.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
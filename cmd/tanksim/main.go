@@ -0,0 +1,244 @@
+// tanksim 在没有窗口的环境中批量运行对局，用于持续集成中的浸泡测试：
+//
+//	go run -race ./cmd/tanksim -matches 1000 -workers 8
+//
+// 使用 -rollback-check 时每局同时运行一个不回滚的对照世界，主世界每隔几帧恢复
+// 之前的快照重新模拟，检查快照是否完整保存了世界状态。
+//
+// 使用 -enemies 时场上会很快布满指定数量的敌方坦克，用于检查寻路等开销
+// 是否仍在一帧的时间之内，结束时输出最慢的一帧的耗时：
+//
+//	go run ./cmd/tanksim -matches 10 -enemies 60
+//
+// 使用 -adaptive 时开启动态难度，并输出每一局中难度的调整记录。
+//
+// 使用 -enemy-types 时从文件中加载敌方坦克的种类，检查新加入的种类的强度：
+//
+//	go run ./cmd/tanksim -level levels/01.txt -enemy-types levels/enemies.txt
+//
+// 使用 -movement 时玩家坦克按八方向或自由模式移动，随机输入中会加入斜向移动、
+// 摇杆移动和瞄准的角度。
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+// result 表示一局模拟的结果
+type result struct {
+	seed  int64
+	ticks int
+	win   bool
+	lose  bool
+	// 回滚后与对照世界不一致的帧号，-1 表示一致
+	mismatch int
+	// 最慢的一帧模拟的耗时
+	slowest time.Duration
+	// 动态难度的调整记录
+	adapt []sim.AdaptChange
+}
+
+// crowdTable 返回每帧都会生成敌方坦克、直到场上有 enemies 辆的生成表
+func crowdTable(level *sim.Level, enemies int) sim.SpawnTable {
+	return sim.SpawnTable{{
+		Kind:     sim.SpawnEnemyTank,
+		MinDelay: 1,
+		MaxDelay: 1,
+		MaxAlive: enemies,
+		Points:   level.EnemySpawns,
+	}}
+}
+
+// runMatch 使用随机输入在指定关卡上模拟一局游戏，最多运行 maxTicks 帧。
+// rollback 大于 0 时每隔 rollback 帧回滚 rollback 帧重新模拟，并与对照世界比较。
+// enemies 大于 0 时改用 crowdTable 生成敌方坦克。
+func runMatch(seed int64, cfg sim.Config, level *sim.Level, maxTicks, rollback, enemies int) result {
+	w := sim.NewLevelWorld(seed, cfg, level)
+	if enemies > 0 {
+		w.SetSpawnTable(crowdTable(level, enemies))
+	}
+	inputRng := rand.New(rand.NewSource(seed ^ 0x5eed))
+
+	var twin *sim.World
+	var snapshot sim.Snapshot
+	var history [][]sim.Input
+	mismatch := -1
+	if rollback > 0 {
+		twin = sim.NewLevelWorld(seed, cfg, level)
+		if enemies > 0 {
+			twin.SetSpawnTable(crowdTable(level, enemies))
+		}
+	}
+	var slowest time.Duration
+
+	inputs := make([]sim.Input, len(w.Players))
+	frame := make([]sim.Input, len(w.Players))
+	for w.Tick() < maxTicks && !w.Finished() {
+		for i := range inputs {
+			// 每隔一段时间随机更换方向，模拟玩家的操作
+			if w.Tick()%20 == 0 {
+				inputs[i] = sim.Input(1 << inputRng.Intn(4))
+				if cfg.Movement != sim.MoveClassic {
+					inputs[i] |= sim.Input(1 << inputRng.Intn(4))
+					inputs[i] = inputs[i].WithAim(sim.Angle(inputRng.Intn(256)))
+					if inputRng.Intn(2) == 0 {
+						inputs[i] = inputs[i].WithMove(sim.Angle(inputRng.Intn(256)))
+					}
+				}
+			}
+			frame[i] = inputs[i]
+			if inputRng.Intn(10) == 0 {
+				frame[i] |= sim.InputFire
+			}
+		}
+		if twin == nil {
+			start := time.Now()
+			w.Step(frame)
+			slowest = max(slowest, time.Since(start))
+			continue
+		}
+
+		if w.Tick()%rollback == 0 {
+			w.Save(&snapshot)
+			history = history[:0]
+		}
+		history = append(history, append([]sim.Input(nil), frame...))
+		w.Step(frame)
+		twin.Step(frame)
+		if len(history) == rollback || w.Finished() {
+			w.Restore(&snapshot)
+			for _, f := range history {
+				w.Step(f)
+			}
+		}
+		if mismatch < 0 && w.Hash() != twin.Hash() {
+			mismatch = w.Tick()
+		}
+	}
+
+	return result{
+		seed:     seed,
+		ticks:    w.Tick(),
+		win:      w.Result == sim.ResultWon,
+		lose:     w.Result == sim.ResultLost,
+		mismatch: mismatch,
+		slowest:  slowest,
+		adapt:    w.AdaptChanges(),
+	}
+}
+
+func main() {
+	matches := flag.Int("matches", 100, "模拟的对局数")
+	maxTicks := flag.Int("ticks", 60*sim.TicksPerSecond, "每局最多模拟的帧数")
+	workers := flag.Int("workers", 4, "并发运行的对局数")
+	seed := flag.Int64("seed", 1, "第一局的随机种子，之后每局递增")
+	levelFile := flag.String("level", "", "关卡文件，为空时使用默认关卡")
+	players := flag.Int("players", 1, "每局的玩家人数")
+	friendlyFire := flag.Bool("friendly-fire", false, "玩家的子弹是否会伤害队友")
+	rollback := flag.Int("rollback-check", 0, "每隔多少帧回滚重新模拟并与对照世界比较，0 表示不检查")
+	enemies := flag.Int("enemies", 0, "场上同时存在的敌方坦克数，0 表示按关卡的生成表")
+	enemyTypes := flag.String("enemy-types", "", "敌方坦克种类的定义文件，为空时使用内置的种类")
+	difficulty := flag.String("difficulty", "normal", "难度：easy、normal、hard 或 nightmare")
+	adaptive := flag.Bool("adaptive", false, "开启动态难度并输出调整记录")
+	movement := flag.String("movement", "classic", "玩家坦克的移动方式：classic、eight 或 analog")
+	flag.Parse()
+
+	if *matches <= 0 || *workers <= 0 {
+		log.Fatal("matches and workers must be positive")
+	}
+	if *players < 1 || *players > sim.MaxPlayers {
+		log.Fatalf("players must be between 1 and %d", sim.MaxPlayers)
+	}
+
+	d, err := sim.ParseDifficulty(*difficulty)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := d.Apply(sim.DefaultConfig())
+	if cfg.Movement, err = sim.ParseMovementMode(*movement); err != nil {
+		log.Fatal(err)
+	}
+	cfg.Adaptive = *adaptive
+	cfg.Players = *players
+	cfg.FriendlyFire = *friendlyFire
+	if *enemies > 0 {
+		cfg.MaxEnemyTankCount = *enemies
+	}
+
+	level := sim.DefaultLevel()
+	if *levelFile != "" {
+		if level, err = sim.LoadLevel(*levelFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *enemyTypes != "" {
+		types, err := sim.LoadEnemyTypes(*enemyTypes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := level.SetEnemyTypes(types); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	seeds := make(chan int64)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range seeds {
+				results <- runMatch(s, cfg, level, *maxTicks, *rollback, *enemies)
+			}
+		}()
+	}
+
+	go func() {
+		for i := 0; i < *matches; i++ {
+			seeds <- *seed + int64(i)
+		}
+		close(seeds)
+		wg.Wait()
+		close(results)
+	}()
+
+	var wins, losses, timeouts, totalTicks, mismatches int
+	var slowest time.Duration
+	for r := range results {
+		totalTicks += r.ticks
+		slowest = max(slowest, r.slowest)
+		for _, c := range r.adapt {
+			log.Printf("seed %d: %s", r.seed, c)
+		}
+		if r.mismatch >= 0 {
+			mismatches++
+			log.Printf("seed %d: state differs from the reference after rollback at tick %d", r.seed, r.mismatch)
+		}
+		switch {
+		case r.win:
+			wins++
+		case r.lose:
+			losses++
+		default:
+			timeouts++
+		}
+	}
+
+	fmt.Printf("matches: %d, wins: %d, losses: %d, timeouts: %d, ticks: %d\n",
+		*matches, wins, losses, timeouts, totalTicks)
+	if *rollback == 0 {
+		fmt.Printf("slowest tick: %v\n", slowest)
+	}
+	if mismatches > 0 {
+		log.Fatalf("%d matches differ after rollback", mismatches)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+// adaptLogFile 用户配置目录中记录动态难度调整的文件，供关卡设计者查看
+const adaptLogFile = "adaptive.log"
+
+// difficultyLabels 菜单中显示的难度名称
+var difficultyLabels = map[sim.Difficulty]string{
+	sim.DifficultyEasy:      "简单",
+	sim.DifficultyNormal:    "普通",
+	sim.DifficultyHard:      "困难",
+	sim.DifficultyNightmare: "噩梦",
+}
+
+// nextDifficulty 返回菜单中 d 之后的难度，最难之后回到最简单
+func nextDifficulty(d sim.Difficulty) sim.Difficulty {
+	all := sim.Difficulties()
+	for i, v := range all {
+		if v == d {
+			return all[(i+1)%len(all)]
+		}
+	}
+	return sim.DifficultyNormal
+}
+
+// ConfigureDifficulty 设置本地游戏和创建局域网主机时使用的难度以及是否开启动态难度
+func (g *Game) ConfigureDifficulty(d sim.Difficulty, adaptive bool) {
+	g.difficulty = d
+	g.adaptive = adaptive
+}
+
+// logAdaptChanges 将本关新发生的动态难度调整追加到日志文件中。
+// 回滚同步重新模拟时同一帧的调整只记录一次。
+func (g *Game) logAdaptChanges() {
+	if g.world == nil || g.playback != nil {
+		return
+	}
+	var lines []string
+	for _, c := range g.world.AdaptChanges() {
+		if c.Tick <= g.adaptTick {
+			continue
+		}
+		g.adaptTick = c.Tick
+		lines = append(lines, fmt.Sprintf("%s %s 第 %d 关 %s %s\n",
+			time.Now().Format(time.DateTime), g.campaign.Name, g.stage+1, g.world.Config().Difficulty, c))
+	}
+	if len(lines) == 0 {
+		return
+	}
+	if err := appendLogLines(adaptLogFile, lines); err != nil {
+		log.Println("log difficulty changes:", err)
+	}
+}
+
+// appendLogLines 将若干行追加到用户配置目录中的日志文件
+func appendLogLines(name string, lines []string) error {
+	path, err := configFile(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err := f.WriteString(line); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"image/color"
 	"log"
-	"math/rand"
-	"time"
+	"math"
 
+	"github.com/ewangplay/golang-exercises/pkgs/tank/netplay"
+	"github.com/ewangplay/golang-exercises/pkgs/tank/replay"
+	"github.com/ewangplay/golang-exercises/pkgs/tank/server"
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
 	"github.com/hajimehoshi/ebiten/v2"
-	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 	"github.com/hajimehoshi/ebiten/v2/inpututil"
 	"github.com/hajimehoshi/ebiten/v2/text/v2"
 	"github.com/hajimehoshi/ebiten/v2/vector"
@@ -26,775 +28,497 @@ func init() {
 	mplusFaceSource = s
 }
 
-// Tank 表示坦克
-type Tank struct {
-	x, y             float32
-	direction        int // 0: 上, 1: 右, 2: 下, 3: 左
-	directionChanged bool
-	hasShot          bool
-	health           int
+// Game 将模拟世界接入 ebiten，负责战役流程、读取输入和绘制画面
+type Game struct {
+	scene scene
+
+	campaign     *sim.Campaign
+	progress     *progress
+	scores       *highScores
+	garage       *garage
+	seed         int64
+	stage        int        // 当前关卡在战役中的下标
+	stageCarry   *sim.Carry // 进入当前关卡时带入的玩家状态，用于重新开始本关
+	record       bool       // 是否录制每一关的输入
+	players      int        // 本局的玩家人数
+	friendlyFire bool       // 玩家的子弹是否会伤害队友
+	difficulty   sim.Difficulty
+	movement     sim.MovementMode
+	adaptive     bool // 是否开启动态难度
+	adaptTick    int  // 已经写入日志的最后一次动态难度调整所在的帧
+	lan          lanOptions
+	net          netMatch // 正在进行的联网对局，本地游戏时为 nil
+	netDebug     bool     // 是否显示联网同步的调试信息
+
+	cursorX, cursorY int  // 上一帧鼠标光标的位置
+	mouseAim         bool // 鼠标在画面中移动过，之后用鼠标瞄准
+
+	streamPath  string               // 观战流文件，为空时不写入
+	stream      *server.StreamWriter // 第一次写入时创建
+	streamWorld *sim.World           // 上一次写入观战流的世界和帧号
+	streamTick  int
+
+	world    *sim.World
+	recorder *replay.Recorder
+	playback *replay.Replay
+	frame    int
 }
 
-// Bullet 表示子弹
-type Bullet struct {
-	x, y      float32
-	direction int
+// NewGame 使用给定的随机种子创建一个战役游戏实例，从标题画面开始
+func NewGame(seed int64, campaign *sim.Campaign, prog *progress, scores *highScores, gr *garage) *Game {
+	g := &Game{
+		campaign:   campaign,
+		progress:   prog,
+		scores:     scores,
+		garage:     gr,
+		seed:       seed,
+		players:    1,
+		difficulty: sim.DifficultyNormal,
+	}
+	g.switchScene(&titleScene{})
+	return g
 }
 
-// Wall 表示墙壁
-type Wall struct {
-	x, y          float32
-	width, height float32
-	health        int
+// NewReplayGame 创建一个回放录像的游戏实例，输入全部来自录像
+func NewReplayGame(r *replay.Replay) *Game {
+	g := &Game{
+		world:    r.NewWorld(),
+		playback: r,
+	}
+	g.switchScene(&replayScene{})
+	return g
 }
 
-// Game 表示游戏状态
-type Game struct {
-	playerTank     *Tank
-	bossTank       *Tank
-	playerBullets  []Bullet
-	enemyTanks     []Tank
-	bossBullets    []Bullet
-	enemyBullets   []Bullet
-	walls          []Wall
-	enemyTimer     *time.Timer
-	wallTimer      *time.Timer
-	gameOver       bool
-	gameSucc       bool
-	enemyTankCount int
-	lastFollowTime time.Time
-}
-
-// NewGame 创建一个新的游戏实例
-func NewGame() *Game {
-	game := &Game{
-		playerTank: &Tank{
-			x:         screenWidth / 2,
-			y:         screenHeight/2 + statusBarHeight,
-			direction: 0,
-			health:    playerTankHP,
-		},
-		playerBullets: []Bullet{},
-		bossTank: &Tank{
-			x:         100,
-			y:         100,
-			direction: 2,
-			health:    bossTankHP,
-		},
-		enemyTanks:   []Tank{},
-		enemyBullets: []Bullet{},
-		bossBullets:  []Bullet{},
-		walls: []Wall{
-			{x: 150, y: 150, width: 100, height: 10, health: wallHP},
-			{x: 250, y: 280, width: 150, height: 10, health: wallHP},
-			{x: 400, y: 50, width: 10, height: 100, health: wallHP},
-			{x: 350, y: 350, width: 10, height: 50, health: wallHP},
-		},
-		enemyTimer:     time.NewTimer(time.Duration(5+rand.Intn(enemyTankCheckInterval)) * time.Second),
-		wallTimer:      time.NewTimer(time.Duration(10+rand.Intn(wallCheckInterval)) * time.Second),
-		gameOver:       false,
-		gameSucc:       false,
-		enemyTankCount: maxEnemyTankCount,
-		lastFollowTime: time.Now(),
-	}
-
-	go game.spawnEnemyTanks()
-	go game.spawnWalls()
-
-	return game
-}
-
-// spawnEnemyTanks 定时生成敌方坦克
-func (g *Game) spawnEnemyTanks() {
-	for {
-		<-g.enemyTimer.C
-		if len(g.enemyTanks) < g.enemyTankCount {
-			newTank := Tank{
-				x:         float32(rand.Intn(screenWidth - 20)),
-				y:         float32(statusBarHeight + rand.Intn(screenHeight-40)),
-				direction: rand.Intn(4),
-				health:    enemyTankHP,
-			}
-			g.enemyTanks = append(g.enemyTanks, newTank)
-		}
-		g.enemyTimer.Reset(time.Duration(5+rand.Intn(enemyTankCheckInterval)) * time.Second)
-	}
-}
-
-// spawnWalls 定时生成墙
-func (g *Game) spawnWalls() {
-	for {
-		<-g.wallTimer.C
-		if len(g.walls) < maxWallCount {
-			var newWall Wall
-			if rand.Intn(2) == 0 {
-				// 生成水平的墙
-				newWall = Wall{
-					x:      float32(rand.Intn(screenWidth - 50)),
-					y:      float32(statusBarHeight*2 + rand.Intn(screenHeight-10)),
-					width:  float32(rand.Intn(50) + 50),
-					height: 10,
-					health: wallHP,
-				}
-			} else {
-				// 生成竖直的墙
-				newWall = Wall{
-					x:      float32(rand.Intn(screenWidth - 10)),
-					y:      float32(statusBarHeight + rand.Intn(screenHeight-50)),
-					width:  10,
-					height: float32(rand.Intn(50) + 50),
-					health: wallHP,
-				}
-			}
-			g.walls = append(g.walls, newWall)
-		}
-		g.wallTimer.Reset(time.Duration(10+rand.Intn(wallCheckInterval)) * time.Second)
+// startStage 创建战役中第 stage 关的游戏世界，carry 为上一关带入的玩家状态
+func (g *Game) startStage(stage int, carry *sim.Carry) {
+	g.stage = stage
+	g.stageCarry = carry
+	g.world = sim.NewLevelWorld(g.seed+int64(stage), g.config(), g.campaign.Stages[stage])
+	g.world.ApplyCarry(carry)
+	g.adaptTick = -1
+	// 回滚同步会反复重新模拟，无法逐帧录制
+	g.recorder = nil
+	if _, rollback := g.net.(*netplay.Rollback); g.record && !rollback {
+		g.recorder = replay.NewRecorder(g.world, len(g.world.Players))
 	}
 }
 
-func (g *Game) updatePlayerTank() error {
-	if g.playerTank != nil {
-		// 处理坦克移动
-		var newX, newY = g.playerTank.x, g.playerTank.y
-
-		if ebiten.IsKeyPressed(ebiten.KeyUp) {
-			g.playerTank.direction = 0
-			if g.playerTank.y > statusBarHeight {
-				newY -= tankSpeed
-			}
-		} else if ebiten.IsKeyPressed(ebiten.KeyRight) {
-			g.playerTank.direction = 1
-			if g.playerTank.x < screenWidth-20 {
-				newX += tankSpeed
-			}
-		} else if ebiten.IsKeyPressed(ebiten.KeyDown) {
-			g.playerTank.direction = 2
-			if g.playerTank.y < screenHeight-20 {
-				newY += tankSpeed
-			}
-		} else if ebiten.IsKeyPressed(ebiten.KeyLeft) {
-			g.playerTank.direction = 3
-			if g.playerTank.x > 0 {
-				newX -= tankSpeed
-			}
-		}
-
-		collision := false
-		// 检测与Boss坦克的碰撞
-		if g.bossTank != nil {
-			if checkCollision(newX, newY, 20, 20, g.bossTank.x, g.bossTank.y, 20, 20) {
-				collision = true
-			}
-		}
-
-		// 检测与敌方坦克的碰撞
-		for _, enemyTank := range g.enemyTanks {
-			if checkCollision(newX, newY, 20, 20, enemyTank.x, enemyTank.y, 20, 20) {
-				collision = true
-				break
-			}
-		}
-
-		// 检测与墙的碰撞
-		for _, wall := range g.walls {
-			if checkCollision(newX, newY, 20, 20, wall.x, wall.y, wall.width, wall.height) {
-				collision = true
-				break
-			}
-		}
-
-		// 如果没有碰撞，更新坦克位置
-		if !collision {
-			g.playerTank.x = newX
-			g.playerTank.y = newY
-		}
-
-		// 处理射击
-		if inpututil.IsKeyJustPressed(ebiten.KeySpace) {
-			bullet := Bullet{
-				x:         g.playerTank.x + 8,
-				y:         g.playerTank.y + 8,
-				direction: g.playerTank.direction,
-			}
-			g.playerBullets = append(g.playerBullets, bullet)
-		}
+// config 返回新一关使用的游戏参数，联网对局时使用主机选定的参数
+func (g *Game) config() sim.Config {
+	if g.net != nil {
+		return g.net.Config()
 	}
-	return nil
+	cfg := g.difficulty.Apply(sim.DefaultConfig())
+	cfg.Adaptive = g.adaptive
+	cfg.Players = g.players
+	cfg.FriendlyFire = g.friendlyFire
+	cfg.Perks = g.garage.Perks
+	cfg.Movement = g.movement
+	return cfg
 }
 
-func (g *Game) updatePlayerBullets() error {
-
-	// 更新子弹位置
-	for i := 0; i < len(g.playerBullets); i++ {
-		switch g.playerBullets[i].direction {
-		case 0:
-			g.playerBullets[i].y -= bulletSpeed
-		case 1:
-			g.playerBullets[i].x += bulletSpeed
-		case 2:
-			g.playerBullets[i].y += bulletSpeed
-		case 3:
-			g.playerBullets[i].x -= bulletSpeed
-		}
-
-		// 检测玩家子弹与Boss坦克的碰撞
-		if g.bossTank != nil {
-			if checkCollision(g.playerBullets[i].x, g.playerBullets[i].y, 5, 5, g.bossTank.x, g.bossTank.y, 20, 20) {
-				g.bossTank.health--
-				if g.bossTank.health <= 0 {
-					// 移除Boss坦克
-					g.bossTank = nil
-				}
-				// 移除子弹
-				g.playerBullets = append(g.playerBullets[:i], g.playerBullets[i+1:]...)
-				i--
-			}
-		}
-
-		// 检测玩家子弹与敌方坦克的碰撞
-		for j := 0; j < len(g.enemyTanks); j++ {
-			if i < 0 {
-				break
-			}
-			if checkCollision(g.playerBullets[i].x, g.playerBullets[i].y, 5, 5, g.enemyTanks[j].x, g.enemyTanks[j].y, 20, 20) {
-				g.enemyTanks[j].health--
-				if g.enemyTanks[j].health <= 0 {
-					// 移除敌方坦克
-					g.enemyTanks = append(g.enemyTanks[:j], g.enemyTanks[j+1:]...)
-					// g.playerTank.health++
-				}
-				// 移除子弹
-				g.playerBullets = append(g.playerBullets[:i], g.playerBullets[i+1:]...)
-				i--
-				break
-			}
-		}
+// startRun 从第 stage 关开始游戏，先显示关卡介绍画面
+func (g *Game) startRun(stage int, carry *sim.Carry) {
+	g.startStage(stage, carry)
+	g.switchScene(&introScene{})
+}
 
-		// 检测玩家子弹与墙的碰撞
-		for j := 0; j < len(g.walls); j++ {
-			if i < 0 {
-				break
-			}
-			if checkCollision(g.playerBullets[i].x, g.playerBullets[i].y, 5, 5, g.walls[j].x, g.walls[j].y, g.walls[j].width, g.walls[j].height) {
-				g.walls[j].health--
-				if g.walls[j].health <= 0 {
-					// 移除墙
-					g.walls = append(g.walls[:j], g.walls[j+1:]...)
-				}
-				// 移除子弹
-				g.playerBullets = append(g.playerBullets[:i], g.playerBullets[i+1:]...)
-				i--
-				break
-			}
-		}
+// restartStage 以进入本关时的状态重新开始当前关卡
+func (g *Game) restartStage() {
+	g.startRun(g.stage, g.stageCarry)
+}
 
-		// // 检测玩家子弹与Boss子弹的碰撞
-		// for j := 0; j < len(g.bossBullets); j++ {
-		// 	if i < 0 {
-		// 		break
-		// 	}
-		// 	if checkCollision(g.playerBullets[i].x, g.playerBullets[i].y, 5, 5, g.bossBullets[j].x, g.bossBullets[j].y, 5, 5) {
-		// 		// 移除Boss子弹
-		// 		g.bossBullets = append(g.bossBullets[:j], g.bossBullets[j+1:]...)
-		// 		// 移除玩家子弹
-		// 		g.playerBullets = append(g.playerBullets[:i], g.playerBullets[i+1:]...)
-		// 		i--
-		// 		break
-		// 	}
-		// }
-
-		// // 检测玩家子弹与敌方子弹的碰撞
-		// for j := 0; j < len(g.enemyBullets); j++ {
-		// 	if i < 0 {
-		// 		break
-		// 	}
-		// 	if checkCollision(g.playerBullets[i].x, g.playerBullets[i].y, 5, 5, g.enemyBullets[j].x, g.enemyBullets[j].y, 5, 5) {
-		// 		// 移除敌方子弹
-		// 		g.enemyBullets = append(g.enemyBullets[:j], g.enemyBullets[j+1:]...)
-		// 		// 移除玩家子弹
-		// 		g.playerBullets = append(g.playerBullets[:i], g.playerBullets[i+1:]...)
-		// 		i--
-		// 		break
-		// 	}
-		// }
-
-		// 移除超出屏幕的子弹
-		if i >= 0 && i < len(g.playerBullets) {
-			if g.playerBullets[i].x < 0 || g.playerBullets[i].x > screenWidth || g.playerBullets[i].y < statusBarHeight || g.playerBullets[i].y > screenHeight {
-				g.playerBullets = append(g.playerBullets[:i], g.playerBullets[i+1:]...)
-				i--
-			}
-		}
+// stageCleared 当前关卡胜利后按本关得分发放金币，解锁下一关并进入车库，
+// 联网对局中直接进入下一关，全部通过时进入通关画面
+func (g *Game) stageCleared() {
+	earned := g.garage.earn(g.stageScore())
+	if err := g.garage.save(); err != nil {
+		log.Println("save garage:", err)
 	}
-	return nil
-}
 
-// isPlayerTankFollowed 判断玩家坦克是否在尾随Boss坦克
-func (g *Game) isPlayerTankFollowed() bool {
-	if g.playerTank == nil || g.bossTank == nil {
-		return false
+	next := g.stage + 1
+	if next >= len(g.campaign.Stages) {
+		g.switchScene(&gameEndScene{victory: true})
+		return
 	}
 
-	isFollowing := false
-	switch g.playerTank.direction {
-	case 0: // 上
-		if g.playerTank.x == g.bossTank.x && g.playerTank.y > g.bossTank.y {
-			isFollowing = true
-		}
-	case 1: // 右
-		if g.playerTank.x < g.bossTank.x && g.playerTank.y == g.bossTank.y {
-			isFollowing = true
-		}
-	case 2: // 下
-		if g.playerTank.x == g.bossTank.x && g.playerTank.y < g.bossTank.y {
-			isFollowing = true
-		}
-	case 3: // 左
-		if g.playerTank.x > g.bossTank.x && g.playerTank.y == g.bossTank.y {
-			isFollowing = true
-		}
+	g.progress.unlock(g.campaign.Name, next+1)
+	if err := g.progress.save(); err != nil {
+		log.Println("save progress:", err)
 	}
-	return isFollowing
+	if g.net != nil {
+		g.startRun(next, g.world.Carry())
+		return
+	}
+	g.switchScene(&garageScene{next: next, carry: g.world.Carry(), earned: earned})
 }
 
-func (g *Game) updateBossTank() error {
-	if g.bossTank != nil {
-		// 检查玩家坦克是否在尾随
-		if g.isPlayerTankFollowed() {
-			if time.Since(g.lastFollowTime) > bossToleranceTime*time.Second {
-				// 玩家坦克尾随超过3秒，Boss坦克转向并射击
-				g.bossTank.direction = (g.bossTank.direction + 2) % 4 // 转向180度
-				g.bossTankFire()
-				g.lastFollowTime = time.Now()
-			}
-		} else {
-			g.lastFollowTime = time.Now()
-		}
-
-		// 简单的随机移动逻辑
-		if int(ebiten.ActualTPS())%changeDirInterval == 0 && !g.bossTank.directionChanged {
-			g.bossTank.direction = rand.Intn(4)
-			g.bossTankFire()
-			g.bossTank.directionChanged = true
-		} else if int(ebiten.ActualTPS())%changeDirInterval != 0 {
-			g.bossTank.directionChanged = false
-		}
-
-		var newX, newY = g.bossTank.x, g.bossTank.y
-
-		switch g.bossTank.direction {
-		case 0:
-			if g.bossTank.y > statusBarHeight {
-				newY -= tankSpeed
-			} else {
-				g.bossTank.direction = rand.Intn(4)
-				g.bossTankFire()
-			}
-		case 1:
-			if g.bossTank.x < screenWidth-20 {
-				newX += tankSpeed
-			} else {
-				g.bossTank.direction = rand.Intn(4)
-				g.bossTankFire()
-			}
-		case 2:
-			if g.bossTank.y < screenHeight-20 {
-				newY += tankSpeed
-			} else {
-				g.bossTank.direction = rand.Intn(4)
-				g.bossTankFire()
-			}
-		case 3:
-			if g.bossTank.x > 0 {
-				newX -= tankSpeed
-			} else {
-				g.bossTank.direction = rand.Intn(4)
-				g.bossTankFire()
-			}
-		}
-
-		// 检测与玩家坦克的碰撞
-		collision := false
-		if g.playerTank != nil {
-			if checkCollision(newX, newY, 20, 20, g.playerTank.x, g.playerTank.y, 20, 20) {
-				collision = true
-			}
-		}
-
-		// 检测与墙的碰撞
-		for _, wall := range g.walls {
-			if checkCollision(newX, newY, 20, 20, wall.x, wall.y, wall.width, wall.height) {
-				collision = true
-				// 随机改变行进方向
-				g.bossTank.direction = rand.Intn(4)
-				g.bossTankFire()
-				break
-			}
-		}
-
-		// 检测与敌方坦克的碰撞
-		for _, enemyTank := range g.enemyTanks {
-			if checkCollision(newX, newY, 20, 20, enemyTank.x, enemyTank.y, 20, 20) {
-				collision = true
-				// 随机改变行进方向
-				g.bossTank.direction = rand.Intn(4)
-				g.bossTankFire()
-				break
-			}
-		}
-
-		// 如果没有碰撞，更新敌人坦克位置
-		if !collision {
-			g.bossTank.x = newX
-			g.bossTank.y = newY
-		}
-
-		// 简单的随机射击逻辑
-		if int(ebiten.ActualTPS())%shootInterval == 0 && !g.bossTank.hasShot {
-			g.bossTankFire()
-			g.bossTank.hasShot = true
-		} else if int(ebiten.ActualTPS())%shootInterval != 0 {
-			g.bossTank.hasShot = false
-		}
-	}
-	return nil
+// StartRecording 开始录制玩家输入，之后每进入新的一关都重新录制
+func (g *Game) StartRecording() {
+	g.record = true
 }
 
-func (g *Game) bossTankFire() {
-	bullet := Bullet{
-		x:         g.bossTank.x + 8,
-		y:         g.bossTank.y + 8,
-		direction: g.bossTank.direction,
+// FinishRecording 结束录制并返回最近一关的录像，没有在录制时返回 nil
+func (g *Game) FinishRecording() *replay.Replay {
+	if g.recorder == nil {
+		return nil
 	}
-	g.bossBullets = append(g.bossBullets, bullet)
+	r := g.recorder.Finish(g.world)
+	g.recorder = nil
+	return r
 }
 
-func (g *Game) updateBossBullets() error {
-	// 更新子弹位置
-	for i := 0; i < len(g.bossBullets); i++ {
-		switch g.bossBullets[i].direction {
-		case 0:
-			g.bossBullets[i].y -= bulletSpeed
-		case 1:
-			g.bossBullets[i].x += bulletSpeed
-		case 2:
-			g.bossBullets[i].y += bulletSpeed
-		case 3:
-			g.bossBullets[i].x -= bulletSpeed
-		}
-
-		// 检测Boss子弹与玩家坦克的碰撞
-		if g.playerTank != nil && len(g.bossBullets) > 0 {
-			if checkCollision(g.bossBullets[i].x, g.bossBullets[i].y, 5, 5, g.playerTank.x, g.playerTank.y, 20, 20) {
-				g.playerTank.health--
-				if g.playerTank.health <= 0 {
-					// 移除玩家坦克
-					g.playerTank = nil
-				}
-				// 移除子弹
-				g.bossBullets = append(g.bossBullets[:i], g.bossBullets[i+1:]...)
-				i--
-				continue
-			}
-		}
-
-		// 检测Boss子弹与墙的碰撞
-		for j := 0; j < len(g.walls); j++ {
-			if i < 0 {
-				break
-			}
-			if checkCollision(g.bossBullets[i].x, g.bossBullets[i].y, 5, 5, g.walls[j].x, g.walls[j].y, g.walls[j].width, g.walls[j].height) {
-				g.walls[j].health--
-				if g.walls[j].health <= 0 {
-					// 移除墙
-					g.walls = append(g.walls[:j], g.walls[j+1:]...)
-				}
-				// 移除子弹
-				g.bossBullets = append(g.bossBullets[:i], g.bossBullets[i+1:]...)
-				i--
-				break
-			}
-		}
+// playbackEnded 判断录像是否已经回放完毕
+func (g *Game) playbackEnded() bool {
+	return g.playback != nil && g.frame >= len(g.playback.Frames)
+}
 
-		// 移除超出屏幕的子弹
-		if i >= 0 && i < len(g.bossBullets) {
-			if g.bossBullets[i].x < 0 || g.bossBullets[i].x > screenWidth || g.bossBullets[i].y < statusBarHeight || g.bossBullets[i].y > screenHeight {
-				g.bossBullets = append(g.bossBullets[:i], g.bossBullets[i+1:]...)
-				i--
-			}
-		}
+// step 使用本帧的输入推进模拟，正在录制时同时记录输入
+func (g *Game) step(inputs []sim.Input) {
+	if g.recorder != nil {
+		g.recorder.Record(inputs)
 	}
-	return nil
+	g.world.Step(inputs)
 }
 
-func (g *Game) updateEnemyTanks() error {
-	// 更新敌人坦克状态
-	for i := 0; i < len(g.enemyTanks); i++ {
-		// 简单的随机移动逻辑
-		if int(ebiten.ActualTPS())%changeDirInterval == 0 && !g.enemyTanks[i].directionChanged {
-			g.enemyTanks[i].direction = rand.Intn(4)
-			g.enemyTankFire(i)
-			g.enemyTanks[i].directionChanged = true
-		} else if int(ebiten.ActualTPS())%changeDirInterval != 0 {
-			g.enemyTanks[i].directionChanged = false
-		}
+// keyBinding 表示一名玩家使用的按键
+type keyBinding struct {
+	up, right, down, left, fire ebiten.Key
+}
 
-		var newX, newY = g.enemyTanks[i].x, g.enemyTanks[i].y
+// playerKeys 各玩家的按键，1 号玩家使用方向键和空格键，2 号玩家使用 WASD 和 F 键
+var playerKeys = []keyBinding{
+	{up: ebiten.KeyUp, right: ebiten.KeyRight, down: ebiten.KeyDown, left: ebiten.KeyLeft, fire: ebiten.KeySpace},
+	{up: ebiten.KeyW, right: ebiten.KeyD, down: ebiten.KeyS, left: ebiten.KeyA, fire: ebiten.KeyF},
+}
 
-		switch g.enemyTanks[i].direction {
-		case 0:
-			if g.enemyTanks[i].y > statusBarHeight {
-				newY -= tankSpeed
-			} else {
-				g.enemyTanks[i].direction = rand.Intn(4)
-				g.enemyTankFire(i)
-			}
-		case 1:
-			if g.enemyTanks[i].x < screenWidth-20 {
-				newX += tankSpeed
-			} else {
-				g.enemyTanks[i].direction = rand.Intn(4)
-				g.enemyTankFire(i)
-			}
-		case 2:
-			if g.enemyTanks[i].y < screenHeight-20 {
-				newY += tankSpeed
-			} else {
-				g.enemyTanks[i].direction = rand.Intn(4)
-				g.enemyTankFire(i)
-			}
-		case 3:
-			if g.enemyTanks[i].x > 0 {
-				newX -= tankSpeed
-			} else {
-				g.enemyTanks[i].direction = rand.Intn(4)
-				g.enemyTankFire(i)
-			}
-		}
+// readPlayerInput 读取一名玩家的键盘输入并转换为模拟层的输入
+func readPlayerInput(keys keyBinding) sim.Input {
+	var input sim.Input
+	if ebiten.IsKeyPressed(keys.up) {
+		input |= sim.InputUp
+	}
+	if ebiten.IsKeyPressed(keys.right) {
+		input |= sim.InputRight
+	}
+	if ebiten.IsKeyPressed(keys.down) {
+		input |= sim.InputDown
+	}
+	if ebiten.IsKeyPressed(keys.left) {
+		input |= sim.InputLeft
+	}
+	if inpututil.IsKeyJustPressed(keys.fire) {
+		input |= sim.InputFire
+	}
+	if ebiten.IsKeyPressed(keys.fire) {
+		input |= sim.InputFireHeld
+	}
+	return input
+}
 
-		// 检测与玩家坦克的碰撞
-		collision := false
-		if g.playerTank != nil {
-			if checkCollision(newX, newY, 20, 20, g.playerTank.x, g.playerTank.y, 20, 20) {
-				collision = true
-			}
+// readInputs 读取本局所有玩家的输入
+func (g *Game) readInputs() []sim.Input {
+	inputs := make([]sim.Input, len(g.world.Players))
+	for i := range inputs {
+		if i < len(playerKeys) {
+			inputs[i] = g.readInput(i, i)
 		}
+	}
+	return inputs
+}
 
-		// 检测与墙的碰撞
-		for _, wall := range g.walls {
-			if checkCollision(newX, newY, 20, 20, wall.x, wall.y, wall.width, wall.height) {
-				collision = true
-				// 随机改变行进方向
-				g.enemyTanks[i].direction = rand.Intn(4)
-				g.enemyTankFire(i)
-				break
-			}
-		}
+// Update 更新游戏状态
+func (g *Game) Update() error {
+	err := g.scene.update(g)
+	g.recordStream()
+	g.logAdaptChanges()
+	return err
+}
 
-		// 检测与Boss坦克的碰撞
-		if g.bossTank != nil {
-			if checkCollision(newX, newY, 20, 20, g.bossTank.x, g.bossTank.y, 20, 20) {
-				collision = true
-				// 随机改变行进方向
-				g.enemyTanks[i].direction = rand.Intn(4)
-				g.enemyTankFire(i)
-			}
-		}
+// drawTank 绘制坦克车身和炮管，车身小于 TankSize 的坦克居中绘制
+func drawTank(screen *ebiten.Image, tank *sim.Tank, clr color.Color) {
+	x, y, size := tank.Body()
+	vector.DrawFilledRect(screen, x, y, size, size, clr, false)
+	cx, cy, half := x+size/2, y+size/2, size/2
+	switch tank.Direction {
+	case 0:
+		vector.StrokeLine(screen, cx, y, cx, y-half, 1, clr, false)
+	case 1:
+		vector.StrokeLine(screen, x+size, cy, x+size+half, cy, 1, clr, false)
+	case 2:
+		vector.StrokeLine(screen, cx, y+size, cx, y+size+half, 1, clr, false)
+	case 3:
+		vector.StrokeLine(screen, x, cy, x-half, cy, 1, clr, false)
+	}
+}
 
-		// 如果没有碰撞，更新敌人坦克位置
-		if !collision {
-			g.enemyTanks[i].x = newX
-			g.enemyTanks[i].y = newY
-		}
+// playerColors 各玩家坦克和子弹的颜色
+var playerColors = []color.RGBA{
+	{0, 255, 0, 255},
+	{255, 220, 0, 255},
+	{0, 220, 255, 255},
+	{255, 128, 0, 255},
+}
 
-		// 简单的随机射击逻辑
-		if int(ebiten.ActualTPS())%shootInterval == 0 && !g.enemyTanks[i].hasShot {
-			g.enemyTankFire(i)
-			g.enemyTanks[i].hasShot = true
-		} else if int(ebiten.ActualTPS())%shootInterval != 0 {
-			g.enemyTanks[i].hasShot = false
-		}
+// starColor 玩家坦克上星级标记的颜色
+var starColor = color.RGBA{255, 215, 0, 255}
+
+// drawPlayerTank 绘制玩家坦克和它的星级：每颗星在车身上画一个金色的点，
+// 两星起炮管加粗，三星时车身加上金色的边框
+func drawPlayerTank(screen *ebiten.Image, tank *sim.Tank, clr color.Color) {
+	drawTank(screen, tank, clr)
+	if tank.Stars >= 2 {
+		cx, cy := tank.X+sim.TankSize/2, tank.Y+sim.TankSize/2
+		dx, dy := directionOffset(tank.Direction)
+		vector.StrokeLine(screen, cx+dx*10, cy+dy*10, cx+dx*20, cy+dy*20, 3, clr, false)
+	}
+	if tank.Stars >= sim.MaxStars {
+		vector.StrokeRect(screen, tank.X+1, tank.Y+1, sim.TankSize-2, sim.TankSize-2, 2, starColor, false)
+	}
+	for i := 0; i < tank.Stars; i++ {
+		x := tank.X + sim.TankSize/2 + float32(i-1)*5
+		vector.DrawFilledCircle(screen, x, tank.Y+sim.TankSize/2, 2, starColor, false)
 	}
-	return nil
 }
 
-func (g *Game) enemyTankFire(i int) {
-	bullet := Bullet{
-		x:         g.enemyTanks[i].x + 8,
-		y:         g.enemyTanks[i].y + 8,
-		direction: g.enemyTanks[i].direction,
+// directionOffset 返回坦克朝向的单位向量
+func directionOffset(dir int) (float32, float32) {
+	switch dir {
+	case 0:
+		return 0, -1
+	case 1:
+		return 1, 0
+	case 2:
+		return 0, 1
 	}
-	g.enemyBullets = append(g.enemyBullets, bullet)
+	return -1, 0
 }
 
-func (g *Game) updateEnemyBullets() error {
-	// 更新子弹位置
-	for i := 0; i < len(g.enemyBullets); i++ {
-		switch g.enemyBullets[i].direction {
-		case 0:
-			g.enemyBullets[i].y -= bulletSpeed
-		case 1:
-			g.enemyBullets[i].x += bulletSpeed
-		case 2:
-			g.enemyBullets[i].y += bulletSpeed
-		case 3:
-			g.enemyBullets[i].x -= bulletSpeed
+func (g *Game) drawPlayerTanks(screen *ebiten.Image) {
+	// 绘制各玩家坦克
+	for i, p := range g.world.Players {
+		if p.Tank == nil {
+			continue
 		}
-
-		// 检测敌方子弹与玩家坦克的碰撞
-		if g.playerTank != nil && len(g.enemyBullets) > 0 {
-			if checkCollision(g.enemyBullets[i].x, g.enemyBullets[i].y, 5, 5, g.playerTank.x, g.playerTank.y, 20, 20) {
-				g.playerTank.health--
-				if g.playerTank.health <= 0 {
-					// 移除玩家坦克
-					g.playerTank = nil
-				}
-				// 移除子弹
-				g.enemyBullets = append(g.enemyBullets[:i], g.enemyBullets[i+1:]...)
-				i--
-				continue
-			}
+		if g.world.Config().Movement == sim.MoveClassic {
+			drawPlayerTank(screen, p.Tank, playerColors[i])
+		} else {
+			drawFreeTank(screen, p.Tank, playerColors[i])
 		}
-
-		// 检测子弹与墙的碰撞
-		for j := 0; j < len(g.walls); j++ {
-			if i < 0 {
-				break
-			}
-			if checkCollision(g.enemyBullets[i].x, g.enemyBullets[i].y, 5, 5, g.walls[j].x, g.walls[j].y, g.walls[j].width, g.walls[j].height) {
-				g.walls[j].health--
-				if g.walls[j].health <= 0 {
-					// 移除墙
-					g.walls = append(g.walls[:j], g.walls[j+1:]...)
-				}
-				// 移除子弹
-				g.enemyBullets = append(g.enemyBullets[:i], g.enemyBullets[i+1:]...)
-				i--
-				break
-			}
-		}
-
-		// 移除超出屏幕的子弹
-		if i >= 0 && i < len(g.enemyBullets) {
-			if g.enemyBullets[i].x < 0 || g.enemyBullets[i].x > screenWidth || g.enemyBullets[i].y < statusBarHeight || g.enemyBullets[i].y > screenHeight {
-				g.enemyBullets = append(g.enemyBullets[:i], g.enemyBullets[i+1:]...)
-				i--
-			}
+		if p.Tank.Shield > 0 {
+			// 绘制护盾
+			cx, cy := p.Tank.X+sim.TankSize/2, p.Tank.Y+sim.TankSize/2
+			vector.StrokeCircle(screen, cx, cy, sim.TankSize*0.8, 2, color.RGBA{0, 200, 255, 255}, false)
 		}
 	}
-	return nil
 }
 
-// Update 更新游戏状态
-func (g *Game) Update() error {
-	if g.gameOver || g.gameSucc {
-		return nil
+func (g *Game) drawBossTank(screen *ebiten.Image) {
+	boss := g.world.BossTank
+	if boss == nil || g.world.IsHidden(boss) {
+		return
 	}
-
-	g.updatePlayerTank()
-	g.updatePlayerBullets()
-	g.updateBossTank()
-	g.updateBossBullets()
-	g.updateEnemyTanks()
-	g.updateEnemyBullets()
-
-	// 检测玩家坦克是否被消灭
-	if g.playerTank == nil {
-		g.gameOver = true
+	clr := color.RGBA{255, 0, 0, 255}
+	if boss.Shield > 0 {
+		// 进入新阶段前蓄力：闪烁并显示逐渐收缩的光圈
+		if boss.Shield/6%2 == 0 {
+			clr = color.RGBA{255, 255, 255, 255}
+		}
+		cx, cy := boss.X+sim.TankSize/2, boss.Y+sim.TankSize/2
+		r := sim.TankSize*0.8 + float32(boss.Shield)/sim.BossTelegraphTicks*sim.TankSize
+		vector.StrokeCircle(screen, cx, cy, r, 2, color.RGBA{255, 128, 0, 255}, false)
 	}
+	drawTank(screen, boss, clr)
+}
 
-	// 检测Boss坦克是否被消灭
-	if g.bossTank == nil {
-		g.gameSucc = true
-	}
+// enemyColor 敌方坦克和子弹的默认颜色
+var enemyColor = color.RGBA{255, 182, 193, 255}
 
-	return nil
+// tankColor 返回敌方坦克种类的颜色，没有指定时使用默认颜色
+func tankColor(tank *sim.Tank) color.RGBA {
+	if tank.Color == 0 {
+		return enemyColor
+	}
+	return color.RGBA{uint8(tank.Color >> 16), uint8(tank.Color >> 8), uint8(tank.Color), 255}
 }
 
-func (g *Game) drawPlayerTank(screen *ebiten.Image) {
-	// 绘制玩家坦克
-	if g.playerTank != nil {
-		vector.DrawFilledRect(screen, g.playerTank.x, g.playerTank.y, 20, 20, color.RGBA{0, 255, 0, 255}, false)
-		switch g.playerTank.direction {
-		case 0:
-			vector.StrokeLine(screen, g.playerTank.x+10, g.playerTank.y, g.playerTank.x+10, g.playerTank.y-10, 1, color.RGBA{0, 255, 0, 255}, false)
-		case 1:
-			vector.StrokeLine(screen, g.playerTank.x+20, g.playerTank.y+10, g.playerTank.x+30, g.playerTank.y+10, 1, color.RGBA{0, 255, 0, 255}, false)
-		case 2:
-			vector.StrokeLine(screen, g.playerTank.x+10, g.playerTank.y+20, g.playerTank.x+10, g.playerTank.y+30, 1, color.RGBA{0, 255, 0, 255}, false)
-		case 3:
-			vector.StrokeLine(screen, g.playerTank.x, g.playerTank.y+10, g.playerTank.x-10, g.playerTank.y+10, 1, color.RGBA{0, 255, 0, 255}, false)
+func (g *Game) drawEnemyTanks(screen *ebiten.Image) {
+	// 绘制敌人坦克，颜色和大小由种类决定
+	for i := range g.world.EnemyTanks {
+		if g.world.IsHidden(&g.world.EnemyTanks[i]) {
+			continue
 		}
+		drawTank(screen, &g.world.EnemyTanks[i], tankColor(&g.world.EnemyTanks[i]))
 	}
 }
 
-func (g *Game) drawPlayerBullets(screen *ebiten.Image) {
-	// 绘制玩家子弹
-	for _, bullet := range g.playerBullets {
-		vector.DrawFilledRect(screen, bullet.x, bullet.y, 5, 5, color.RGBA{0, 255, 0, 255}, false)
+// bulletColor 返回子弹所属阵营的颜色，玩家子弹与发射者的坦克相同
+func bulletColor(bullet *sim.Bullet) color.RGBA {
+	switch bullet.Team {
+	case sim.TeamPlayer:
+		return playerColors[bullet.Owner]
+	case sim.TeamBoss:
+		return color.RGBA{255, 0, 0, 255}
+	default:
+		return enemyColor
 	}
 }
 
-func (g *Game) drawBossTank(screen *ebiten.Image) {
-	if g.bossTank != nil {
-		vector.DrawFilledRect(screen, g.bossTank.x, g.bossTank.y, 20, 20, color.RGBA{255, 0, 0, 255}, false)
-		switch g.bossTank.direction {
-		case 0:
-			vector.StrokeLine(screen, g.bossTank.x+10, g.bossTank.y, g.bossTank.x+10, g.bossTank.y-10, 1, color.RGBA{255, 0, 0, 255}, false)
-		case 1:
-			vector.StrokeLine(screen, g.bossTank.x+20, g.bossTank.y+10, g.bossTank.x+30, g.bossTank.y+10, 1, color.RGBA{255, 0, 0, 255}, false)
-		case 2:
-			vector.StrokeLine(screen, g.bossTank.x+10, g.bossTank.y+20, g.bossTank.x+10, g.bossTank.y+30, 1, color.RGBA{255, 0, 0, 255}, false)
-		case 3:
-			vector.StrokeLine(screen, g.bossTank.x, g.bossTank.y+10, g.bossTank.x-10, g.bossTank.y+10, 1, color.RGBA{255, 0, 0, 255}, false)
+func (g *Game) drawBullets(screen *ebiten.Image) {
+	// 绘制所有子弹，颜色表示阵营，形状表示种类
+	for i := range g.world.Bullets {
+		bullet := &g.world.Bullets[i]
+		clr := bulletColor(bullet)
+		cx, cy := bullet.X+bullet.Size/2, bullet.Y+bullet.Size/2
+		switch bullet.Kind {
+		case sim.BulletShell:
+			vector.DrawFilledCircle(screen, cx, cy, bullet.Size, color.RGBA{192, 128, 64, 255}, false)
+		case sim.BulletHeavy:
+			vector.DrawFilledRect(screen, bullet.X, bullet.Y, bullet.Size, bullet.Size, color.RGBA{160, 160, 160, 255}, false)
+		case sim.BulletBounce:
+			vector.DrawFilledCircle(screen, cx, cy, bullet.Size/2, clr, false)
+			vector.StrokeCircle(screen, cx, cy, bullet.Size/2, 1, color.White, false)
+		case sim.BulletRocket, sim.BulletHoming:
+			// 画出指向飞行方向的弹头和拖在后面的尾焰
+			speed := float32(math.Hypot(float64(bullet.VX), float64(bullet.VY)))
+			if speed == 0 {
+				speed = 1
+			}
+			dx, dy := bullet.VX/speed*bullet.Size, bullet.VY/speed*bullet.Size
+			vector.StrokeLine(screen, cx-dx, cy-dy, cx+dx/2, cy+dy/2, 2, color.RGBA{255, 160, 0, 255}, false)
+			vector.DrawFilledCircle(screen, cx+dx/2, cy+dy/2, bullet.Size/2, clr, false)
+		case sim.BulletLaser:
+			// 光束逐渐变细直到消失
+			width := bullet.Size * float32(bullet.Life) / sim.LaserTicks
+			vector.StrokeLine(screen, cx, cy, cx+bullet.VX*bullet.Length, cy+bullet.VY*bullet.Length, max(width, 1), clr, false)
+		default:
+			vector.DrawFilledRect(screen, bullet.X, bullet.Y, bullet.Size, bullet.Size, clr, false)
 		}
 	}
 }
 
-func (g *Game) drawBossBullets(screen *ebiten.Image) {
-	// 绘制敌人子弹
-	for _, bullet := range g.bossBullets {
-		vector.DrawFilledRect(screen, bullet.x, bullet.y, 5, 5, color.RGBA{255, 0, 0, 255}, false)
+func (g *Game) drawEffects(screen *ebiten.Image) {
+	// 绘制爆炸和火花，都会逐渐扩大并变淡
+	for _, e := range g.world.Effects {
+		ticks := e.Kind.Ticks()
+		alpha := uint8(e.Life * 255 / ticks)
+		r := e.Radius * (1 - float32(e.Life)/float32(2*ticks))
+		switch e.Kind {
+		case sim.EffectExplosion:
+			vector.DrawFilledCircle(screen, e.X, e.Y, r, color.RGBA{alpha, alpha / 2, 0, alpha}, false)
+		case sim.EffectSpark:
+			// 四道交叉的火花
+			clr := color.RGBA{alpha, alpha, alpha / 2, alpha}
+			d := r * 2
+			vector.StrokeLine(screen, e.X-d, e.Y, e.X+d, e.Y, 1, clr, false)
+			vector.StrokeLine(screen, e.X, e.Y-d, e.X, e.Y+d, 1, clr, false)
+			vector.StrokeLine(screen, e.X-r, e.Y-r, e.X+r, e.Y+r, 1, clr, false)
+			vector.StrokeLine(screen, e.X-r, e.Y+r, e.X+r, e.Y-r, 1, clr, false)
+		}
 	}
 }
 
-func (g *Game) drawEnemyTanks(screen *ebiten.Image) {
-	// 绘制敌人坦克
-	for _, enemyTank := range g.enemyTanks {
-		vector.DrawFilledRect(screen, enemyTank.x, enemyTank.y, 20, 20, color.RGBA{255, 182, 193, 255}, false)
-		switch enemyTank.direction {
-		case 0:
-			vector.StrokeLine(screen, enemyTank.x+10, enemyTank.y, enemyTank.x+10, enemyTank.y-10, 1, color.RGBA{255, 182, 193, 255}, false)
-		case 1:
-			vector.StrokeLine(screen, enemyTank.x+20, enemyTank.y+10, enemyTank.x+30, enemyTank.y+10, 1, color.RGBA{255, 182, 193, 255}, false)
-		case 2:
-			vector.StrokeLine(screen, enemyTank.x+10, enemyTank.y+20, enemyTank.x+10, enemyTank.y+30, 1, color.RGBA{255, 182, 193, 255}, false)
-		case 3:
-			vector.StrokeLine(screen, enemyTank.x, enemyTank.y+10, enemyTank.x-10, enemyTank.y+10, 1, color.RGBA{255, 182, 193, 255}, false)
-		}
+// powerUpLabels 各种道具上显示的文字
+var powerUpLabels = map[sim.PowerUpKind]string{
+	sim.PowerUpShield:      "盾",
+	sim.PowerUpRapidFire:   "连",
+	sim.PowerUpBulletSpeed: "速",
+	sim.PowerUpExtraLife:   "命",
+	sim.PowerUpBomb:        "炸",
+	sim.PowerUpFreeze:      "冻",
+	sim.PowerUpFortify:     "固",
+	sim.PowerUpStar:        "星",
+}
+
+func (g *Game) drawPowerUps(screen *ebiten.Image) {
+	// 绘制道具，即将消失的道具闪烁显示
+	face := &text.GoTextFace{
+		Source: mplusFaceSource,
+		Size:   12,
 	}
+	for _, p := range g.world.PowerUps {
+		if p.Blinking() && g.world.Tick()/8%2 == 0 {
+			continue
+		}
+		vector.DrawFilledRect(screen, p.X, p.Y, sim.PowerUpSize, sim.PowerUpSize, color.RGBA{255, 255, 255, 255}, false)
+		vector.StrokeRect(screen, p.X, p.Y, sim.PowerUpSize, sim.PowerUpSize, 1, color.RGBA{255, 0, 255, 255}, false)
+		op := &text.DrawOptions{}
+		op.GeoM.Translate(float64(p.X)+2, float64(p.Y)+1)
+		op.ColorScale.ScaleWithColor(color.RGBA{255, 0, 255, 255})
+		text.Draw(screen, powerUpLabels[p.Kind], face, op)
+	}
+}
+
+// terrainColors 各种地形的颜色
+var terrainColors = map[sim.Terrain]color.RGBA{
+	sim.TerrainBrick:  {128, 128, 128, 255},
+	sim.TerrainSteel:  {200, 200, 210, 255},
+	sim.TerrainWater:  {30, 90, 200, 255},
+	sim.TerrainForest: {20, 110, 40, 255},
+	sim.TerrainIce:    {190, 230, 245, 255},
+	sim.TerrainBase:   {255, 200, 0, 255},
 }
 
-func (g *Game) drawEnemyBullets(screen *ebiten.Image) {
-	// 绘制敌人子弹
-	for _, bullet := range g.enemyBullets {
-		vector.DrawFilledRect(screen, bullet.x, bullet.y, 5, 5, color.RGBA{255, 182, 193, 255}, false)
+// drawWall 绘制一块墙或地形
+func drawWall(screen *ebiten.Image, wall *sim.Wall) {
+	clr := terrainColors[wall.Kind]
+	vector.DrawFilledRect(screen, wall.X, wall.Y, wall.Width, wall.Height, clr, false)
+
+	switch wall.Kind {
+	case sim.TerrainSteel:
+		// 钢墙画出内框
+		vector.StrokeRect(screen, wall.X+2, wall.Y+2, wall.Width-4, wall.Height-4, 1, color.RGBA{120, 120, 130, 255}, false)
+	case sim.TerrainWater:
+		// 水面画出波纹
+		for y := wall.Y + 4; y < wall.Y+wall.Height; y += 6 {
+			vector.StrokeLine(screen, wall.X+2, y, wall.X+wall.Width-2, y, 1, color.RGBA{120, 170, 255, 255}, false)
+		}
+	case sim.TerrainForest:
+		// 树林画出树冠
+		r := wall.Width / 4
+		for _, dx := range []float32{r, 3 * r} {
+			for _, dy := range []float32{r, 3 * r} {
+				vector.DrawFilledCircle(screen, wall.X+dx, wall.Y+dy, r, color.RGBA{40, 160, 60, 255}, false)
+			}
+		}
+	case sim.TerrainIce:
+		// 冰面画出反光
+		vector.StrokeLine(screen, wall.X+3, wall.Y+wall.Height-3, wall.X+wall.Width-3, wall.Y+3, 1, color.White, false)
+	case sim.TerrainBase:
+		// 基地画出老鹰标志
+		cx, cy := wall.X+wall.Width/2, wall.Y+wall.Height/2
+		vector.DrawFilledRect(screen, cx-2, cy-wall.Height/3, 4, wall.Height*2/3, color.Black, false)
+		vector.StrokeLine(screen, cx, cy, cx-wall.Width/3, cy-wall.Height/4, 2, color.Black, false)
+		vector.StrokeLine(screen, cx, cy, cx+wall.Width/3, cy-wall.Height/4, 2, color.Black, false)
 	}
 }
 
 func (g *Game) drawWalls(screen *ebiten.Image) {
-	// 绘制墙壁
-	for _, wall := range g.walls {
-		vector.DrawFilledRect(screen, wall.x, wall.y, wall.width, wall.height, color.RGBA{128, 128, 128, 255}, false)
+	// 绘制墙壁，树林在坦克之上单独绘制
+	for i := range g.world.Walls {
+		if g.world.Walls[i].Kind != sim.TerrainForest {
+			drawWall(screen, &g.world.Walls[i])
+		}
+	}
+}
+
+func (g *Game) drawForest(screen *ebiten.Image) {
+	// 绘制树林，遮住其中的坦克
+	for i := range g.world.Walls {
+		if g.world.Walls[i].Kind == sim.TerrainForest {
+			drawWall(screen, &g.world.Walls[i])
+		}
 	}
 }
 
 func (g *Game) drawStatusBar(screen *ebiten.Image) {
 	// 绘制状态栏
-	vector.DrawFilledRect(screen, 0, 0, screenWidth, 20, color.RGBA{192, 192, 192, 255}, false)
+	vector.DrawFilledRect(screen, 0, 0, screenWidth, statusBarHeight, color.RGBA{192, 192, 192, 255}, false)
 
 	const (
-		fontSize = 14
+		fontSize         = 14
+		hudPlayerSpacing = 390 // 相邻两名玩家状态之间的水平距离
+		hudBossX         = 250
 	)
 
 	face := &text.GoTextFace{
@@ -805,51 +529,148 @@ func (g *Game) drawStatusBar(screen *ebiten.Image) {
 	op.ColorScale.ScaleWithColor(color.RGBA{0, 0, 255, 255})
 
 	var msg string
-	// // 绘制敌方坦克总数
-	// msg = fmt.Sprintf("敌方坦克总数: %d", g.enemyTankCount)
+	// // 绘制敌方剩余坦克数
+	// msg = fmt.Sprintf("敌方剩余坦克数: %d", g.world.EnemyReserve())
 	// op.GeoM.Translate(2, 1)
 	// text.Draw(screen, msg, face, op)
 
 	// // 绘制敌方出动坦克数
-	// msg = fmt.Sprintf("敌方出动坦克数: %d", len(g.enemyTanks))
+	// msg = fmt.Sprintf("敌方出动坦克数: %d", len(g.world.EnemyTanks))
 	// op.GeoM.Translate(120, 1)
 	// text.Draw(screen, msg, face, op)
 
-	// 绘制玩家坦克生命值
-	if g.playerTank != nil {
-		msg = fmt.Sprintf("玩家生命值: %d", g.playerTank.health)
-		op.GeoM.Translate(2, 1)
+	// 绘制各玩家的生命值、备用生命、得分和连击倍率，1 号玩家在左侧，2 号玩家在右侧；
+	// 联网对局超过两人时改用紧凑的格式平分状态栏
+	compact := len(g.world.Players) > 2
+	spacing := float64(hudPlayerSpacing)
+	if compact {
+		spacing = screenWidth / float64(len(g.world.Players))
+	}
+	for i, p := range g.world.Players {
+		switch {
+		case compact && p.Tank != nil:
+			msg = fmt.Sprintf("%dP %d/%d %d", i+1, p.Tank.Health, p.Lives, p.Score)
+		case compact && p.Out():
+			msg = fmt.Sprintf("%dP 阵亡 %d", i+1, p.Score)
+		case compact:
+			msg = fmt.Sprintf("%dP 重生 %d", i+1, p.Score)
+		case p.Tank != nil:
+			msg = fmt.Sprintf("%dP 生命%d 备用%d 得分%d", i+1, p.Tank.Health, p.Lives, p.Score)
+		case p.Out():
+			msg = fmt.Sprintf("%dP 阵亡 得分%d", i+1, p.Score)
+		default:
+			msg = fmt.Sprintf("%dP 重生中 备用%d 得分%d", i+1, p.Lives, p.Score)
+		}
+		if p.ComboMultiplier() > 1 {
+			msg += fmt.Sprintf(" x%d", p.ComboMultiplier())
+		}
+		op = &text.DrawOptions{}
+		op.ColorScale.ScaleWithColor(color.RGBA{0, 0, 255, 255})
+		op.GeoM.Translate(2+float64(i)*spacing, 1)
 		text.Draw(screen, msg, face, op)
 	}
 
-	// 绘制Boss坦克生命值
-	if g.bossTank != nil {
-		msg = fmt.Sprintf("敌方生命值: %d", g.bossTank.health)
-		op.GeoM.Translate(120, 1)
+	// 绘制Boss坦克的阶段，紧凑格式下状态栏没有空位
+	if phase := g.world.BossPhase(); phase >= 0 && !compact {
+		msg = fmt.Sprintf("Boss 第%d阶段", phase+1)
+		op = &text.DrawOptions{}
+		op.ColorScale.ScaleWithColor(color.RGBA{0, 0, 255, 255})
+		op.GeoM.Translate(hudBossX, 1)
 		text.Draw(screen, msg, face, op)
 	}
 }
 
-// Draw 绘制游戏画面
-func (g *Game) Draw(screen *ebiten.Image) {
-	if g.gameOver {
-		ebitenutil.DebugPrint(screen, "GAME OVER!")
+// drawBossHealthBar 在状态栏下方绘制Boss的血条，竖线标出各阶段的门槛
+func (g *Game) drawBossHealthBar(screen *ebiten.Image) {
+	boss := g.world.BossTank
+	if boss == nil {
 		return
 	}
+	const (
+		barWidth  = 300
+		barHeight = 5
+		barX      = (screenWidth - barWidth) / 2
+		barY      = statusBarHeight + 2
+	)
+	maxHP := max(1, g.world.Config().BossTankHP)
+	fill := float32(barWidth * min(max(boss.Health, 0), maxHP) / maxHP)
 
-	if g.gameSucc {
-		ebitenutil.DebugPrint(screen, "YOU WIN!")
+	clr := color.RGBA{220, 0, 0, 255}
+	if boss.Shield > 0 {
+		// 蓄力期间不会受到伤害
+		clr = color.RGBA{255, 255, 255, 255}
+	}
+	vector.DrawFilledRect(screen, barX, barY, barWidth, barHeight, color.RGBA{64, 0, 0, 192}, false)
+	vector.DrawFilledRect(screen, barX, barY, fill, barHeight, clr, false)
+	for _, p := range g.world.BossPhases() {
+		if p.Health >= 100 {
+			continue
+		}
+		x := float32(barX + barWidth*p.Health/100)
+		vector.StrokeLine(screen, x, barY-1, x, barY+barHeight+1, 1, color.RGBA{255, 215, 0, 255}, false)
+	}
+	vector.StrokeRect(screen, barX, barY, barWidth, barHeight, 1, color.RGBA{255, 255, 255, 128}, false)
+}
+
+// drawCenteredText 在屏幕水平居中的位置绘制一行文字
+func drawCenteredText(screen *ebiten.Image, msg string, size float64, y float64, clr color.Color) {
+	face := &text.GoTextFace{
+		Source: mplusFaceSource,
+		Size:   size,
+	}
+	w, _ := text.Measure(msg, face, 0)
+	op := &text.DrawOptions{}
+	op.GeoM.Translate((screenWidth-w)/2, y)
+	op.ColorScale.ScaleWithColor(clr)
+	text.Draw(screen, msg, face, op)
+}
+
+// drawTextAt 在 (x, y) 处绘制一行文字
+func drawTextAt(screen *ebiten.Image, msg string, size, x, y float64, clr color.Color) {
+	face := &text.GoTextFace{
+		Source: mplusFaceSource,
+		Size:   size,
+	}
+	op := &text.DrawOptions{}
+	op.GeoM.Translate(x, y)
+	op.ColorScale.ScaleWithColor(clr)
+	text.Draw(screen, msg, face, op)
+}
+
+// drawHighScores 从 y 开始绘制高分榜，名次为 rank 的记录高亮显示
+func (g *Game) drawHighScores(screen *ebiten.Image, y float64, rank int) {
+	drawCenteredText(screen, "高分榜", 20, y, color.RGBA{192, 192, 192, 255})
+	if len(g.scores.Entries) == 0 {
+		drawCenteredText(screen, "暂无记录", 16, y+30, color.RGBA{128, 128, 128, 255})
 		return
 	}
+	for i, e := range g.scores.Entries {
+		clr := color.Color(color.White)
+		if i == rank {
+			clr = color.RGBA{255, 215, 0, 255}
+		}
+		msg := fmt.Sprintf("%2d. %-12s %8d  第 %d 关", i+1, e.Name, e.Score, e.Stage)
+		drawCenteredText(screen, msg, 16, y+30+float64(i)*22, clr)
+	}
+}
 
+// drawWorld 绘制状态栏和游戏世界
+func (g *Game) drawWorld(screen *ebiten.Image) {
 	g.drawStatusBar(screen)
-	g.drawPlayerTank(screen)
-	g.drawPlayerBullets(screen)
+	g.drawWalls(screen)
+	g.drawPlayerTanks(screen)
 	g.drawBossTank(screen)
-	g.drawBossBullets(screen)
 	g.drawEnemyTanks(screen)
-	g.drawEnemyBullets(screen)
-	g.drawWalls(screen)
+	g.drawBullets(screen)
+	g.drawEffects(screen)
+	g.drawForest(screen)
+	g.drawPowerUps(screen)
+	g.drawBossHealthBar(screen)
+}
+
+// Draw 绘制游戏画面
+func (g *Game) Draw(screen *ebiten.Image) {
+	g.scene.draw(g, screen)
 }
 
 // Layout 返回游戏画面的布局
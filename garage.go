@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+const (
+	// coinScore 每得多少分获得一枚金币
+	coinScore = 100
+	// perkBaseCost 永久强化第一级的价格，之后每级递增同样的金币
+	perkBaseCost = 20
+)
+
+// garage 表示保存在用户配置目录中的车库：攒下的金币和已经购买的永久强化
+type garage struct {
+	Coins int       `json:"coins"`
+	Perks sim.Perks `json:"perks"`
+}
+
+// loadGarage 读取车库，读取失败时返回空的车库
+func loadGarage() *garage {
+	gr := &garage{}
+	if err := loadJSONFile("garage.json", gr); err != nil {
+		return &garage{}
+	}
+	return gr
+}
+
+// save 保存车库
+func (gr *garage) save() error {
+	return saveJSONFile("garage.json", gr)
+}
+
+// earn 按一关中获得的分数发放金币，返回本次获得的金币数
+func (gr *garage) earn(score int) int {
+	coins := max(0, score/coinScore)
+	gr.Coins += coins
+	return coins
+}
+
+// perkItem 描述车库中出售的一项永久强化
+type perkItem struct {
+	name  string
+	desc  string
+	level func(p *sim.Perks) *int
+}
+
+// perkItems 车库中出售的永久强化
+var perkItems = []perkItem{
+	{name: "装甲", desc: "生命值 +1", level: func(p *sim.Perks) *int { return &p.Armor }},
+	{name: "引擎", desc: "移动速度提高", level: func(p *sim.Perks) *int { return &p.Engine }},
+	{name: "护盾", desc: "每关开局获得 3 秒护盾", level: func(p *sim.Perks) *int { return &p.Shield }},
+}
+
+// perkCost 返回把强化从 level 级升到下一级的价格
+func perkCost(level int) int {
+	return (level + 1) * perkBaseCost
+}
+
+// buy 购买一级强化，金币不足或已经满级时返回 false
+func (gr *garage) buy(item perkItem) bool {
+	level := item.level(&gr.Perks)
+	if *level >= sim.MaxPerkLevel || gr.Coins < perkCost(*level) {
+		return false
+	}
+	gr.Coins -= perkCost(*level)
+	*level++
+	return true
+}
+
+// stageScore 返回本关获得的分数，即当前总分减去带入本关的总分
+func (g *Game) stageScore() int {
+	score := g.world.TotalScore()
+	if g.stageCarry != nil {
+		for _, pc := range g.stageCarry.Players {
+			score -= pc.Score
+		}
+	}
+	return score
+}
+
+// garageScene 两关之间的车库，用金币购买永久强化后进入下一关
+type garageScene struct {
+	next   int        // 下一关的下标
+	carry  *sim.Carry // 带入下一关的玩家状态
+	earned int        // 刚通过的一关获得的金币
+	menu   menu
+	notice string // 上一次购买失败的原因
+}
+
+func (s *garageScene) enter(g *Game) {
+	s.build(g)
+}
+
+// build 根据当前的强化等级和金币生成菜单项，保留光标位置
+func (s *garageScene) build(g *Game) {
+	s.menu.items = nil
+	for _, item := range perkItems {
+		level := *item.level(&g.garage.Perks)
+		label := fmt.Sprintf("%s Lv%d/%d  %s", item.name, level, sim.MaxPerkLevel, item.desc)
+		if level < sim.MaxPerkLevel {
+			label += fmt.Sprintf("  %d 金币", perkCost(level))
+		}
+		s.menu.items = append(s.menu.items, menuItem{label: label, action: func(g *Game) error {
+			s.notice = ""
+			if !g.garage.buy(item) {
+				s.notice = "金币不足或已经满级"
+				return nil
+			}
+			if err := g.garage.save(); err != nil {
+				log.Println("save garage:", err)
+			}
+			s.build(g)
+			return nil
+		}})
+	}
+	s.menu.items = append(s.menu.items, menuItem{label: fmt.Sprintf("进入第 %d 关", s.next+1), action: func(g *Game) error {
+		g.startRun(s.next, s.carry)
+		return nil
+	}})
+}
+
+func (s *garageScene) exit(g *Game) {}
+
+func (s *garageScene) update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.startRun(s.next, s.carry)
+		return nil
+	}
+	return s.menu.update(g)
+}
+
+func (s *garageScene) draw(g *Game, screen *ebiten.Image) {
+	drawCenteredText(screen, "车库", 36, 40, color.White)
+	drawCenteredText(screen, fmt.Sprintf("本关获得 %d 金币，共有 %d 金币", s.earned, g.garage.Coins), 18, 100, color.RGBA{255, 215, 0, 255})
+	drawCenteredText(screen, "强化永久有效，对之后的每一关都生效", 16, 130, color.RGBA{192, 192, 192, 255})
+	s.menu.draw(screen, 190)
+	if s.notice != "" {
+		drawCenteredText(screen, s.notice, 16, 400, color.RGBA{255, 0, 0, 255})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// 高分榜保留的记录数
+	highScoreCount = 10
+	// 玩家名字的最大长度
+	maxNameLength = 12
+)
+
+// scoreEntry 表示高分榜中的一条记录
+type scoreEntry struct {
+	Name  string    `json:"name"`
+	Score int       `json:"score"`
+	Stage int       `json:"stage"`
+	Date  time.Time `json:"date"`
+}
+
+// highScores 表示保存在用户配置目录中的高分榜
+type highScores struct {
+	Entries []scoreEntry `json:"entries"`
+}
+
+// loadHighScores 读取高分榜，读取失败时返回空的高分榜
+func loadHighScores() *highScores {
+	h := &highScores{}
+	if err := loadJSONFile("highscores.json", h); err != nil {
+		return &highScores{}
+	}
+	return h
+}
+
+// save 保存高分榜
+func (h *highScores) save() error {
+	return saveJSONFile("highscores.json", h)
+}
+
+// qualifies 判断分数是否可以进入高分榜
+func (h *highScores) qualifies(score int) bool {
+	if score <= 0 {
+		return false
+	}
+	if len(h.Entries) < highScoreCount {
+		return true
+	}
+	return score > h.Entries[len(h.Entries)-1].Score
+}
+
+// add 将记录加入高分榜，返回其名次（从 0 开始），没有进入高分榜时返回 -1
+func (h *highScores) add(e scoreEntry) int {
+	if !h.qualifies(e.Score) {
+		return -1
+	}
+	h.Entries = append(h.Entries, e)
+	sort.SliceStable(h.Entries, func(i, j int) bool {
+		return h.Entries[i].Score > h.Entries[j].Score
+	})
+	if len(h.Entries) > highScoreCount {
+		h.Entries = h.Entries[:highScoreCount]
+	}
+	for i := range h.Entries {
+		if h.Entries[i] == e {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/netplay"
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+const (
+	// discoverTimeout 搜索局域网主机时等待回复的时间
+	discoverTimeout = time.Second
+	// netCatchUp 联网对局中每次更新最多推进的帧数，落后于其他玩家时用于追赶
+	netCatchUp = 4
+)
+
+// lanOptions 局域网对战的设置
+type lanOptions struct {
+	name      string // 本地玩家的名称
+	port      int    // 创建主机时监听的端口
+	broadcast string // 搜索主机时使用的广播地址
+	delay     int    // 创建主机时选择的输入延迟帧数，小于 0 时使用同步方式的默认值
+}
+
+// inputDelay 返回主机开始对局时使用的输入延迟帧数
+func (o lanOptions) inputDelay(rollback bool) int {
+	switch {
+	case o.delay >= 0:
+		return o.delay
+	case rollback:
+		return netplay.DefaultRollbackDelay
+	}
+	return netplay.DefaultDelay
+}
+
+// ConfigureLAN 设置局域网对战使用的玩家名称、主机端口、广播地址和输入延迟，
+// delay 小于 0 时按同步方式使用默认的延迟
+func (g *Game) ConfigureLAN(name string, port int, broadcast string, delay int) {
+	g.lan = lanOptions{name: name, port: port, broadcast: broadcast, delay: delay}
+}
+
+// netMatch 表示一场联网对局，锁步同步的 netplay.Session 和回滚同步的
+// netplay.Rollback 都实现了它
+type netMatch interface {
+	Poll() error
+	Close()
+	Seed() int64
+	Slot() int
+	Players() int
+	Config() sim.Config
+	Campaign() string
+}
+
+// lobbyMatch 返回大厅中已经开始的对局，还没有开始时返回 nil
+func lobbyMatch(l *netplay.Lobby) netMatch {
+	if s := l.Session(); s != nil {
+		return s
+	}
+	if r := l.Rollback(); r != nil {
+		return r
+	}
+	return nil
+}
+
+// HostLAN 创建局域网主机并进入大厅
+func (g *Game) HostLAN() error {
+	lobby, err := netplay.HostLobby(fmt.Sprintf(":%d", g.lan.port), g.lan.name, sim.MaxPlayers)
+	if err != nil {
+		return err
+	}
+	g.switchScene(&lobbyScene{lobby: lobby})
+	return nil
+}
+
+// JoinLAN 加入 addr 上的局域网主机并进入大厅
+func (g *Game) JoinLAN(addr string) error {
+	lobby, err := netplay.JoinLobby(addr, g.lan.name)
+	if err != nil {
+		return err
+	}
+	g.switchScene(&lobbyScene{lobby: lobby})
+	return nil
+}
+
+// startNet 联网对局开始后从第一关开始游戏，各玩家必须使用相同的战役
+func (g *Game) startNet(s netMatch) error {
+	if s.Campaign() != g.campaign.Name {
+		s.Close()
+		return fmt.Errorf("主机使用的战役“%s”与本地的“%s”不同", s.Campaign(), g.campaign.Name)
+	}
+	g.net = s
+	g.seed = s.Seed()
+	g.players = s.Players()
+	g.startRun(0, nil)
+	return nil
+}
+
+// leaveNet 离开正在进行的联网对局
+func (g *Game) leaveNet() {
+	if g.net != nil {
+		g.net.Close()
+		g.net = nil
+	}
+}
+
+// pollNet 收发联网对局的报文，连接中断时切换到提示画面并返回 false
+func (g *Game) pollNet() bool {
+	if g.net == nil {
+		return true
+	}
+	if err := g.net.Poll(); err != nil {
+		g.leaveNet()
+		g.switchScene(&messageScene{title: "联机中断", msg: netErrorText(err)})
+		return false
+	}
+	return true
+}
+
+// stepNet 提交本地玩家的输入并推进模拟，没有推进任何一帧时返回 false。
+// 锁步同步用已经确认的各帧输入推进，并每隔 netplay.HashInterval 帧提交一次
+// 状态哈希；回滚同步预测对方的输入先行推进，需要时回滚重新模拟。
+func (g *Game) stepNet(input sim.Input) bool {
+	switch m := g.net.(type) {
+	case *netplay.Rollback:
+		m.AddInput(input)
+		return m.Advance(g.world)
+	case *netplay.Session:
+		m.AddInput(input)
+		stepped := false
+		for i := 0; i < netCatchUp && !g.world.Finished(); i++ {
+			inputs, ok := m.Next()
+			if !ok {
+				break
+			}
+			g.step(inputs)
+			stepped = true
+			if m.Tick()%netplay.HashInterval == 0 {
+				m.ReportHash(m.Tick(), g.world.Hash())
+			}
+		}
+		return stepped
+	}
+	return false
+}
+
+// result 返回当前关卡的胜负，回滚同步中只有对方的输入全部确认后结果才算数
+func (g *Game) result() sim.Result {
+	if r, ok := g.net.(*netplay.Rollback); ok && !r.Confirmed() {
+		return sim.ResultNone
+	}
+	return g.world.Result
+}
+
+// drawNetDebug 在画面左下角显示联网同步的调试信息，按 F3 键开关
+func (g *Game) drawNetDebug(screen *ebiten.Image) {
+	if !g.netDebug {
+		return
+	}
+	var msg string
+	switch m := g.net.(type) {
+	case *netplay.Rollback:
+		st := m.Stats()
+		msg = fmt.Sprintf("ROLLBACK tick %d delay %d\nrollbacks %d resim %d depth %d/%d\nadvantage %+d remote %+d stalls %d",
+			m.Tick(), st.Delay, st.Rollbacks, st.Resimulated, st.LastDepth, st.MaxDepth, st.Advantage, st.RemoteAdvantage, st.Stalls)
+	case *netplay.Session:
+		msg = fmt.Sprintf("LOCKSTEP tick %d waiting %v", m.Tick(), m.Waiting())
+	default:
+		return
+	}
+	ebitenutil.DebugPrintAt(screen, msg, 2, screenHeight-50)
+}
+
+// netErrorText 返回联网错误的说明文字
+func netErrorText(err error) string {
+	var desync *netplay.DesyncError
+	switch {
+	case errors.As(err, &desync):
+		return fmt.Sprintf("第 %d 帧出现不同步", desync.Tick)
+	case errors.Is(err, netplay.ErrPeerLost):
+		return "与其他玩家的连接已断开"
+	case errors.Is(err, netplay.ErrPeerLeft):
+		return "有玩家离开了对局"
+	case errors.Is(err, netplay.ErrRejected):
+		return "主机拒绝加入，人数已满或对局已经开始"
+	case errors.Is(err, netplay.ErrNoHost):
+		return "主机没有回应"
+	case errors.Is(err, netplay.ErrRollbackPlayers):
+		return "回滚同步只支持两名玩家"
+	case errors.Is(err, netplay.ErrNotReady):
+		return "至少需要两名玩家并且全部准备好"
+	}
+	return err.Error()
+}
+
+// discoverResult 后台搜索主机的结果
+type discoverResult struct {
+	hosts []netplay.HostInfo
+	err   error
+}
+
+// lanScene 局域网对战菜单，可以创建主机或搜索并加入局域网中的主机
+type lanScene struct {
+	menu   menu
+	result chan discoverResult // 正在搜索时不为 nil
+	hosts  []netplay.HostInfo
+	status string
+}
+
+func (s *lanScene) enter(g *Game) {
+	s.build()
+}
+
+// build 根据搜索到的主机生成菜单项
+func (s *lanScene) build() {
+	s.menu.items = []menuItem{
+		{label: "创建主机", action: func(g *Game) error {
+			if err := g.HostLAN(); err != nil {
+				s.status = err.Error()
+			}
+			return nil
+		}},
+		{label: "搜索主机", action: func(g *Game) error {
+			s.search(g)
+			return nil
+		}},
+	}
+	for _, h := range s.hosts {
+		addr := h.Addr.String()
+		label := fmt.Sprintf("加入 %s（%s）%d/%d", h.Name, addr, h.Players, h.MaxPlayers)
+		s.menu.items = append(s.menu.items, menuItem{label: label, action: func(g *Game) error {
+			if err := g.JoinLAN(addr); err != nil {
+				s.status = err.Error()
+			}
+			return nil
+		}})
+	}
+	s.menu.items = append(s.menu.items, menuItem{label: "返回", action: func(g *Game) error {
+		g.switchScene(&menuScene{})
+		return nil
+	}})
+	s.menu.cursor = min(s.menu.cursor, len(s.menu.items)-1)
+}
+
+// search 在后台搜索局域网中的主机，不阻塞游戏循环
+func (s *lanScene) search(g *Game) {
+	if s.result != nil {
+		return
+	}
+	s.status = "正在搜索……"
+	s.result = make(chan discoverResult, 1)
+	go func(broadcast string, result chan<- discoverResult) {
+		hosts, err := netplay.Discover(broadcast, discoverTimeout)
+		result <- discoverResult{hosts: hosts, err: err}
+	}(g.lan.broadcast, s.result)
+}
+
+func (s *lanScene) exit(g *Game) {}
+
+func (s *lanScene) update(g *Game) error {
+	if s.result != nil {
+		select {
+		case r := <-s.result:
+			s.result = nil
+			s.hosts = r.hosts
+			switch {
+			case r.err != nil:
+				s.status = r.err.Error()
+			case len(r.hosts) == 0:
+				s.status = "没有找到主机"
+			default:
+				s.status = fmt.Sprintf("找到 %d 个主机", len(r.hosts))
+			}
+			s.build()
+		default:
+		}
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.switchScene(&menuScene{})
+		return nil
+	}
+	return s.menu.update(g)
+}
+
+func (s *lanScene) draw(g *Game, screen *ebiten.Image) {
+	drawCenteredText(screen, "局域网对战", 32, 60, color.White)
+	drawCenteredText(screen, "玩家名称："+g.lan.name, 16, 110, color.RGBA{192, 192, 192, 255})
+	s.menu.draw(screen, 160)
+	drawCenteredText(screen, s.status, 16, 430, color.RGBA{255, 215, 0, 255})
+}
+
+// lobbyScene 对局开始前的大厅，所有玩家准备好后由主机开始对局
+type lobbyScene struct {
+	lobby  *netplay.Lobby
+	status string
+}
+
+func (s *lobbyScene) enter(g *Game) {}
+
+func (s *lobbyScene) exit(g *Game) {}
+
+func (s *lobbyScene) update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		s.lobby.Close()
+		g.switchScene(&lanScene{})
+		return nil
+	}
+
+	if err := s.lobby.Poll(); err != nil {
+		s.lobby.Close()
+		g.switchScene(&messageScene{title: "无法加入", msg: netErrorText(err)})
+		return nil
+	}
+
+	if s.lobby.IsHost() && lobbyMatch(s.lobby) == nil {
+		if inpututil.IsKeyJustPressed(ebiten.KeyM) {
+			s.lobby.UseRollback(!s.lobby.UsesRollback())
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+			if err := s.start(g); err != nil {
+				s.status = netErrorText(err)
+			}
+		}
+	}
+	if m := lobbyMatch(s.lobby); m != nil {
+		if err := g.startNet(m); err != nil {
+			g.switchScene(&messageScene{title: "无法开始", msg: err.Error()})
+		}
+		return nil
+	}
+
+	if s.lobby.Slot() >= 0 && (inpututil.IsKeyJustPressed(ebiten.KeyR) || inpututil.IsKeyJustPressed(ebiten.KeySpace)) {
+		s.lobby.SetReady(!s.lobby.Ready())
+	}
+	return nil
+}
+
+// start 由主机按选择的同步方式开始对局，回滚同步为打开友军伤害的双人对战
+func (s *lobbyScene) start(g *Game) error {
+	seed := time.Now().UnixNano()
+	cfg := g.config()
+	if s.lobby.UsesRollback() {
+		cfg.FriendlyFire = true
+		_, err := s.lobby.StartRollback(seed, cfg, g.campaign.Name, g.lan.inputDelay(true))
+		return err
+	}
+	_, err := s.lobby.Start(seed, cfg, g.campaign.Name, g.lan.inputDelay(false))
+	return err
+}
+
+func (s *lobbyScene) draw(g *Game, screen *ebiten.Image) {
+	title := "局域网大厅（客户端）"
+	if s.lobby.IsHost() {
+		title = "局域网大厅（主机）"
+	}
+	drawCenteredText(screen, title, 28, 60, color.White)
+
+	if s.lobby.Slot() < 0 {
+		drawCenteredText(screen, "正在连接主机……", 20, 200, color.RGBA{192, 192, 192, 255})
+		return
+	}
+
+	mode := "同步方式：锁步（合作）"
+	if s.lobby.UsesRollback() {
+		mode = "同步方式：回滚（双人对战）"
+	}
+	drawCenteredText(screen, mode, 18, 100, color.RGBA{192, 192, 192, 255})
+
+	for i, p := range s.lobby.Players() {
+		state := "未准备"
+		if p.Ready {
+			state = "已准备"
+		}
+		clr := color.Color(color.RGBA{192, 192, 192, 255})
+		if i == s.lobby.Slot() {
+			clr = playerColors[i]
+		}
+		drawCenteredText(screen, fmt.Sprintf("%dP  %-12s  %s", i+1, p.Name, state), 20, 150+float64(i)*32, clr)
+	}
+
+	help := "R 键准备/取消准备，Esc 键离开"
+	if s.lobby.IsHost() {
+		help = "R 键准备/取消准备，M 键切换同步方式，全员准备后按回车键开始，Esc 键离开"
+	}
+	drawCenteredText(screen, help, 16, 400, color.RGBA{192, 192, 192, 255})
+	drawCenteredText(screen, s.status, 16, 430, color.RGBA{255, 215, 0, 255})
+}
+
+// messageScene 显示一条提示，按回车键或 Esc 键返回主菜单
+type messageScene struct {
+	title string
+	msg   string
+}
+
+func (s *messageScene) enter(g *Game) {}
+
+func (s *messageScene) exit(g *Game) {}
+
+func (s *messageScene) update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.switchScene(&menuScene{})
+	}
+	return nil
+}
+
+func (s *messageScene) draw(g *Game, screen *ebiten.Image) {
+	drawCenteredText(screen, s.title, 32, 150, color.RGBA{255, 0, 0, 255})
+	drawCenteredText(screen, s.msg, 18, 210, color.White)
+	drawCenteredText(screen, "按回车键返回主菜单", 16, 300, color.RGBA{192, 192, 192, 255})
+}
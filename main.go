@@ -1,53 +1,159 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"os"
+	"time"
 
+	"github.com/ewangplay/golang-exercises/pkgs/tank/netplay"
+	"github.com/ewangplay/golang-exercises/pkgs/tank/replay"
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
 	"github.com/hajimehoshi/ebiten/v2"
 )
 
 const (
-	// 屏幕宽高
-	screenWidth = 640
+	// 屏幕宽度
+	screenWidth = sim.ScreenWidth
 	// 屏幕高度
-	screenHeight = 480
-	// 坦克的速度
-	tankSpeed = 2
-	// 子弹速度
-	bulletSpeed = 5
-	// 每 30 帧改变一次方向
-	changeDirInterval = 30
-	// 每 5 帧射击一次
-	shootInterval = 5
-	// 最大敌方坦克数量
-	maxEnemyTankCount = 10
-	// 最大墙的数量
-	maxWallCount = 5
-	// 墙的检测间隔，单位为秒
-	wallCheckInterval = 60
-	// 敌方坦克的检测间隔，单位为秒
-	enemyTankCheckInterval = 60
+	screenHeight = sim.ScreenHeight
 	// 状态栏的高度
-	statusBarHeight = 20
-	// 玩家坦克的生命值
-	playerTankHP = 3
-	// Boss 坦克的生命值
-	bossTankHP = 100
-	// 敌方坦克的生命值
-	enemyTankHP = 1
-	// 墙的坚固值
-	wallHP = 5
-	// Boss坦克容忍的最长尾随时间
-	bossToleranceTime = 3
+	statusBarHeight = sim.StatusBarHeight
 )
 
+// loadCampaign 加载战役。指定了关卡文件时只包含这一关，
+// 战役文件不存在时使用默认战役。
+func loadCampaign(campaignFile, levelFile string) (*sim.Campaign, error) {
+	if levelFile != "" {
+		level, err := sim.LoadLevel(levelFile)
+		if err != nil {
+			return nil, err
+		}
+		return &sim.Campaign{Name: levelFile, Stages: []*sim.Level{level}}, nil
+	}
+
+	campaign, err := sim.LoadCampaign(campaignFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return sim.DefaultCampaign(), nil
+	}
+	return campaign, err
+}
+
+// loadEnemyTypes 从文件中加载敌方坦克的种类并用于战役的每一关，
+// 文件不存在时使用内置的种类
+func loadEnemyTypes(campaign *sim.Campaign, path string) error {
+	types, err := sim.LoadEnemyTypes(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return campaign.SetEnemyTypes(types)
+}
+
+// defaultPlayerName 返回局域网对战中默认的玩家名称，即本机的主机名
+func defaultPlayerName() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "玩家"
+	}
+	return name
+}
+
 func main() {
+	seed := flag.Int64("seed", 0, "随机种子，0 表示使用当前时间")
+	record := flag.String("record", "", "将本局录像保存到指定文件")
+	replayFile := flag.String("replay", "", "回放指定的录像文件")
+	levelFile := flag.String("level", "", "只玩指定的关卡文件")
+	campaignFile := flag.String("campaign", "levels/campaign.txt", "战役文件")
+	enemiesFile := flag.String("enemies", "levels/enemies.txt", "敌方坦克种类的定义文件，不存在时使用内置的种类")
+	host := flag.Bool("host", false, "启动后直接创建局域网主机")
+	join := flag.String("join", "", "启动后直接加入指定地址的局域网主机，例如 192.168.1.10:7777")
+	name := flag.String("name", defaultPlayerName(), "局域网对战中显示的玩家名称")
+	port := flag.Int("port", netplay.DefaultPort, "创建局域网主机时监听的 UDP 端口")
+	broadcast := flag.String("broadcast", fmt.Sprintf("255.255.255.255:%d", netplay.DefaultPort), "搜索局域网主机时使用的广播地址")
+	delay := flag.Int("delay", -1, "创建局域网主机时的输入延迟帧数，-1 表示按同步方式使用默认值")
+	serverAddr := flag.String("server", "", "启动后直接连接指定地址的专用服务器，例如 example.com:7778")
+	room := flag.String("room", "default", "连接专用服务器时加入的房间")
+	spectate := flag.Bool("spectate", false, "连接专用服务器时以观战者身份加入")
+	stream := flag.String("stream", "", "把对局写入观战流文件")
+	watch := flag.String("watch", "", "观看观战流文件")
+	difficulty := flag.String("difficulty", "normal", "难度：easy、normal、hard 或 nightmare")
+	adaptive := flag.Bool("adaptive", false, "根据玩家的表现动态调整难度，调整记录写入用户配置目录中的 adaptive.log")
+	movement := flag.String("movement", "classic", "玩家坦克的移动方式：classic、eight 或 analog")
+	flag.Parse()
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	var game *Game
+	if *replayFile != "" {
+		r, err := replay.Load(*replayFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		game = NewReplayGame(r)
+	} else {
+		campaign, err := loadCampaign(*campaignFile, *levelFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := loadEnemyTypes(campaign, *enemiesFile); err != nil {
+			log.Fatal(err)
+		}
+		d, err := sim.ParseDifficulty(*difficulty)
+		if err != nil {
+			log.Fatal(err)
+		}
+		game = NewGame(*seed, campaign, loadProgress(), loadHighScores(), loadGarage())
+		game.ConfigureDifficulty(d, *adaptive)
+		m, err := sim.ParseMovementMode(*movement)
+		if err != nil {
+			log.Fatal(err)
+		}
+		game.ConfigureMovement(m)
+		if *record != "" {
+			game.StartRecording()
+		}
+		if *stream != "" {
+			game.StartStream(*stream)
+		}
+		game.ConfigureLAN(*name, *port, *broadcast, *delay)
+		switch {
+		case *host:
+			err = game.HostLAN()
+		case *join != "":
+			err = game.JoinLAN(*join)
+		case *watch != "":
+			err = game.WatchStream(*watch)
+		case *serverAddr != "" && *spectate:
+			err = game.SpectateServer(*serverAddr, *room)
+		case *serverAddr != "":
+			err = game.JoinServer(*serverAddr, *room)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	ebiten.SetWindowSize(screenWidth, screenHeight)
 	ebiten.SetWindowTitle("Tank Game")
-	game := NewGame()
 	if err := ebiten.RunGame(game); err != nil {
 		log.Fatal(err)
 	}
+
+	if err := game.FinishStream(); err != nil {
+		log.Fatal(err)
+	}
+	if r := game.FinishRecording(); r != nil {
+		if err := r.Save(*record); err != nil {
+			log.Fatal(err)
+		}
+	}
 	fmt.Println("Game Over")
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"slices"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// stickDeadZone 摇杆偏离中心不超过这个比例时当作没有推动
+const stickDeadZone = 0.3
+
+// movementLabels 菜单中显示的移动方式名称
+var movementLabels = map[sim.MovementMode]string{
+	sim.MoveClassic:  "经典四方向",
+	sim.MoveEightWay: "八方向",
+	sim.MoveAnalog:   "自由移动",
+}
+
+// nextMovement 返回菜单中 m 之后的移动方式，最后一种之后回到第一种
+func nextMovement(m sim.MovementMode) sim.MovementMode {
+	all := sim.MovementModes()
+	for i, v := range all {
+		if v == m {
+			return all[(i+1)%len(all)]
+		}
+	}
+	return sim.MoveClassic
+}
+
+// ConfigureMovement 设置本地游戏和创建局域网主机时玩家坦克的移动方式
+func (g *Game) ConfigureMovement(m sim.MovementMode) {
+	g.movement = m
+}
+
+// angleOf 返回向量 (dx, dy) 的方向，屏幕坐标中 y 轴向下
+func angleOf(dx, dy float64) sim.Angle {
+	// 0 为正上方，顺时针增大
+	turns := math.Atan2(dx, -dy) / (2 * math.Pi)
+	return sim.Angle(int(math.Round(turns*256)) & 0xff)
+}
+
+// readInput 读取本地第 local 名玩家的输入，slot 为其在世界中的序号。
+// 八方向和自由模式下还读取手柄的摇杆和按键，1 号本地玩家可以用鼠标瞄准和射击。
+func (g *Game) readInput(local, slot int) sim.Input {
+	input := readPlayerInput(playerKeys[local])
+	if g.world == nil || g.world.Config().Movement == sim.MoveClassic {
+		return input
+	}
+
+	ids := slices.Sorted(slices.Values(ebiten.AppendGamepadIDs(nil)))
+	if local < len(ids) && ebiten.IsStandardGamepadLayoutAvailable(ids[local]) {
+		input = readGamepad(ids[local], input)
+	}
+	if local == 0 {
+		input = g.readMouse(slot, input)
+	}
+	return input
+}
+
+// readGamepad 把标准布局手柄的输入合并到 input 中：左摇杆和十字键移动，
+// 右摇杆瞄准，A 键或右扳机射击
+func readGamepad(id ebiten.GamepadID, input sim.Input) sim.Input {
+	stick := func(h, v ebiten.StandardGamepadAxis) (float64, float64, bool) {
+		x, y := ebiten.StandardGamepadAxisValue(id, h), ebiten.StandardGamepadAxisValue(id, v)
+		return x, y, math.Hypot(x, y) > stickDeadZone
+	}
+	if x, y, ok := stick(ebiten.StandardGamepadAxisLeftStickHorizontal, ebiten.StandardGamepadAxisLeftStickVertical); ok {
+		input = input.WithMove(angleOf(x, y))
+	}
+	if x, y, ok := stick(ebiten.StandardGamepadAxisRightStickHorizontal, ebiten.StandardGamepadAxisRightStickVertical); ok {
+		input = input.WithAim(angleOf(x, y))
+	}
+
+	buttons := []struct {
+		button ebiten.StandardGamepadButton
+		input  sim.Input
+	}{
+		{ebiten.StandardGamepadButtonLeftTop, sim.InputUp},
+		{ebiten.StandardGamepadButtonLeftRight, sim.InputRight},
+		{ebiten.StandardGamepadButtonLeftBottom, sim.InputDown},
+		{ebiten.StandardGamepadButtonLeftLeft, sim.InputLeft},
+	}
+	for _, b := range buttons {
+		if ebiten.IsStandardGamepadButtonPressed(id, b.button) {
+			input |= b.input
+		}
+	}
+	for _, b := range []ebiten.StandardGamepadButton{ebiten.StandardGamepadButtonRightBottom, ebiten.StandardGamepadButtonFrontBottomRight} {
+		if inpututil.IsStandardGamepadButtonJustPressed(id, b) {
+			input |= sim.InputFire
+		}
+		if ebiten.IsStandardGamepadButtonPressed(id, b) {
+			input |= sim.InputFireHeld
+		}
+	}
+	return input
+}
+
+// readMouse 把鼠标的输入合并到 input 中：鼠标在画面中移动过之后炮塔瞄准光标，
+// 左键射击。右摇杆已经在瞄准时不使用鼠标。
+func (g *Game) readMouse(slot int, input sim.Input) sim.Input {
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		input |= sim.InputFire
+	}
+	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
+		input |= sim.InputFireHeld
+	}
+
+	x, y := ebiten.CursorPosition()
+	if x != g.cursorX || y != g.cursorY {
+		g.cursorX, g.cursorY = x, y
+		g.mouseAim = true
+	}
+	if _, ok := input.Aim(); ok || !g.mouseAim || slot < 0 || slot >= len(g.world.Players) {
+		return input
+	}
+	tank := g.world.Players[slot].Tank
+	if tank == nil {
+		return input
+	}
+	dx := float64(x) - float64(tank.X+sim.TankSize/2)
+	dy := float64(y) - float64(tank.Y+sim.TankSize/2)
+	if dx == 0 && dy == 0 {
+		return input
+	}
+	return input.WithAim(angleOf(dx, dy))
+}
+
+// drawFreeTank 绘制八方向和自由模式下的玩家坦克：按车身角度旋转的车身、
+// 车头的横线、居中的炮塔和指向炮塔角度的炮管。两星起炮管加粗，三星时车身加上金色的边框。
+func drawFreeTank(screen *ebiten.Image, tank *sim.Tank, clr color.Color) {
+	const half float32 = sim.TankSize / 2
+	cx, cy := tank.X+half, tank.Y+half
+	fx, fy := tank.Angle.Vector()
+	sx, sy := -fy, fx
+
+	// 宽度等于车身边长的线段就是一个旋转后的正方形
+	vector.StrokeLine(screen, cx-fx*half, cy-fy*half, cx+fx*half, cy+fy*half, sim.TankSize, clr, false)
+	front := half - 2
+	shade := color.RGBA{0, 0, 0, 128}
+	vector.StrokeLine(screen, cx+(fx-sx)*front, cy+(fy-sy)*front, cx+(fx+sx)*front, cy+(fy+sy)*front, 2, shade, false)
+	if tank.Stars >= sim.MaxStars {
+		corners := [4][2]float32{{1, 1}, {1, -1}, {-1, -1}, {-1, 1}}
+		for i, a := range corners {
+			b := corners[(i+1)%len(corners)]
+			x0, y0 := cx+(fx*a[0]+sx*a[1])*(half-1), cy+(fy*a[0]+sy*a[1])*(half-1)
+			x1, y1 := cx+(fx*b[0]+sx*b[1])*(half-1), cy+(fy*b[0]+sy*b[1])*(half-1)
+			vector.StrokeLine(screen, x0, y0, x1, y1, 2, starColor, false)
+		}
+	}
+
+	tx, ty := tank.Turret.Vector()
+	width := float32(2)
+	if tank.Stars >= 2 {
+		width = 4
+	}
+	vector.StrokeLine(screen, cx, cy, cx+tx*sim.TankSize, cy+ty*sim.TankSize, width, clr, false)
+	vector.DrawFilledCircle(screen, cx, cy, 6, clr, false)
+	vector.StrokeCircle(screen, cx, cy, 6, 1, shade, false)
+	for i := 0; i < tank.Stars; i++ {
+		vector.DrawFilledCircle(screen, cx+float32(i-1)*4, cy, 1.5, starColor, false)
+	}
+}
@@ -0,0 +1,467 @@
+package netplay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+const (
+	// JoinTimeout 加入大厅时等待主机回复的最长时间
+	JoinTimeout = 5 * time.Second
+	// 大厅中重发状态的间隔
+	lobbyInterval = 200 * time.Millisecond
+)
+
+var (
+	// ErrRejected 表示主机拒绝加入，通常是因为人数已满或对局已经开始
+	ErrRejected = errors.New("netplay: host rejected the join request")
+	// ErrNoHost 表示主机没有回复加入请求
+	ErrNoHost = errors.New("netplay: host did not answer")
+	// ErrNotReady 表示还有玩家没有准备好，或者人数不足
+	ErrNotReady = errors.New("netplay: not all players are ready")
+)
+
+// HostInfo 表示在局域网中发现的一个主机
+type HostInfo struct {
+	Name       string
+	Addr       *net.UDPAddr
+	Players    int
+	MaxPlayers int
+}
+
+// Discover 向 broadcast 地址广播查找请求，返回在 timeout 内回复的主机。
+// 在同一台机器上测试时可以使用 127.255.255.255 加端口作为广播地址。
+func Discover(broadcast string, timeout time.Duration) ([]HostInfo, error) {
+	addr, err := net.ResolveUDPAddr("udp4", broadcast)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP(appendHeader(nil, packetDiscover), addr); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	var hosts []HostInfo
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return hosts, nil
+			}
+			return hosts, err
+		}
+		typ, data, err := parseHeader(buf[:n])
+		if err != nil || typ != packetAnnounce {
+			continue
+		}
+		var msg announceMsg
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		known := false
+		for _, h := range hosts {
+			if sameAddr(h.Addr, from) {
+				known = true
+			}
+		}
+		if !known {
+			hosts = append(hosts, HostInfo{Name: msg.Name, Addr: from, Players: msg.Players, MaxPlayers: msg.MaxPlayers})
+		}
+	}
+}
+
+// lobbyPeer 主机记录的一个客户端
+type lobbyPeer struct {
+	addr     *net.UDPAddr
+	name     string
+	ready    bool
+	lastSeen time.Time
+}
+
+// Lobby 表示对局开始前的大厅，主机和客户端各有一个
+type Lobby struct {
+	t    *transport
+	host bool
+	name string
+	slot int
+
+	// 主机使用的状态
+	maxPlayers int
+	peers      []*lobbyPeer
+	ready      bool
+
+	// 客户端使用的状态
+	hostAddr *net.UDPAddr
+	hostSeen time.Time
+	joined   bool
+	players  []LobbyPlayer
+
+	rollbackMode bool // 主机是否选择了回滚同步，客户端从大厅状态中得知
+	session      *Session
+	rollback     *Rollback
+	lastSend     time.Time
+	err          error
+}
+
+// HostLobby 在 addr（例如 ":7777"）上创建主机大厅，最多容纳 maxPlayers 名玩家
+func HostLobby(addr, name string, maxPlayers int) (*Lobby, error) {
+	if maxPlayers < 2 || maxPlayers > sim.MaxPlayers {
+		return nil, fmt.Errorf("netplay: max players must be between 2 and %d", sim.MaxPlayers)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &Lobby{
+		t:          newTransport(conn),
+		host:       true,
+		name:       name,
+		maxPlayers: maxPlayers,
+	}, nil
+}
+
+// JoinLobby 加入 addr（例如 "192.168.1.10:7777"）上的主机大厅
+func JoinLobby(addr, name string) (*Lobby, error) {
+	hostAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Lobby{
+		t:        newTransport(conn),
+		name:     name,
+		hostAddr: hostAddr,
+		hostSeen: time.Now(),
+	}, nil
+}
+
+// IsHost 判断本地是否为主机
+func (l *Lobby) IsHost() bool {
+	return l.host
+}
+
+// Slot 返回本地玩家的序号，客户端在加入成功之前为 -1
+func (l *Lobby) Slot() int {
+	if l.host {
+		return 0
+	}
+	if !l.joined {
+		return -1
+	}
+	return l.slot
+}
+
+// Players 返回大厅中的所有玩家，第 i 个为 i 号玩家
+func (l *Lobby) Players() []LobbyPlayer {
+	if !l.host {
+		return l.players
+	}
+	players := []LobbyPlayer{{Name: l.name, Ready: l.ready}}
+	for _, peer := range l.peers {
+		players = append(players, LobbyPlayer{Name: peer.name, Ready: peer.ready})
+	}
+	return players
+}
+
+// Ready 返回本地玩家的准备状态
+func (l *Lobby) Ready() bool {
+	if l.host {
+		return l.ready
+	}
+	if l.joined && l.slot < len(l.players) {
+		return l.players[l.slot].Ready
+	}
+	return false
+}
+
+// SetReady 设置本地玩家的准备状态
+func (l *Lobby) SetReady(ready bool) {
+	if l.host {
+		l.ready = ready
+		return
+	}
+	if l.joined && l.slot < len(l.players) {
+		l.players[l.slot].Ready = ready
+	}
+	l.t.send(l.hostAddr, encodeJSON(packetReady, readyMsg{Ready: ready}))
+}
+
+// AllReady 判断是否至少有两名玩家且全部已经准备好
+func (l *Lobby) AllReady() bool {
+	players := l.Players()
+	if len(players) < 2 {
+		return false
+	}
+	for _, p := range players {
+		if !p.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// UseRollback 由主机选择本场对局是否使用回滚同步，选择会显示在各客户端的大厅中
+func (l *Lobby) UseRollback(on bool) {
+	if l.host {
+		l.rollbackMode = on
+		l.lastSend = time.Time{}
+	}
+}
+
+// UsesRollback 判断主机是否选择了回滚同步
+func (l *Lobby) UsesRollback() bool {
+	return l.rollbackMode
+}
+
+// Session 返回开始后的锁步对局，对局开始之前或使用回滚同步时为 nil
+func (l *Lobby) Session() *Session {
+	return l.session
+}
+
+// Rollback 返回开始后的回滚同步对局，对局开始之前或使用锁步同步时为 nil
+func (l *Lobby) Rollback() *Rollback {
+	return l.rollback
+}
+
+// started 判断对局是否已经开始
+func (l *Lobby) started() bool {
+	return l.session != nil || l.rollback != nil
+}
+
+// Start 由主机在所有玩家准备好后开始对局，各玩家使用 cfg 和 seed 创建世界，
+// cfg.Players 会被设置为大厅中的人数
+func (l *Lobby) Start(seed int64, cfg sim.Config, campaign string, delay int) (*Session, error) {
+	if !l.host {
+		return nil, errors.New("netplay: only the host can start the match")
+	}
+	if !l.AllReady() {
+		return nil, ErrNotReady
+	}
+
+	cfg.Players = len(l.peers) + 1
+	start := startMsg{Seed: seed, Config: cfg, Campaign: campaign, Delay: delay}
+	s := newSession(l.t, true, start, cfg.Players)
+	now := time.Now()
+	for i, peer := range l.peers {
+		start.Slot = i + 1
+		s.peers = append(s.peers, &sessionPeer{
+			addr:     peer.addr,
+			start:    encodeJSON(packetStart, start),
+			lastSeen: now,
+		})
+	}
+	l.session = s
+	return s, nil
+}
+
+// StartRollback 由主机在两名玩家都准备好后开始回滚同步的对局，
+// cfg.Players 会被设置为 2
+func (l *Lobby) StartRollback(seed int64, cfg sim.Config, campaign string, delay int) (*Rollback, error) {
+	if !l.host {
+		return nil, errors.New("netplay: only the host can start the match")
+	}
+	if len(l.peers) != 1 {
+		return nil, ErrRollbackPlayers
+	}
+	if !l.AllReady() {
+		return nil, ErrNotReady
+	}
+
+	cfg.Players = 2
+	start := startMsg{Seed: seed, Config: cfg, Campaign: campaign, Delay: delay, Rollback: true}
+	r := newRollback(l.t, true, start, l.peers[0].addr)
+	start.Slot = 1
+	r.start = encodeJSON(packetStart, start)
+	l.rollback = r
+	return r, nil
+}
+
+// Poll 处理收到的报文并定期重发大厅状态，应在每一帧中调用。
+// 对局开始后报文交给 Session 或 Rollback 处理，Poll 不再做任何事情。
+func (l *Lobby) Poll() error {
+	if l.err != nil || l.started() {
+		return l.err
+	}
+
+	now := time.Now()
+	for !l.started() {
+		p, ok := l.t.receive()
+		if !ok {
+			break
+		}
+		if l.host {
+			l.handleHostPacket(p, now)
+		} else {
+			l.handleClientPacket(p, now)
+		}
+	}
+	if l.started() {
+		return nil
+	}
+
+	if l.host {
+		// 移除长时间没有消息的客户端
+		kept := l.peers[:0]
+		for _, peer := range l.peers {
+			if now.Sub(peer.lastSeen) <= PeerTimeout {
+				kept = append(kept, peer)
+			}
+		}
+		l.peers = kept
+	} else if now.Sub(l.hostSeen) > PeerTimeout || (!l.joined && now.Sub(l.hostSeen) > JoinTimeout) {
+		if l.joined {
+			l.err = ErrPeerLost
+		} else {
+			l.err = ErrNoHost
+		}
+		return l.err
+	}
+
+	if now.Sub(l.lastSend) >= lobbyInterval {
+		l.lastSend = now
+		l.flush()
+	}
+	return l.err
+}
+
+// Close 离开大厅并关闭连接，对局已经开始时由 Session 或 Rollback 负责关闭
+func (l *Lobby) Close() {
+	if l.started() {
+		return
+	}
+	leave := appendHeader(nil, packetLeave)
+	if l.host {
+		for _, peer := range l.peers {
+			l.t.send(peer.addr, leave)
+		}
+	} else {
+		l.t.send(l.hostAddr, leave)
+	}
+	l.t.close()
+}
+
+// handleHostPacket 主机处理大厅中的报文
+func (l *Lobby) handleHostPacket(p packet, now time.Time) {
+	var peer *lobbyPeer
+	index := -1
+	for i, pr := range l.peers {
+		if sameAddr(pr.addr, p.from) {
+			peer, index = pr, i
+		}
+	}
+
+	switch p.typ {
+	case packetDiscover:
+		msg := announceMsg{Name: l.name, Players: len(l.peers) + 1, MaxPlayers: l.maxPlayers}
+		l.t.send(p.from, encodeJSON(packetAnnounce, msg))
+	case packetJoin:
+		var msg joinMsg
+		if json.Unmarshal(p.data, &msg) != nil {
+			return
+		}
+		if peer == nil {
+			if len(l.peers)+1 >= l.maxPlayers {
+				l.t.send(p.from, appendHeader(nil, packetLeave))
+				return
+			}
+			peer = &lobbyPeer{addr: p.from}
+			l.peers = append(l.peers, peer)
+		}
+		peer.name = msg.Name
+		peer.lastSeen = now
+		// 立即回复，让客户端尽快知道自己的序号
+		l.lastSend = time.Time{}
+	case packetReady:
+		var msg readyMsg
+		if peer == nil || json.Unmarshal(p.data, &msg) != nil {
+			return
+		}
+		peer.ready = msg.Ready
+		peer.lastSeen = now
+	case packetLeave:
+		if peer != nil {
+			l.peers = append(l.peers[:index], l.peers[index+1:]...)
+		}
+	}
+}
+
+// handleClientPacket 客户端处理大厅中的报文
+func (l *Lobby) handleClientPacket(p packet, now time.Time) {
+	if !sameAddr(p.from, l.hostAddr) {
+		return
+	}
+	l.hostSeen = now
+
+	switch p.typ {
+	case packetLobby:
+		var msg lobbyMsg
+		if json.Unmarshal(p.data, &msg) != nil || msg.Slot <= 0 || msg.Slot >= len(msg.Players) {
+			return
+		}
+		// 本地的准备状态以自己为准，避免主机的旧状态覆盖刚刚的修改
+		ready := l.Ready()
+		l.joined = true
+		l.slot = msg.Slot
+		l.players = msg.Players
+		l.players[l.slot].Ready = ready
+		l.rollbackMode = msg.Rollback
+	case packetStart:
+		var msg startMsg
+		if json.Unmarshal(p.data, &msg) != nil || msg.Config.Players < 2 || msg.Config.Players > sim.MaxPlayers {
+			return
+		}
+		if msg.Rollback {
+			if msg.Config.Players != 2 || msg.Slot != 1 {
+				return
+			}
+			r := newRollback(l.t, false, msg, l.hostAddr)
+			r.peerSeen = now
+			l.rollback = r
+			return
+		}
+		s := newSession(l.t, false, msg, msg.Config.Players)
+		s.hostAddr = l.hostAddr
+		s.hostSeen = now
+		l.session = s
+	case packetLeave:
+		if l.joined {
+			l.err = ErrPeerLeft
+		} else {
+			l.err = ErrRejected
+		}
+	}
+}
+
+// flush 主机向每个客户端发送大厅状态，客户端向主机发送加入请求和准备状态
+func (l *Lobby) flush() {
+	if l.host {
+		players := l.Players()
+		for i, peer := range l.peers {
+			l.t.send(peer.addr, encodeJSON(packetLobby, lobbyMsg{Slot: i + 1, Players: players, Rollback: l.rollbackMode}))
+		}
+		return
+	}
+	l.t.send(l.hostAddr, encodeJSON(packetJoin, joinMsg{Name: l.name}))
+	if l.joined {
+		l.t.send(l.hostAddr, encodeJSON(packetReady, readyMsg{Ready: l.Ready()}))
+	}
+}
@@ -0,0 +1,338 @@
+// Package netplay 实现局域网多人对战：通过 UDP 广播发现主机、在大厅中准备，
+// 开始后各玩家只交换每一帧的输入，以锁步方式推进各自的模拟。
+//
+// 对局采用主机转发的星型结构：客户端把自己的输入发给主机，主机收齐所有玩家
+// 某一帧的输入后把这一帧广播给每个客户端。每个报文都会重发对方尚未确认的全部
+// 数据，因此丢包只会造成短暂的等待，不需要单独的重传机制。
+//
+// 双人对战还可以使用回滚同步（Rollback）：两名玩家直接交换输入，本地不等待
+// 对方，而是预测对方的输入先行模拟，并在每一帧保存世界快照；对方真实的输入
+// 到达后如果与预测不同，就恢复到出错的那一帧重新模拟到当前帧。
+package netplay
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+const (
+	// DefaultPort 主机默认监听的 UDP 端口
+	DefaultPort = 7777
+	// DefaultDelay 锁步同步默认的输入延迟帧数
+	DefaultDelay = 3
+	// DefaultRollbackDelay 回滚同步默认的输入延迟帧数，延迟越小需要回滚的帧数越多
+	DefaultRollbackDelay = 1
+	// HashInterval 每隔多少帧比较一次各玩家的世界状态哈希
+	HashInterval = 60
+
+	protocolMagic = "TNKN"
+	// 局域网协议的版本号，各版本的变化见 README
+	protocolVersion = 3
+	maxPacketSize   = 1400
+	// 每个报文最多携带的输入帧数
+	maxFramesPerPacket = 64
+	// 客户端每个报文中附带的最近哈希数
+	maxHashReports = 4
+)
+
+// packetType 表示报文的类型
+type packetType uint8
+
+const (
+	packetDiscover packetType = iota + 1 // 客户端广播：查找局域网中的主机
+	packetAnnounce                       // 主机回复：主机名称和人数
+	packetJoin                           // 客户端：请求加入大厅
+	packetLobby                          // 主机：大厅中的玩家和准备状态
+	packetReady                          // 客户端：准备状态
+	packetStart                          // 主机：对局开始，附带随机种子和游戏参数
+	packetLeave                          // 离开大厅或对局，主机拒绝加入时也发送
+	packetInput                          // 客户端：本地玩家的输入和状态哈希
+	packetFrames                         // 主机：所有玩家的输入帧
+	packetRollback                       // 回滚同步：本地玩家的输入、帧号和状态哈希
+)
+
+// ErrBadPacket 表示收到的报文格式不正确
+var ErrBadPacket = errors.New("netplay: bad packet")
+
+// announceMsg 主机对查找请求的回复
+type announceMsg struct {
+	Name       string `json:"name"`
+	Players    int    `json:"players"`
+	MaxPlayers int    `json:"max_players"`
+}
+
+// joinMsg 客户端请求加入大厅
+type joinMsg struct {
+	Name string `json:"name"`
+}
+
+// LobbyPlayer 表示大厅中的一名玩家
+type LobbyPlayer struct {
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+}
+
+// lobbyMsg 主机发给每个客户端的大厅状态
+type lobbyMsg struct {
+	Slot     int           `json:"slot"` // 接收者的玩家序号
+	Players  []LobbyPlayer `json:"players"`
+	Rollback bool          `json:"rollback"` // 主机选择的是否为回滚同步
+}
+
+// readyMsg 客户端的准备状态
+type readyMsg struct {
+	Ready bool `json:"ready"`
+}
+
+// startMsg 对局开始时主机发给每个客户端的参数
+type startMsg struct {
+	Slot     int        `json:"slot"`
+	Seed     int64      `json:"seed"`
+	Config   sim.Config `json:"config"`
+	Campaign string     `json:"campaign"`
+	Delay    int        `json:"delay"`
+	Rollback bool       `json:"rollback"`
+}
+
+// hashReport 表示某一帧模拟完成后的世界状态哈希
+type hashReport struct {
+	Tick int
+	Hash uint64
+}
+
+// inputPacket 客户端发给主机的输入报文
+type inputPacket struct {
+	Start  int         // Inputs[0] 对应的帧号
+	Inputs []sim.Input // 本地玩家从 Start 开始的输入
+	Acked  int         // 客户端已经连续收到的帧数
+	Hashes []hashReport
+}
+
+// framesPacket 主机发给客户端的帧报文
+type framesPacket struct {
+	Start   int           // Frames[0] 对应的帧号
+	Players int           // 每一帧的玩家人数
+	Frames  [][]sim.Input // 从 Start 开始的各帧所有玩家的输入
+	Acked   int           // 主机已经连续收到该客户端的输入数
+	Desync  int           // 检测到不同步的帧号，-1 表示没有
+}
+
+// rollbackPacket 回滚同步中双方互相发送的报文
+type rollbackPacket struct {
+	Start     int         // Inputs[0] 对应的帧号
+	Inputs    []sim.Input // 本地玩家从 Start 开始的输入
+	Acked     int         // 已经连续收到对方的输入数
+	Tick      int         // 发送者当前模拟到的帧号
+	Advantage int         // 发送者领先对方的帧数，可以为负数
+	Desync    int         // 检测到不同步的帧号，-1 表示没有
+	Hashes    []hashReport
+}
+
+// appendHeader 写入报文头
+func appendHeader(b []byte, t packetType) []byte {
+	b = append(b, protocolMagic...)
+	return append(b, protocolVersion, byte(t))
+}
+
+// parseHeader 校验报文头，返回报文类型和内容
+func parseHeader(p []byte) (packetType, []byte, error) {
+	if len(p) < len(protocolMagic)+2 || string(p[:len(protocolMagic)]) != protocolMagic {
+		return 0, nil, ErrBadPacket
+	}
+	p = p[len(protocolMagic):]
+	if p[0] != protocolVersion {
+		return 0, nil, ErrBadPacket
+	}
+	return packetType(p[1]), p[2:], nil
+}
+
+// encodeJSON 将大厅报文编码为报文头加 JSON 内容
+func encodeJSON(t packetType, v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// 报文内容都是固定的结构体，不会编码失败
+		panic(err)
+	}
+	return append(appendHeader(nil, t), data...)
+}
+
+// encode 编码输入报文
+func (p *inputPacket) encode() []byte {
+	b := appendHeader(nil, packetInput)
+	b = binary.AppendUvarint(b, uint64(p.Start))
+	b = binary.AppendUvarint(b, uint64(len(p.Inputs)))
+	for _, in := range p.Inputs {
+		b = binary.AppendUvarint(b, uint64(in))
+	}
+	b = binary.AppendUvarint(b, uint64(p.Acked))
+	b = binary.AppendUvarint(b, uint64(len(p.Hashes)))
+	for _, h := range p.Hashes {
+		b = binary.AppendUvarint(b, uint64(h.Tick))
+		b = binary.LittleEndian.AppendUint64(b, h.Hash)
+	}
+	return b
+}
+
+// decodeInputPacket 解码输入报文的内容
+func decodeInputPacket(data []byte) (*inputPacket, error) {
+	r := packetReader{b: data}
+	p := &inputPacket{Start: r.int()}
+	n := r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		p.Inputs = append(p.Inputs, r.input())
+	}
+	p.Acked = r.int()
+	n = r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		p.Hashes = append(p.Hashes, hashReport{Tick: r.int(), Hash: r.uint64()})
+	}
+	return p, r.finish()
+}
+
+// encode 编码帧报文
+func (p *framesPacket) encode() []byte {
+	b := appendHeader(nil, packetFrames)
+	b = binary.AppendUvarint(b, uint64(p.Start))
+	b = binary.AppendUvarint(b, uint64(p.Players))
+	b = binary.AppendUvarint(b, uint64(len(p.Frames)))
+	for _, frame := range p.Frames {
+		for i := 0; i < p.Players; i++ {
+			b = binary.AppendUvarint(b, uint64(frame[i]))
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(p.Acked))
+	b = binary.AppendUvarint(b, uint64(p.Desync+1))
+	return b
+}
+
+// decodeFramesPacket 解码帧报文的内容
+func decodeFramesPacket(data []byte) (*framesPacket, error) {
+	r := packetReader{b: data}
+	p := &framesPacket{Start: r.int(), Players: r.int()}
+	if p.Players <= 0 || p.Players > sim.MaxPlayers {
+		return nil, ErrBadPacket
+	}
+	n := r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		frame := make([]sim.Input, p.Players)
+		for j := range frame {
+			frame[j] = r.input()
+		}
+		p.Frames = append(p.Frames, frame)
+	}
+	p.Acked = r.int()
+	p.Desync = r.int() - 1
+	return p, r.finish()
+}
+
+// encode 编码回滚同步报文
+func (p *rollbackPacket) encode() []byte {
+	b := appendHeader(nil, packetRollback)
+	b = binary.AppendUvarint(b, uint64(p.Start))
+	b = binary.AppendUvarint(b, uint64(len(p.Inputs)))
+	for _, in := range p.Inputs {
+		b = binary.AppendUvarint(b, uint64(in))
+	}
+	b = binary.AppendUvarint(b, uint64(p.Acked))
+	b = binary.AppendUvarint(b, uint64(p.Tick))
+	b = binary.AppendVarint(b, int64(p.Advantage))
+	b = binary.AppendUvarint(b, uint64(p.Desync+1))
+	b = binary.AppendUvarint(b, uint64(len(p.Hashes)))
+	for _, h := range p.Hashes {
+		b = binary.AppendUvarint(b, uint64(h.Tick))
+		b = binary.LittleEndian.AppendUint64(b, h.Hash)
+	}
+	return b
+}
+
+// decodeRollbackPacket 解码回滚同步报文的内容
+func decodeRollbackPacket(data []byte) (*rollbackPacket, error) {
+	r := packetReader{b: data}
+	p := &rollbackPacket{Start: r.int()}
+	n := r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		p.Inputs = append(p.Inputs, r.input())
+	}
+	p.Acked = r.int()
+	p.Tick = r.int()
+	p.Advantage = r.signed()
+	p.Desync = r.int() - 1
+	n = r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		p.Hashes = append(p.Hashes, hashReport{Tick: r.int(), Hash: r.uint64()})
+	}
+	return p, r.finish()
+}
+
+// packetReader 从二进制报文中依次读取字段，出错后的读取都返回零值
+type packetReader struct {
+	b   []byte
+	err error
+}
+
+func (r *packetReader) int() int {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 || v > math.MaxInt32 {
+		r.err = ErrBadPacket
+		return 0
+	}
+	r.b = r.b[n:]
+	return int(v)
+}
+
+// signed 读取一个可以为负数的整数
+func (r *packetReader) signed() int {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.b)
+	if n <= 0 || v > math.MaxInt32 || v < math.MinInt32 {
+		r.err = ErrBadPacket
+		return 0
+	}
+	r.b = r.b[n:]
+	return int(v)
+}
+
+// count 读取一个长度字段，长度不可能超过报文本身的大小
+func (r *packetReader) count() int {
+	n := r.int()
+	if n > maxPacketSize {
+		r.err = ErrBadPacket
+		return 0
+	}
+	return n
+}
+
+// input 读取一个玩家输入
+func (r *packetReader) input() sim.Input {
+	return sim.Input(r.int())
+}
+
+func (r *packetReader) uint64() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.b) < 8 {
+		r.err = ErrBadPacket
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v
+}
+
+// finish 检查报文是否被完整读取
+func (r *packetReader) finish() error {
+	if r.err == nil && len(r.b) != 0 {
+		r.err = ErrBadPacket
+	}
+	return r.err
+}
@@ -0,0 +1,111 @@
+package netplay
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Proxy 是一个本地的 UDP 转发代理，按设定的延迟、抖动和丢包率转发报文，
+// 用于在一台机器上模拟有延迟的网络来测试同步：客户端加入 Proxy 的地址，
+// 代理把报文转发给真正的主机，再把主机的回复转发回客户端。
+//
+// 一个代理只服务一个客户端，即最近一次向它发送报文的地址。
+type Proxy struct {
+	Delay  time.Duration // 单向的固定延迟
+	Jitter time.Duration // 在固定延迟上随机增加的最大延迟，可能使报文乱序
+	Loss   float64       // 丢包率，0 到 1 之间
+
+	front  *net.UDPConn // 面向客户端
+	back   *net.UDPConn // 面向主机
+	target *net.UDPAddr
+
+	mu     sync.Mutex
+	client *net.UDPAddr
+	rng    *rand.Rand
+	wg     sync.WaitGroup
+}
+
+// NewProxy 在 listen（例如 "127.0.0.1:0"）上创建转发到 target 的代理
+func NewProxy(listen, target string, delay, jitter time.Duration, loss float64) (*Proxy, error) {
+	listenAddr, err := net.ResolveUDPAddr("udp4", listen)
+	if err != nil {
+		return nil, err
+	}
+	targetAddr, err := net.ResolveUDPAddr("udp4", target)
+	if err != nil {
+		return nil, err
+	}
+	front, err := net.ListenUDP("udp4", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	back, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		front.Close()
+		return nil, err
+	}
+
+	p := &Proxy{
+		Delay:  delay,
+		Jitter: jitter,
+		Loss:   loss,
+		front:  front,
+		back:   back,
+		target: targetAddr,
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	p.wg.Add(2)
+	go p.forward(front, true)
+	go p.forward(back, false)
+	return p, nil
+}
+
+// Addr 返回客户端应当连接的地址
+func (p *Proxy) Addr() *net.UDPAddr {
+	return p.front.LocalAddr().(*net.UDPAddr)
+}
+
+// Close 关闭代理，尚未送达的报文被丢弃
+func (p *Proxy) Close() {
+	p.front.Close()
+	p.back.Close()
+	p.wg.Wait()
+}
+
+// forward 从 conn 读取报文，延迟后转发给另一端，直到连接被关闭
+func (p *Proxy) forward(conn *net.UDPConn, fromClient bool) {
+	defer p.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		data := append([]byte(nil), buf[:n]...)
+
+		p.mu.Lock()
+		if fromClient {
+			p.client = from
+		}
+		client := p.client
+		drop := p.rng.Float64() < p.Loss
+		delay := p.Delay
+		if p.Jitter > 0 {
+			delay += time.Duration(p.rng.Int63n(int64(p.Jitter)))
+		}
+		p.mu.Unlock()
+
+		if drop || (!fromClient && client == nil) {
+			continue
+		}
+		time.AfterFunc(delay, func() {
+			if fromClient {
+				p.back.WriteToUDP(data, p.target)
+			} else {
+				p.front.WriteToUDP(data, client)
+			}
+		})
+	}
+}
@@ -0,0 +1,387 @@
+package netplay
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+const (
+	// MaxRollback 最多可以回滚的帧数，本地领先对方已确认的输入超过这么多帧时暂停等待
+	MaxRollback = 10
+	// 两次为了与对方同步节奏而暂停一帧之间的最少帧数
+	timeSyncInterval = 10
+)
+
+// ErrRollbackPlayers 表示回滚同步只支持两名玩家
+var ErrRollbackPlayers = errors.New("netplay: rollback requires exactly two players")
+
+// RollbackStats 回滚同步的统计数据，用于调试时显示
+type RollbackStats struct {
+	Rollbacks       int // 发生回滚的次数
+	Resimulated     int // 因为回滚而重新模拟的总帧数
+	LastDepth       int // 最近一次回滚的帧数
+	MaxDepth        int // 回滚帧数的最大值
+	Advantage       int // 本地领先对方的帧数
+	RemoteAdvantage int // 对方最近报告的领先帧数
+	Stalls          int // 因为领先太多而等待的次数
+	Delay           int // 输入延迟帧数
+}
+
+// Rollback 表示一场使用回滚同步的双人对局
+//
+// 游戏循环在每一帧中依次调用 Poll、AddInput 和 Advance，Advance 会在需要时
+// 恢复快照重新模拟，然后用本地输入和预测的对方输入推进一帧。对方的输入还没有
+// 到达时，预测它与最近一次收到的输入相同（射击键只在按下的那一帧有效，不会
+// 被重复）。模拟结果只有在 Confirmed 返回 true 时才是最终的，游戏应当在那之后
+// 才根据胜负切换画面。
+type Rollback struct {
+	t        *transport
+	host     bool
+	slot     int
+	seed     int64
+	cfg      sim.Config
+	campaign string
+	delay    int
+
+	peer     *net.UDPAddr
+	peerSeen time.Time
+	start    []byte // 主机在收到对方的第一个报文前一直重发对局开始报文
+
+	local     []sim.Input    // 本地玩家从第 0 帧开始的输入
+	remote    []sim.Input    // 已经收到的对方从第 0 帧开始的输入
+	used      []sim.Input    // 模拟每一帧时使用的对方输入，可能是预测值
+	peerAcked int            // 对方已经连续收到的本地输入数
+	peerTick  int            // 对方最近报告的帧号
+	tick      int            // 下一帧要模拟的帧号
+	rollTo    int            // 需要回滚到的帧号，-1 表示不需要
+	snapshots []sim.Snapshot // 最近各帧模拟之前的快照，第 t 帧在 t % len 处
+	nextSync  int            // 下一次允许为同步节奏而等待的帧号
+
+	pending   map[int]uint64 // 本地已经算出、但输入还没有全部确认的哈希
+	confirmed map[int]uint64 // 本地输入已经全部确认的哈希
+	reports   []hashReport   // 对方提交的、本地还没有确认的哈希
+	recent    []hashReport   // 最近确认的本地哈希，随每个报文发给对方
+
+	stats    RollbackStats
+	desync   int
+	lastSend time.Time
+	err      error
+}
+
+// newRollback 创建回滚同步对局，本地玩家前 delay 帧的输入为空
+func newRollback(t *transport, host bool, start startMsg, peer *net.UDPAddr) *Rollback {
+	return &Rollback{
+		t:         t,
+		host:      host,
+		slot:      start.Slot,
+		seed:      start.Seed,
+		cfg:       start.Config,
+		campaign:  start.Campaign,
+		delay:     start.Delay,
+		peer:      peer,
+		peerSeen:  time.Now(),
+		local:     make([]sim.Input, start.Delay),
+		rollTo:    -1,
+		snapshots: make([]sim.Snapshot, MaxRollback+1),
+		pending:   make(map[int]uint64),
+		confirmed: make(map[int]uint64),
+		stats:     RollbackStats{Delay: start.Delay},
+		desync:    -1,
+	}
+}
+
+// Slot 返回本地玩家的序号，主机总是 0 号
+func (r *Rollback) Slot() int {
+	return r.slot
+}
+
+// Players 返回对局的玩家人数，回滚同步总是两人
+func (r *Rollback) Players() int {
+	return 2
+}
+
+// Seed 返回主机选定的随机种子
+func (r *Rollback) Seed() int64 {
+	return r.seed
+}
+
+// Config 返回主机选定的游戏参数
+func (r *Rollback) Config() sim.Config {
+	return r.cfg
+}
+
+// Campaign 返回主机使用的战役名称，双方必须使用同样的战役
+func (r *Rollback) Campaign() string {
+	return r.campaign
+}
+
+// Tick 返回已经模拟的帧数
+func (r *Rollback) Tick() int {
+	return r.tick
+}
+
+// Confirmed 判断已经模拟的所有帧是否都使用了对方真实的输入
+func (r *Rollback) Confirmed() bool {
+	return r.rollTo < 0 && len(r.remote) >= r.tick
+}
+
+// Stats 返回回滚同步的统计数据
+func (r *Rollback) Stats() RollbackStats {
+	s := r.stats
+	s.Advantage = r.advantage()
+	return s
+}
+
+// AddInput 提交本地玩家下一帧的输入，它会在输入延迟之后生效；
+// 本地输入最多领先已经模拟的帧数延迟帧数，多出的输入被丢弃
+func (r *Rollback) AddInput(in sim.Input) {
+	if len(r.local) > r.tick+r.delay {
+		return
+	}
+	r.local = append(r.local, in)
+}
+
+// Advance 在需要时回滚并重新模拟，然后推进一帧，没有推进时返回 false。
+// w 必须是各帧一直使用的同一个世界；切换关卡时只能在 Confirmed 之后换用新的世界。
+func (r *Rollback) Advance(w *sim.World) bool {
+	if r.err != nil {
+		return false
+	}
+
+	if r.rollTo >= 0 {
+		from := r.rollTo
+		r.rollTo = -1
+		// 之后各帧的哈希都会在重新模拟时重新计算
+		for tick := range r.pending {
+			if tick > from {
+				delete(r.pending, tick)
+			}
+		}
+		w.Restore(&r.snapshots[from%len(r.snapshots)])
+		end := r.tick
+		r.tick = from
+		for r.tick < end && !w.Finished() {
+			r.simulate(w)
+		}
+		depth := end - from
+		r.stats.Rollbacks++
+		r.stats.Resimulated += r.tick - from
+		r.stats.LastDepth = depth
+		r.stats.MaxDepth = max(r.stats.MaxDepth, depth)
+	}
+	r.confirmHashes()
+
+	// 世界已经结束时不再推进，等待结果被确认或被回滚推翻
+	if w.Finished() || r.tick >= len(r.local) {
+		return false
+	}
+	// 领先对方太多时等待，保证需要回滚的帧都还有快照
+	if r.tick-len(r.remote) >= MaxRollback {
+		r.stats.Stalls++
+		return false
+	}
+	// 本地比对方领先得多时偶尔等待一帧，让双方的节奏一致，减少回滚
+	if r.tick >= r.nextSync && (r.advantage()-r.stats.RemoteAdvantage)/2 >= 1 {
+		r.nextSync = r.tick + timeSyncInterval
+		r.stats.Stalls++
+		return false
+	}
+
+	r.simulate(w)
+	r.confirmHashes()
+	return true
+}
+
+// simulate 保存快照后用第 tick 帧的输入推进模拟
+func (r *Rollback) simulate(w *sim.World) {
+	t := r.tick
+	w.Save(&r.snapshots[t%len(r.snapshots)])
+
+	remote := r.predict(t)
+	if t < len(r.used) {
+		r.used[t] = remote
+	} else {
+		r.used = append(r.used, remote)
+	}
+	inputs := make([]sim.Input, 2)
+	inputs[r.slot] = r.local[t]
+	inputs[1-r.slot] = remote
+	w.Step(inputs)
+
+	r.tick++
+	if r.tick%HashInterval == 0 {
+		r.pending[r.tick] = w.Hash()
+	}
+}
+
+// predict 返回第 t 帧对方的输入，还没有收到时用最近一次的输入预测
+func (r *Rollback) predict(t int) sim.Input {
+	if t < len(r.remote) {
+		return r.remote[t]
+	}
+	if len(r.remote) == 0 {
+		return 0
+	}
+	return r.remote[len(r.remote)-1] &^ sim.InputFire
+}
+
+// advantage 返回本地领先对方的帧数
+func (r *Rollback) advantage() int {
+	return r.tick - r.peerTick
+}
+
+// confirmHashes 将输入已经全部确认的帧的哈希移到 confirmed 中，并与对方的哈希比较
+func (r *Rollback) confirmHashes() {
+	if r.rollTo >= 0 {
+		return
+	}
+	for tick, hash := range r.pending {
+		if tick > len(r.remote) {
+			continue
+		}
+		delete(r.pending, tick)
+		r.confirmed[tick] = hash
+		r.recent = append(r.recent, hashReport{Tick: tick, Hash: hash})
+		if len(r.recent) > maxHashReports {
+			r.recent = r.recent[1:]
+		}
+	}
+	r.checkReports()
+}
+
+// checkReports 比较对方提交的哈希和本地已经确认的哈希
+func (r *Rollback) checkReports() {
+	kept := r.reports[:0]
+	for _, rep := range r.reports {
+		hash, ok := r.confirmed[rep.Tick]
+		if !ok {
+			kept = append(kept, rep)
+			continue
+		}
+		if hash != rep.Hash && r.desync < 0 {
+			r.desync = rep.Tick
+			r.fail(&DesyncError{Tick: rep.Tick})
+		}
+	}
+	r.reports = kept
+}
+
+// Poll 处理收到的报文并发送对方还没有确认的输入，应在每一帧中调用；
+// 连接断开或检测到不同步时返回错误，之后对局不再推进，但在关闭之前
+// 仍会继续收发报文，让对方也能得知不同步
+func (r *Rollback) Poll() error {
+	if r.err == net.ErrClosed {
+		return r.err
+	}
+
+	now := time.Now()
+	for {
+		p, ok := r.t.receive()
+		if !ok {
+			break
+		}
+		r.handlePacket(p, now)
+	}
+	if now.Sub(r.peerSeen) > PeerTimeout {
+		r.fail(ErrPeerLost)
+	}
+
+	if now.Sub(r.lastSend) >= sendInterval {
+		r.lastSend = now
+		r.flush()
+	}
+	return r.err
+}
+
+// Close 通知对方并关闭连接
+func (r *Rollback) Close() {
+	r.t.send(r.peer, appendHeader(nil, packetLeave))
+	r.t.close()
+	if r.err == nil {
+		r.err = net.ErrClosed
+	}
+}
+
+// fail 记录第一个错误
+func (r *Rollback) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// updatePeerTick 记录对方报告的帧号和领先帧数
+func (r *Rollback) updatePeerTick(p *rollbackPacket) {
+	if p.Tick > r.peerTick {
+		r.peerTick = p.Tick
+	}
+	r.stats.RemoteAdvantage = p.Advantage
+}
+
+// handlePacket 处理对方发来的报文
+func (r *Rollback) handlePacket(p packet, now time.Time) {
+	if !sameAddr(p.from, r.peer) {
+		if r.host && p.typ == packetJoin {
+			// 对局已经开始，拒绝新的玩家
+			r.t.send(p.from, appendHeader(nil, packetLeave))
+		}
+		return
+	}
+	r.peerSeen = now
+
+	switch p.typ {
+	case packetLeave:
+		r.fail(ErrPeerLeft)
+	case packetRollback:
+		in, err := decodeRollbackPacket(p.data)
+		if err != nil {
+			return
+		}
+		r.start = nil
+		r.addRemote(in.Start, in.Inputs)
+		if in.Acked > r.peerAcked {
+			r.peerAcked = in.Acked
+		}
+		r.updatePeerTick(in)
+		if in.Desync >= 0 {
+			r.desync = in.Desync
+			r.fail(&DesyncError{Tick: in.Desync})
+		}
+		r.reports = append(r.reports, in.Hashes...)
+		r.checkReports()
+	}
+}
+
+// addRemote 记录对方新到达的输入，与已经用过的预测不同时安排回滚
+func (r *Rollback) addRemote(start int, inputs []sim.Input) {
+	if start > len(r.remote) || start+len(inputs) <= len(r.remote) {
+		return
+	}
+	for _, in := range inputs[len(r.remote)-start:] {
+		t := len(r.remote)
+		r.remote = append(r.remote, in)
+		if t < r.tick && r.used[t] != in && (r.rollTo < 0 || t < r.rollTo) {
+			r.rollTo = t
+		}
+	}
+}
+
+// flush 向对方发送还没有确认的本地输入
+func (r *Rollback) flush() {
+	if r.start != nil {
+		r.t.send(r.peer, r.start)
+	}
+	end := min(len(r.local), r.peerAcked+maxFramesPerPacket)
+	p := rollbackPacket{
+		Start:     r.peerAcked,
+		Inputs:    r.local[min(r.peerAcked, end):end],
+		Acked:     len(r.remote),
+		Tick:      r.tick,
+		Advantage: r.advantage(),
+		Desync:    r.desync,
+		Hashes:    r.recent,
+	}
+	r.t.send(r.peer, p.encode())
+}
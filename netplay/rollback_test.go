@@ -0,0 +1,159 @@
+package netplay
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+// rollbackPeer 表示回环测试中的一方
+type rollbackPeer struct {
+	r     *Rollback
+	w     *sim.World
+	rng   *rand.Rand
+	input sim.Input
+	added int // 已经提交的本地输入数，包括输入延迟的空帧
+}
+
+// next 随机生成本地玩家的输入，每隔一段时间随机更换方向
+func (p *rollbackPeer) next() sim.Input {
+	if p.rng.Intn(20) == 0 {
+		p.input = sim.Input(1 << p.rng.Intn(4))
+	}
+	in := p.input
+	if p.rng.Intn(10) == 0 {
+		in |= sim.InputFire
+	}
+	return in
+}
+
+// step 按正常速度推进一帧，本地输入只提交到第 maxTicks 帧为止，双方最终停在同一帧
+func (p *rollbackPeer) step(maxTicks int) error {
+	if err := p.r.Poll(); err != nil {
+		return err
+	}
+	if p.added < maxTicks && p.added <= p.r.Tick()+p.r.delay {
+		p.r.AddInput(p.next())
+		p.added++
+	}
+	p.r.Advance(p.w)
+	return nil
+}
+
+// done 判断这一方是否已经模拟完并且所有帧都使用了对方真实的输入
+func (p *rollbackPeer) done(maxTicks int) bool {
+	return (p.r.Tick() >= maxTicks || p.w.Finished()) && p.r.Confirmed()
+}
+
+// startRollback 在回环地址上创建主机和经过 Proxy 加入的客户端，开始回滚同步的对局
+func startRollback(t *testing.T, delay, jitter time.Duration, loss float64) (host, client *Rollback, proxy *Proxy) {
+	t.Helper()
+	hostLobby, err := HostLobby("127.0.0.1:0", "host", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err = NewProxy("127.0.0.1:0", hostLobby.t.conn.LocalAddr().String(), delay, jitter, loss)
+	if err != nil {
+		hostLobby.Close()
+		t.Fatal(err)
+	}
+	clientLobby, err := JoinLobby(proxy.Addr().String(), "client")
+	if err != nil {
+		hostLobby.Close()
+		proxy.Close()
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for clientLobby.Rollback() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the match to start")
+		}
+		if err := clientLobby.Poll(); err != nil {
+			t.Fatal(err)
+		}
+		if host == nil {
+			if err := hostLobby.Poll(); err != nil {
+				t.Fatal(err)
+			}
+			if clientLobby.Slot() >= 0 && !clientLobby.Ready() {
+				clientLobby.SetReady(true)
+			}
+			hostLobby.SetReady(true)
+			if hostLobby.AllReady() {
+				host, err = hostLobby.StartRollback(7, sim.DefaultConfig(), "test", DefaultRollbackDelay)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		} else if err := host.Poll(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return host, clientLobby.Rollback(), proxy
+}
+
+// TestRollbackLossyLink 通过有延迟、抖动和丢包的代理运行一场回滚同步的对局，
+// 检查确实发生了回滚、没有检测到不同步，并且双方最终的世界状态相同
+func TestRollbackLossyLink(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a real-time match over UDP")
+	}
+	const maxTicks = 5 * sim.TicksPerSecond
+
+	host, client, proxy := startRollback(t, 30*time.Millisecond, 30*time.Millisecond, 0.1)
+	defer proxy.Close()
+	defer host.Close()
+	defer client.Close()
+
+	peers := []*rollbackPeer{
+		{r: host, rng: rand.New(rand.NewSource(1))},
+		{r: client, rng: rand.New(rand.NewSource(2))},
+	}
+	for _, p := range peers {
+		p.w = sim.NewWorld(p.r.Seed(), p.r.Config())
+		p.added = p.r.delay
+	}
+
+	ticker := time.NewTicker(time.Second / sim.TicksPerSecond)
+	defer ticker.Stop()
+	deadline := time.Now().Add(30 * time.Second)
+	for !peers[0].done(maxTicks) || !peers[1].done(maxTicks) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out at ticks %d and %d", host.Tick(), client.Tick())
+		}
+		<-ticker.C
+		for i, p := range peers {
+			if err := p.step(maxTicks); err != nil {
+				t.Fatalf("peer %d: %v", i, err)
+			}
+		}
+	}
+
+	// 继续收发一段时间，让双方比较最后几次的哈希
+	for end := time.Now().Add(500 * time.Millisecond); time.Now().Before(end); time.Sleep(5 * time.Millisecond) {
+		for i, p := range peers {
+			var desync *DesyncError
+			if err := p.r.Poll(); errors.As(err, &desync) {
+				t.Fatalf("peer %d: %v", i, err)
+			}
+		}
+	}
+
+	if host.Tick() != client.Tick() {
+		t.Fatalf("host stopped at tick %d, client at %d", host.Tick(), client.Tick())
+	}
+	if h, c := peers[0].w.Hash(), peers[1].w.Hash(); h != c {
+		t.Fatalf("final hash %016x on host, %016x on client", h, c)
+	}
+	rollbacks := host.Stats().Rollbacks + client.Stats().Rollbacks
+	if rollbacks == 0 {
+		t.Fatal("no rollbacks happened over a lagging link")
+	}
+	t.Logf("ticks %d, rollbacks %d+%d, max depth %d/%d", host.Tick(),
+		host.Stats().Rollbacks, client.Stats().Rollbacks, host.Stats().MaxDepth, client.Stats().MaxDepth)
+}
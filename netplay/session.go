@@ -0,0 +1,361 @@
+package netplay
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+const (
+	// PeerTimeout 超过这段时间没有收到对方的报文时认为连接已断开
+	PeerTimeout = 5 * time.Second
+	// 两次发送之间的最短间隔
+	sendInterval = 10 * time.Millisecond
+)
+
+var (
+	// ErrPeerLost 表示与其他玩家的连接超时
+	ErrPeerLost = errors.New("netplay: connection to peer lost")
+	// ErrPeerLeft 表示有玩家离开了对局
+	ErrPeerLeft = errors.New("netplay: peer left the match")
+)
+
+// DesyncError 表示各玩家的模拟在某一帧之后出现了不一致
+type DesyncError struct {
+	Tick int
+}
+
+func (e *DesyncError) Error() string {
+	return fmt.Sprintf("netplay: desync detected at tick %d", e.Tick)
+}
+
+// sessionPeer 主机记录的一个客户端
+type sessionPeer struct {
+	addr     *net.UDPAddr
+	start    []byte      // 对局开始报文，收到该客户端的第一个输入报文前一直重发
+	inputs   []sim.Input // 该客户端从第 0 帧开始的输入
+	acked    int         // 该客户端已经连续收到的帧数
+	lastSeen time.Time
+}
+
+// Session 表示一场正在进行的锁步对局
+//
+// 游戏循环在每一帧中依次调用 Poll、AddInput 和 Next，Next 返回输入时用它推进
+// 一帧模拟，并每隔 HashInterval 帧调用 ReportHash 提交世界状态哈希。
+type Session struct {
+	t        *transport
+	host     bool
+	slot     int
+	players  int
+	seed     int64
+	cfg      sim.Config
+	campaign string
+	delay    int
+
+	local  []sim.Input   // 本地玩家从第 0 帧开始的输入
+	frames [][]sim.Input // 已经确认的各帧所有玩家的输入
+	next   int           // 下一帧要模拟的帧号
+
+	// 主机使用的状态
+	peers   []*sessionPeer // 第 i 个为 i+1 号玩家
+	hashes  map[int]uint64 // 主机本地各帧的状态哈希
+	reports []hashReport   // 客户端提交的、主机还没有算到的哈希
+
+	// 客户端使用的状态
+	hostAddr  *net.UDPAddr
+	hostSeen  time.Time
+	hostAcked int          // 主机已经连续收到的本地输入数
+	recent    []hashReport // 最近的本地哈希，随每个输入报文发给主机
+
+	desync   int
+	lastSend time.Time
+	err      error
+}
+
+// newSession 创建对局，本地玩家前 delay 帧的输入为空
+func newSession(t *transport, host bool, start startMsg, players int) *Session {
+	s := &Session{
+		t:        t,
+		host:     host,
+		slot:     start.Slot,
+		players:  players,
+		seed:     start.Seed,
+		cfg:      start.Config,
+		campaign: start.Campaign,
+		delay:    start.Delay,
+		local:    make([]sim.Input, start.Delay),
+		hashes:   make(map[int]uint64),
+		desync:   -1,
+	}
+	return s
+}
+
+// Slot 返回本地玩家的序号，主机总是 0 号
+func (s *Session) Slot() int {
+	return s.slot
+}
+
+// Players 返回对局的玩家人数
+func (s *Session) Players() int {
+	return s.players
+}
+
+// Seed 返回主机选定的随机种子
+func (s *Session) Seed() int64 {
+	return s.seed
+}
+
+// Config 返回主机选定的游戏参数
+func (s *Session) Config() sim.Config {
+	return s.cfg
+}
+
+// Campaign 返回主机使用的战役名称，各玩家必须使用同样的战役
+func (s *Session) Campaign() string {
+	return s.campaign
+}
+
+// Tick 返回已经取出的帧数
+func (s *Session) Tick() int {
+	return s.next
+}
+
+// Waiting 判断是否正在等待其他玩家的输入
+func (s *Session) Waiting() bool {
+	return s.err == nil && s.next >= len(s.frames)
+}
+
+// AddInput 提交本地玩家下一帧的输入，它会在输入延迟之后生效；
+// 等待其他玩家时本地输入最多领先延迟帧数，多出的输入被丢弃
+func (s *Session) AddInput(in sim.Input) {
+	if len(s.local) > s.next+s.delay {
+		return
+	}
+	s.local = append(s.local, in)
+}
+
+// Next 取出下一帧所有玩家的输入，还没有收齐时 ok 为 false
+func (s *Session) Next() (inputs []sim.Input, ok bool) {
+	if s.err != nil || s.next >= len(s.frames) {
+		return nil, false
+	}
+	inputs = s.frames[s.next]
+	s.next++
+	return inputs, true
+}
+
+// ReportHash 提交第 tick 帧模拟完成后的世界状态哈希，用于检测不同步
+func (s *Session) ReportHash(tick int, hash uint64) {
+	if s.host {
+		s.hashes[tick] = hash
+		s.checkReports()
+		return
+	}
+	s.recent = append(s.recent, hashReport{Tick: tick, Hash: hash})
+	if len(s.recent) > maxHashReports {
+		s.recent = s.recent[1:]
+	}
+}
+
+// Poll 处理收到的报文并发送对方还没有确认的数据，应在每一帧中调用；
+// 连接断开或检测到不同步时返回错误，之后对局不再推进，但在关闭之前
+// 仍会继续收发报文，让其他玩家也能得知不同步
+func (s *Session) Poll() error {
+	if s.err == net.ErrClosed {
+		return s.err
+	}
+
+	now := time.Now()
+	for {
+		p, ok := s.t.receive()
+		if !ok {
+			break
+		}
+		if s.host {
+			s.handleHostPacket(p, now)
+		} else {
+			s.handleClientPacket(p, now)
+		}
+	}
+
+	if s.host {
+		s.buildFrames()
+		for _, peer := range s.peers {
+			if now.Sub(peer.lastSeen) > PeerTimeout {
+				s.fail(ErrPeerLost)
+			}
+		}
+	} else if now.Sub(s.hostSeen) > PeerTimeout {
+		s.fail(ErrPeerLost)
+	}
+
+	if now.Sub(s.lastSend) >= sendInterval {
+		s.lastSend = now
+		s.flush()
+	}
+	return s.err
+}
+
+// Close 通知其他玩家并关闭连接
+func (s *Session) Close() {
+	leave := appendHeader(nil, packetLeave)
+	if s.host {
+		for _, peer := range s.peers {
+			s.t.send(peer.addr, leave)
+		}
+	} else {
+		s.t.send(s.hostAddr, leave)
+	}
+	s.t.close()
+	if s.err == nil {
+		s.err = net.ErrClosed
+	}
+}
+
+// fail 记录第一个错误
+func (s *Session) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// handleHostPacket 主机处理客户端发来的报文
+func (s *Session) handleHostPacket(p packet, now time.Time) {
+	peer := s.peerFor(p.from)
+	if peer == nil {
+		if p.typ == packetJoin {
+			// 对局已经开始，拒绝新的玩家
+			s.t.send(p.from, appendHeader(nil, packetLeave))
+		}
+		return
+	}
+	peer.lastSeen = now
+
+	switch p.typ {
+	case packetLeave:
+		s.fail(ErrPeerLeft)
+	case packetInput:
+		in, err := decodeInputPacket(p.data)
+		if err != nil {
+			return
+		}
+		peer.start = nil
+		if in.Start <= len(peer.inputs) && in.Start+len(in.Inputs) > len(peer.inputs) {
+			peer.inputs = append(peer.inputs, in.Inputs[len(peer.inputs)-in.Start:]...)
+		}
+		if in.Acked > peer.acked {
+			peer.acked = in.Acked
+		}
+		s.reports = append(s.reports, in.Hashes...)
+		s.checkReports()
+	}
+}
+
+// peerFor 根据地址找到对应的客户端
+func (s *Session) peerFor(addr *net.UDPAddr) *sessionPeer {
+	for _, peer := range s.peers {
+		if sameAddr(peer.addr, addr) {
+			return peer
+		}
+	}
+	return nil
+}
+
+// buildFrames 主机收齐某一帧所有玩家的输入后生成这一帧
+func (s *Session) buildFrames() {
+	for {
+		t := len(s.frames)
+		if t >= len(s.local) {
+			return
+		}
+		for _, peer := range s.peers {
+			if t >= len(peer.inputs) {
+				return
+			}
+		}
+		frame := make([]sim.Input, s.players)
+		frame[0] = s.local[t]
+		for i, peer := range s.peers {
+			frame[i+1] = peer.inputs[t]
+		}
+		s.frames = append(s.frames, frame)
+	}
+}
+
+// checkReports 比较客户端提交的哈希和主机本地的哈希
+func (s *Session) checkReports() {
+	kept := s.reports[:0]
+	for _, r := range s.reports {
+		hash, ok := s.hashes[r.Tick]
+		if !ok {
+			kept = append(kept, r)
+			continue
+		}
+		if hash != r.Hash && s.desync < 0 {
+			s.desync = r.Tick
+			s.fail(&DesyncError{Tick: r.Tick})
+		}
+	}
+	s.reports = kept
+}
+
+// handleClientPacket 客户端处理主机发来的报文
+func (s *Session) handleClientPacket(p packet, now time.Time) {
+	if !sameAddr(p.from, s.hostAddr) {
+		return
+	}
+	s.hostSeen = now
+
+	switch p.typ {
+	case packetLeave:
+		s.fail(ErrPeerLeft)
+	case packetFrames:
+		f, err := decodeFramesPacket(p.data)
+		if err != nil || f.Players != s.players {
+			return
+		}
+		if f.Start <= len(s.frames) && f.Start+len(f.Frames) > len(s.frames) {
+			s.frames = append(s.frames, f.Frames[len(s.frames)-f.Start:]...)
+		}
+		if f.Acked > s.hostAcked {
+			s.hostAcked = f.Acked
+		}
+		if f.Desync >= 0 {
+			s.desync = f.Desync
+			s.fail(&DesyncError{Tick: f.Desync})
+		}
+	}
+}
+
+// flush 向对方发送还没有确认的输入或帧
+func (s *Session) flush() {
+	if s.host {
+		for _, peer := range s.peers {
+			if peer.start != nil {
+				s.t.send(peer.addr, peer.start)
+			}
+			end := min(len(s.frames), peer.acked+maxFramesPerPacket)
+			f := framesPacket{
+				Start:   peer.acked,
+				Players: s.players,
+				Frames:  s.frames[min(peer.acked, end):end],
+				Acked:   len(peer.inputs),
+				Desync:  s.desync,
+			}
+			s.t.send(peer.addr, f.encode())
+		}
+		return
+	}
+
+	end := min(len(s.local), s.hostAcked+maxFramesPerPacket)
+	in := inputPacket{
+		Start:  s.hostAcked,
+		Inputs: s.local[min(s.hostAcked, end):end],
+		Acked:  len(s.frames),
+		Hashes: s.recent,
+	}
+	s.t.send(s.hostAddr, in.encode())
+}
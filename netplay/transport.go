@@ -0,0 +1,78 @@
+package netplay
+
+import (
+	"net"
+)
+
+// packet 表示收到的一个报文
+type packet struct {
+	typ  packetType
+	data []byte
+	from *net.UDPAddr
+}
+
+// transport 在后台读取 UDP 报文并放入队列，由游戏循环在每一帧中取出处理，
+// 因此大厅和对局的状态只会在游戏循环中被修改
+type transport struct {
+	conn    *net.UDPConn
+	packets chan packet
+}
+
+// newTransport 开始从 conn 中读取报文
+func newTransport(conn *net.UDPConn) *transport {
+	t := &transport{
+		conn:    conn,
+		packets: make(chan packet, 256),
+	}
+	go t.readLoop()
+	return t
+}
+
+// readLoop 持续读取报文直到连接被关闭，队列已满时丢弃报文
+func (t *transport) readLoop() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			close(t.packets)
+			return
+		}
+		typ, data, err := parseHeader(buf[:n])
+		if err != nil {
+			continue
+		}
+		p := packet{typ: typ, data: append([]byte(nil), data...), from: from}
+		select {
+		case t.packets <- p:
+		default:
+		}
+	}
+}
+
+// receive 不阻塞地取出一个报文，没有报文时 ok 为 false
+func (t *transport) receive() (p packet, ok bool) {
+	select {
+	case p, ok = <-t.packets:
+		return p, ok
+	default:
+		return packet{}, false
+	}
+}
+
+// send 向 addr 发送一个报文，UDP 发送失败时直接忽略，由后续的重发弥补
+func (t *transport) send(addr *net.UDPAddr, b []byte) {
+	t.conn.WriteToUDP(b, addr)
+}
+
+// close 关闭连接，后台读取随之结束
+func (t *transport) close() {
+	t.conn.Close()
+}
+
+// sameAddr 判断两个 UDP 地址是否相同
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
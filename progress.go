@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// configFile 返回用户配置目录下本游戏的数据文件路径
+func configFile(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tank", name), nil
+}
+
+// loadJSONFile 从用户配置目录中读取 JSON 数据，文件不存在时保持 v 不变
+func loadJSONFile(name string, v any) error {
+	path, err := configFile(name)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSONFile 将 JSON 数据写入用户配置目录
+func saveJSONFile(name string, v any) error {
+	path, err := configFile(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// progress 记录玩家在各个战役中已经解锁的关卡数
+type progress struct {
+	Unlocked map[string]int `json:"unlocked"`
+}
+
+// loadProgress 读取战役进度，读取失败时返回空的进度
+func loadProgress() *progress {
+	p := &progress{}
+	if err := loadJSONFile("progress.json", p); err != nil {
+		p = &progress{}
+	}
+	if p.Unlocked == nil {
+		p.Unlocked = map[string]int{}
+	}
+	return p
+}
+
+// save 保存战役进度
+func (p *progress) save() error {
+	return saveJSONFile("progress.json", p)
+}
+
+// unlocked 返回战役中已经解锁的关卡数，第一关总是解锁的
+func (p *progress) unlocked(campaign string) int {
+	if n := p.Unlocked[campaign]; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// unlock 解锁战役的前 n 关
+func (p *progress) unlock(campaign string, n int) {
+	if n > p.unlocked(campaign) {
+		p.Unlocked[campaign] = n
+	}
+}
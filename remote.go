@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/server"
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// JoinServer 连接 addr 上的专用服务器并加入指定的房间，
+// 之后游戏只发送本地玩家的输入并显示服务器发来的画面
+func (g *Game) JoinServer(addr, room string) error {
+	c, err := server.Dial(addr, g.lan.name, room)
+	if err != nil {
+		return err
+	}
+	g.switchScene(&remoteScene{client: c})
+	return nil
+}
+
+// serverErrorText 返回连接专用服务器失败时显示给玩家的说明
+func serverErrorText(err error) string {
+	switch {
+	case errors.Is(err, server.ErrRoomFull):
+		return "房间已满"
+	case errors.Is(err, server.ErrBadToken):
+		return "等待重新连接的时间已过，位置已经被释放"
+	case errors.Is(err, server.ErrServerFull):
+		return "服务器上的房间数已满"
+	}
+	return err.Error()
+}
+
+// remoteScene 作为专用服务器的瘦客户端进行游戏：模拟全部在服务器上运行，
+// 本地把收到的快照插值后填入一个只用于显示的世界
+type remoteScene struct {
+	client *server.Client
+}
+
+func (s *remoteScene) enter(g *Game) {
+	g.world = sim.NewViewWorld(s.client.Config())
+	if w := g.openStream(s.client.Room(), s.client.Config()); w != nil {
+		s.client.Record(w)
+	}
+}
+
+func (s *remoteScene) exit(g *Game) {
+	s.client.Record(nil)
+	s.client.Close()
+}
+
+func (s *remoteScene) update(g *Game) error {
+	if err := s.client.Err(); err != nil {
+		g.switchScene(&messageScene{title: "与服务器的连接中断", msg: serverErrorText(err)})
+		return nil
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.switchScene(&menuScene{})
+		return nil
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF3) {
+		g.netDebug = !g.netDebug
+	}
+	s.client.SendInput(g.readInput(0, s.client.Slot()))
+	return nil
+}
+
+func (s *remoteScene) draw(g *Game, screen *ebiten.Image) {
+	state := s.client.State()
+	if state == nil {
+		drawCenteredText(screen, "正在连接服务器……", 24, 200, color.White)
+		return
+	}
+	state.Apply(g.world)
+	g.drawWorld(screen)
+
+	drawRemoteOverlay(screen, state)
+
+	if g.netDebug {
+		stats := s.client.Stats()
+		msg := fmt.Sprintf("room %s  slot %d  stage %d  tick %d\nsnapshots %d  bytes %d/%d  reconnects %d",
+			s.client.Room(), s.client.Slot()+1, state.Stage+1, state.Tick,
+			stats.Snapshots, stats.Bytes, stats.FullBytes, stats.Reconnects)
+		ebitenutil.DebugPrintAt(screen, msg, 4, screenHeight-50)
+	}
+}
+
+// drawRemoteOverlay 在服务器发来的画面上显示本关的结果和不在线的玩家
+func drawRemoteOverlay(screen *ebiten.Image, state *server.State) {
+	switch state.Result {
+	case sim.ResultWon:
+		drawCenteredText(screen, fmt.Sprintf("第 %d 关胜利", state.Stage+1), 32, 200, color.RGBA{255, 215, 0, 255})
+	case sim.ResultLost:
+		drawCenteredText(screen, "失败，即将重新开始", 32, 200, color.RGBA{255, 0, 0, 255})
+	}
+	for i, p := range state.Players {
+		if !p.Connected {
+			msg := fmt.Sprintf("%dP 不在线", i+1)
+			drawCenteredText(screen, msg, 14, screenHeight-20-float64(len(state.Players)-i)*18, color.RGBA{192, 192, 192, 255})
+		}
+	}
+}
@@ -0,0 +1,368 @@
+// Package replay 实现对局录像的录制、读写和回放校验
+//
+// 录像文件只保存随机种子、游戏参数和每一帧的玩家输入，
+// 回放时重新运行同一套模拟逻辑即可逐帧还原对局。
+package replay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+// magic 录像文件的文件头标识
+var magic = [4]byte{'T', 'N', 'K', 'R'}
+
+// version 录像文件格式的版本号，各版本的变化见 README
+const version = 13
+
+// minVersion 仍然可以读取的最早的录像格式版本
+const minVersion = 1
+
+// varintInputVersion 从这个版本起每个输入是一个变长整数，更早的版本中是一个字节
+const varintInputVersion = 13
+
+const (
+	// maxJSONSize 录像中一段 JSON 数据（游戏参数、关卡或带入的状态）的最大长度
+	maxJSONSize = 1 << 20
+	// maxFrames 一段录像最多包含的帧数，即 24 小时
+	maxFrames = 24 * 60 * 60 * sim.TicksPerSecond
+)
+
+// ErrBadFormat 表示录像文件格式不正确
+var ErrBadFormat = errors.New("replay: bad file format")
+
+// Replay 表示一段对局录像
+type Replay struct {
+	Seed      int64
+	Config    sim.Config
+	Level     *sim.Level // 为 nil 时使用默认关卡
+	Carry     *sim.Carry // 从上一关带入的玩家状态，可以为 nil
+	Players   int
+	Frames    [][]sim.Input // Frames[t][i] 为第 i 个玩家在第 t 帧的输入
+	FinalHash uint64        // 最后一帧模拟完成后的世界状态哈希
+}
+
+// Recorder 在对局进行时逐帧记录玩家输入
+type Recorder struct {
+	replay Replay
+}
+
+// NewRecorder 为刚创建、尚未开始模拟的游戏世界创建一个录像记录器
+func NewRecorder(w *sim.World, players int) *Recorder {
+	return &Recorder{
+		replay: Replay{
+			Seed:    w.Seed(),
+			Config:  w.Config(),
+			Level:   w.Level(),
+			Carry:   w.Carry(),
+			Players: players,
+		},
+	}
+}
+
+// Record 记录一帧的输入，应在每次调用 World.Step 时使用同样的输入调用
+func (r *Recorder) Record(inputs []sim.Input) {
+	frame := make([]sim.Input, r.replay.Players)
+	copy(frame, inputs)
+	r.replay.Frames = append(r.replay.Frames, frame)
+}
+
+// Finish 结束录制并记下世界的最终状态哈希
+func (r *Recorder) Finish(w *sim.World) *Replay {
+	r.replay.FinalHash = w.Hash()
+	return &r.replay
+}
+
+// NewWorld 创建与录像开始时一致的游戏世界
+func (r *Replay) NewWorld() *sim.World {
+	level := r.Level
+	if level == nil {
+		level = sim.DefaultLevel()
+	}
+	w := sim.NewLevelWorld(r.Seed, r.Config, level)
+	w.ApplyCarry(r.Carry)
+	return w
+}
+
+// Simulate 在无界面的环境中重新模拟整段录像，返回最终的游戏世界
+func (r *Replay) Simulate() *sim.World {
+	w := r.NewWorld()
+	for _, frame := range r.Frames {
+		w.Step(frame)
+	}
+	return w
+}
+
+// Verify 重新模拟录像并比较最终状态哈希，返回实际得到的哈希以及是否一致
+func (r *Replay) Verify() (uint64, bool) {
+	got := r.Simulate().Hash()
+	return got, got == r.FinalHash
+}
+
+// Write 将录像写入 w。连续相同的输入帧使用游程编码压缩。
+func (r *Replay) Write(w io.Writer) error {
+	if r.Players <= 0 {
+		return fmt.Errorf("replay: invalid player count %d", r.Players)
+	}
+	if len(r.Frames) > maxFrames {
+		return fmt.Errorf("replay: too many frames %d", len(r.Frames))
+	}
+
+	cfg, err := json.Marshal(r.Config)
+	if err != nil {
+		return err
+	}
+	level, err := json.Marshal(r.Level)
+	if err != nil {
+		return err
+	}
+	carry, err := json.Marshal(r.Carry)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf[:], v)
+		bw.Write(buf[:n])
+	}
+
+	bw.Write(magic[:])
+	bw.WriteByte(version)
+	n := binary.PutVarint(buf[:], r.Seed)
+	bw.Write(buf[:n])
+	putUvarint(uint64(len(cfg)))
+	bw.Write(cfg)
+	putUvarint(uint64(len(level)))
+	bw.Write(level)
+	putUvarint(uint64(len(carry)))
+	bw.Write(carry)
+	putUvarint(uint64(r.Players))
+	putUvarint(uint64(len(r.Frames)))
+	binary.LittleEndian.PutUint64(buf[:8], r.FinalHash)
+	bw.Write(buf[:8])
+
+	for i := 0; i < len(r.Frames); {
+		run := 1
+		for i+run < len(r.Frames) && sameFrame(r.Frames[i], r.Frames[i+run]) {
+			run++
+		}
+		putUvarint(uint64(run))
+		for p := 0; p < r.Players; p++ {
+			var in sim.Input
+			if p < len(r.Frames[i]) {
+				in = r.Frames[i][p]
+			}
+			putUvarint(uint64(in))
+		}
+		i += run
+	}
+
+	return bw.Flush()
+}
+
+// Read 从 r 中读取一段录像
+func Read(r io.Reader) (*Replay, error) {
+	br := bufio.NewReader(r)
+
+	var head [5]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return nil, err
+	}
+	if [4]byte(head[:4]) != magic {
+		return nil, ErrBadFormat
+	}
+	if head[4] < minVersion || head[4] > version {
+		return nil, fmt.Errorf("replay: unsupported version %d", head[4])
+	}
+
+	// 旧版本的录像中没有的参数使用默认值
+	rp := &Replay{Config: sim.DefaultConfig()}
+	var err error
+	if rp.Seed, err = binary.ReadVarint(br); err != nil {
+		return nil, err
+	}
+
+	if err := readJSON(br, &rp.Config); err != nil {
+		return nil, err
+	}
+	if head[4] >= 2 {
+		if err := readJSON(br, &rp.Level); err != nil {
+			return nil, err
+		}
+	}
+	if head[4] >= 3 {
+		if err := readJSON(br, &rp.Carry); err != nil {
+			return nil, err
+		}
+	}
+
+	players, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if players == 0 || players > sim.MaxPlayers {
+		return nil, ErrBadFormat
+	}
+	rp.Players = int(players)
+	if err := rp.check(); err != nil {
+		return nil, err
+	}
+
+	frames, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if frames > maxFrames {
+		return nil, ErrBadFormat
+	}
+
+	var hash [8]byte
+	if _, err := io.ReadFull(br, hash[:]); err != nil {
+		return nil, err
+	}
+	rp.FinalHash = binary.LittleEndian.Uint64(hash[:])
+
+	// 帧数来自文件，不能直接用来分配内存，逐段读出后再追加
+	for uint64(len(rp.Frames)) < frames {
+		run, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		if run == 0 || uint64(len(rp.Frames))+run > frames {
+			return nil, ErrBadFormat
+		}
+		frame := make([]sim.Input, rp.Players)
+		for p := range frame {
+			in, err := readInput(br, head[4])
+			if err != nil {
+				return nil, err
+			}
+			frame[p] = in
+		}
+		for k := uint64(0); k < run; k++ {
+			rp.Frames = append(rp.Frames, frame)
+		}
+	}
+
+	return rp, nil
+}
+
+// check 检查从文件中读出的游戏参数和关卡，拒绝会让模拟出错的数值。
+// 录像文件可能被手工修改或已经损坏，不能直接交给模拟。
+func (r *Replay) check() error {
+	cfg := r.Config
+	intervals := []struct {
+		name  string
+		value int
+	}{
+		{"change_dir_interval", cfg.ChangeDirInterval},
+		{"shoot_interval", cfg.ShootInterval},
+		{"wall_check_interval", cfg.WallCheckInterval},
+		{"enemy_tank_check_interval", cfg.EnemyTankCheckInterval},
+	}
+	for _, iv := range intervals {
+		if iv.value <= 0 {
+			return fmt.Errorf("replay: invalid %s %d", iv.name, iv.value)
+		}
+	}
+	if cfg.Players < 1 || cfg.Players > sim.MaxPlayers {
+		return fmt.Errorf("replay: invalid player count %d", cfg.Players)
+	}
+	if r.Level == nil {
+		return nil
+	}
+	for i, rule := range r.Level.Spawns {
+		if len(rule.Types) == 0 {
+			continue
+		}
+		total := 0
+		for _, wt := range rule.Types {
+			total += wt.Weight
+		}
+		if total <= 0 {
+			return fmt.Errorf("replay: spawn rule %d has no positive enemy weight", i)
+		}
+	}
+	return nil
+}
+
+// readInput 读取格式版本为 v 的录像中的一个输入
+func readInput(br *bufio.Reader, v byte) (sim.Input, error) {
+	if v < varintInputVersion {
+		b, err := br.ReadByte()
+		return sim.Input(b), err
+	}
+	in, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, err
+	}
+	if in > math.MaxUint32 {
+		return 0, ErrBadFormat
+	}
+	return sim.Input(in), nil
+}
+
+// readJSON 读取一段带长度前缀的 JSON 数据
+func readJSON(br *bufio.Reader, v any) error {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return err
+	}
+	if n > maxJSONSize {
+		return ErrBadFormat
+	}
+	data, err := io.ReadAll(io.LimitReader(br, int64(n)))
+	if err != nil {
+		return err
+	}
+	if uint64(len(data)) < n {
+		return io.ErrUnexpectedEOF
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Save 将录像保存到文件
+func (r *Replay) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := r.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load 从文件中加载录像
+func Load(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// sameFrame 判断两帧的输入是否完全相同
+func sameFrame(a, b []sim.Input) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+// record 用随机输入录制一段双人对局，输入中带有瞄准和摇杆的角度
+func record(t *testing.T) *Replay {
+	t.Helper()
+	cfg := sim.DefaultConfig()
+	cfg.Players = 2
+	cfg.Movement = sim.MoveAnalog
+	w := sim.NewLevelWorld(7, cfg, sim.DefaultLevel())
+	rec := NewRecorder(w, len(w.Players))
+
+	rng := rand.New(rand.NewSource(7))
+	inputs := make([]sim.Input, len(w.Players))
+	for w.Tick() < 10*sim.TicksPerSecond && !w.Finished() {
+		for i := range inputs {
+			// 每隔一段时间更换输入，让游程编码有连续相同的帧可以压缩
+			if w.Tick()%15 == 0 {
+				inputs[i] = sim.Input(1 << rng.Intn(4)).WithAim(sim.Angle(rng.Intn(256)))
+				if rng.Intn(3) == 0 {
+					inputs[i] |= sim.InputFire
+				}
+			}
+		}
+		rec.Record(inputs)
+		w.Step(inputs)
+	}
+	return rec.Finish(w)
+}
+
+// encode 将录像写入内存并返回文件内容
+func encode(t *testing.T, r *Replay) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := record(t)
+	got, err := Read(bytes.NewReader(encode(t, want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Seed != want.Seed || got.Players != want.Players || got.FinalHash != want.FinalHash {
+		t.Errorf("header: got seed %d, players %d, hash %016x; want %d, %d, %016x",
+			got.Seed, got.Players, got.FinalHash, want.Seed, want.Players, want.FinalHash)
+	}
+	if !reflect.DeepEqual(got.Config, want.Config) {
+		t.Errorf("config: got %+v, want %+v", got.Config, want.Config)
+	}
+	if !reflect.DeepEqual(got.Level, want.Level) {
+		t.Error("level differs after round trip")
+	}
+	if !reflect.DeepEqual(got.Carry, want.Carry) {
+		t.Errorf("carry: got %+v, want %+v", got.Carry, want.Carry)
+	}
+	if !reflect.DeepEqual(got.Frames, want.Frames) {
+		t.Errorf("frames differ after round trip: got %d, want %d", len(got.Frames), len(want.Frames))
+	}
+	if hash, ok := got.Verify(); !ok {
+		t.Errorf("verify: got hash %016x, want %016x", hash, want.FinalHash)
+	}
+}
+
+// rawReplay 按录像格式逐项拼出文件内容，用于构造格式错误的录像
+type rawReplay struct {
+	b []byte
+}
+
+func newRawReplay(v byte) *rawReplay {
+	return &rawReplay{b: append(magic[:len(magic):len(magic)], v)}
+}
+
+func (r *rawReplay) uvarint(v uint64) *rawReplay {
+	r.b = binary.AppendUvarint(r.b, v)
+	return r
+}
+
+func (r *rawReplay) varint(v int64) *rawReplay {
+	r.b = binary.AppendVarint(r.b, v)
+	return r
+}
+
+func (r *rawReplay) json(s string) *rawReplay {
+	r.uvarint(uint64(len(s)))
+	r.b = append(r.b, s...)
+	return r
+}
+
+// header 写入种子、空的参数、关卡和带入状态、玩家人数、帧数和哈希
+func (r *rawReplay) header(players, frames uint64) *rawReplay {
+	r.varint(1).json("{}").json("null").json("null").uvarint(players).uvarint(frames)
+	r.b = append(r.b, make([]byte, 8)...)
+	return r
+}
+
+func TestReadMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error // 为 nil 时只要求返回错误
+	}{
+		{"empty", nil, nil},
+		{"bad magic", []byte("TNKX\x0d"), ErrBadFormat},
+		{"version too old", newRawReplay(0).b, nil},
+		{"version too new", newRawReplay(version + 1).b, nil},
+		{"huge config", newRawReplay(version).varint(1).uvarint(math.MaxInt64).b, ErrBadFormat},
+		{"huge level", newRawReplay(version).varint(1).json("{}").uvarint(1 << 40).b, ErrBadFormat},
+		{"short config", newRawReplay(version).varint(1).uvarint(100).b, nil},
+		{"bad config", newRawReplay(version).varint(1).json("{").b, nil},
+		{"no players", newRawReplay(version).header(0, 0).b, ErrBadFormat},
+		{"too many players", newRawReplay(version).header(sim.MaxPlayers+1, 0).b, ErrBadFormat},
+		{"huge frame count", newRawReplay(version).header(1, math.MaxUint64).b, ErrBadFormat},
+		{"frames missing", newRawReplay(version).header(1, 10).b, nil},
+		{"empty run", newRawReplay(version).header(1, 10).uvarint(0).b, ErrBadFormat},
+		{"run past end", newRawReplay(version).header(1, 10).uvarint(11).uvarint(0).b, ErrBadFormat},
+		{"huge run", newRawReplay(version).header(1, 10).uvarint(math.MaxUint64).uvarint(0).b, ErrBadFormat},
+		{"input too wide", newRawReplay(version).header(1, 1).uvarint(1).uvarint(math.MaxUint32 + 1).b, ErrBadFormat},
+		{"zero interval", newRawReplay(version).varint(1).json(`{"change_dir_interval":0}`).json("null").json("null").uvarint(1).b, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp, err := Read(bytes.NewReader(tt.data))
+			if err == nil {
+				t.Fatalf("got replay with %d frames, want error", len(rp.Frames))
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	data := encode(t, record(t))
+	for n := 0; n < len(data); n++ {
+		if _, err := Read(bytes.NewReader(data[:n])); err == nil {
+			t.Fatalf("reading the first %d of %d bytes succeeded", n, len(data))
+		}
+	}
+}
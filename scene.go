@@ -0,0 +1,77 @@
+package main
+
+import (
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// scene 表示游戏中的一个画面状态，例如标题、菜单、游戏中和暂停
+type scene interface {
+	// enter 在切换到该画面时调用
+	enter(g *Game)
+	// exit 在离开该画面时调用
+	exit(g *Game)
+	// update 更新画面状态，返回 ebiten.Termination 时退出游戏
+	update(g *Game) error
+	// draw 绘制画面
+	draw(g *Game, screen *ebiten.Image)
+}
+
+// switchScene 离开当前画面并进入新的画面
+func (g *Game) switchScene(s scene) {
+	if g.scene != nil {
+		g.scene.exit(g)
+	}
+	g.scene = s
+	s.enter(g)
+}
+
+// menuItem 表示菜单中的一项
+type menuItem struct {
+	label  string
+	action func(g *Game) error
+}
+
+// menu 表示可以用方向键选择、回车确认的菜单
+type menu struct {
+	items  []menuItem
+	cursor int
+}
+
+// update 处理菜单的键盘操作，选中某一项时执行其动作
+func (m *menu) update(g *Game) error {
+	if len(m.items) == 0 {
+		return nil
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyUp) || inpututil.IsKeyJustPressed(ebiten.KeyW) {
+		m.cursor = (m.cursor + len(m.items) - 1) % len(m.items)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyDown) || inpututil.IsKeyJustPressed(ebiten.KeyS) {
+		m.cursor = (m.cursor + 1) % len(m.items)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeySpace) {
+		return m.items[m.cursor].action(g)
+	}
+	return nil
+}
+
+// draw 从 y 开始逐行绘制菜单，当前选中的一项高亮显示
+func (m *menu) draw(screen *ebiten.Image, y float64) {
+	for i, item := range m.items {
+		clr := color.Color(color.RGBA{192, 192, 192, 255})
+		label := item.label
+		if i == m.cursor {
+			clr = color.RGBA{255, 215, 0, 255}
+			label = "> " + label + " <"
+		}
+		drawCenteredText(screen, label, 20, y+float64(i)*32, clr)
+	}
+}
+
+// quitGame 菜单中“退出”一项的动作，联网对局中先通知其他玩家
+func quitGame(g *Game) error {
+	g.leaveNet()
+	return ebiten.Termination
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+const (
+	// stageIntroTicks 关卡介绍画面显示的帧数
+	stageIntroTicks = 2 * sim.TicksPerSecond
+	// stallNoticeTicks 联网对局中等待其他玩家超过这么多次更新后显示提示
+	stallNoticeTicks = sim.TicksPerSecond / 2
+)
+
+// titleScene 标题画面
+type titleScene struct{}
+
+func (s *titleScene) enter(g *Game) {}
+
+func (s *titleScene) exit(g *Game) {}
+
+func (s *titleScene) update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeySpace) {
+		g.switchScene(&menuScene{})
+	}
+	return nil
+}
+
+func (s *titleScene) draw(g *Game, screen *ebiten.Image) {
+	drawCenteredText(screen, "坦克大战", 56, 150, color.RGBA{0, 255, 0, 255})
+	drawCenteredText(screen, "按回车键开始", 20, 300, color.RGBA{192, 192, 192, 255})
+}
+
+// menuScene 主菜单
+type menuScene struct {
+	menu menu
+}
+
+func (s *menuScene) enter(g *Game) {
+	g.leaveNet()
+	s.build(g)
+}
+
+// build 根据当前的玩家人数、友军伤害和难度设置生成菜单项，保留光标位置
+func (s *menuScene) build(g *Game) {
+	s.menu.items = []menuItem{
+		{label: "开始游戏", action: func(g *Game) error {
+			g.startRun(0, nil)
+			return nil
+		}},
+	}
+	if g.progress.unlocked(g.campaign.Name) > 1 {
+		s.menu.items = append(s.menu.items, menuItem{label: "选择关卡", action: func(g *Game) error {
+			g.switchScene(&stageSelectScene{})
+			return nil
+		}})
+	}
+
+	players := "玩家人数：单人"
+	if g.players == 2 {
+		players = "玩家人数：双人合作"
+	}
+	s.menu.items = append(s.menu.items, menuItem{label: players, action: func(g *Game) error {
+		g.players = 3 - g.players
+		s.build(g)
+		return nil
+	}})
+	if g.players > 1 {
+		friendlyFire := "友军伤害：关"
+		if g.friendlyFire {
+			friendlyFire = "友军伤害：开"
+		}
+		s.menu.items = append(s.menu.items, menuItem{label: friendlyFire, action: func(g *Game) error {
+			g.friendlyFire = !g.friendlyFire
+			s.build(g)
+			return nil
+		}})
+	}
+
+	adaptive := "动态难度：关"
+	if g.adaptive {
+		adaptive = "动态难度：开"
+	}
+	s.menu.items = append(s.menu.items,
+		menuItem{label: "难度：" + difficultyLabels[g.difficulty], action: func(g *Game) error {
+			g.difficulty = nextDifficulty(g.difficulty)
+			s.build(g)
+			return nil
+		}},
+		menuItem{label: adaptive, action: func(g *Game) error {
+			g.adaptive = !g.adaptive
+			s.build(g)
+			return nil
+		}},
+		menuItem{label: "移动方式：" + movementLabels[g.movement], action: func(g *Game) error {
+			g.movement = nextMovement(g.movement)
+			s.build(g)
+			return nil
+		}},
+		menuItem{label: "局域网对战", action: func(g *Game) error {
+			g.switchScene(&lanScene{})
+			return nil
+		}},
+		menuItem{label: "高分榜", action: func(g *Game) error {
+			g.switchScene(&highScoreScene{})
+			return nil
+		}},
+		menuItem{label: "退出", action: quitGame},
+	)
+}
+
+func (s *menuScene) exit(g *Game) {}
+
+func (s *menuScene) update(g *Game) error {
+	return s.menu.update(g)
+}
+
+func (s *menuScene) draw(g *Game, screen *ebiten.Image) {
+	drawCenteredText(screen, "坦克大战", 40, 60, color.RGBA{0, 255, 0, 255})
+	drawCenteredText(screen, g.campaign.Name, 18, 120, color.RGBA{192, 192, 192, 255})
+	s.menu.draw(screen, 200)
+}
+
+// stageSelectScene 选择已经解锁的起始关卡
+type stageSelectScene struct {
+	menu menu
+}
+
+func (s *stageSelectScene) enter(g *Game) {
+	s.menu = menu{}
+	unlocked := g.progress.unlocked(g.campaign.Name)
+	for i := 0; i < unlocked && i < len(g.campaign.Stages); i++ {
+		stage := i
+		label := fmt.Sprintf("第 %d 关  %s", i+1, g.campaign.Stages[i].Name)
+		s.menu.items = append(s.menu.items, menuItem{label: label, action: func(g *Game) error {
+			g.startRun(stage, nil)
+			return nil
+		}})
+	}
+	s.menu.items = append(s.menu.items, menuItem{label: "返回", action: func(g *Game) error {
+		g.switchScene(&menuScene{})
+		return nil
+	}})
+}
+
+func (s *stageSelectScene) exit(g *Game) {}
+
+func (s *stageSelectScene) update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.switchScene(&menuScene{})
+		return nil
+	}
+	return s.menu.update(g)
+}
+
+func (s *stageSelectScene) draw(g *Game, screen *ebiten.Image) {
+	unlocked := g.progress.unlocked(g.campaign.Name)
+	drawCenteredText(screen, fmt.Sprintf("选择关卡（已解锁 %d/%d）", unlocked, len(g.campaign.Stages)), 24, 60, color.White)
+	s.menu.draw(screen, 130)
+}
+
+// highScoreScene 从主菜单进入的高分榜
+type highScoreScene struct{}
+
+func (s *highScoreScene) enter(g *Game) {}
+
+func (s *highScoreScene) exit(g *Game) {}
+
+func (s *highScoreScene) update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) || inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		g.switchScene(&menuScene{})
+	}
+	return nil
+}
+
+func (s *highScoreScene) draw(g *Game, screen *ebiten.Image) {
+	g.drawHighScores(screen, 60, -1)
+	drawCenteredText(screen, "按回车键返回", 16, 440, color.RGBA{192, 192, 192, 255})
+}
+
+// introScene 关卡介绍画面，显示一段时间或按回车键后开始游戏
+type introScene struct {
+	ticks int
+}
+
+func (s *introScene) enter(g *Game) {
+	s.ticks = stageIntroTicks
+}
+
+func (s *introScene) exit(g *Game) {}
+
+func (s *introScene) update(g *Game) error {
+	if !g.pollNet() {
+		return nil
+	}
+	s.ticks--
+	// 联网对局中各玩家的介绍画面显示同样长的时间，不能跳过
+	if s.ticks <= 0 || (g.net == nil && inpututil.IsKeyJustPressed(ebiten.KeyEnter)) {
+		g.switchScene(&playingScene{})
+	}
+	return nil
+}
+
+func (s *introScene) draw(g *Game, screen *ebiten.Image) {
+	level := g.campaign.Stages[g.stage]
+	drawCenteredText(screen, g.campaign.Name, 20, 140, color.RGBA{192, 192, 192, 255})
+	drawCenteredText(screen, fmt.Sprintf("第 %d 关  %s", g.stage+1, level.Name), 32, 200, color.White)
+}
+
+// playingScene 游戏进行中
+type playingScene struct {
+	stalls int // 联网对局中连续等待其他玩家输入的更新次数
+}
+
+func (s *playingScene) enter(g *Game) {}
+
+func (s *playingScene) exit(g *Game) {}
+
+func (s *playingScene) update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) || inpututil.IsKeyJustPressed(ebiten.KeyP) {
+		g.switchScene(&pauseScene{})
+		return nil
+	}
+
+	if g.net != nil {
+		if !g.pollNet() {
+			return nil
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyF3) {
+			g.netDebug = !g.netDebug
+		}
+		if g.stepNet(g.readInput(0, g.net.Slot())) {
+			s.stalls = 0
+		} else {
+			s.stalls++
+		}
+	} else {
+		g.step(g.readInputs())
+	}
+
+	switch g.result() {
+	case sim.ResultWon:
+		g.stageCleared()
+	case sim.ResultLost:
+		g.switchScene(&gameEndScene{})
+	}
+	return nil
+}
+
+func (s *playingScene) draw(g *Game, screen *ebiten.Image) {
+	g.drawWorld(screen)
+	g.drawNetDebug(screen)
+	if s.stalls > stallNoticeTicks {
+		drawCenteredText(screen, "等待其他玩家……", 20, 220, color.RGBA{255, 215, 0, 255})
+	}
+}
+
+// pauseScene 暂停画面
+type pauseScene struct {
+	menu menu
+}
+
+func (s *pauseScene) enter(g *Game) {
+	if g.net != nil {
+		// 联网对局不能暂停，也不能单独重新开始，菜单打开时游戏仍在继续
+		s.menu = menu{
+			items: []menuItem{
+				{label: "继续", action: func(g *Game) error {
+					g.switchScene(&playingScene{})
+					return nil
+				}},
+				{label: "离开对局", action: func(g *Game) error {
+					g.switchScene(&menuScene{})
+					return nil
+				}},
+				{label: "退出", action: quitGame},
+			},
+		}
+		return
+	}
+	s.menu = menu{
+		items: []menuItem{
+			{label: "继续", action: func(g *Game) error {
+				g.switchScene(&playingScene{})
+				return nil
+			}},
+			{label: "重新开始本关", action: func(g *Game) error {
+				g.restartStage()
+				return nil
+			}},
+			{label: "返回主菜单", action: func(g *Game) error {
+				g.switchScene(&menuScene{})
+				return nil
+			}},
+			{label: "退出", action: quitGame},
+		},
+	}
+}
+
+func (s *pauseScene) exit(g *Game) {}
+
+func (s *pauseScene) update(g *Game) error {
+	if g.net != nil {
+		if !g.pollNet() {
+			return nil
+		}
+		g.stepNet(0)
+		switch g.result() {
+		case sim.ResultWon:
+			g.stageCleared()
+			return nil
+		case sim.ResultLost:
+			g.switchScene(&gameEndScene{})
+			return nil
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) || inpututil.IsKeyJustPressed(ebiten.KeyP) {
+		g.switchScene(&playingScene{})
+		return nil
+	}
+	return s.menu.update(g)
+}
+
+func (s *pauseScene) draw(g *Game, screen *ebiten.Image) {
+	g.drawWorld(screen)
+	vector.DrawFilledRect(screen, 0, 0, screenWidth, screenHeight, color.RGBA{0, 0, 0, 160}, false)
+	title := "暂停"
+	if g.net != nil {
+		title = "菜单"
+	}
+	drawCenteredText(screen, title, 40, 100, color.White)
+	s.menu.draw(screen, 190)
+}
+
+// gameEndScene 游戏失败或通关画面，分数上榜时先输入名字
+type gameEndScene struct {
+	victory  bool   // 是否通过了全部关卡
+	entering bool   // 是否正在输入高分榜上的名字
+	name     []rune // 正在输入的名字
+	rank     int    // 本局在高分榜上的名次，-1 表示没有上榜
+	menu     menu
+}
+
+func (s *gameEndScene) enter(g *Game) {
+	s.rank = -1
+	s.entering = g.scores.qualifies(g.world.TotalScore())
+
+	// 联网对局到此结束，不能单独重新开始
+	networked := g.net != nil
+	g.leaveNet()
+
+	s.menu = menu{}
+	if !networked {
+		restart := menuItem{label: "重新开始本关", action: func(g *Game) error {
+			g.restartStage()
+			return nil
+		}}
+		if s.victory {
+			restart = menuItem{label: "重新开始", action: func(g *Game) error {
+				g.startRun(0, nil)
+				return nil
+			}}
+		}
+		s.menu.items = append(s.menu.items, restart)
+	}
+	s.menu.items = append(s.menu.items,
+		menuItem{label: "返回主菜单", action: func(g *Game) error {
+			g.switchScene(&menuScene{})
+			return nil
+		}},
+		menuItem{label: "退出", action: quitGame},
+	)
+}
+
+func (s *gameEndScene) exit(g *Game) {}
+
+func (s *gameEndScene) update(g *Game) error {
+	if !s.entering {
+		return s.menu.update(g)
+	}
+
+	for _, r := range ebiten.AppendInputChars(nil) {
+		if len(s.name) < maxNameLength {
+			s.name = append(s.name, r)
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBackspace) && len(s.name) > 0 {
+		s.name = s.name[:len(s.name)-1]
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		name := strings.TrimSpace(string(s.name))
+		if name == "" {
+			name = "无名氏"
+		}
+		s.rank = g.scores.add(scoreEntry{
+			Name:  name,
+			Score: g.world.TotalScore(),
+			Stage: g.stage + 1,
+			Date:  time.Now(),
+		})
+		if err := g.scores.save(); err != nil {
+			log.Println("save high scores:", err)
+		}
+		s.entering = false
+	}
+	return nil
+}
+
+func (s *gameEndScene) draw(g *Game, screen *ebiten.Image) {
+	switch {
+	case s.victory:
+		drawCenteredText(screen, "恭喜通关！", 36, 20, color.RGBA{255, 215, 0, 255})
+		drawCenteredText(screen, fmt.Sprintf("%s 全部 %d 关已完成", g.campaign.Name, len(g.campaign.Stages)), 16, 66, color.White)
+	case g.world.BaseDestroyed:
+		drawCenteredText(screen, "基地被摧毁！", 36, 20, color.RGBA{255, 0, 0, 255})
+	default:
+		drawCenteredText(screen, "GAME OVER!", 36, 20, color.RGBA{255, 0, 0, 255})
+	}
+	msg := fmt.Sprintf("得分: %d", g.world.TotalScore())
+	if len(g.world.Players) > 1 {
+		for i, p := range g.world.Players {
+			msg += fmt.Sprintf("  %dP: %d", i+1, p.Score)
+		}
+	}
+	drawCenteredText(screen, msg, 20, 90, color.White)
+
+	if s.entering {
+		drawCenteredText(screen, "新纪录！请输入名字，回车确认", 18, 180, color.RGBA{192, 192, 192, 255})
+		drawCenteredText(screen, string(s.name)+"_", 24, 215, color.RGBA{255, 215, 0, 255})
+		return
+	}
+
+	g.drawHighScores(screen, 120, s.rank)
+	s.menu.draw(screen, 370)
+}
+
+// replayScene 回放录像，P 键暂停，Esc 键退出
+type replayScene struct {
+	paused bool
+}
+
+func (s *replayScene) enter(g *Game) {}
+
+func (s *replayScene) exit(g *Game) {}
+
+func (s *replayScene) update(g *Game) error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		return ebiten.Termination
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyP) {
+		s.paused = !s.paused
+	}
+	if s.paused || g.world.Finished() || g.playbackEnded() {
+		return nil
+	}
+	g.step(g.playback.Frames[g.frame])
+	g.frame++
+	return nil
+}
+
+func (s *replayScene) draw(g *Game, screen *ebiten.Image) {
+	g.drawWorld(screen)
+
+	switch {
+	case g.world.Result == sim.ResultLost:
+		ebitenutil.DebugPrintAt(screen, "REPLAY END: GAME OVER", 2, statusBarHeight+2)
+	case g.world.Result == sim.ResultWon:
+		ebitenutil.DebugPrintAt(screen, "REPLAY END: YOU WIN", 2, statusBarHeight+2)
+	case g.playbackEnded():
+		ebitenutil.DebugPrintAt(screen, "REPLAY END", 2, statusBarHeight+2)
+	case s.paused:
+		ebitenutil.DebugPrintAt(screen, "REPLAY PAUSED", 2, statusBarHeight+2)
+	}
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+const (
+	// InterpolationDelay 客户端显示的画面比收到的最新快照晚的帧数，
+	// 留出两个快照间隔，偶尔晚到一个快照时仍然有两个快照可以插值
+	InterpolationDelay = 2 * SnapshotInterval
+	// 连接断开后每隔多久尝试重新连接一次
+	reconnectInterval = time.Second
+	// 建立连接和握手的最长时间
+	dialTimeout = 5 * time.Second
+	// 客户端保留的快照数
+	keptStates = 8
+)
+
+// ClientStats 客户端收到的快照的统计
+type ClientStats struct {
+	Snapshots  int // 收到的快照数
+	Bytes      int // 收到的快照的总字节数
+	FullBytes  int // 这些快照不做增量编码时的总字节数
+	Reconnects int // 重新连接的次数
+}
+
+// Client 连接到专用服务器的瘦客户端：发送本地玩家的输入，接收快照并插值显示。
+// 连接断开后客户端会在 ReconnectTimeout 内自动用令牌重新连接到原来的位置。
+type Client struct {
+	addr     string
+	name     string
+	spectate bool
+
+	mu        sync.Mutex
+	conn      net.Conn
+	welcome   welcomeMsg
+	states    []*State // 按帧号排序的最近几个快照
+	offset    float64  // 服务器第 0 帧对应的本地时间（秒），用于把本地时间换算为服务器帧号
+	hasOffset bool
+	stats     ClientStats
+	err       error // 无法恢复的错误，例如重连超时
+	closed    bool
+	stream    *StreamWriter // 不为 nil 时收到的快照同时写入观战流
+}
+
+// Dial 连接到服务器并加入指定的房间
+func Dial(addr, name, room string) (*Client, error) {
+	return dial(&Client{addr: addr, name: name}, room)
+}
+
+// Spectate 以观战者身份连接到服务器上已经存在的房间，只接收快照
+func Spectate(addr, name, room string) (*Client, error) {
+	return dial(&Client{addr: addr, name: name, spectate: true}, room)
+}
+
+func dial(c *Client, room string) (*Client, error) {
+	conn, reader, welcome, err := c.handshake(helloMsg{Name: c.name, Room: room})
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	c.welcome = welcome
+	go c.readLoop(conn, reader)
+	return c, nil
+}
+
+// handshake 建立连接并完成加入房间的握手
+func (c *Client) handshake(hello helloMsg) (net.Conn, *bufio.Reader, welcomeMsg, error) {
+	var welcome welcomeMsg
+	conn, err := net.DialTimeout("tcp", c.addr, dialTimeout)
+	if err != nil {
+		return nil, nil, welcome, err
+	}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	hello.Magic = protocolMagic
+	hello.Version = protocolVersion
+	hello.Spectate = c.spectate
+	if err := writeJSON(conn, msgHello, hello); err != nil {
+		conn.Close()
+		return nil, nil, welcome, err
+	}
+
+	reader := bufio.NewReader(conn)
+	typ, payload, err := readMessage(reader)
+	if err == nil {
+		switch typ {
+		case msgWelcome:
+			err = json.Unmarshal(payload, &welcome)
+		case msgReject:
+			var msg rejectMsg
+			if err = json.Unmarshal(payload, &msg); err == nil {
+				err = rejectError(msg.Reason)
+			}
+		default:
+			err = ErrBadMessage
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, welcome, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, reader, welcome, nil
+}
+
+// rejectError 把服务器拒绝的原因还原为对应的错误
+func rejectError(reason string) error {
+	for _, err := range []error{ErrRoomFull, ErrBadToken, ErrNoRoom, ErrServerFull, ErrServerClosed} {
+		if reason == err.Error() {
+			return err
+		}
+	}
+	return errors.New(reason)
+}
+
+// readLoop 接收快照，连接断开后尝试重新连接
+func (c *Client) readLoop(conn net.Conn, reader *bufio.Reader) {
+	for {
+		c.receive(conn, reader)
+		conn.Close()
+
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+		var err error
+		if conn, reader, err = c.reconnect(); err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			return
+		}
+	}
+}
+
+// receive 接收快照直到连接出错
+func (c *Client) receive(conn net.Conn, reader *bufio.Reader) error {
+	var base []byte
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		typ, payload, err := readMessage(reader)
+		if err != nil {
+			return err
+		}
+		if typ != msgSnapshot {
+			continue
+		}
+		cur, err := decodeDelta(base, payload)
+		if err != nil {
+			return err
+		}
+		state, err := decodeState(cur)
+		if err != nil {
+			return err
+		}
+		base = cur
+		c.addState(state, len(payload)+5, len(cur)+5)
+	}
+}
+
+// reconnect 用令牌重新连接到原来的位置，直到成功或超时
+func (c *Client) reconnect() (net.Conn, *bufio.Reader, error) {
+	c.mu.Lock()
+	hello := helloMsg{Name: c.name, Room: c.welcome.Room, Token: c.welcome.Token}
+	c.mu.Unlock()
+
+	deadline := time.Now().Add(ReconnectTimeout)
+	for {
+		conn, reader, welcome, err := c.handshake(hello)
+		if err == nil {
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				conn.Close()
+				return nil, nil, ErrServerClosed
+			}
+			c.conn = conn
+			c.welcome = welcome
+			c.states = nil
+			c.stats.Reconnects++
+			c.mu.Unlock()
+			return conn, reader, nil
+		}
+		if errors.Is(err, ErrBadToken) || errors.Is(err, ErrRoomFull) || errors.Is(err, ErrNoRoom) || time.Now().After(deadline) {
+			return nil, nil, err
+		}
+		time.Sleep(reconnectInterval)
+	}
+}
+
+// addState 保存收到的快照，并根据它的帧号校准服务器的时钟
+func (c *Client) addState(s *State, size, fullSize int) {
+	now := float64(time.Now().UnixNano()) / 1e9
+	offset := now - float64(s.Tick)/sim.TicksPerSecond
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// 快照到达的时间有抖动，平滑地跟踪服务器时钟，但较早到达的快照说明估计偏晚，
+	// 立即采用
+	if !c.hasOffset || offset < c.offset {
+		c.offset = offset
+		c.hasOffset = true
+	} else {
+		c.offset += (offset - c.offset) / 16
+	}
+	if n := len(c.states); n > 0 && s.Tick <= c.states[n-1].Tick {
+		c.states = c.states[:0]
+	}
+	c.states = append(c.states, s)
+	if len(c.states) > keptStates {
+		c.states = c.states[1:]
+	}
+	c.stats.Snapshots++
+	c.stats.Bytes += size
+	c.stats.FullBytes += fullSize
+	if c.stream != nil {
+		if err := c.stream.WriteState(s); err != nil {
+			c.err = err
+		}
+	}
+}
+
+// Record 把之后收到的快照同时写入观战流，w 为 nil 时停止写入。
+// 观战流由调用者关闭。
+func (c *Client) Record(w *StreamWriter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stream = w
+}
+
+// State 返回当前应当显示的状态：在比服务器晚 InterpolationDelay 帧的
+// 位置前后两个快照之间插值。还没有收到快照时返回 nil。
+func (c *Client) State() *State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.states) == 0 {
+		return nil
+	}
+	now := float64(time.Now().UnixNano()) / 1e9
+	return stateAt(c.states, (now-c.offset)*sim.TicksPerSecond-InterpolationDelay)
+}
+
+// SendInput 发送本地玩家这一帧的输入，连接断开时忽略
+func (c *Client) SendInput(in sim.Input) {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if writeMessage(conn, msgInput, binary.AppendUvarint(nil, uint64(in))) != nil {
+		// 关闭连接让 readLoop 发现错误并重新连接
+		conn.Close()
+	}
+}
+
+// Slot 返回本地玩家的序号，观战者为 -1
+func (c *Client) Slot() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.welcome.Slot
+}
+
+// Config 返回房间的游戏参数
+func (c *Client) Config() sim.Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.welcome.Config
+}
+
+// Room 返回房间的名字
+func (c *Client) Room() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.welcome.Room
+}
+
+// Stats 返回收到的快照的统计
+func (c *Client) Stats() ClientStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Err 返回导致客户端无法继续的错误，例如重新连接超时
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Drop 断开当前的连接但不离开房间，客户端随后会自动重新连接。用于测试断线重连。
+func (c *Client) Drop() {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	conn.Close()
+}
+
+// Close 离开房间并断开连接，服务器立即释放玩家的位置
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	conn := c.conn
+	c.mu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	writeMessage(conn, msgLeave, nil)
+	return conn.Close()
+}
@@ -0,0 +1,135 @@
+// Package server 实现专用服务器：服务器在没有窗口的环境中运行权威的模拟，
+// 客户端通过 TCP 连接到服务器上的某个房间，只发送自己的输入，并显示服务器
+// 发来的快照。一个服务器进程可以同时运行多个房间，每个房间是一场独立的对局。
+//
+// 快照以增量方式发送：服务器把世界状态编码为固定顺序的字节序列，与上一次发给
+// 该客户端的快照逐字节异或，再把连续的零压缩掉。墙和道具在两次快照之间几乎
+// 不变，异或后大部分是零。TCP 保证了顺序和可靠性，因此上一次发送的快照就是
+// 客户端已经拥有的基准，不需要确认；重新连接时从完整的快照重新开始。
+//
+// 客户端在两个快照之间插值显示，显示时间比收到的最新快照晚一点，画面因此
+// 是平滑的，即使快照的频率低于画面的帧率。
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+const (
+	// DefaultPort 服务器默认监听的 TCP 端口
+	DefaultPort = 7778
+	// SnapshotInterval 每隔多少帧发送一次快照
+	SnapshotInterval = 3
+
+	protocolMagic = "TNKS"
+	// 专用服务器协议的版本号，各版本的变化见 README
+	protocolVersion = 4
+	// 一条消息的最大长度
+	maxMessageSize = 1 << 20
+)
+
+// msgType 表示消息的类型
+type msgType uint8
+
+const (
+	msgHello    msgType = iota + 1 // 客户端：加入房间或重新连接，JSON
+	msgWelcome                     // 服务器：分配的玩家序号和重连令牌，JSON
+	msgReject                      // 服务器：拒绝加入的原因，JSON
+	msgInput                       // 客户端：本地玩家的输入，不超过 32 位的 uvarint
+	msgSnapshot                    // 服务器：增量编码的世界快照
+	msgLeave                       // 客户端：离开房间，不再重新连接
+)
+
+var (
+	// ErrBadMessage 表示收到的消息格式不正确
+	ErrBadMessage = errors.New("server: bad message")
+	// ErrRoomFull 表示房间中已经没有空位
+	ErrRoomFull = errors.New("server: room is full")
+	// ErrBadToken 表示重连令牌无效，通常是因为等待重连的时间已过
+	ErrBadToken = errors.New("server: invalid reconnect token")
+	// ErrNoRoom 表示要观战的房间不存在
+	ErrNoRoom = errors.New("server: no such room")
+)
+
+// helloMsg 客户端连接后发送的第一条消息
+type helloMsg struct {
+	Magic   string `json:"magic"`
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Room    string `json:"room"`
+	Token   string `json:"token,omitempty"` // 重新连接时使用之前得到的令牌
+	// Spectate 为 true 时以观战者身份加入，只接收快照，不占用玩家的位置
+	Spectate bool `json:"spectate,omitempty"`
+}
+
+// welcomeMsg 服务器接受加入后的回复
+type welcomeMsg struct {
+	Room    string     `json:"room"`
+	Slot    int        `json:"slot"` // 观战者为 -1
+	Token   string     `json:"token"`
+	Config  sim.Config `json:"config"`
+	Players int        `json:"players"`
+}
+
+// rejectMsg 服务器拒绝加入的原因
+type rejectMsg struct {
+	Reason string `json:"reason"`
+}
+
+// encodeMessage 编码一条消息：4 字节长度、1 字节类型和内容
+func encodeMessage(t msgType, payload []byte) []byte {
+	msg := make([]byte, 5, 5+len(payload))
+	binary.LittleEndian.PutUint32(msg, uint32(len(payload)+1))
+	msg[4] = byte(t)
+	return append(msg, payload...)
+}
+
+// writeMessage 写入一条消息
+func writeMessage(w io.Writer, t msgType, payload []byte) error {
+	_, err := w.Write(encodeMessage(t, payload))
+	return err
+}
+
+// writeJSON 写入一条 JSON 内容的消息
+func writeJSON(w io.Writer, t msgType, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeMessage(w, t, data)
+}
+
+// readMessage 读取一条消息
+func readMessage(r io.Reader) (msgType, []byte, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.LittleEndian.Uint32(head[:4])
+	if n == 0 || n > maxMessageSize {
+		return 0, nil, ErrBadMessage
+	}
+	payload := make([]byte, n-1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return msgType(head[4]), payload, nil
+}
+
+// readJSON 读取一条指定类型的 JSON 消息
+func readJSON(r io.Reader, t msgType, v any) error {
+	typ, data, err := readMessage(r)
+	if err != nil {
+		return err
+	}
+	if typ != t {
+		return fmt.Errorf("server: unexpected message type %d", typ)
+	}
+	return json.Unmarshal(data, v)
+}
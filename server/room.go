@@ -0,0 +1,356 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+const (
+	// ReconnectTimeout 客户端断开后为它保留位置的时间，期间可以用令牌重新连接
+	ReconnectTimeout = 30 * time.Second
+	// EmptyRoomTimeout 房间中没有任何玩家（包括等待重连的玩家）超过这段时间后关闭，观战者不算在内
+	EmptyRoomTimeout = 10 * time.Second
+	// 一局结束后等待多少帧开始下一关或重新开始
+	stageEndTicks = 3 * sim.TicksPerSecond
+	// 每名玩家最多排队的输入数，超过时丢弃最旧的输入，避免延迟越积越大
+	maxQueuedInputs = 8
+	// 每个客户端最多排队等待发送的消息数，超过时认为客户端太慢而断开它
+	maxQueuedMessages = 64
+	// 每个房间最多的观战者人数
+	maxSpectators = 16
+)
+
+// clientConn 表示房间中的一个客户端连接
+type clientConn struct {
+	send      chan []byte // 等待写入连接的消息，由 writeLoop 发送
+	closeOnce sync.Once
+	base      []byte // 上一次发给该客户端的快照编码，只由房间的模拟协程访问
+}
+
+// close 停止发送，writeLoop 随之关闭连接
+func (c *clientConn) close() {
+	c.closeOnce.Do(func() { close(c.send) })
+}
+
+// seat 表示房间中的一个玩家位置
+type seat struct {
+	name   string
+	token  string      // 为空表示位置空闲
+	conn   *clientConn // 客户端断开时为 nil
+	lost   time.Time   // 客户端断开的时间
+	inputs []sim.Input // 还没有使用的输入
+	last   sim.Input   // 最近一次使用的输入
+}
+
+// room 表示服务器上的一个房间，运行一场独立的对局
+type room struct {
+	name     string
+	campaign *sim.Campaign
+	cfg      sim.Config
+
+	mu         sync.Mutex
+	seats      []seat
+	spectators []*clientConn
+	world      *sim.World
+	stage      int
+	tick       int // 房间创建以来的帧数，切换关卡时不会归零
+	endTicks   int // 本关结束后已经等待的帧数
+	emptySince time.Time
+	closed     bool
+	done       chan struct{}
+}
+
+// newRoom 创建房间并从战役的第一关开始
+func newRoom(name string, campaign *sim.Campaign, cfg sim.Config) *room {
+	r := &room{
+		name:       name,
+		campaign:   campaign,
+		cfg:        cfg,
+		seats:      make([]seat, cfg.Players),
+		emptySince: time.Now(),
+		done:       make(chan struct{}),
+	}
+	r.startStage(0, nil)
+	return r
+}
+
+// startStage 开始战役中的第 stage 关，随机种子由房间的帧数决定
+func (r *room) startStage(stage int, carry *sim.Carry) {
+	r.stage = stage
+	r.endTicks = 0
+	r.world = sim.NewLevelWorld(int64(r.tick)+1, r.cfg, r.campaign.Stages[stage])
+	r.world.ApplyCarry(carry)
+}
+
+// join 为客户端分配一个位置，token 不为空时重新连接到之前的位置
+func (r *room) join(c *clientConn, name, token string) (welcomeMsg, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return welcomeMsg{}, ErrRoomFull
+	}
+	index := -1
+	for i := range r.seats {
+		if token != "" && r.seats[i].token == token {
+			index = i
+			break
+		}
+		if token == "" && r.seats[i].token == "" && index < 0 {
+			index = i
+		}
+	}
+	switch {
+	case index < 0 && token != "":
+		return welcomeMsg{}, ErrBadToken
+	case index < 0:
+		return welcomeMsg{}, ErrRoomFull
+	}
+
+	s := &r.seats[index]
+	if s.conn != nil {
+		// 旧的连接可能还没有发现自己已经断开
+		s.conn.close()
+	}
+	if s.token == "" {
+		s.token = newToken()
+		s.name = name
+	}
+	s.conn = c
+	s.inputs = s.inputs[:0]
+	c.base = nil
+
+	welcome := welcomeMsg{Room: r.name, Slot: index, Token: s.token, Config: r.cfg, Players: len(r.seats)}
+	return welcome, nil
+}
+
+// spectate 以观战者身份加入房间
+func (r *room) spectate(c *clientConn) (welcomeMsg, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return welcomeMsg{}, ErrNoRoom
+	}
+	if len(r.spectators) >= maxSpectators {
+		return welcomeMsg{}, ErrRoomFull
+	}
+	r.spectators = append(r.spectators, c)
+	return welcomeMsg{Room: r.name, Slot: -1, Config: r.cfg, Players: len(r.seats)}, nil
+}
+
+// leave 客户端断开连接。release 为 false 时它的位置会保留一段时间等待重新连接，
+// 为 true 表示玩家主动离开，位置立即空出来
+func (r *room) leave(c *clientConn, release bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.seats {
+		if r.seats[i].conn != c {
+			continue
+		}
+		if release {
+			r.seats[i] = seat{}
+		} else {
+			r.seats[i].conn = nil
+			r.seats[i].lost = time.Now()
+		}
+	}
+	r.removeSpectator(c)
+	c.close()
+}
+
+// removeSpectator 从观战者中移除 c
+func (r *room) removeSpectator(c *clientConn) {
+	for i, s := range r.spectators {
+		if s == c {
+			r.spectators = append(r.spectators[:i], r.spectators[i+1:]...)
+			return
+		}
+	}
+}
+
+// input 记录客户端发来的一个输入
+func (r *room) input(c *clientConn, in sim.Input) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.seats {
+		s := &r.seats[i]
+		if s.conn != c {
+			continue
+		}
+		if len(s.inputs) >= maxQueuedInputs {
+			// 丢弃最旧的输入，但保留其中的射击
+			s.inputs[1] |= s.inputs[0] & sim.InputFire
+			s.inputs = s.inputs[1:]
+		}
+		s.inputs = append(s.inputs, in)
+	}
+}
+
+// run 以固定的帧率推进模拟并发送快照，直到房间关闭
+func (r *room) run(onClose func()) {
+	defer close(r.done)
+	ticker := time.NewTicker(time.Second / sim.TicksPerSecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !r.update(time.Now()) {
+			onClose()
+			return
+		}
+	}
+}
+
+// update 推进一帧，房间应当关闭时返回 false
+func (r *room) update(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return false
+	}
+
+	occupied := false
+	for i := range r.seats {
+		s := &r.seats[i]
+		if s.token != "" && s.conn == nil && now.Sub(s.lost) > ReconnectTimeout {
+			// 等待重连超时，位置空出来给新的玩家
+			*s = seat{}
+		}
+		if s.token != "" {
+			occupied = true
+		}
+	}
+	if occupied {
+		r.emptySince = now
+	} else if now.Sub(r.emptySince) > EmptyRoomTimeout {
+		r.closeLocked()
+		return false
+	}
+
+	r.step()
+	r.tick++
+	if r.tick%SnapshotInterval == 0 {
+		r.broadcast()
+	}
+	return true
+}
+
+// step 用各玩家排队的输入推进模拟，一关结束后等待一会儿再进入下一关或重新开始
+func (r *room) step() {
+	if r.world.Finished() {
+		r.endTicks++
+		if r.endTicks < stageEndTicks {
+			return
+		}
+		switch {
+		case r.world.Result == sim.ResultWon && r.stage+1 < len(r.campaign.Stages):
+			r.startStage(r.stage+1, r.world.Carry())
+		default:
+			r.startStage(0, nil)
+		}
+		return
+	}
+
+	inputs := make([]sim.Input, len(r.seats))
+	for i := range r.seats {
+		s := &r.seats[i]
+		if len(s.inputs) > 0 {
+			s.last = s.inputs[0]
+			s.inputs = s.inputs[1:]
+			inputs[i] = s.last
+		} else if s.conn != nil {
+			// 输入还没有到达时沿用上一次的方向，射击只在按下的那一帧有效
+			inputs[i] = s.last &^ sim.InputFire
+		}
+	}
+	r.world.Step(inputs)
+}
+
+// broadcast 向每个在线的客户端发送相对于它上一次快照的增量
+func (r *room) broadcast() {
+	state := NewState(r.world, r.stage)
+	state.Tick = r.tick
+	for i := range state.Players {
+		state.Players[i].Connected = i < len(r.seats) && r.seats[i].conn != nil
+	}
+	enc := state.encode()
+
+	for i := range r.seats {
+		if c := r.seats[i].conn; c != nil && !r.send(c, enc) {
+			// 客户端跟不上，断开后它会重新连接并收到完整的快照
+			r.seats[i].conn = nil
+			r.seats[i].lost = time.Now()
+			c.close()
+		}
+	}
+	for i := 0; i < len(r.spectators); {
+		if c := r.spectators[i]; !r.send(c, enc) {
+			r.removeSpectator(c)
+			c.close()
+			continue
+		}
+		i++
+	}
+}
+
+// send 向客户端发送相对于它上一次快照的增量，发送队列已满时返回 false
+func (r *room) send(c *clientConn, enc []byte) bool {
+	select {
+	case c.send <- encodeMessage(msgSnapshot, encodeDelta(c.base, enc)):
+		c.base = enc
+		return true
+	default:
+		return false
+	}
+}
+
+// info 返回房间的状态
+func (r *room) info() RoomInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info := RoomInfo{Name: r.name, Stage: r.stage, Tick: r.tick, MaxPlayers: len(r.seats)}
+	for _, s := range r.seats {
+		if s.token != "" {
+			info.Players++
+		}
+		if s.conn != nil {
+			info.Online++
+		}
+	}
+	info.Spectators = len(r.spectators)
+	return info
+}
+
+// close 关闭房间并断开所有客户端
+func (r *room) close() {
+	r.mu.Lock()
+	r.closeLocked()
+	r.mu.Unlock()
+	<-r.done
+}
+
+func (r *room) closeLocked() {
+	if r.closed {
+		return
+	}
+	r.closed = true
+	for i := range r.seats {
+		if c := r.seats[i].conn; c != nil {
+			c.close()
+		}
+		r.seats[i] = seat{}
+	}
+	for _, c := range r.spectators {
+		c.close()
+	}
+	r.spectators = nil
+}
+
+// newToken 生成一个随机的重连令牌
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
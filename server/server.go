@@ -0,0 +1,257 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+const (
+	// 客户端连接后必须在这段时间内发送 hello
+	helloTimeout = 5 * time.Second
+	// 客户端超过这段时间没有发来任何消息时认为它已经断开
+	readTimeout = 10 * time.Second
+	// 写入一条消息的最长时间
+	writeTimeout = 5 * time.Second
+)
+
+// ErrServerFull 表示服务器上的房间数已经达到上限
+var ErrServerFull = errors.New("server: too many rooms")
+
+// ErrServerClosed 表示服务器已经关闭
+var ErrServerClosed = errors.New("server: closed")
+
+// RoomInfo 房间的状态
+type RoomInfo struct {
+	Name       string
+	Stage      int
+	Tick       int
+	Players    int // 占用的位置数，包括等待重连的玩家
+	Online     int // 在线的玩家数
+	MaxPlayers int
+	Spectators int
+}
+
+// Server 专用服务器，为每个房间运行一场独立的对局
+type Server struct {
+	// Campaign 每个房间进行的战役
+	Campaign *sim.Campaign
+	// Config 每个房间的游戏参数，Players 为房间的玩家人数
+	Config sim.Config
+	// MaxRooms 同时存在的最多房间数，0 表示不限制
+	MaxRooms int
+	// Logf 记录房间的创建、关闭和玩家的进出，为 nil 时不记录
+	Logf func(format string, args ...any)
+
+	mu     sync.Mutex
+	rooms  map[string]*room
+	ln     net.Listener
+	closed bool
+}
+
+// NewServer 创建服务器
+func NewServer(campaign *sim.Campaign, cfg sim.Config) *Server {
+	return &Server{
+		Campaign: campaign,
+		Config:   cfg,
+		rooms:    make(map[string]*room),
+	}
+}
+
+// Serve 接受客户端的连接，直到 Close 被调用
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close 停止接受连接并关闭所有房间
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	rooms := make([]*room, 0, len(s.rooms))
+	for _, r := range s.rooms {
+		rooms = append(rooms, r)
+	}
+	s.mu.Unlock()
+
+	for _, r := range rooms {
+		r.close()
+	}
+	return err
+}
+
+// Rooms 返回所有房间的状态，按名字排序
+func (s *Server) Rooms() []RoomInfo {
+	s.mu.Lock()
+	rooms := make([]*room, 0, len(s.rooms))
+	for _, r := range s.rooms {
+		rooms = append(rooms, r)
+	}
+	s.mu.Unlock()
+
+	infos := make([]RoomInfo, 0, len(rooms))
+	for _, r := range rooms {
+		infos = append(infos, r.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// room 返回指定名字的房间，不存在时 create 为 true 则创建，否则返回 ErrNoRoom
+func (s *Server) room(name string, create bool) (*room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrServerClosed
+	}
+	if r, ok := s.rooms[name]; ok {
+		return r, nil
+	}
+	if !create {
+		return nil, ErrNoRoom
+	}
+	if s.MaxRooms > 0 && len(s.rooms) >= s.MaxRooms {
+		return nil, ErrServerFull
+	}
+
+	r := newRoom(name, s.Campaign, s.Config)
+	s.rooms[name] = r
+	go r.run(func() {
+		s.mu.Lock()
+		if s.rooms[name] == r {
+			delete(s.rooms, name)
+		}
+		s.mu.Unlock()
+		s.logf("room %q closed", name)
+	})
+	s.logf("room %q created", name)
+	return r, nil
+}
+
+// handle 处理一个客户端连接：握手后把收到的输入交给房间，直到连接断开
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	var hello helloMsg
+	conn.SetDeadline(time.Now().Add(helloTimeout))
+	if err := readJSON(reader, msgHello, &hello); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	if hello.Magic != protocolMagic || hello.Version != protocolVersion {
+		reject(conn, errors.New("server: protocol version mismatch"))
+		return
+	}
+
+	// 观战者不会创建房间
+	r, err := s.room(hello.Room, !hello.Spectate)
+	if err != nil {
+		reject(conn, err)
+		return
+	}
+	c := &clientConn{send: make(chan []byte, maxQueuedMessages)}
+	var welcome welcomeMsg
+	if hello.Spectate {
+		welcome, err = r.spectate(c)
+	} else {
+		welcome, err = r.join(c, hello.Name, hello.Token)
+	}
+	if err != nil {
+		reject(conn, err)
+		return
+	}
+	if err := writeJSON(conn, msgWelcome, welcome); err != nil {
+		r.leave(c, false)
+		return
+	}
+	switch {
+	case hello.Spectate:
+		s.logf("%s (%s) is watching room %q", hello.Name, conn.RemoteAddr(), r.name)
+	case hello.Token != "":
+		s.logf("%s (%s) reconnected to room %q as player %d", hello.Name, conn.RemoteAddr(), r.name, welcome.Slot+1)
+	default:
+		s.logf("%s (%s) joined room %q as player %d", hello.Name, conn.RemoteAddr(), r.name, welcome.Slot+1)
+	}
+
+	go writeLoop(conn, c)
+	release := false
+	for !release {
+		// 观战者不发送输入，断开后由写入失败发现
+		if !hello.Spectate {
+			conn.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+		typ, payload, err := readMessage(reader)
+		if err != nil {
+			break
+		}
+		switch {
+		case typ == msgInput && !hello.Spectate:
+			in, n := binary.Uvarint(payload)
+			if n > 0 && n == len(payload) && in <= math.MaxUint32 {
+				r.input(c, sim.Input(in))
+			}
+		case typ == msgLeave:
+			release = true
+		}
+	}
+	r.leave(c, release)
+	if release {
+		s.logf("%s (%s) left room %q", hello.Name, conn.RemoteAddr(), r.name)
+	} else {
+		s.logf("%s (%s) disconnected from room %q", hello.Name, conn.RemoteAddr(), r.name)
+	}
+}
+
+// writeLoop 把房间交给客户端的消息写入连接，发送队列关闭或写入失败时关闭连接
+func writeLoop(conn net.Conn, c *clientConn) {
+	defer conn.Close()
+	for msg := range c.send {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := conn.Write(msg); err != nil {
+			return
+		}
+	}
+}
+
+// reject 告诉客户端拒绝加入的原因
+func reject(conn net.Conn, err error) {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	writeJSON(conn, msgReject, rejectMsg{Reason: err.Error()})
+}
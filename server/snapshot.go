@@ -0,0 +1,443 @@
+package server
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+// PlayerState 快照中的一名玩家
+type PlayerState struct {
+	Tank      sim.Tank // HasTank 为 false 时无意义
+	HasTank   bool
+	Lives     int
+	Score     int
+	Combo     int
+	Respawn   int  // 距离重生剩余的帧数
+	Connected bool // 玩家的客户端是否在线
+}
+
+// State 表示服务器发给客户端的一帧世界状态，只包含显示需要的部分
+type State struct {
+	Tick          int
+	Stage         int // 当前关卡在战役中的下标
+	Result        sim.Result
+	BaseDestroyed bool
+	FreezeTicks   int
+	Walls         []sim.Wall
+	PowerUps      []sim.PowerUp
+	Boss          *sim.Tank
+	Enemies       []sim.Tank
+	Players       []PlayerState
+	Bullets       []sim.Bullet
+	Effects       []sim.Effect
+}
+
+// NewState 从世界中取出快照，stage 为当前关卡在战役中的下标。
+// 快照的帧号为世界的帧号，所有玩家都标记为在线。
+func NewState(w *sim.World, stage int) *State {
+	s := &State{
+		Tick:          w.Tick(),
+		Stage:         stage,
+		Result:        w.Result,
+		BaseDestroyed: w.BaseDestroyed,
+		FreezeTicks:   w.FreezeTicks,
+		Walls:         append([]sim.Wall(nil), w.Walls...),
+		PowerUps:      append([]sim.PowerUp(nil), w.PowerUps...),
+		Enemies:       append([]sim.Tank(nil), w.EnemyTanks...),
+		Bullets:       append([]sim.Bullet(nil), w.Bullets...),
+		Effects:       append([]sim.Effect(nil), w.Effects...),
+	}
+	if w.BossTank != nil {
+		boss := *w.BossTank
+		s.Boss = &boss
+	}
+	for _, p := range w.Players {
+		ps := PlayerState{
+			HasTank:   p.Tank != nil,
+			Lives:     p.Lives,
+			Score:     p.Score,
+			Combo:     p.Combo,
+			Respawn:   p.RespawnTicks,
+			Connected: true,
+		}
+		if p.Tank != nil {
+			ps.Tank = *p.Tank
+		}
+		s.Players = append(s.Players, ps)
+	}
+	return s
+}
+
+// Apply 将快照填入一个由 sim.NewViewWorld 创建的显示用世界
+func (s *State) Apply(w *sim.World) {
+	w.SetTick(s.Tick)
+	w.Result = s.Result
+	w.BaseDestroyed = s.BaseDestroyed
+	w.FreezeTicks = s.FreezeTicks
+	w.Walls = append(w.Walls[:0], s.Walls...)
+	w.PowerUps = append(w.PowerUps[:0], s.PowerUps...)
+	w.EnemyTanks = append(w.EnemyTanks[:0], s.Enemies...)
+	w.Bullets = append(w.Bullets[:0], s.Bullets...)
+	w.Effects = append(w.Effects[:0], s.Effects...)
+	w.BossTank = nil
+	if s.Boss != nil {
+		boss := *s.Boss
+		w.BossTank = &boss
+	}
+
+	w.Players = w.Players[:0]
+	for _, ps := range s.Players {
+		p := &sim.Player{Lives: ps.Lives, Score: ps.Score, Combo: ps.Combo, RespawnTicks: ps.Respawn}
+		if ps.HasTank {
+			tank := ps.Tank
+			p.Tank = &tank
+		}
+		w.Players = append(w.Players, p)
+	}
+}
+
+// maxLerpDistance 两个快照之间同一序号的对象移动超过这个距离时不插值，
+// 通常是因为对象被消灭后后面的对象补上了它的序号，或者玩家重生了
+const maxLerpDistance = 3 * SnapshotInterval * 4
+
+// Interpolate 返回 a 和 b 之间按 t（0 到 1）插值的快照，只有坐标会被插值，
+// 其他状态都取自 b
+func Interpolate(a, b *State, t float32) *State {
+	if a == nil || t >= 1 {
+		return b
+	}
+	s := *b
+	s.Enemies = lerpTanks(a.Enemies, b.Enemies, t)
+	s.Bullets = lerpBullets(a.Bullets, b.Bullets, t)
+	if a.Boss != nil && b.Boss != nil {
+		boss := *b.Boss
+		boss.X, boss.Y = lerpPoint(a.Boss.X, a.Boss.Y, b.Boss.X, b.Boss.Y, t)
+		s.Boss = &boss
+	}
+	s.Players = append([]PlayerState(nil), b.Players...)
+	for i := range s.Players {
+		if i < len(a.Players) && a.Players[i].HasTank && b.Players[i].HasTank {
+			pa, pb := &a.Players[i].Tank, &s.Players[i].Tank
+			pb.X, pb.Y = lerpPoint(pa.X, pa.Y, pb.X, pb.Y, t)
+		}
+	}
+	return &s
+}
+
+// lerpPoint 在两点之间插值，距离太远时直接取 b
+func lerpPoint(ax, ay, bx, by float32, t float32) (float32, float32) {
+	if abs(bx-ax)+abs(by-ay) > maxLerpDistance {
+		return bx, by
+	}
+	return ax + (bx-ax)*t, ay + (by-ay)*t
+}
+
+func lerpTanks(a, b []sim.Tank, t float32) []sim.Tank {
+	out := append([]sim.Tank(nil), b...)
+	for i := range out {
+		if i < len(a) {
+			out[i].X, out[i].Y = lerpPoint(a[i].X, a[i].Y, out[i].X, out[i].Y, t)
+		}
+	}
+	return out
+}
+
+func lerpBullets(a, b []sim.Bullet, t float32) []sim.Bullet {
+	out := append([]sim.Bullet(nil), b...)
+	for i := range out {
+		if i < len(a) && a[i].Team == out[i].Team && a[i].Kind == out[i].Kind {
+			out[i].X, out[i].Y = lerpPoint(a[i].X, a[i].Y, out[i].X, out[i].Y, t)
+		}
+	}
+	return out
+}
+
+func abs(v float32) float32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// encode 将快照编码为固定顺序的字节序列。变化最少的墙和道具放在最前面，
+// 这样前面的内容在两次快照之间保持对齐，异或后是大段的零。
+func (s *State) encode() []byte {
+	e := &stateEncoder{}
+	e.int(len(s.Walls))
+	for _, w := range s.Walls {
+		e.float(w.X)
+		e.float(w.Y)
+		e.float(w.Width)
+		e.float(w.Height)
+		e.int(w.Health)
+		e.int(int(w.Kind))
+		e.bool(w.Spawned)
+	}
+	e.int(len(s.PowerUps))
+	for _, p := range s.PowerUps {
+		e.float(p.X)
+		e.float(p.Y)
+		e.int(int(p.Kind))
+		e.int(p.Life)
+	}
+	e.int(s.Stage)
+	e.int(int(s.Result))
+	e.bool(s.BaseDestroyed)
+	e.int(s.FreezeTicks)
+	e.int(len(s.Players))
+	for _, p := range s.Players {
+		e.bool(p.HasTank)
+		e.tank(&p.Tank)
+		e.int(p.Lives)
+		e.int(p.Score)
+		e.int(p.Combo)
+		e.int(p.Respawn)
+		e.bool(p.Connected)
+	}
+	e.bool(s.Boss != nil)
+	if s.Boss != nil {
+		e.tank(s.Boss)
+	}
+	e.int(len(s.Enemies))
+	for i := range s.Enemies {
+		e.tank(&s.Enemies[i])
+	}
+	e.bullets(s.Bullets)
+	e.int(len(s.Effects))
+	for _, ef := range s.Effects {
+		e.float(ef.X)
+		e.float(ef.Y)
+		e.float(ef.Radius)
+		e.int(int(ef.Kind))
+		e.int(ef.Life)
+	}
+	e.int(s.Tick)
+	return e.b
+}
+
+// decodeState 解码 encode 编码的快照
+func decodeState(b []byte) (*State, error) {
+	d := &stateDecoder{b: b}
+	s := &State{}
+	n := d.count()
+	for i := 0; i < n && d.err == nil; i++ {
+		s.Walls = append(s.Walls, sim.Wall{
+			X: d.float(), Y: d.float(), Width: d.float(), Height: d.float(),
+			Health: d.int(), Kind: sim.Terrain(d.int()), Spawned: d.bool(),
+		})
+	}
+	n = d.count()
+	for i := 0; i < n && d.err == nil; i++ {
+		s.PowerUps = append(s.PowerUps, sim.PowerUp{X: d.float(), Y: d.float(), Kind: sim.PowerUpKind(d.int()), Life: d.int()})
+	}
+	s.Stage = d.int()
+	s.Result = sim.Result(d.int())
+	s.BaseDestroyed = d.bool()
+	s.FreezeTicks = d.int()
+	n = d.count()
+	for i := 0; i < n && d.err == nil; i++ {
+		p := PlayerState{HasTank: d.bool(), Tank: d.tank()}
+		p.Lives = d.int()
+		p.Score = d.int()
+		p.Combo = d.int()
+		p.Respawn = d.int()
+		p.Connected = d.bool()
+		s.Players = append(s.Players, p)
+	}
+	if d.bool() {
+		boss := d.tank()
+		s.Boss = &boss
+	}
+	n = d.count()
+	for i := 0; i < n && d.err == nil; i++ {
+		s.Enemies = append(s.Enemies, d.tank())
+	}
+	s.Bullets = d.bullets()
+	n = d.count()
+	for i := 0; i < n && d.err == nil; i++ {
+		s.Effects = append(s.Effects, sim.Effect{X: d.float(), Y: d.float(), Radius: d.float(), Kind: sim.EffectKind(d.int()), Life: d.int()})
+	}
+	s.Tick = d.int()
+	if d.err == nil && len(d.b) != 0 {
+		d.err = ErrBadMessage
+	}
+	return s, d.err
+}
+
+// stateEncoder 按固定宽度写入各字段，保证同一字段在两次快照中的位置相同
+type stateEncoder struct {
+	b []byte
+}
+
+func (e *stateEncoder) int(v int) {
+	e.b = binary.LittleEndian.AppendUint32(e.b, uint32(int32(v)))
+}
+
+func (e *stateEncoder) float(v float32) {
+	e.b = binary.LittleEndian.AppendUint32(e.b, math.Float32bits(v))
+}
+
+func (e *stateEncoder) bool(v bool) {
+	if v {
+		e.b = append(e.b, 1)
+	} else {
+		e.b = append(e.b, 0)
+	}
+}
+
+func (e *stateEncoder) tank(t *sim.Tank) {
+	e.float(t.X)
+	e.float(t.Y)
+	e.int(t.Direction)
+	e.int(int(t.Angle))
+	e.int(int(t.Turret))
+	e.int(t.Health)
+	e.int(t.Stars)
+	e.int(t.Shield)
+	e.int(t.RapidFire)
+	e.int(t.FastBullet)
+	e.float(t.Size)
+	e.int(int(t.Color))
+}
+
+func (e *stateEncoder) bullets(bullets []sim.Bullet) {
+	e.int(len(bullets))
+	for _, b := range bullets {
+		e.float(b.X)
+		e.float(b.Y)
+		e.float(b.VX)
+		e.float(b.VY)
+		e.float(b.Size)
+		e.float(b.Length)
+		e.int(int(b.Team))
+		e.int(b.Owner)
+		e.int(int(b.Kind))
+	}
+}
+
+// stateDecoder 依次读取 stateEncoder 写入的字段，出错后的读取都返回零值
+type stateDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *stateDecoder) int() int {
+	if d.err != nil || len(d.b) < 4 {
+		d.err = ErrBadMessage
+		return 0
+	}
+	v := int32(binary.LittleEndian.Uint32(d.b))
+	d.b = d.b[4:]
+	return int(v)
+}
+
+// count 读取一个长度字段，每个元素至少占一个字节，长度不可能超过剩余的内容
+func (d *stateDecoder) count() int {
+	n := d.int()
+	if n < 0 || n > len(d.b) {
+		d.err = ErrBadMessage
+		return 0
+	}
+	return n
+}
+
+func (d *stateDecoder) float() float32 {
+	return math.Float32frombits(uint32(d.int()))
+}
+
+func (d *stateDecoder) bool() bool {
+	if d.err != nil || len(d.b) < 1 {
+		d.err = ErrBadMessage
+		return false
+	}
+	v := d.b[0] != 0
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *stateDecoder) tank() sim.Tank {
+	return sim.Tank{
+		X: d.float(), Y: d.float(), Direction: d.int(), Angle: sim.Angle(d.int()), Turret: sim.Angle(d.int()), Health: d.int(),
+		Stars: d.int(), Shield: d.int(), RapidFire: d.int(), FastBullet: d.int(),
+		Size: d.float(), Color: uint32(d.int()),
+	}
+}
+
+func (d *stateDecoder) bullets() []sim.Bullet {
+	var bullets []sim.Bullet
+	n := d.count()
+	for i := 0; i < n && d.err == nil; i++ {
+		bullets = append(bullets, sim.Bullet{
+			X: d.float(), Y: d.float(), VX: d.float(), VY: d.float(), Size: d.float(), Length: d.float(),
+			Team: sim.Team(d.int()), Owner: d.int(), Kind: sim.BulletKind(d.int()),
+		})
+	}
+	return bullets
+}
+
+// encodeDelta 将 cur 与 base 逐字节异或，并把连续的零压缩为
+// “零的个数、非零字节数、非零字节”交替的序列。base 为 nil 时等于完整编码。
+func encodeDelta(base, cur []byte) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(cur)))
+	at := func(i int) byte {
+		if i < len(base) {
+			return cur[i] ^ base[i]
+		}
+		return cur[i]
+	}
+	for i := 0; i < len(cur); {
+		zeros := 0
+		for i < len(cur) && at(i) == 0 {
+			zeros++
+			i++
+		}
+		start := i
+		for i < len(cur) && at(i) != 0 {
+			i++
+		}
+		out = binary.AppendUvarint(out, uint64(zeros))
+		out = binary.AppendUvarint(out, uint64(i-start))
+		for k := start; k < i; k++ {
+			out = append(out, at(k))
+		}
+	}
+	return out
+}
+
+// decodeDelta 用 base 还原 encodeDelta 编码的内容
+func decodeDelta(base, delta []byte) ([]byte, error) {
+	size, n := binary.Uvarint(delta)
+	if n <= 0 || size > maxMessageSize {
+		return nil, ErrBadMessage
+	}
+	delta = delta[n:]
+	cur := make([]byte, 0, size)
+	for len(delta) > 0 {
+		zeros, n := binary.Uvarint(delta)
+		if n <= 0 {
+			return nil, ErrBadMessage
+		}
+		delta = delta[n:]
+		literal, n := binary.Uvarint(delta)
+		if n <= 0 || literal > uint64(len(delta)-n) || uint64(len(cur))+zeros+literal > size {
+			return nil, ErrBadMessage
+		}
+		delta = delta[n:]
+		for k := uint64(0); k < zeros; k++ {
+			cur = append(cur, 0)
+		}
+		cur = append(cur, delta[:literal]...)
+		delta = delta[literal:]
+	}
+	if uint64(len(cur)) != size {
+		return nil, ErrBadMessage
+	}
+	for i := range cur {
+		if i < len(base) {
+			cur[i] ^= base[i]
+		}
+	}
+	return cur, nil
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+)
+
+// 观战流文件的格式：文件头标识和版本号之后是与观战者从服务器收到的完全相同的
+// 消息序列，先是一条 JSON 的 welcome，之后是增量编码的快照。观战流可以由
+// 观战者或玩家在收到快照时写入，也可以由本地游戏直接从模拟中截取。
+var streamMagic = [4]byte{'T', 'N', 'K', 'V'}
+
+// streamVersion 观战流文件格式的版本号，各版本的变化见 README
+const streamVersion = 4
+
+// ErrBadStream 表示观战流文件格式不正确
+var ErrBadStream = errors.New("server: bad stream file")
+
+// StreamWriter 把快照逐个写入观战流文件
+type StreamWriter struct {
+	w     *bufio.Writer
+	f     io.Closer
+	base  []byte
+	last  int // 上一个写入的快照的帧号
+	shift int // 写入时加到帧号上的偏移
+}
+
+// CreateStream 创建观战流文件，room 和 cfg 为对局的房间名和游戏参数
+func CreateStream(path, room string, cfg sim.Config) (*StreamWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &StreamWriter{w: bufio.NewWriter(f), f: f}
+	s.w.Write(streamMagic[:])
+	s.w.WriteByte(streamVersion)
+	welcome := welcomeMsg{Room: room, Slot: -1, Config: cfg, Players: cfg.Players}
+	if err := writeJSON(s.w, msgWelcome, welcome); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// WriteState 写入一个快照。帧号没有递增时，例如本地游戏进入了新的一关，
+// 这个快照和之后的快照顺延到上一个快照之后，观战流中的帧号始终递增。
+func (s *StreamWriter) WriteState(st *State) error {
+	out := *st
+	out.Tick += s.shift
+	if s.base != nil && out.Tick <= s.last {
+		s.shift += s.last + SnapshotInterval - out.Tick
+		out.Tick = s.last + SnapshotInterval
+	}
+	cur := out.encode()
+	if err := writeMessage(s.w, msgSnapshot, encodeDelta(s.base, cur)); err != nil {
+		return err
+	}
+	s.base = cur
+	s.last = out.Tick
+	// 及时写入文件，游戏异常退出时已经写入的部分仍然可以观看
+	return s.w.Flush()
+}
+
+// Close 关闭观战流文件
+func (s *StreamWriter) Close() error {
+	err := s.w.Flush()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Stream 表示从文件中读取的观战流
+type Stream struct {
+	Room   string
+	Config sim.Config
+	States []*State // 按帧号递增排列
+}
+
+// ReadStream 从 r 中读取观战流。文件末尾不完整的快照会被忽略，
+// 这通常是因为写入观战流的游戏还没有正常结束。
+func ReadStream(r io.Reader) (*Stream, error) {
+	br := bufio.NewReader(r)
+	var head [5]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return nil, err
+	}
+	if [4]byte(head[:4]) != streamMagic {
+		return nil, ErrBadStream
+	}
+	if head[4] != streamVersion {
+		return nil, fmt.Errorf("server: unsupported stream version %d", head[4])
+	}
+
+	var welcome welcomeMsg
+	if err := readJSON(br, msgWelcome, &welcome); err != nil {
+		return nil, err
+	}
+	s := &Stream{Room: welcome.Room, Config: welcome.Config}
+	var base []byte
+	for {
+		typ, payload, err := readMessage(br)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if typ != msgSnapshot {
+			return nil, ErrBadStream
+		}
+		cur, err := decodeDelta(base, payload)
+		if err != nil {
+			return nil, err
+		}
+		st, err := decodeState(cur)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(s.States); n > 0 && st.Tick <= s.States[n-1].Tick {
+			return nil, ErrBadStream
+		}
+		base = cur
+		s.States = append(s.States, st)
+	}
+	if len(s.States) == 0 {
+		return nil, ErrBadStream
+	}
+	return s, nil
+}
+
+// LoadStream 从文件中读取观战流
+func LoadStream(path string) (*Stream, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadStream(f)
+}
+
+// FirstTick 返回观战流第一个快照的帧号
+func (s *Stream) FirstTick() int {
+	return s.States[0].Tick
+}
+
+// LastTick 返回观战流最后一个快照的帧号
+func (s *Stream) LastTick() int {
+	return s.States[len(s.States)-1].Tick
+}
+
+// StateAt 返回第 tick 帧的状态，在前后两个快照之间插值
+func (s *Stream) StateAt(tick float64) *State {
+	return stateAt(s.States, tick)
+}
+
+// stateAt 在按帧号排列的快照中找出 tick 前后的两个快照并插值，
+// tick 早于第一个快照时返回第一个，晚于最后一个时返回最后一个
+func stateAt(states []*State, tick float64) *State {
+	n := len(states)
+	if n == 0 {
+		return nil
+	}
+	// 二分查找第一个帧号大于 tick 的快照
+	lo, hi := 0, n
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if float64(states[mid].Tick) <= tick {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	switch {
+	case lo == 0:
+		return states[0]
+	case lo == n:
+		return states[n-1]
+	}
+	a, b := states[lo-1], states[lo]
+	return Interpolate(a, b, float32((tick-float64(a.Tick))/float64(b.Tick-a.Tick)))
+}
@@ -0,0 +1,111 @@
+package sim
+
+import "fmt"
+
+const (
+	// AdaptWindow 动态难度每隔多少帧根据这段时间的战况评估一次
+	AdaptWindow = 20 * TicksPerSecond
+	// AdaptMaxLevel 动态难度最多上调或下调的级数
+	AdaptMaxLevel = 3
+
+	// adaptDeaths 一个评估窗口内玩家被消灭这么多次时降低难度
+	adaptDeaths = 2
+	// adaptKills 一个评估窗口内没有玩家被消灭且消灭了这么多敌人时提高难度
+	adaptKills = 5
+	// adaptFastClear 上一关在这么多帧内通过时提高难度
+	adaptFastClear = 90 * TicksPerSecond
+	// adaptSlowClear 上一关用了超过这么多帧才通过时降低难度
+	adaptSlowClear = 240 * TicksPerSecond
+	// adaptPercent 每调整一级，敌人的射击和出动间隔变化的百分比
+	adaptPercent = 15
+	// adaptAccuracy 每调整一级，AI 射击精度的变化
+	adaptAccuracy = 0.15
+)
+
+// AdaptChange 记录动态难度的一次调整
+type AdaptChange struct {
+	Tick   int    // 调整发生的帧
+	Level  int    // 调整后的级数，正数表示比预设更难
+	Reason string // 调整的原因
+}
+
+func (c AdaptChange) String() string {
+	return fmt.Sprintf("第 %d 帧 动态难度 %+d：%s", c.Tick, c.Level, c.Reason)
+}
+
+// AdaptCarry 表示带入下一关的动态难度状态
+type AdaptCarry struct {
+	Level      int `json:"level"`
+	ClearTicks int `json:"clear_ticks"` // 通过上一关用的帧数
+}
+
+// adaptState 记录动态难度的当前级数和评估窗口内的战况
+type adaptState struct {
+	level   int
+	deaths  int // 本窗口内玩家被消灭的次数
+	kills   int // 本窗口内消灭的敌方坦克数
+	changes []AdaptChange
+}
+
+// AdaptLevel 返回动态难度当前的级数，没有开启动态难度时总是 0
+func (w *World) AdaptLevel() int {
+	return w.adapt.level
+}
+
+// AdaptChanges 返回本关动态难度的全部调整记录
+func (w *World) AdaptChanges() []AdaptChange {
+	return w.adapt.changes
+}
+
+// adjustDifficulty 将动态难度调整 delta 级并记录原因
+func (w *World) adjustDifficulty(delta int, reason string) {
+	level := min(AdaptMaxLevel, max(-AdaptMaxLevel, w.adapt.level+delta))
+	if level == w.adapt.level {
+		return
+	}
+	w.adapt.level = level
+	w.adapt.changes = append(w.adapt.changes, AdaptChange{Tick: w.tick, Level: level, Reason: reason})
+}
+
+// updateAdapt 在每个评估窗口结束时根据玩家的伤亡和消灭敌人的速度调整难度
+func (w *World) updateAdapt() {
+	if !w.cfg.Adaptive || w.tick == 0 || w.tick%AdaptWindow != 0 {
+		return
+	}
+	switch {
+	case w.adapt.deaths >= adaptDeaths:
+		w.adjustDifficulty(-1, fmt.Sprintf("%d 秒内玩家被消灭 %d 次", AdaptWindow/TicksPerSecond, w.adapt.deaths))
+	case w.adapt.deaths == 0 && w.adapt.kills >= adaptKills:
+		w.adjustDifficulty(1, fmt.Sprintf("%d 秒内没有伤亡并消灭了 %d 辆敌方坦克", AdaptWindow/TicksPerSecond, w.adapt.kills))
+	}
+	w.adapt.deaths = 0
+	w.adapt.kills = 0
+}
+
+// applyAdaptCarry 继承上一关的动态难度，并按通过上一关的速度调整
+func (w *World) applyAdaptCarry(c *AdaptCarry) {
+	if !w.cfg.Adaptive || c == nil {
+		return
+	}
+	w.adapt.level = min(AdaptMaxLevel, max(-AdaptMaxLevel, c.Level))
+	secs := c.ClearTicks / TicksPerSecond
+	switch {
+	case c.ClearTicks < adaptFastClear:
+		w.adjustDifficulty(1, fmt.Sprintf("上一关只用了 %d 秒", secs))
+	case c.ClearTicks > adaptSlowClear:
+		w.adjustDifficulty(-1, fmt.Sprintf("上一关用了 %d 秒", secs))
+	}
+}
+
+// adaptInterval 返回按动态难度缩放后的间隔帧数，难度越高间隔越短
+func (w *World) adaptInterval(ticks int) int {
+	if w.adapt.level == 0 {
+		return ticks
+	}
+	return max(1, ticks*(100-adaptPercent*w.adapt.level)/100)
+}
+
+// aiAccuracy 返回按动态难度调整后的 AI 射击精度
+func (w *World) aiAccuracy() float32 {
+	return min(1, max(0, w.cfg.AIAccuracy+adaptAccuracy*float32(w.adapt.level)))
+}
@@ -0,0 +1,199 @@
+package sim
+
+// Perception 是 AI 了解战场的唯一途径。AI 只能看到没有藏在树林中的玩家，
+// 视线会被挡住子弹的墙挡住。返回的坦克只能读取，不能修改。
+type Perception interface {
+	// Self 返回 AI 控制的坦克
+	Self() *Tank
+	// MaxHealth 返回 AI 控制的坦克满血时的生命值
+	MaxHealth() int
+	// Home 返回坦克的驻地，即坦克出生的位置
+	Home() Point
+	// NearestPlayer 返回离坦克最近的、没有藏在树林中的玩家坦克，没有时返回 nil
+	NearestPlayer() *Tank
+	// CanSee 判断从坦克的中心到 target 的中心的视线是否没有被墙挡住
+	CanSee(target *Tank) bool
+	// LinedUp 判断 target 是否与坦克在同一行或同一列、中间没有墙挡住，
+	// 是时返回坦克应该朝向的方向
+	LinedUp(target *Tank) (dir int, ok bool)
+	// Aim 根据 target 的移动预判子弹到达时它的位置，返回能够命中它且弹道
+	// 没有被墙挡住的射击方向。预判的程度由 Config.AIAccuracy 和动态难度决定。
+	Aim(target *Tank) (dir int, ok bool)
+	// Base 返回离坦克最近的玩家基地，关卡中没有基地时返回 nil
+	Base() *Wall
+}
+
+// perception 是 World 对 Perception 的实现
+type perception struct {
+	w *World
+	t *Tank
+}
+
+func (p perception) Self() *Tank {
+	return p.t
+}
+
+func (p perception) MaxHealth() int {
+	return p.t.ai.maxHealth
+}
+
+func (p perception) Home() Point {
+	return p.t.ai.home
+}
+
+func (p perception) NearestPlayer() *Tank {
+	return p.w.nearestVisiblePlayer(p.t.X, p.t.Y)
+}
+
+func (p perception) CanSee(target *Tank) bool {
+	return p.w.lineOfSight(p.t.X+TankSize/2, p.t.Y+TankSize/2, target.X+TankSize/2, target.Y+TankSize/2)
+}
+
+func (p perception) LinedUp(target *Tank) (int, bool) {
+	dx, dy := target.X-p.t.X, target.Y-p.t.Y
+	// 子弹从坦克的中心射出，目标的中心偏离不超过半个坦克即可命中
+	var dir int
+	switch {
+	case absf(dx) < TankSize/2 && dy < 0:
+		dir = 0
+	case absf(dx) < TankSize/2:
+		dir = 2
+	case absf(dy) < TankSize/2 && dx > 0:
+		dir = 1
+	case absf(dy) < TankSize/2:
+		dir = 3
+	default:
+		return 0, false
+	}
+	return dir, p.CanSee(target)
+}
+
+func (p perception) Aim(target *Tank) (int, bool) {
+	return p.w.aimSolution(p.t, target, p.w.aiAccuracy())
+}
+
+func (p perception) Base() *Wall {
+	return p.w.baseWall(p.t.X, p.t.Y)
+}
+
+// aiMove 表示 AI 在本帧如何移动
+type aiMove int
+
+const (
+	// aiWander 随机移动
+	aiWander aiMove = iota
+	// aiHold 停在原地
+	aiHold
+	// aiNav 沿路径前往 navPath 中的目标
+	aiNav
+)
+
+// aiAction 是行为树在一帧中做出的决定，由坦克的更新逻辑执行
+type aiAction struct {
+	move aiMove
+	face int // 要转向的方向，-1 表示不转向
+	fire bool
+}
+
+// aiMemory 记录一辆由 AI 控制的坦克的状态
+type aiMemory struct {
+	brain     *Brain // 行为树在模拟中不会被修改，快照与世界共用它
+	home      Point
+	maxHealth int
+	goal      int32 // 巡逻、撤退等节点选定的目标格子
+	goalNode  int   // 选定 goal 的节点编号，0 表示没有
+	reload    int   // 瞄准射击的剩余冷却帧数
+	act       aiAction
+}
+
+// aiContext 是行为树节点执行时的上下文
+type aiContext struct {
+	Perception
+	w   *World
+	mem *aiMemory
+	act *aiAction
+}
+
+// navigate 让坦克沿路径前往 target，target 为 navTargetCell 时前往格子 goal
+func (c *aiContext) navigate(target navTarget, goal int32) {
+	t := c.Self()
+	if t.nav.target != target || (target == navTargetCell && t.nav.goal != goal) {
+		t.nav.target = target
+		t.nav.goal = goal
+		t.nav.cells = nil
+	}
+	c.act.move = aiNav
+}
+
+// at 判断坦克是否已经到达格子 cell
+func (c *aiContext) at(cell int32) bool {
+	x, y := navCellPos(cell)
+	t := c.Self()
+	return t.X == x && t.Y == y
+}
+
+// newAI 为出生在当前位置的坦克 t 装上行为树
+func (w *World) newAI(t *Tank, brain *Brain) {
+	t.ai = aiMemory{
+		brain:     brain,
+		home:      Point{X: t.X, Y: t.Y},
+		maxHealth: t.Health,
+		act:       aiAction{face: -1},
+	}
+}
+
+// think 执行坦克 t 的行为树，决定本帧的行动
+func (w *World) think(t *Tank) {
+	mem := &t.ai
+	mem.act = aiAction{move: aiWander, face: -1}
+	if mem.reload > 0 {
+		mem.reload--
+	}
+	if mem.brain == nil {
+		return
+	}
+	c := &aiContext{Perception: perception{w: w, t: t}, w: w, mem: mem, act: &mem.act}
+	mem.brain.root.tick(c)
+}
+
+// updateBrains 让 Boss 和所有敌方坦克做出本帧的决定，然后在预算内规划路径
+func (w *World) updateBrains() {
+	// 冻结期间敌人不会思考
+	if w.FreezeTicks > 0 {
+		return
+	}
+	if w.BossTank != nil {
+		w.think(w.BossTank)
+	}
+	for i := range w.EnemyTanks {
+		w.think(&w.EnemyTanks[i])
+	}
+	w.planPaths()
+}
+
+// aiStep 执行坦克 t 本帧的决定，返回移动后的位置和实际的移动方式。
+// 沿路径移动的坦克被挡住时应调用 navBlocked，随机移动的坦克被挡住时应随机
+// 改变方向。AI 只在行为树决定射击时调用 fire。
+func (w *World) aiStep(t *Tank, fire func()) (x, y float32, move aiMove) {
+	act := t.ai.act
+	if act.face >= 0 {
+		t.Direction = act.face
+	}
+	if act.fire {
+		fire()
+	}
+
+	switch act.move {
+	case aiHold:
+		return t.X, t.Y, aiHold
+	case aiNav:
+		x, y, dir, ok := w.navStep(t)
+		if ok {
+			t.Direction = dir
+			return x, y, aiNav
+		}
+	}
+	// 没有路径可走时随机移动
+	x, y = w.wanderStep(t)
+	return x, y, aiWander
+}
@@ -0,0 +1,41 @@
+package sim
+
+// aimSolution 计算坦克 t 朝哪个方向射击能够命中 target。子弹飞行期间 target
+// 按上一帧的速度乘以 lead 继续移动，lead 为 1 时完全预判，为 0 时只瞄准
+// target 当前的位置。弹道被墙挡住的方向不算（炮弹除外），有多个方向可以命中时
+// 选择子弹最快到达的方向。
+func (w *World) aimSolution(t, target *Tank, lead float32) (dir int, ok bool) {
+	kind := t.bulletKind()
+	speed := kind.speed(w.cfg.BulletSpeed)
+	// 子弹从坦克中心射出
+	bx, by := t.X+TankSize/2, t.Y+TankSize/2
+	size := kind.spec().size
+	rx, ry := target.X+TankSize/2-bx, target.Y+TankSize/2-by
+	vx, vy := target.vx*lead, target.vy*lead
+
+	best := float32(-1)
+	for d := 0; d < 4; d++ {
+		fx, fy := directionVector(d)
+		gap := rx*fx + ry*fy
+		closing := speed - (vx*fx + vy*fy)
+		if gap <= 0 || closing <= 0 {
+			continue
+		}
+		ticks := gap / closing
+		// 子弹到达时目标偏离弹道不超过半个坦克加半颗子弹即可命中
+		side := -fy*rx + fx*ry
+		drift := -fy*vx + fx*vy
+		if absf(side+drift*ticks) >= (TankSize+size)/2 {
+			continue
+		}
+		if best >= 0 && ticks >= best {
+			continue
+		}
+		hx, hy := bx+fx*speed*ticks, by+fy*speed*ticks
+		if !kind.overWalls() && !w.lineOfSight(bx, by, hx, hy) {
+			continue
+		}
+		best, dir, ok = ticks, d, true
+	}
+	return dir, ok
+}
@@ -0,0 +1,312 @@
+package sim
+
+import "fmt"
+
+// BossAttack 表示 Boss 在一个阶段中使用的攻击方式
+type BossAttack int
+
+const (
+	// BossSpreadShot 向前方扇形发射一排子弹
+	BossSpreadShot BossAttack = iota
+	// BossRing 向四周发射一圈子弹
+	BossRing
+	// BossCharge 朝最近的玩家高速冲锋，撞到玩家时造成伤害
+	BossCharge
+	// BossSummon 从敌方坦克的出动名额中召唤随从
+	BossSummon
+	// BossDeployWalls 在自己与最近的玩家之间筑起砖墙
+	BossDeployWalls
+)
+
+// bossAttackNames 关卡文件中使用的攻击方式名字
+var bossAttackNames = map[string]BossAttack{
+	"spread": BossSpreadShot,
+	"ring":   BossRing,
+	"charge": BossCharge,
+	"summon": BossSummon,
+	"walls":  BossDeployWalls,
+}
+
+func (a BossAttack) String() string {
+	for name, attack := range bossAttackNames {
+		if attack == a {
+			return name
+		}
+	}
+	return fmt.Sprintf("BossAttack(%d)", int(a))
+}
+
+// BossPhase 描述 Boss 战的一个阶段
+type BossPhase struct {
+	Health   int        // Boss 的生命值降到满血时的这个百分比时进入本阶段
+	Attack   BossAttack // 本阶段的攻击方式
+	Interval int        // 两次攻击之间的帧数
+}
+
+const (
+	// BossTelegraphTicks Boss 进入新阶段前停下蓄力的帧数，其间不会受到伤害
+	BossTelegraphTicks = 3 * TicksPerSecond / 2
+	// bossChargeTicks 一次冲锋持续的帧数
+	bossChargeTicks = TicksPerSecond * 3 / 4
+	// bossChargeSpeed 冲锋的速度是平时的几倍
+	bossChargeSpeed = 3
+	// bossSummonCount 每次召唤的随从数
+	bossSummonCount = 2
+	// bossWallCount 每次筑起的砖墙数
+	bossWallCount = 3
+	// bossWallDistance 砖墙与 Boss 的中心之间的距离
+	bossWallDistance = 40
+)
+
+// DefaultBossPhases 返回默认的 Boss 战阶段
+func DefaultBossPhases() []BossPhase {
+	return []BossPhase{
+		{Health: 100, Attack: BossSpreadShot, Interval: 3 * TicksPerSecond},
+		{Health: 80, Attack: BossRing, Interval: 3 * TicksPerSecond},
+		{Health: 60, Attack: BossCharge, Interval: 4 * TicksPerSecond},
+		{Health: 40, Attack: BossSummon, Interval: 8 * TicksPerSecond},
+		{Health: 20, Attack: BossDeployWalls, Interval: 5 * TicksPerSecond},
+	}
+}
+
+// BossPhases 返回本关的 Boss 战阶段，按进入的顺序排列
+func (w *World) BossPhases() []BossPhase {
+	if len(w.level.BossPhases) > 0 {
+		return w.level.BossPhases
+	}
+	return DefaultBossPhases()
+}
+
+// BossPhase 返回 Boss 当前所处的阶段在 BossPhases 中的下标，
+// 还没有进入任何阶段或 Boss 已被消灭时返回 -1
+func (w *World) BossPhase() int {
+	if w.BossTank == nil {
+		return -1
+	}
+	return w.phaseFor(w.BossTank.Health)
+}
+
+// phaseFor 返回 Boss 的生命值为 health 时应处的阶段
+func (w *World) phaseFor(health int) int {
+	phase := -1
+	for i, p := range w.BossPhases() {
+		if health*100 <= w.cfg.BossTankHP*p.Health {
+			phase = i
+		}
+	}
+	return phase
+}
+
+// updateBossPhase 在 Boss 的生命值降过阶段的门槛时进入新的阶段。Boss 先停下蓄力，
+// 期间由护盾保护，然后开始新阶段的攻击。一次降过几道门槛时逐个进入各阶段，
+// 每个阶段都要蓄力。
+func (w *World) updateBossPhase() {
+	boss := w.BossTank
+	if boss.Shield > 0 || w.phaseFor(boss.Health) <= w.bossPhase {
+		return
+	}
+	w.bossPhase++
+	w.bossCharge = 0
+	w.bossAttackTicks = w.BossPhases()[w.bossPhase].Interval
+	boss.Shield = BossTelegraphTicks
+}
+
+// bossAttack 到时间时发动当前阶段的攻击，返回 Boss 本帧是否由攻击接管了移动
+func (w *World) bossAttack() bool {
+	if w.bossCharge > 0 {
+		w.bossChargeStep()
+		return true
+	}
+	if w.bossPhase < 0 {
+		return false
+	}
+	w.bossAttackTicks--
+	if w.bossAttackTicks > 0 {
+		return false
+	}
+	phase := w.BossPhases()[w.bossPhase]
+	w.bossAttackTicks = max(1, phase.Interval)
+
+	switch phase.Attack {
+	case BossSpreadShot:
+		w.bossSpreadShot()
+	case BossRing:
+		w.bossRing()
+	case BossCharge:
+		if target := w.nearestVisiblePlayer(w.BossTank.X, w.BossTank.Y); target != nil {
+			w.BossTank.Direction = directionTo(w.BossTank, target)
+			w.bossCharge = bossChargeTicks
+			return true
+		}
+	case BossSummon:
+		w.bossSummon()
+	case BossDeployWalls:
+		w.bossDeployWalls()
+	}
+	return false
+}
+
+// directionTo 返回从 t 朝向 target 的主要方向
+func directionTo(t, target *Tank) int {
+	dx, dy := target.X-t.X, target.Y-t.Y
+	switch {
+	case absf(dx) >= absf(dy) && dx > 0:
+		return 1
+	case absf(dx) >= absf(dy):
+		return 3
+	case dy > 0:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// directionVector 返回方向 dir 的单位向量
+func directionVector(dir int) (float32, float32) {
+	switch dir {
+	case 0:
+		return 0, -1
+	case 1:
+		return 1, 0
+	case 2:
+		return 0, 1
+	default:
+		return -1, 0
+	}
+}
+
+// bossBullet 从 Boss 的中心沿单位向量 (dx, dy) 的方向发射一颗子弹
+func (w *World) bossBullet(dx, dy float32) {
+	boss := w.BossTank
+	bullet := w.newBullet(BulletNormal, TeamBoss, boss.X+TankSize/2, boss.Y+TankSize/2, dx, dy)
+	bullet.BreakSteel = boss.Stars >= SteelBreakStars
+	w.fire(bullet)
+}
+
+// spreadAngles 扇形射击中各子弹与前方的夹角（-30° 到 30°，每 15° 一颗）的
+// 余弦和正弦。用常量而不是三角函数，保证不同平台上的结果完全相同。
+var spreadAngles = [...][2]float32{
+	{0.8660254, -0.5},
+	{0.9659258, -0.2588190},
+	{1, 0},
+	{0.9659258, 0.2588190},
+	{0.8660254, 0.5},
+}
+
+// bossSpreadShot 向前方扇形发射一排子弹
+func (w *World) bossSpreadShot() {
+	fx, fy := directionVector(w.BossTank.Direction)
+	sx, sy := -fy, fx
+	for _, a := range spreadAngles {
+		w.bossBullet(a[0]*fx+a[1]*sx, a[0]*fy+a[1]*sy)
+	}
+}
+
+// ringDirections 一圈子弹的方向，每 30° 一颗，同样使用常量
+var ringDirections = [...][2]float32{
+	{1, 0}, {0.8660254, 0.5}, {0.5, 0.8660254},
+	{0, 1}, {-0.5, 0.8660254}, {-0.8660254, 0.5},
+	{-1, 0}, {-0.8660254, -0.5}, {-0.5, -0.8660254},
+	{0, -1}, {0.5, -0.8660254}, {0.8660254, -0.5},
+}
+
+// bossRing 向四周发射一圈子弹
+func (w *World) bossRing() {
+	for _, d := range ringDirections {
+		w.bossBullet(d[0], d[1])
+	}
+}
+
+// bossChargeStep 冲锋一帧，撞到玩家时造成伤害，撞到墙、敌方坦克或屏幕边缘时停下
+func (w *World) bossChargeStep() {
+	boss := w.BossTank
+	w.bossCharge--
+	dx, dy := directionVector(boss.Direction)
+	speed := w.cfg.TankSpeed * bossChargeSpeed
+	x, y := boss.X+dx*speed, boss.Y+dy*speed
+	if x < 0 || x > ScreenWidth-TankSize || y < StatusBarHeight || y > ScreenHeight-TankSize || w.tankBlockedByWall(x, y) {
+		w.bossCharge = 0
+		return
+	}
+	for _, e := range w.EnemyTanks {
+		if checkCollision(x, y, TankSize, TankSize, e.X, e.Y, TankSize, TankSize) {
+			w.bossCharge = 0
+			return
+		}
+	}
+	for _, p := range w.Players {
+		if p.Tank != nil && w.tankOverlaps(p.Tank, x, y, TankSize, TankSize) {
+			w.damagePlayer(p, 1)
+			w.bossCharge = 0
+			return
+		}
+	}
+	boss.X, boss.Y = x, y
+	boss.nav.cells = nil
+}
+
+// bossSummon 在 Boss 身边召唤随从，随从占用生成表中敌方坦克的出动名额，
+// 名额用完后不再召唤
+func (w *World) bossSummon() {
+	boss := w.BossTank
+	points := []Point{
+		{X: boss.X - TankSize - 4, Y: boss.Y},
+		{X: boss.X + TankSize + 4, Y: boss.Y},
+		{X: boss.X, Y: boss.Y - TankSize - 4},
+		{X: boss.X, Y: boss.Y + TankSize + 4},
+	}
+	for n := 0; n < bossSummonCount; n++ {
+		entry := w.spawner.enemyEntry()
+		if entry == nil {
+			return
+		}
+		for attempt := 0; attempt < spawnAttempts; attempt++ {
+			tank := w.newEnemyTank(points, entry.rule)
+			if tank.X < 0 || tank.X > ScreenWidth-TankSize || tank.Y < StatusBarHeight || tank.Y > ScreenHeight-TankSize {
+				continue
+			}
+			if w.isAreaOccupied(tank.X, tank.Y, TankSize, TankSize) {
+				continue
+			}
+			w.EnemyTanks = append(w.EnemyTanks, tank)
+			entry.spawned++
+			break
+		}
+	}
+}
+
+// bossDeployWalls 在 Boss 与最近的玩家之间筑起一排与两者连线垂直的砖墙
+func (w *World) bossDeployWalls() {
+	boss := w.BossTank
+	target := w.nearestVisiblePlayer(boss.X, boss.Y)
+	if target == nil {
+		return
+	}
+	dir := directionTo(boss, target)
+	fx, fy := directionVector(dir)
+	sx, sy := -fy, fx
+	const length, thickness = TankSize, 10
+	width, height := float32(length), float32(thickness)
+	if dir == 1 || dir == 3 {
+		width, height = height, width
+	}
+	cx := boss.X + TankSize/2 + fx*bossWallDistance
+	cy := boss.Y + TankSize/2 + fy*bossWallDistance
+
+	placed := false
+	for k := -(bossWallCount / 2); k <= bossWallCount/2; k++ {
+		x := cx + sx*float32(k)*length - width/2
+		y := cy + sy*float32(k)*length - height/2
+		if x < 0 || x+width > ScreenWidth || y < StatusBarHeight || y+height > ScreenHeight {
+			continue
+		}
+		if w.isAreaOccupied(x, y, width, height) {
+			continue
+		}
+		w.Walls = append(w.Walls, Wall{X: x, Y: y, Width: width, Height: height, Health: w.cfg.WallHP, BaseHealth: w.cfg.WallHP, Spawned: true})
+		placed = true
+	}
+	if placed {
+		w.wallsChanged()
+	}
+}
@@ -0,0 +1,38 @@
+package sim
+
+import "testing"
+
+// TestBossPhaseWhileFrozen 在冻结期间一次打掉 Boss 两个阶段的生命值，
+// Boss 仍要逐个进入这两个阶段，每个阶段都停下蓄力
+func TestBossPhaseWhileFrozen(t *testing.T) {
+	w := NewWorld(1, DefaultConfig())
+	boss := w.BossTank
+	if w.bossPhase != 0 {
+		t.Fatalf("starting in phase %d, want 0", w.bossPhase)
+	}
+	w.FreezeTicks = FreezeTicks
+	boss.Health = w.cfg.BossTankHP * 50 / 100
+
+	for _, want := range []int{1, 2} {
+		w.updateBossTank()
+		if w.bossPhase != want {
+			t.Fatalf("got phase %d, want %d", w.bossPhase, want)
+		}
+		if boss.Shield != BossTelegraphTicks-1 {
+			t.Fatalf("phase %d: shield %d, want a full telegraph", want, boss.Shield)
+		}
+		// 蓄力结束之前不会进入下一个阶段
+		for boss.Shield > 0 {
+			w.updateBossTank()
+			if w.bossPhase != want {
+				t.Fatalf("entered phase %d during the telegraph of phase %d", w.bossPhase, want)
+			}
+		}
+	}
+
+	// 已经进入了与生命值相符的阶段，不再蓄力
+	w.updateBossTank()
+	if w.bossPhase != 2 || boss.Shield != 0 {
+		t.Fatalf("got phase %d with shield %d, want phase 2 without shield", w.bossPhase, boss.Shield)
+	}
+}
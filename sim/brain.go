@@ -0,0 +1,499 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Brain 是由节点组合而成的行为树，决定一辆坦克每帧的行动。
+//
+// 行为树用一行文本定义，节点的参数写在括号中：
+//
+//	selector(sequence(low_health(30), flee), aim_fire, patrol(200))
+//
+// 组合节点：
+//
+//	selector(...)  依次执行子节点，直到有一个成功或正在进行
+//	sequence(...)  依次执行子节点，直到有一个失败或正在进行
+//
+// 条件节点：
+//
+//	see_player        能看到最近的玩家
+//	player_near(d)    最近的玩家在 d 像素之内，默认 150
+//	low_health(p)     生命值不超过满血时的 p%，默认 50
+//	has_base          关卡中有玩家的基地
+//
+// 行动节点：
+//
+//	patrol(r)   在驻地周围 r 像素内随机选择地点巡逻，默认 160
+//	chase       追击最近的玩家
+//	flee        远离最近的玩家
+//	cover       躲进最近的树林或墙后
+//	aim_fire    预判玩家的移动，能够命中且弹道没有被墙挡住时转向并射击
+//	guard(r)    守卫驻地，追击进入驻地周围 r 像素的玩家，默认 120
+//	attack_base 前往玩家的基地
+//	wander      随机移动
+//
+// 条件满足或行动完成时节点成功，行动仍在进行时节点返回正在进行。
+// 行为树在模拟中不会被修改，可以被多个世界共用。
+type Brain struct {
+	Name string
+	def  string
+	root aiNode
+}
+
+// String 返回行为树的定义
+func (b *Brain) String() string {
+	return b.def
+}
+
+// aiStatus 表示行为树节点的执行结果
+type aiStatus int
+
+const (
+	aiFailure aiStatus = iota
+	aiSuccess
+	aiRunning
+)
+
+// aiNode 是行为树的节点
+type aiNode interface {
+	tick(c *aiContext) aiStatus
+}
+
+// 节点的默认参数，单位为像素或百分比
+const (
+	defaultNearDistance   = 150
+	defaultLowHealth      = 50
+	defaultPatrolRadius   = 160
+	defaultGuardRadius    = 120
+	fleeDistance          = 160
+	patrolAttempts        = 8
+	aimFireReloadDivision = 2 // 瞄准射击的冷却时间为 ShootInterval 的几分之一
+)
+
+type selectorNode struct{ children []aiNode }
+
+func (n *selectorNode) tick(c *aiContext) aiStatus {
+	for _, child := range n.children {
+		if s := child.tick(c); s != aiFailure {
+			return s
+		}
+	}
+	return aiFailure
+}
+
+type sequenceNode struct{ children []aiNode }
+
+func (n *sequenceNode) tick(c *aiContext) aiStatus {
+	for _, child := range n.children {
+		if s := child.tick(c); s != aiSuccess {
+			return s
+		}
+	}
+	return aiSuccess
+}
+
+// status 将条件转换为节点的执行结果
+func status(ok bool) aiStatus {
+	if ok {
+		return aiSuccess
+	}
+	return aiFailure
+}
+
+type seePlayerNode struct{}
+
+func (seePlayerNode) tick(c *aiContext) aiStatus {
+	p := c.NearestPlayer()
+	return status(p != nil && c.CanSee(p))
+}
+
+type playerNearNode struct{ distance float32 }
+
+func (n *playerNearNode) tick(c *aiContext) aiStatus {
+	p := c.NearestPlayer()
+	if p == nil {
+		return aiFailure
+	}
+	t := c.Self()
+	return status(absf(p.X-t.X)+absf(p.Y-t.Y) <= n.distance)
+}
+
+type lowHealthNode struct{ percent int }
+
+func (n *lowHealthNode) tick(c *aiContext) aiStatus {
+	return status(c.Self().Health*100 <= c.MaxHealth()*n.percent)
+}
+
+type hasBaseNode struct{}
+
+func (hasBaseNode) tick(c *aiContext) aiStatus {
+	return status(c.Base() != nil)
+}
+
+// patrolNode 在驻地周围随机选择地点，到达后再选择下一个
+type patrolNode struct {
+	id     int
+	radius int
+}
+
+func (n *patrolNode) tick(c *aiContext) aiStatus {
+	if c.mem.goalNode != n.id || c.at(c.mem.goal) || !c.w.navGrid().walkable(c.mem.goal) {
+		home := c.Home()
+		goal := int32(-1)
+		for i := 0; i < patrolAttempts && goal < 0; i++ {
+			x := home.X + float32(c.w.rng.Intn(2*n.radius+1)-n.radius)
+			y := home.Y + float32(c.w.rng.Intn(2*n.radius+1)-n.radius)
+			goal = c.w.navGrid().nearestWalkable(x, y)
+		}
+		if goal < 0 {
+			return aiFailure
+		}
+		c.mem.goal, c.mem.goalNode = goal, n.id
+	}
+	c.navigate(navTargetCell, c.mem.goal)
+	return aiRunning
+}
+
+type chaseNode struct{}
+
+func (chaseNode) tick(c *aiContext) aiStatus {
+	if c.NearestPlayer() == nil {
+		return aiFailure
+	}
+	c.navigate(navTargetPlayer, -1)
+	return aiRunning
+}
+
+// fleeNode 向远离最近的玩家的方向撤退，到达后根据玩家的位置再选择下一个地点
+type fleeNode struct{ id int }
+
+func (n *fleeNode) tick(c *aiContext) aiStatus {
+	p := c.NearestPlayer()
+	if p == nil {
+		return aiFailure
+	}
+	if c.mem.goalNode != n.id || c.at(c.mem.goal) {
+		t := c.Self()
+		dx, dy := t.X-p.X, t.Y-p.Y
+		d := absf(dx) + absf(dy)
+		if d == 0 {
+			dx, d = 1, 1
+		}
+		goal := c.w.navGrid().nearestWalkable(t.X+dx/d*fleeDistance, t.Y+dy/d*fleeDistance)
+		if goal < 0 {
+			return aiFailure
+		}
+		c.mem.goal, c.mem.goalNode = goal, n.id
+	}
+	c.navigate(navTargetCell, c.mem.goal)
+	return aiRunning
+}
+
+type coverNode struct{}
+
+func (coverNode) tick(c *aiContext) aiStatus {
+	c.navigate(navTargetCover, -1)
+	return aiRunning
+}
+
+type aimFireNode struct{}
+
+func (aimFireNode) tick(c *aiContext) aiStatus {
+	p := c.NearestPlayer()
+	if p == nil {
+		return aiFailure
+	}
+	reload := c.w.reloadTicks(c.Self())
+	if reload == 0 {
+		return aiFailure
+	}
+	dir, ok := c.Aim(p)
+	if !ok {
+		return aiFailure
+	}
+	c.act.face = dir
+	c.act.move = aiHold
+	if c.mem.reload == 0 {
+		c.act.fire = true
+		c.mem.reload = c.w.adaptInterval(reload)
+	}
+	return aiSuccess
+}
+
+// guardNode 守在驻地，追击进入驻地周围的玩家，玩家离开后返回驻地
+type guardNode struct{ radius float32 }
+
+func (n *guardNode) tick(c *aiContext) aiStatus {
+	home := c.Home()
+	if p := c.NearestPlayer(); p != nil && absf(p.X-home.X)+absf(p.Y-home.Y) <= n.radius {
+		c.navigate(navTargetPlayer, -1)
+		return aiRunning
+	}
+	goal := c.w.navGrid().nearestWalkable(home.X, home.Y)
+	if goal < 0 || c.at(goal) {
+		c.act.move = aiHold
+		return aiRunning
+	}
+	c.navigate(navTargetCell, goal)
+	return aiRunning
+}
+
+type attackBaseNode struct{}
+
+func (attackBaseNode) tick(c *aiContext) aiStatus {
+	if c.Base() == nil {
+		return aiFailure
+	}
+	c.navigate(navTargetBase, -1)
+	return aiRunning
+}
+
+type wanderNode struct{}
+
+func (wanderNode) tick(c *aiContext) aiStatus {
+	c.act.move = aiWander
+	return aiRunning
+}
+
+// BrainError 表示行为树定义中的错误，Pos 为出错的位置（从 0 开始，按字符计）
+type BrainError struct {
+	Pos int
+	Msg string
+}
+
+func (e *BrainError) Error() string {
+	return fmt.Sprintf("brain:%d: %s", e.Pos+1, e.Msg)
+}
+
+// ParseBrain 解析行为树的定义，语法见 Brain
+func ParseBrain(name, def string) (*Brain, error) {
+	p := &brainParser{src: []rune(def)}
+	root, err := p.node()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return &Brain{Name: name, def: def, root: root}, nil
+}
+
+// brainParser 保存解析行为树定义时的状态
+type brainParser struct {
+	src []rune
+	pos int
+	ids int // 已经分配的节点编号
+}
+
+func (p *brainParser) errorf(format string, args ...any) error {
+	return &BrainError{Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *brainParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// word 读取一个由字母、数字和下划线组成的名字或数字
+func (p *brainParser) word() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && (p.src[p.pos] == '_' || unicode.IsLetter(p.src[p.pos]) || unicode.IsDigit(p.src[p.pos])) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// peek 跳过空白后返回下一个字符，已经到达末尾时返回 0
+func (p *brainParser) peek() rune {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// node 解析一个节点
+func (p *brainParser) node() (aiNode, error) {
+	start := p.pos
+	name := p.word()
+	if name == "" {
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("expected node")
+		}
+		return nil, p.errorf("expected node, got %q", p.src[p.pos])
+	}
+
+	switch name {
+	case "selector", "sequence":
+		children, err := p.children(name)
+		if err != nil {
+			return nil, err
+		}
+		if name == "selector" {
+			return &selectorNode{children: children}, nil
+		}
+		return &sequenceNode{children: children}, nil
+	}
+
+	var arg int
+	hasArg := false
+	if p.peek() == '(' {
+		p.pos++
+		argPos := p.pos
+		text := p.word()
+		v, err := strconv.Atoi(text)
+		if err != nil || v <= 0 {
+			p.pos = argPos
+			p.skipSpace()
+			return nil, p.errorf("expected positive integer, got %q", text)
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		arg, hasArg = v, true
+	}
+	param := func(def int) int {
+		if hasArg {
+			return arg
+		}
+		return def
+	}
+	noArg := func(n aiNode) (aiNode, error) {
+		if hasArg {
+			p.pos = start
+			p.skipSpace()
+			return nil, p.errorf("%s takes no argument", name)
+		}
+		return n, nil
+	}
+
+	p.ids++
+	switch name {
+	case "see_player":
+		return noArg(seePlayerNode{})
+	case "player_near":
+		return &playerNearNode{distance: float32(param(defaultNearDistance))}, nil
+	case "low_health":
+		return &lowHealthNode{percent: param(defaultLowHealth)}, nil
+	case "has_base":
+		return noArg(hasBaseNode{})
+	case "patrol":
+		return &patrolNode{id: p.ids, radius: param(defaultPatrolRadius)}, nil
+	case "chase":
+		return noArg(chaseNode{})
+	case "flee":
+		return noArg(&fleeNode{id: p.ids})
+	case "cover":
+		return noArg(coverNode{})
+	case "aim_fire":
+		return noArg(aimFireNode{})
+	case "guard":
+		return &guardNode{radius: float32(param(defaultGuardRadius))}, nil
+	case "attack_base":
+		return noArg(attackBaseNode{})
+	case "wander":
+		return noArg(wanderNode{})
+	}
+	p.pos = start
+	p.skipSpace()
+	return nil, p.errorf("unknown node %q", name)
+}
+
+// children 解析组合节点括号中的子节点
+func (p *brainParser) children(name string) ([]aiNode, error) {
+	if p.peek() != '(' {
+		return nil, p.errorf("%s requires children in parentheses", name)
+	}
+	p.pos++
+	var children []aiNode
+	for {
+		child, err := p.node()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return children, nil
+		default:
+			return nil, p.errorf("expected , or )")
+		}
+	}
+}
+
+// 内置的行为树
+const (
+	// BrainHunter 追击玩家，对齐时射击
+	BrainHunter = "hunter"
+	// BrainRaider 直奔基地，对齐时射击，没有基地时追击玩家
+	BrainRaider = "raider"
+	// BrainSentry 守卫出生点附近
+	BrainSentry = "sentry"
+	// BrainScout 在出生点附近巡逻，受伤后撤退
+	BrainScout = "scout"
+	// BrainSniper 躲在掩体中等待玩家进入射界
+	BrainSniper = "sniper"
+	// BrainBoss Boss 使用的行为树，血量只剩四分之一时躲进掩体
+	BrainBoss = "boss"
+	// BrainKamikaze 冲向玩家，供撞到玩家时自爆的坦克使用
+	BrainKamikaze = "kamikaze"
+)
+
+// builtinBrains 内置行为树的定义
+var builtinBrains = map[string]string{
+	BrainHunter:   "selector(aim_fire, chase, wander)",
+	BrainRaider:   "selector(aim_fire, attack_base, chase, wander)",
+	BrainSentry:   "selector(aim_fire, guard(120))",
+	BrainScout:    "selector(sequence(low_health(50), flee), aim_fire, patrol(200))",
+	BrainSniper:   "selector(aim_fire, sequence(see_player, cover), patrol(80))",
+	BrainBoss:     "selector(sequence(low_health(25), cover), aim_fire, chase, wander)",
+	BrainKamikaze: "selector(chase, wander)",
+}
+
+// BuiltinBrains 返回所有内置行为树的名字，按字母顺序排列
+func BuiltinBrains() []string {
+	names := make([]string, 0, len(builtinBrains))
+	for name := range builtinBrains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compileBrains 解析内置的和关卡中定义的行为树，关卡中的定义覆盖同名的内置行为树。
+// 关卡文件中的定义在 ParseLevel 时已经检查过，其他来源的关卡中有误的定义被忽略。
+func compileBrains(level *Level) map[string]*Brain {
+	brains := make(map[string]*Brain, len(builtinBrains)+len(level.Brains))
+	for name, def := range builtinBrains {
+		b, err := ParseBrain(name, def)
+		if err != nil {
+			panic(fmt.Sprintf("builtin brain %s: %v", name, err))
+		}
+		brains[name] = b
+	}
+	for name, def := range level.Brains {
+		if b, err := ParseBrain(name, def); err == nil {
+			brains[name] = b
+		}
+	}
+	return brains
+}
+
+// isBrainName 判断 name 是否可以用作行为树的名字
+func isBrainName(name string) bool {
+	return name != "" && strings.IndexFunc(name, func(r rune) bool {
+		return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) < 0
+}
@@ -0,0 +1,418 @@
+package sim
+
+import "math"
+
+// Team 表示子弹所属的阵营，子弹只会击中其他阵营的坦克
+type Team int
+
+const (
+	// TeamPlayer 玩家的子弹，开启友军伤害时也会击中发射者以外的玩家
+	TeamPlayer Team = iota
+	// TeamEnemy 敌方坦克的子弹
+	TeamEnemy
+	// TeamBoss Boss 的子弹
+	TeamBoss
+)
+
+// BulletKind 表示子弹的种类
+type BulletKind int
+
+const (
+	// BulletNormal 普通的子弹
+	BulletNormal BulletKind = iota
+	// BulletFast 速度更快的子弹
+	BulletFast
+	// BulletHeavy 穿甲弹，造成两点伤害并可以击毁钢墙
+	BulletHeavy
+	// BulletShell 炮弹，飞得很慢但会越过墙，造成两点伤害
+	BulletShell
+	// BulletBounce 弹跳弹，撞到墙后反弹，存在一段时间后消失
+	BulletBounce
+	// BulletRocket 火箭弹，击中坦克或墙时爆炸，对爆炸范围内的坦克造成两点伤害
+	BulletRocket
+	// BulletLaser 激光，发射的瞬间击中弹道上直到墙为止的所有坦克
+	BulletLaser
+	// BulletHoming 追踪导弹，飞行中转向最近的目标，存在一段时间后自毁
+	BulletHoming
+)
+
+const (
+	// maxBulletStep 子弹每一小步移动的最大距离。快速的子弹每帧分成几步移动，
+	// 每一步都检测碰撞，不会穿过坦克和墙。
+	maxBulletStep = BulletSize
+	// laserSpeed 瞄准时把激光当作这个倍数的速度，近似于瞬间到达
+	laserSpeed = 100
+	// LaserTicks 激光光束显示的帧数
+	LaserTicks = 10
+)
+
+// bulletSpec 描述一种子弹的默认属性
+type bulletSpec struct {
+	name       string
+	speed      float32 // 普通子弹速度的倍数
+	damage     int     // 击中坦克时造成的伤害
+	size       float32 // 边长，激光为光束的宽度
+	life       int     // 存在的帧数，0 表示飞出屏幕之前一直存在
+	bounces    int     // 撞墙后反弹的次数
+	splash     float32 // 爆炸的半径，0 表示不会爆炸
+	homing     float32 // 每帧转向目标的比例，0 表示不追踪
+	overWalls  bool    // 是否从墙上越过
+	intercept  bool    // 是否会与相对阵营的子弹相撞抵消
+	breakSteel bool    // 是否可以击毁钢墙
+	laser      bool    // 是否为瞬间命中的激光
+}
+
+// bulletSpecs 各种子弹的默认属性，新的种类只需要在这里加一行
+var bulletSpecs = [...]bulletSpec{
+	BulletNormal: {name: "normal", speed: 1, damage: 1, size: BulletSize, intercept: true},
+	BulletFast:   {name: "fast", speed: 1.5, damage: 1, size: BulletSize, intercept: true},
+	BulletHeavy:  {name: "heavy", speed: 1, damage: 2, size: BulletSize, breakSteel: true, intercept: true},
+	BulletShell:  {name: "shell", speed: 0.5, damage: 2, size: BulletSize, overWalls: true},
+	BulletBounce: {name: "bounce", speed: 0.8, damage: 1, size: 6, life: 5 * TicksPerSecond, bounces: 3, intercept: true},
+	BulletRocket: {name: "rocket", speed: 0.8, damage: 2, size: 6, splash: 30, intercept: true},
+	BulletLaser:  {name: "laser", speed: laserSpeed, damage: 1, size: 3, life: LaserTicks, laser: true},
+	BulletHoming: {name: "homing", speed: 0.6, damage: 1, size: BulletSize, life: 4 * TicksPerSecond, homing: 0.08, intercept: true},
+}
+
+// parseBulletKind 根据名字返回子弹的种类
+func parseBulletKind(name string) (BulletKind, bool) {
+	for k, spec := range bulletSpecs {
+		if spec.name == name {
+			return BulletKind(k), true
+		}
+	}
+	return BulletNormal, false
+}
+
+// spec 返回这种子弹的默认属性，未知的种类按普通子弹处理
+func (k BulletKind) spec() *bulletSpec {
+	if k < 0 || int(k) >= len(bulletSpecs) {
+		return &bulletSpecs[BulletNormal]
+	}
+	return &bulletSpecs[k]
+}
+
+// speed 返回这种子弹的速度，base 为普通子弹的速度
+func (k BulletKind) speed(base float32) float32 {
+	return base * k.spec().speed
+}
+
+// interceptable 判断这种子弹能否被相对阵营的子弹拦截。炮弹从空中飞过，
+// 激光瞬间命中，都不会被拦截。
+func (k BulletKind) interceptable() bool {
+	return k.spec().intercept
+}
+
+// overWalls 判断这种子弹是否会越过墙
+func (k BulletKind) overWalls() bool {
+	return k.spec().overWalls
+}
+
+// Splash 返回这种子弹爆炸的半径，不会爆炸时返回 0
+func (k BulletKind) Splash() float32 {
+	return k.spec().splash
+}
+
+// Bullet 表示一颗子弹。所有阵营和种类的子弹都在 updateBullets 中统一更新，
+// 各字段在发射时按种类的默认属性填好。
+type Bullet struct {
+	X, Y       float32 // 左上角的坐标
+	VX, VY     float32 // 每帧的位移；激光不会移动，为光束方向的单位向量
+	Size       float32 // 边长；激光为光束的宽度
+	Length     float32 // 激光光束的长度
+	Team       Team
+	Owner      int // 发射子弹的玩家序号，只对玩家子弹有效
+	Kind       BulletKind
+	Damage     int  // 击中坦克时造成的伤害
+	Pierce     int  // 还可以打穿的砖墙数
+	Bounces    int  // 还可以反弹的次数
+	Life       int  // 剩余的帧数，0 表示飞出屏幕之前一直存在
+	BreakSteel bool // 是否可以击毁钢墙
+}
+
+// newBullet 创建一颗 team 阵营的 kind 种类的子弹，中心位于 (cx, cy)，
+// 以种类的速度沿单位向量 (dx, dy) 的方向飞行
+func (w *World) newBullet(kind BulletKind, team Team, cx, cy, dx, dy float32) Bullet {
+	spec := kind.spec()
+	speed := kind.speed(w.cfg.BulletSpeed)
+	if spec.laser {
+		speed = 1
+	}
+	return Bullet{
+		X:          cx - spec.size/2,
+		Y:          cy - spec.size/2,
+		VX:         dx * speed,
+		VY:         dy * speed,
+		Size:       spec.size,
+		Team:       team,
+		Kind:       kind,
+		Damage:     spec.damage,
+		Bounces:    spec.bounces,
+		Life:       spec.life,
+		BreakSteel: spec.breakSteel,
+	}
+}
+
+// fire 将子弹放到场上，激光在发射的瞬间完成命中
+func (w *World) fire(b Bullet) {
+	if b.Kind.spec().laser {
+		w.traceLaser(&b)
+	}
+	w.Bullets = append(w.Bullets, b)
+}
+
+// updateBullets 移动所有子弹并检测碰撞，移除命中、过期或飞出屏幕的子弹
+func (w *World) updateBullets() {
+	n := 0
+	for i := range w.Bullets {
+		if w.updateBullet(&w.Bullets[i]) {
+			w.Bullets[n] = w.Bullets[i]
+			n++
+		}
+	}
+	w.Bullets = w.Bullets[:n]
+}
+
+// updateBullet 将子弹推进一帧，返回子弹是否还留在场上
+func (w *World) updateBullet(b *Bullet) bool {
+	spec := b.Kind.spec()
+	if spec.laser {
+		b.Life--
+		return b.Life > 0
+	}
+	if spec.homing > 0 {
+		w.steerBullet(b, spec.homing)
+	}
+
+	// 反弹会改变速度的方向，每一步都按当前的速度移动
+	steps := max(1, int(math.Ceil(float64(max(absf(b.VX), absf(b.VY))/maxBulletStep))))
+	for s := 0; s < steps; s++ {
+		px, py := b.X, b.Y
+		b.X += b.VX / float32(steps)
+		b.Y += b.VY / float32(steps)
+
+		if target, ok := w.hitTarget(b); ok {
+			if spec.splash > 0 {
+				w.explode(b)
+			} else {
+				w.damageTargets(b, []bulletTarget{target})
+			}
+			return false
+		}
+		if !spec.overWalls && w.bulletHitWalls(b, px, py) {
+			if spec.splash > 0 {
+				w.explode(b)
+			}
+			return false
+		}
+		if b.X < 0 || b.X > ScreenWidth || b.Y < StatusBarHeight || b.Y > ScreenHeight {
+			return false
+		}
+	}
+
+	if b.Life > 0 {
+		b.Life--
+		if b.Life == 0 {
+			if spec.splash > 0 {
+				w.explode(b)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// bulletTarget 表示子弹可以击中的一辆坦克：Boss、第 enemy 辆敌方坦克或第 player 号玩家
+type bulletTarget struct {
+	boss   bool
+	enemy  int // 不是敌方坦克时为 -1
+	player int // 不是玩家时为 -1
+}
+
+// eachTarget 依次对子弹 b 可以击中的每辆坦克调用 fn，(x, y, size) 为车身所占的
+// 正方形，fn 返回 false 时停止。敌方坦克按下标从小到大排列。
+func (w *World) eachTarget(b *Bullet, fn func(t bulletTarget, tank *Tank, x, y, size float32) bool) {
+	if b.Team == TeamPlayer {
+		if w.BossTank != nil && !fn(bulletTarget{boss: true, enemy: -1, player: -1}, w.BossTank, w.BossTank.X, w.BossTank.Y, TankSize) {
+			return
+		}
+		for j := range w.EnemyTanks {
+			x, y, size := w.EnemyTanks[j].Body()
+			if !fn(bulletTarget{enemy: j, player: -1}, &w.EnemyTanks[j], x, y, size) {
+				return
+			}
+		}
+		// 关闭友军伤害时子弹直接穿过队友
+		if !w.cfg.FriendlyFire {
+			return
+		}
+	}
+	for k, p := range w.Players {
+		if p.Tank == nil || (b.Team == TeamPlayer && k == b.Owner) {
+			continue
+		}
+		if !fn(bulletTarget{enemy: -1, player: k}, p.Tank, p.Tank.X, p.Tank.Y, TankSize) {
+			return
+		}
+	}
+}
+
+// hitTarget 返回子弹当前位置击中的第一辆坦克
+func (w *World) hitTarget(b *Bullet) (target bulletTarget, ok bool) {
+	w.eachTarget(b, func(t bulletTarget, tank *Tank, x, y, size float32) bool {
+		if w.tankOverlaps(tank, b.X, b.Y, b.Size, b.Size) {
+			target, ok = t, true
+			return false
+		}
+		return true
+	})
+	return target, ok
+}
+
+// damageTargets 对各个目标造成子弹的伤害。targets 按 eachTarget 的顺序排列，
+// 倒序处理，这样移除被消灭的敌方坦克时不会改变其余目标的下标。
+func (w *World) damageTargets(b *Bullet, targets []bulletTarget) {
+	for i := len(targets) - 1; i >= 0; i-- {
+		t := targets[i]
+		switch {
+		case t.boss:
+			// 蓄力进入新阶段时Boss不会受到伤害
+			if w.BossTank.Shield == 0 {
+				w.BossTank.Health -= b.Damage
+				w.Players[b.Owner].Score += BossHitScore * b.Damage
+			}
+			if w.BossTank.Health <= 0 {
+				// 移除Boss坦克
+				w.BossTank = nil
+			}
+		case t.enemy >= 0:
+			enemy := &w.EnemyTanks[t.enemy]
+			enemy.Health -= b.Damage
+			if enemy.Health <= 0 {
+				w.Players[b.Owner].addKillScore(EnemyKillScore)
+				w.adapt.kills++
+				w.dropPowerUp(enemy)
+				// 移除敌方坦克
+				w.EnemyTanks = append(w.EnemyTanks[:t.enemy], w.EnemyTanks[t.enemy+1:]...)
+			}
+		default:
+			w.damagePlayer(w.Players[t.player], b.Damage)
+		}
+	}
+}
+
+// explode 子弹在当前位置爆炸，对爆炸范围内其他阵营的所有坦克造成伤害
+func (w *World) explode(b *Bullet) {
+	r := b.Kind.Splash()
+	cx, cy := b.X+b.Size/2, b.Y+b.Size/2
+	var targets []bulletTarget
+	w.eachTarget(b, func(t bulletTarget, tank *Tank, x, y, size float32) bool {
+		if w.tankInRange(tank, cx, cy, r) {
+			targets = append(targets, t)
+		}
+		return true
+	})
+	w.damageTargets(b, targets)
+	w.addEffect(EffectExplosion, cx, cy, r)
+}
+
+// bulletHitWalls 检测子弹与墙的碰撞，返回子弹是否被挡住。还可以反弹的子弹
+// 被挡住时退回上一步的位置 (px, py) 并反弹，不算被挡住。
+func (w *World) bulletHitWalls(b *Bullet, px, py float32) bool {
+	for j := 0; j < len(w.Walls); j++ {
+		wall := w.Walls[j]
+		if !checkCollision(b.X, b.Y, b.Size, b.Size, wall.X, wall.Y, wall.Width, wall.Height) {
+			continue
+		}
+		blocked, destroyed := w.bulletHitWall(b, j)
+		if destroyed && b.Team == TeamPlayer {
+			w.Players[b.Owner].Score += WallDestroyScore
+		}
+		if !blocked {
+			if destroyed {
+				// 子弹打穿了这堵墙，后面的墙前移了一位
+				j--
+			}
+			continue
+		}
+		if b.Bounces == 0 {
+			return true
+		}
+		b.Bounces--
+		// 上一步已经与墙在水平方向上重叠，说明是从上方或下方撞上的
+		if px < wall.X+wall.Width && px+b.Size > wall.X {
+			b.VY = -b.VY
+		} else {
+			b.VX = -b.VX
+		}
+		b.X, b.Y = px, py
+		return false
+	}
+	return false
+}
+
+// steerBullet 使追踪导弹以 rate 的比例转向最近的目标，速度大小不变。
+// 藏在树林中的坦克不会被追踪。
+func (w *World) steerBullet(b *Bullet, rate float32) {
+	cx, cy := b.X+b.Size/2, b.Y+b.Size/2
+	best := float32(-1)
+	var tx, ty float32
+	w.eachTarget(b, func(t bulletTarget, tank *Tank, x, y, size float32) bool {
+		if w.IsHidden(tank) {
+			return true
+		}
+		dx, dy := x+size/2-cx, y+size/2-cy
+		if d := dx*dx + dy*dy; best < 0 || d < best {
+			best, tx, ty = d, dx, dy
+		}
+		return true
+	})
+	if best <= 0 {
+		return
+	}
+	speed := length(b.VX, b.VY)
+	dist := length(tx, ty)
+	vx := b.VX + (tx/dist*speed-b.VX)*rate
+	vy := b.VY + (ty/dist*speed-b.VY)*rate
+	if l := length(vx, vy); l > 0 {
+		b.VX, b.VY = vx/l*speed, vy/l*speed
+	}
+}
+
+// traceLaser 沿激光的方向前进直到被墙挡住或离开屏幕，对光束经过的所有坦克造成伤害
+func (w *World) traceLaser(b *Bullet) {
+	cx, cy := b.X+b.Size/2, b.Y+b.Size/2
+	probe := *b
+	probe.Size = BulletSize
+	for {
+		px, py := probe.X, probe.Y
+		probe.X = cx - BulletSize/2 + b.VX*(b.Length+maxBulletStep)
+		probe.Y = cy - BulletSize/2 + b.VY*(b.Length+maxBulletStep)
+		if probe.X < 0 || probe.X > ScreenWidth || probe.Y < StatusBarHeight || probe.Y > ScreenHeight {
+			break
+		}
+		if w.bulletHitWalls(&probe, px, py) {
+			break
+		}
+		b.Length += maxBulletStep
+	}
+
+	// 光束所占的矩形
+	x0, y0 := cx, cy
+	x1, y1 := cx+b.VX*b.Length, cy+b.VY*b.Length
+	rx, ry := min(x0, x1)-b.Size/2, min(y0, y1)-b.Size/2
+	rw, rh := absf(x1-x0)+b.Size, absf(y1-y0)+b.Size
+	var targets []bulletTarget
+	w.eachTarget(b, func(t bulletTarget, tank *Tank, x, y, size float32) bool {
+		if w.tankOverlaps(tank, rx, ry, rw, rh) {
+			targets = append(targets, t)
+		}
+		return true
+	})
+	w.damageTargets(b, targets)
+}
+
+// length 返回向量 (x, y) 的长度
+func length(x, y float32) float32 {
+	return float32(math.Sqrt(float64(x*x + y*y)))
+}
@@ -0,0 +1,127 @@
+package sim
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Campaign 表示由若干关卡按顺序组成的战役
+type Campaign struct {
+	Name   string
+	Stages []*Level
+}
+
+// Carry 表示过关时带入下一关的各玩家状态
+type Carry struct {
+	Players []PlayerCarry `json:"players"`
+	Adapt   *AdaptCarry   `json:"adapt,omitempty"` // 没有开启动态难度时为 nil
+}
+
+// PlayerCarry 表示一名玩家带入下一关的状态
+type PlayerCarry struct {
+	Health int  `json:"health"` // 为 0 表示过关时坦克已被消灭，下一关以满血出场
+	Stars  int  `json:"stars,omitempty"`
+	Lives  int  `json:"lives"`
+	Score  int  `json:"score"`
+	Out    bool `json:"out,omitempty"` // 过关时已经用完全部生命，之后的关卡中不再出场
+}
+
+// DefaultCampaign 返回只包含默认关卡的战役
+func DefaultCampaign() *Campaign {
+	return &Campaign{
+		Name:   "默认战役",
+		Stages: []*Level{DefaultLevel()},
+	}
+}
+
+// LoadCampaign 从文件中加载战役
+//
+// 战役文件每行是一个关卡文件的路径（相对于战役文件所在的目录），
+// # 开头的行为注释，name 指令设置战役名称：
+//
+//	name 主线战役
+//	01.txt
+//	02.txt
+func LoadCampaign(path string) (*Campaign, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &Campaign{Name: filepath.Base(path)}
+	dir := filepath.Dir(path)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if name, ok := strings.CutPrefix(text, "name "); ok {
+			c.Name = strings.TrimSpace(name)
+			continue
+		}
+
+		level, err := LoadLevel(filepath.Join(dir, text))
+		if err != nil {
+			if _, ok := err.(*LevelError); ok {
+				return nil, err
+			}
+			return nil, &LevelError{File: path, Line: line, Col: 1, Msg: err.Error()}
+		}
+		c.Stages = append(c.Stages, level)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(c.Stages) == 0 {
+		return nil, &LevelError{File: path, Line: line + 1, Col: 1, Msg: "campaign has no stages"}
+	}
+	return c, nil
+}
+
+// Carry 返回各玩家当前需要带入下一关的状态
+func (w *World) Carry() *Carry {
+	c := &Carry{}
+	for _, p := range w.Players {
+		pc := PlayerCarry{Lives: p.Lives, Score: p.Score, Out: p.Out()}
+		if p.Tank != nil {
+			pc.Health = p.Tank.Health
+			pc.Stars = p.Tank.Stars
+		}
+		c.Players = append(c.Players, pc)
+	}
+	if w.cfg.Adaptive {
+		c.Adapt = &AdaptCarry{Level: w.adapt.level, ClearTicks: w.tick}
+	}
+	return c
+}
+
+// ApplyCarry 将上一关带来的各玩家状态应用到本关
+func (w *World) ApplyCarry(c *Carry) {
+	if c == nil {
+		return
+	}
+	for i, pc := range c.Players {
+		if i >= len(w.Players) {
+			break
+		}
+		p := w.Players[i]
+		if pc.Out {
+			// 用完生命的玩家不会因为队友过关而复活
+			p.Tank = nil
+			p.RespawnTicks = 0
+		} else if pc.Health > 0 {
+			p.Tank.Health = pc.Health
+			p.Tank.Stars = pc.Stars
+		}
+		p.Lives = pc.Lives
+		p.Score = pc.Score
+	}
+	w.applyAdaptCarry(c.Adapt)
+}
@@ -0,0 +1,39 @@
+package sim
+
+// 碰撞检测函数
+func checkCollision(x1, y1, w1, h1, x2, y2, w2, h2 float32) bool {
+	return x1 < x2+w2 && x1+w1 > x2 && y1 < y2+h2 && y1+h1 > y2
+}
+
+// circleHitsRect 判断以 (cx, cy) 为圆心、r 为半径的圆是否与矩形重叠
+func circleHitsRect(cx, cy, r, x, y, w, h float32) bool {
+	dx := cx - min(max(cx, x), x+w)
+	dy := cy - min(max(cy, y), y+h)
+	return dx*dx+dy*dy <= r*r
+}
+
+// sweptCollision 判断两个以 (vx1, vy1) 和 (vx2, vy2) 匀速移动一帧的正方形在这一帧内
+// 是否相撞，相撞时返回最先接触的时刻 t（0 到 1）。按相对运动计算，
+// 移动再快也不会互相穿过。
+func sweptCollision(x1, y1, s1, vx1, vy1, x2, y2, s2, vx2, vy2 float32) (t float32, ok bool) {
+	enter, exit := float32(0), float32(1)
+	for _, axis := range [2][2]float32{{x2 - x1, vx2 - vx1}, {y2 - y1, vy2 - vy1}} {
+		d, v := axis[0], axis[1]
+		// 第二个正方形相对第一个的位置在 (-s2, s1) 之间时两者重叠
+		if v == 0 {
+			if d <= -s2 || d >= s1 {
+				return 0, false
+			}
+			continue
+		}
+		t1, t2 := (-s2-d)/v, (s1-d)/v
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		enter, exit = max(enter, t1), min(exit, t2)
+		if enter >= exit {
+			return 0, false
+		}
+	}
+	return enter, true
+}
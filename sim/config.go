@@ -0,0 +1,96 @@
+package sim
+
+const (
+	// 模拟每秒推进的帧数
+	TicksPerSecond = 60
+	// 屏幕宽度
+	ScreenWidth = 640
+	// 屏幕高度
+	ScreenHeight = 480
+	// 状态栏的高度
+	StatusBarHeight = 20
+	// 坦克的边长
+	TankSize = 20
+	// 子弹的边长
+	BulletSize = 5
+	// 坦克的速度
+	TankSpeed = 2
+	// 子弹速度
+	BulletSpeed = 5
+	// 每 30 帧改变一次方向
+	ChangeDirInterval = 30
+	// 每 60 帧射击一次
+	ShootInterval = 60
+	// 最大敌方坦克数量
+	MaxEnemyTankCount = 10
+	// 最大墙的数量
+	MaxWallCount = 5
+	// 墙的检测间隔，单位为秒
+	WallCheckInterval = 60
+	// 敌方坦克的检测间隔，单位为秒
+	EnemyTankCheckInterval = 60
+	// 玩家坦克的生命值
+	PlayerTankHP = 3
+	// Boss 坦克的生命值
+	BossTankHP = 100
+	// 敌方坦克的生命值
+	EnemyTankHP = 1
+	// 墙的坚固值
+	WallHP = 5
+	// Boss坦克容忍的最长尾随时间
+	BossToleranceTime = 3
+	// 玩家人数上限
+	MaxPlayers = 4
+	// 玩家坦克的备用生命数
+	PlayerLives = 2
+	// AI 射击时预判玩家移动的程度
+	AIAccuracy = 0.8
+)
+
+// Config 表示一局游戏中可以调整的数值参数
+type Config struct {
+	TankSpeed              float32      `json:"tank_speed"`
+	BulletSpeed            float32      `json:"bullet_speed"`
+	ChangeDirInterval      int          `json:"change_dir_interval"`
+	ShootInterval          int          `json:"shoot_interval"`
+	MaxEnemyTankCount      int          `json:"max_enemy_tank_count"`
+	MaxWallCount           int          `json:"max_wall_count"`
+	WallCheckInterval      int          `json:"wall_check_interval"`
+	EnemyTankCheckInterval int          `json:"enemy_tank_check_interval"`
+	PlayerTankHP           int          `json:"player_tank_hp"`
+	BossTankHP             int          `json:"boss_tank_hp"`
+	EnemyTankHP            int          `json:"enemy_tank_hp"`
+	WallHP                 int          `json:"wall_hp"`
+	BossToleranceTime      int          `json:"boss_tolerance_time"`
+	Players                int          `json:"players"`       // 玩家人数，1 到 MaxPlayers
+	PlayerLives            int          `json:"player_lives"`  // 每名玩家的备用生命数
+	FriendlyFire           bool         `json:"friendly_fire"` // 玩家的子弹是否会伤害队友
+	AIAccuracy             float32      `json:"ai_accuracy"`   // AI 射击时预判玩家移动的程度，0 到 1
+	Difficulty             Difficulty   `json:"difficulty"`    // 调整参数时使用的难度预设，只用于显示
+	Adaptive               bool         `json:"adaptive"`      // 是否根据玩家的表现动态调整难度
+	Perks                  Perks        `json:"perks"`         // 车库中购买的永久强化
+	Movement               MovementMode `json:"movement"`      // 玩家坦克的移动方式
+}
+
+// DefaultConfig 返回默认的游戏参数
+func DefaultConfig() Config {
+	return Config{
+		TankSpeed:              TankSpeed,
+		BulletSpeed:            BulletSpeed,
+		ChangeDirInterval:      ChangeDirInterval,
+		ShootInterval:          ShootInterval,
+		MaxEnemyTankCount:      MaxEnemyTankCount,
+		MaxWallCount:           MaxWallCount,
+		WallCheckInterval:      WallCheckInterval,
+		EnemyTankCheckInterval: EnemyTankCheckInterval,
+		PlayerTankHP:           PlayerTankHP,
+		BossTankHP:             BossTankHP,
+		EnemyTankHP:            EnemyTankHP,
+		WallHP:                 WallHP,
+		BossToleranceTime:      BossToleranceTime,
+		Players:                1,
+		PlayerLives:            PlayerLives,
+		AIAccuracy:             AIAccuracy,
+		Difficulty:             DifficultyNormal,
+	}
+}
@@ -0,0 +1,93 @@
+package sim
+
+import "fmt"
+
+// Difficulty 表示难度预设
+type Difficulty int
+
+const (
+	// DifficultyEasy 简单：敌人射得慢、打得偏，玩家多一条备用生命
+	DifficultyEasy Difficulty = iota
+	// DifficultyNormal 普通：使用默认参数
+	DifficultyNormal
+	// DifficultyHard 困难：敌人更多、更耐打，射击更快更准
+	DifficultyHard
+	// DifficultyNightmare 噩梦：在困难的基础上进一步加强敌人，玩家没有备用生命
+	DifficultyNightmare
+)
+
+// difficultyPreset 描述一个难度预设对默认参数的调整，百分比都以默认值为 100
+type difficultyPreset struct {
+	name     string
+	speed    int     // TankSpeed 的百分比，越大坦克移动越快
+	shoot    int     // ShootInterval 的百分比，越小敌人射击越快
+	spawn    int     // EnemyTankCheckInterval 的百分比，越小敌人出动越频繁
+	enemies  int     // MaxEnemyTankCount 的百分比
+	enemyHP  int     // EnemyTankHP 的倍数
+	bossHP   int     // BossTankHP 的百分比
+	accuracy float32 // AIAccuracy 的取值
+	lives    int     // PlayerLives 的增减
+}
+
+// difficultyPresets 各难度的预设
+var difficultyPresets = [...]difficultyPreset{
+	DifficultyEasy:      {name: "easy", speed: 90, shoot: 150, spawn: 150, enemies: 70, enemyHP: 1, bossHP: 60, accuracy: 0.3, lives: 1},
+	DifficultyNormal:    {name: "normal", speed: 100, shoot: 100, spawn: 100, enemies: 100, enemyHP: 1, bossHP: 100, accuracy: AIAccuracy},
+	DifficultyHard:      {name: "hard", speed: 115, shoot: 75, spawn: 75, enemies: 130, enemyHP: 2, bossHP: 150, accuracy: 1, lives: -1},
+	DifficultyNightmare: {name: "nightmare", speed: 130, shoot: 50, spawn: 50, enemies: 160, enemyHP: 3, bossHP: 200, accuracy: 1, lives: -2},
+}
+
+// Difficulties 返回所有难度，从易到难排列
+func Difficulties() []Difficulty {
+	return []Difficulty{DifficultyEasy, DifficultyNormal, DifficultyHard, DifficultyNightmare}
+}
+
+// ParseDifficulty 根据名字（easy、normal、hard、nightmare）返回难度
+func ParseDifficulty(name string) (Difficulty, error) {
+	for d, p := range difficultyPresets {
+		if p.name == name {
+			return Difficulty(d), nil
+		}
+	}
+	return DifficultyNormal, fmt.Errorf("unknown difficulty %q", name)
+}
+
+func (d Difficulty) String() string {
+	if d >= 0 && int(d) < len(difficultyPresets) {
+		return difficultyPresets[d].name
+	}
+	return fmt.Sprintf("Difficulty(%d)", int(d))
+}
+
+// MarshalText 将难度保存为名字
+func (d Difficulty) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText 根据名字读取难度
+func (d *Difficulty) UnmarshalText(text []byte) error {
+	v, err := ParseDifficulty(string(text))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// Apply 返回按难度预设调整后的游戏参数
+func (d Difficulty) Apply(cfg Config) Config {
+	if d < 0 || int(d) >= len(difficultyPresets) {
+		return cfg
+	}
+	p := difficultyPresets[d]
+	cfg.Difficulty = d
+	cfg.TankSpeed = cfg.TankSpeed * float32(p.speed) / 100
+	cfg.ShootInterval = max(1, cfg.ShootInterval*p.shoot/100)
+	cfg.EnemyTankCheckInterval = max(1, cfg.EnemyTankCheckInterval*p.spawn/100)
+	cfg.MaxEnemyTankCount = max(1, cfg.MaxEnemyTankCount*p.enemies/100)
+	cfg.EnemyTankHP *= p.enemyHP
+	cfg.BossTankHP = max(1, cfg.BossTankHP*p.bossHP/100)
+	cfg.AIAccuracy = p.accuracy
+	cfg.PlayerLives = max(0, cfg.PlayerLives+p.lives)
+	return cfg
+}
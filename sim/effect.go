@@ -0,0 +1,52 @@
+package sim
+
+// EffectKind 表示视觉效果的种类
+type EffectKind int
+
+const (
+	// EffectExplosion 火箭弹爆炸，半径为爆炸的范围
+	EffectExplosion EffectKind = iota
+	// EffectSpark 两颗子弹相撞抵消时的火花
+	EffectSpark
+)
+
+const (
+	// ExplosionTicks 爆炸效果显示的帧数
+	ExplosionTicks = 15
+	// SparkTicks 火花效果显示的帧数
+	SparkTicks = 8
+)
+
+// Ticks 返回这种效果显示的帧数
+func (k EffectKind) Ticks() int {
+	if k == EffectSpark {
+		return SparkTicks
+	}
+	return ExplosionTicks
+}
+
+// Effect 表示一个短暂的视觉效果，只用于显示，不影响模拟
+type Effect struct {
+	X, Y   float32 // 中心的坐标
+	Radius float32
+	Kind   EffectKind
+	Life   int // 剩余的帧数
+}
+
+// addEffect 在 (x, y) 处添加一个视觉效果
+func (w *World) addEffect(kind EffectKind, x, y, radius float32) {
+	w.Effects = append(w.Effects, Effect{X: x, Y: y, Radius: radius, Kind: kind, Life: kind.Ticks()})
+}
+
+// ageEffects 减少各视觉效果的剩余帧数，移除已经结束的效果
+func (w *World) ageEffects() {
+	n := 0
+	for _, e := range w.Effects {
+		if e.Life > 1 {
+			e.Life--
+			w.Effects[n] = e
+			n++
+		}
+	}
+	w.Effects = w.Effects[:n]
+}
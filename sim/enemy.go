@@ -0,0 +1,280 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnemyType 描述一种敌方坦克
+type EnemyType struct {
+	Name   string
+	HP     int        // 普通难度下的生命值，按 Config.EnemyTankHP 的倍数随难度变化
+	Speed  float32    // 普通难度下每帧移动的距离，随 Config.TankSpeed 变化，0 表示与 Config.TankSpeed 相同
+	Reload int        // 普通难度下两次射击之间的帧数，随 Config.ShootInterval 变化，0 表示不会射击
+	Bullet BulletKind // 发射的子弹种类
+	Size   float32    // 车身的边长，不超过 TankSize
+	Color  uint32     // 车身的颜色，0xRRGGBB
+	Brain  string     // 默认使用的行为树，为空时按关卡选择追击玩家或直奔基地
+	Ram    bool       // 撞到玩家时自爆，对玩家造成 RamDamage 点伤害
+}
+
+const (
+	// EnemyBasic 基本的敌方坦克，生成表没有指定种类时使用
+	EnemyBasic = "basic"
+	// RamDamage 自爆坦克撞到玩家时造成的伤害
+	RamDamage = 2
+	// minEnemySize 敌方坦克车身的最小边长
+	minEnemySize = BulletSize * 2
+)
+
+// DefaultEnemyTypes 返回内置的敌方坦克种类，第一种为 EnemyBasic
+func DefaultEnemyTypes() []EnemyType {
+	return []EnemyType{
+		{Name: EnemyBasic, HP: 1, Reload: ShootInterval / 2, Bullet: BulletNormal, Size: TankSize, Color: 0xffb6c1},
+		{Name: "scout", HP: 1, Speed: 3, Reload: ShootInterval * 3 / 4, Bullet: BulletFast, Size: 14, Color: 0x80c0ff, Brain: BrainScout},
+		{Name: "heavy", HP: 4, Speed: 1, Reload: ShootInterval, Bullet: BulletHeavy, Size: TankSize, Color: 0x909090},
+		{Name: "artillery", HP: 2, Speed: 1, Reload: ShootInterval * 2, Bullet: BulletShell, Size: TankSize, Color: 0xc08040, Brain: BrainSniper},
+		{Name: "kamikaze", HP: 1, Speed: 3, Size: 16, Color: 0xff4040, Brain: BrainKamikaze, Ram: true},
+		{Name: "bouncer", HP: 1, Reload: ShootInterval, Bullet: BulletBounce, Size: TankSize, Color: 0x80e080},
+		{Name: "rocketeer", HP: 2, Speed: 1, Reload: ShootInterval * 3 / 2, Bullet: BulletRocket, Size: TankSize, Color: 0xe08030},
+		{Name: "lancer", HP: 2, Speed: 1, Reload: ShootInterval * 2, Bullet: BulletLaser, Size: 18, Color: 0xc080ff, Brain: BrainSniper},
+		{Name: "seeker", HP: 1, Speed: 2, Reload: ShootInterval * 2, Bullet: BulletHoming, Size: 16, Color: 0x40e0e0},
+	}
+}
+
+// LoadEnemyTypes 从文件中加载敌方坦克的种类
+func LoadEnemyTypes(path string) ([]EnemyType, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseEnemyTypes(path, f)
+}
+
+// ParseEnemyTypes 从 r 中解析敌方坦克的种类，name 用于错误信息中的文件名
+//
+// 每行用 enemy 指令定义一种坦克，# 开头的行为注释：
+//
+//	enemy scout hp=1 speed=3 reload=45 bullet=fast size=14 color=80c0ff brain=scout
+//	enemy kamikaze hp=1 speed=3 size=16 color=ff4040 brain=kamikaze ram
+//
+// reload 的单位为帧，省略时不会射击；bullet 为 normal、fast、heavy、shell、
+// bounce、rocket、laser 或 homing；
+// color 为十六进制的 RRGGBB；ram 表示撞到玩家时自爆。省略的选项取 EnemyBasic 的值。
+// 第一种坦克在生成表没有指定种类时使用。
+func ParseEnemyTypes(name string, r io.Reader) ([]EnemyType, error) {
+	p := &levelParser{file: name}
+	var types []EnemyType
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.line++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		fields := splitLevelFields(line)
+		if fields[0].text != "enemy" {
+			return nil, p.errorf(fields[0].col, "unknown directive %q", fields[0].text)
+		}
+		t, err := p.parseEnemyType(fields)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range types {
+			if other.Name == t.Name {
+				return nil, p.errorf(fields[1].col, "duplicate enemy type %q", t.Name)
+			}
+		}
+		types = append(types, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(types) == 0 {
+		p.line++
+		return nil, p.errorf(1, "no enemy types")
+	}
+	return types, nil
+}
+
+// parseEnemyType 解析 enemy 指令：enemy 名字 选项...
+func (p *levelParser) parseEnemyType(fields []levelField) (EnemyType, error) {
+	if len(fields) < 2 {
+		return EnemyType{}, p.errorf(fields[0].col, "enemy requires a name")
+	}
+	if !isBrainName(fields[1].text) {
+		return EnemyType{}, p.errorf(fields[1].col, "invalid enemy type name %q", fields[1].text)
+	}
+	t := DefaultEnemyTypes()[0]
+	t.Name = fields[1].text
+	t.Reload = 0
+	t.Brain = ""
+
+	for _, f := range fields[2:] {
+		if f.text == "ram" {
+			t.Ram = true
+			continue
+		}
+		key, value, ok := strings.Cut(f.text, "=")
+		if !ok {
+			return t, p.errorf(f.col, "expected key=value, got %q", f.text)
+		}
+		vf := levelField{text: value, col: f.col + len([]rune(key)) + 1}
+		var err error
+		switch key {
+		case "hp":
+			t.HP, err = p.parseInt(vf)
+		case "speed":
+			t.Speed, err = p.parseSpeed(vf)
+		case "reload":
+			t.Reload, err = p.parseUint(vf)
+		case "bullet":
+			kind, ok := parseBulletKind(value)
+			if !ok {
+				return t, p.errorf(vf.col, "unknown bullet %q", value)
+			}
+			t.Bullet = kind
+		case "size":
+			var size int
+			size, err = p.parseInt(vf)
+			if err == nil && (size < minEnemySize || size > TankSize) {
+				err = p.errorf(vf.col, "size must be between %d and %d", minEnemySize, TankSize)
+			}
+			t.Size = float32(size)
+		case "color":
+			var c uint64
+			c, err = strconv.ParseUint(value, 16, 32)
+			if err != nil || len(value) != 6 {
+				err = p.errorf(vf.col, "expected color RRGGBB, got %q", value)
+			}
+			t.Color = uint32(c)
+		case "brain":
+			if !isBrainName(value) {
+				err = p.errorf(vf.col, "invalid brain name %q", value)
+			}
+			t.Brain = value
+		default:
+			err = p.errorf(f.col, "unknown enemy option %q", key)
+		}
+		if err != nil {
+			return t, err
+		}
+	}
+	return t, nil
+}
+
+// parseSpeed 解析一个不超过一个导航格子的正数速度
+func (p *levelParser) parseSpeed(f levelField) (float32, error) {
+	v, err := strconv.ParseFloat(f.text, 32)
+	if err != nil || v <= 0 || v > NavCellSize {
+		return 0, p.errorf(f.col, "expected speed between 0 and %d, got %q", NavCellSize, f.text)
+	}
+	return float32(v), nil
+}
+
+// SetEnemyTypes 设置关卡使用的敌方坦克种类，并检查生成表引用的种类都有定义、
+// 各种类的行为树是内置的或在关卡中有定义
+func (l *Level) SetEnemyTypes(types []EnemyType) error {
+	for _, rule := range l.Spawns {
+		for _, wt := range rule.Types {
+			if findEnemyType(types, wt.Type) == nil {
+				return fmt.Errorf("%s: unknown enemy type %q", l.Name, wt.Type)
+			}
+		}
+	}
+	for _, t := range types {
+		if t.Brain == "" {
+			continue
+		}
+		if _, ok := builtinBrains[t.Brain]; ok {
+			continue
+		}
+		if _, ok := l.Brains[t.Brain]; !ok {
+			return fmt.Errorf("%s: enemy type %q uses unknown brain %q", l.Name, t.Name, t.Brain)
+		}
+	}
+	l.EnemyTypes = types
+	return nil
+}
+
+// SetEnemyTypes 为战役的每一关设置敌方坦克的种类
+func (c *Campaign) SetEnemyTypes(types []EnemyType) error {
+	for _, level := range c.Stages {
+		if err := level.SetEnemyTypes(types); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findEnemyType 返回名为 name 的种类，没有时返回 nil
+func findEnemyType(types []EnemyType, name string) *EnemyType {
+	for i := range types {
+		if types[i].Name == name {
+			return &types[i]
+		}
+	}
+	return nil
+}
+
+// tankSpeed 返回坦克每帧移动的距离
+func (w *World) tankSpeed(t *Tank) float32 {
+	if t.kind != nil && t.kind.Speed > 0 {
+		return t.kind.Speed * w.cfg.TankSpeed / TankSpeed
+	}
+	return w.cfg.TankSpeed
+}
+
+// reloadTicks 返回坦克两次瞄准射击之间的帧数，不会射击时返回 0
+func (w *World) reloadTicks(t *Tank) int {
+	if t.kind == nil {
+		return max(1, w.cfg.ShootInterval/aimFireReloadDivision)
+	}
+	if t.kind.Reload == 0 {
+		return 0
+	}
+	return max(1, t.kind.Reload*w.cfg.ShootInterval/ShootInterval)
+}
+
+// bulletKind 返回坦克发射的子弹种类
+func (t *Tank) bulletKind() BulletKind {
+	if t.kind != nil {
+		return t.kind.Bullet
+	}
+	return BulletNormal
+}
+
+// EnemyWeight 表示生成表中一种敌方坦克被选中的权重
+type EnemyWeight struct {
+	Type   string
+	Weight int
+}
+
+// pickEnemyType 按权重随机选择一种敌方坦克，weights 为空或权重之和不为正时使用第一种
+func (w *World) pickEnemyType(weights []EnemyWeight) *EnemyType {
+	types := w.enemyTypes
+	total := 0
+	for _, wt := range weights {
+		total += wt.Weight
+	}
+	if total <= 0 {
+		return &types[0]
+	}
+	n := w.rng.Intn(total)
+	for _, wt := range weights {
+		if n < wt.Weight {
+			if t := findEnemyType(types, wt.Type); t != nil {
+				return t
+			}
+			break
+		}
+		n -= wt.Weight
+	}
+	return &types[0]
+}
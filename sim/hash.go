@@ -0,0 +1,166 @@
+package sim
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"math"
+)
+
+// stateHasher 将世界状态按固定顺序写入哈希
+type stateHasher struct {
+	h   hash.Hash64
+	buf [8]byte
+}
+
+func (s *stateHasher) int(v int) {
+	binary.LittleEndian.PutUint64(s.buf[:], uint64(int64(v)))
+	s.h.Write(s.buf[:])
+}
+
+func (s *stateHasher) float(v float32) {
+	binary.LittleEndian.PutUint32(s.buf[:4], math.Float32bits(v))
+	s.h.Write(s.buf[:4])
+}
+
+func (s *stateHasher) bool(v bool) {
+	if v {
+		s.int(1)
+	} else {
+		s.int(0)
+	}
+}
+
+func (s *stateHasher) tank(t *Tank) {
+	if t == nil {
+		s.bool(false)
+		return
+	}
+	s.bool(true)
+	s.float(t.X)
+	s.float(t.Y)
+	s.int(t.Direction)
+	s.int(int(t.Angle))
+	s.int(int(t.Turret))
+	s.int(t.Health)
+	s.int(t.Stars)
+	s.int(t.Shield)
+	s.int(t.RapidFire)
+	s.int(t.FastBullet)
+	s.float(t.Size)
+	s.int(int(t.Color))
+	if t.kind != nil {
+		s.h.Write([]byte(t.kind.Name))
+	}
+	s.float(t.vx)
+	s.float(t.vy)
+	s.int(t.slide)
+	s.int(t.fireCooldown)
+	s.int(int(t.nav.target))
+	s.int(len(t.nav.cells))
+	s.int(t.nav.next)
+	s.int(t.nav.planned)
+	s.int(t.nav.stuck)
+	s.int(t.nav.wander)
+	s.int(int(t.nav.goal))
+	if t.ai.brain != nil {
+		s.h.Write([]byte(t.ai.brain.Name))
+	}
+	s.int(int(t.ai.goal))
+	s.int(t.ai.goalNode)
+	s.int(t.ai.reload)
+}
+
+func (s *stateHasher) bullets(bullets []Bullet) {
+	s.int(len(bullets))
+	for _, b := range bullets {
+		s.float(b.X)
+		s.float(b.Y)
+		s.float(b.VX)
+		s.float(b.VY)
+		s.float(b.Size)
+		s.float(b.Length)
+		s.int(int(b.Team))
+		s.int(b.Owner)
+		s.int(int(b.Kind))
+		s.int(b.Damage)
+		s.int(b.Pierce)
+		s.int(b.Bounces)
+		s.int(b.Life)
+		s.bool(b.BreakSteel)
+	}
+}
+
+// Hash 返回当前世界状态的哈希值，用于比较两次模拟是否一致
+func (w *World) Hash() uint64 {
+	s := &stateHasher{h: fnv.New64a()}
+
+	s.int(w.tick)
+	s.int(int(w.rng.state))
+	s.int(int(w.Result))
+	s.bool(w.BaseDestroyed)
+	s.int(w.followTicks)
+	s.int(w.bossPhase)
+	s.int(w.bossAttackTicks)
+	s.int(w.bossCharge)
+	s.int(w.navVersion)
+	s.int(w.navCursor)
+	s.int(w.adapt.level)
+	s.int(w.adapt.deaths)
+	s.int(w.adapt.kills)
+
+	s.int(len(w.Players))
+	for _, p := range w.Players {
+		s.tank(p.Tank)
+		s.float(p.Spawn.X)
+		s.float(p.Spawn.Y)
+		s.int(p.Lives)
+		s.int(p.RespawnTicks)
+		s.int(p.Score)
+		s.int(p.Combo)
+		s.int(p.comboTicks)
+	}
+	s.tank(w.BossTank)
+	s.int(len(w.EnemyTanks))
+	for i := range w.EnemyTanks {
+		s.tank(&w.EnemyTanks[i])
+	}
+
+	s.bullets(w.Bullets)
+	s.int(len(w.Effects))
+	for _, e := range w.Effects {
+		s.float(e.X)
+		s.float(e.Y)
+		s.float(e.Radius)
+		s.int(int(e.Kind))
+		s.int(e.Life)
+	}
+
+	s.int(len(w.Walls))
+	for _, wall := range w.Walls {
+		s.float(wall.X)
+		s.float(wall.Y)
+		s.float(wall.Width)
+		s.float(wall.Height)
+		s.int(wall.Health)
+		s.int(wall.BaseHealth)
+		s.int(int(wall.Kind))
+		s.bool(wall.Spawned)
+	}
+
+	s.int(len(w.PowerUps))
+	for _, p := range w.PowerUps {
+		s.float(p.X)
+		s.float(p.Y)
+		s.int(int(p.Kind))
+		s.int(p.Life)
+	}
+	s.int(w.FreezeTicks)
+
+	for _, entry := range w.spawner.entries {
+		s.int(entry.next)
+		s.int(entry.spawned)
+	}
+
+	return s.h.Sum64()
+}
@@ -0,0 +1,49 @@
+package sim
+
+// Input 表示玩家在一帧内的输入。低 8 位为按键，按位组合；
+// 八方向和自由模式下 8-15 位为炮塔瞄准的角度，16-23 位为摇杆移动的角度。
+type Input uint32
+
+const (
+	// InputUp 向上移动
+	InputUp Input = 1 << iota
+	// InputRight 向右移动
+	InputRight
+	// InputDown 向下移动
+	InputDown
+	// InputLeft 向左移动
+	InputLeft
+	// InputFire 射击（仅在按下的那一帧置位）
+	InputFire
+	// InputFireHeld 射击键处于按住状态，用于连发
+	InputFireHeld
+	// InputAim 本帧用鼠标或右摇杆瞄准，角度见 Aim
+	InputAim
+	// InputMove 本帧用左摇杆移动，角度见 Move，此时忽略方向键
+	InputMove
+)
+
+// Has 判断输入中是否包含指定按键
+func (in Input) Has(flag Input) bool {
+	return in&flag != 0
+}
+
+// WithAim 返回加上炮塔瞄准角度 a 的输入
+func (in Input) WithAim(a Angle) Input {
+	return in&^(0xff<<8) | InputAim | Input(a)<<8
+}
+
+// Aim 返回炮塔瞄准的角度，没有瞄准时返回 false
+func (in Input) Aim() (Angle, bool) {
+	return Angle(in >> 8), in.Has(InputAim)
+}
+
+// WithMove 返回加上摇杆移动角度 a 的输入
+func (in Input) WithMove(a Angle) Input {
+	return in&^(0xff<<16) | InputMove | Input(a)<<16
+}
+
+// Move 返回摇杆要求移动的角度，没有使用摇杆时返回 false
+func (in Input) Move() (Angle, bool) {
+	return Angle(in >> 16), in.Has(InputMove)
+}
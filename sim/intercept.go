@@ -0,0 +1,43 @@
+package sim
+
+// opposes 判断两个阵营的子弹是否会互相拦截。敌方坦克和 Boss 是同一边的，
+// 只有玩家的子弹与它们的子弹相撞时才会抵消。
+func (t Team) opposes(o Team) bool {
+	return (t == TeamPlayer) != (o == TeamPlayer)
+}
+
+// interceptBullets 让相对阵营的子弹在相撞时互相抵消，并在相撞处留下火花。
+//
+// 在子弹移动之前按本帧的速度扫掠检测，相向飞行的快速子弹即使在一帧内
+// 交换了位置也会相撞。每颗子弹最多抵消一颗，按序号从小到大配对。
+func (w *World) interceptBullets() {
+	for i := 0; i < len(w.Bullets); {
+		a := &w.Bullets[i]
+		hit := -1
+		var at float32
+		if a.Kind.interceptable() {
+			for j := i + 1; j < len(w.Bullets); j++ {
+				b := &w.Bullets[j]
+				if !b.Kind.interceptable() || !a.Team.opposes(b.Team) {
+					continue
+				}
+				if t, ok := sweptCollision(a.X, a.Y, a.Size, a.VX, a.VY, b.X, b.Y, b.Size, b.VX, b.VY); ok {
+					hit, at = j, t
+					break
+				}
+			}
+		}
+		if hit < 0 {
+			i++
+			continue
+		}
+
+		// 火花位于两颗子弹相撞时的中点
+		b := &w.Bullets[hit]
+		ax, ay := a.X+a.Size/2+a.VX*at, a.Y+a.Size/2+a.VY*at
+		bx, by := b.X+b.Size/2+b.VX*at, b.Y+b.Size/2+b.VY*at
+		w.addEffect(EffectSpark, (ax+bx)/2, (ay+by)/2, max(a.Size, b.Size))
+		w.Bullets = append(w.Bullets[:hit], w.Bullets[hit+1:]...)
+		w.Bullets = append(w.Bullets[:i], w.Bullets[i+1:]...)
+	}
+}
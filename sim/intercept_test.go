@@ -0,0 +1,111 @@
+package sim
+
+import "testing"
+
+// testBullet 返回一颗左上角位于 (x, y)、每帧移动 (vx, vy) 的子弹
+func testBullet(kind BulletKind, team Team, x, y, vx, vy float32) Bullet {
+	return Bullet{X: x, Y: y, VX: vx, VY: vy, Size: kind.spec().size, Team: team, Kind: kind, Damage: 1}
+}
+
+func TestInterceptBullets(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   Bullet
+		cancel bool
+	}{
+		{
+			// 相距 10 像素、每帧相向移动 15 像素，帧末已经交换了位置，只有扫掠检测才能发现
+			name:   "swap in one tick",
+			a:      testBullet(BulletFast, TeamPlayer, 100, 200, 15, 0),
+			b:      testBullet(BulletFast, TeamEnemy, 110, 200, -15, 0),
+			cancel: true,
+		},
+		{
+			name:   "head on",
+			a:      testBullet(BulletNormal, TeamPlayer, 300, 100, 0, 3),
+			b:      testBullet(BulletNormal, TeamBoss, 300, 108, 0, -3),
+			cancel: true,
+		},
+		{
+			name: "miss",
+			a:    testBullet(BulletNormal, TeamPlayer, 100, 200, 15, 0),
+			b:    testBullet(BulletNormal, TeamEnemy, 110, 220, -15, 0),
+		},
+		{
+			name: "shell flies over",
+			a:    testBullet(BulletShell, TeamEnemy, 100, 200, 15, 0),
+			b:    testBullet(BulletNormal, TeamPlayer, 110, 200, -15, 0),
+		},
+		{
+			name: "shell is not hit",
+			a:    testBullet(BulletNormal, TeamPlayer, 100, 200, 15, 0),
+			b:    testBullet(BulletShell, TeamBoss, 110, 200, -15, 0),
+		},
+		{
+			name: "laser",
+			a:    testBullet(BulletLaser, TeamPlayer, 100, 200, 1, 0),
+			b:    testBullet(BulletNormal, TeamEnemy, 101, 200, -3, 0),
+		},
+		{
+			name: "both players",
+			a:    testBullet(BulletNormal, TeamPlayer, 100, 200, 15, 0),
+			b:    testBullet(BulletNormal, TeamPlayer, 110, 200, -15, 0),
+		},
+		{
+			name: "enemy and boss",
+			a:    testBullet(BulletNormal, TeamEnemy, 100, 200, 15, 0),
+			b:    testBullet(BulletNormal, TeamBoss, 110, 200, -15, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWorld(1, DefaultConfig())
+			w.Bullets = []Bullet{tt.a, tt.b}
+			w.interceptBullets()
+
+			if !tt.cancel {
+				if len(w.Bullets) != 2 || len(w.Effects) != 0 {
+					t.Fatalf("got %d bullets and %d effects, want both bullets to pass", len(w.Bullets), len(w.Effects))
+				}
+				return
+			}
+			if len(w.Bullets) != 0 {
+				t.Fatalf("got %d bullets left, want both to cancel", len(w.Bullets))
+			}
+			if len(w.Effects) != 1 || w.Effects[0].Kind != EffectSpark {
+				t.Fatalf("got effects %+v, want one spark", w.Effects)
+			}
+			// 两颗子弹速度相同、方向相反，火花位于两者中心的正中间
+			x := (tt.a.X + tt.b.X + tt.a.Size) / 2
+			y := (tt.a.Y + tt.b.Y + tt.a.Size) / 2
+			if e := w.Effects[0]; e.X != x || e.Y != y {
+				t.Errorf("spark at (%v, %v), want (%v, %v)", e.X, e.Y, x, y)
+			}
+		})
+	}
+}
+
+func TestSweptCollision(t *testing.T) {
+	tests := []struct {
+		name                 string
+		x1, y1, s1, vx1, vy1 float32
+		x2, y2, s2, vx2, vy2 float32
+		want                 float32
+		ok                   bool
+	}{
+		{"swap", 100, 200, 5, 15, 0, 110, 200, 5, -15, 0, 1.0 / 6, true},
+		{"overlapping", 100, 200, 5, 0, 0, 102, 202, 5, 0, 0, 0, true},
+		{"too slow", 100, 200, 5, 1, 0, 110, 200, 5, -1, 0, 0, false},
+		{"parallel", 100, 200, 5, 15, 0, 110, 210, 5, -15, 0, 0, false},
+		{"touching edges", 100, 200, 5, 0, 0, 105, 200, 5, 0, 0, 0, false},
+		{"crossing paths", 100, 200, 5, 10, 0, 108, 190, 5, 0, 10, 0.5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := sweptCollision(tt.x1, tt.y1, tt.s1, tt.vx1, tt.vy1, tt.x2, tt.y2, tt.s2, tt.vx2, tt.vy2)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("got (%v, %v), want (%v, %v)", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
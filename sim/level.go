@@ -0,0 +1,575 @@
+package sim
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultTileSize 关卡文件中默认的格子边长
+const DefaultTileSize = 20
+
+// Level 表示一个关卡的地图和生成规则
+type Level struct {
+	Name         string
+	Cols, Rows   int
+	TileSize     int
+	Walls        []Wall  // Health 为 0 的墙使用游戏参数中的 WallHP
+	PlayerSpawns []Point // 第 i 个为第 i 号玩家的出生点，多出的玩家在 1 号玩家附近出生
+	BossSpawn    Point
+	EnemySpawns  []Point
+	Spawns       SpawnTable        // 为空时使用默认生成表
+	Brains       map[string]string // 关卡中定义的行为树，名字到定义，语法见 Brain
+	BossBrain    string            // Boss 使用的行为树，为空时使用 BrainBoss
+	BossPhases   []BossPhase       // Boss 战的各个阶段，为空时使用 DefaultBossPhases
+	EnemyTypes   []EnemyType       // 敌方坦克的种类，为空时使用 DefaultEnemyTypes，见 SetEnemyTypes
+}
+
+// DefaultLevel 返回内置的默认关卡
+func DefaultLevel() *Level {
+	return &Level{
+		Name:     "默认关卡",
+		Cols:     ScreenWidth / DefaultTileSize,
+		Rows:     (ScreenHeight - StatusBarHeight) / DefaultTileSize,
+		TileSize: DefaultTileSize,
+		Walls: []Wall{
+			{X: 150, Y: 150, Width: 100, Height: 10},
+			{X: 250, Y: 280, Width: 150, Height: 10},
+			{X: 400, Y: 50, Width: 10, Height: 100},
+			{X: 350, Y: 350, Width: 10, Height: 50},
+		},
+		PlayerSpawns: []Point{{X: ScreenWidth / 2, Y: ScreenHeight/2 + StatusBarHeight}},
+		BossSpawn:    Point{X: 100, Y: 100},
+	}
+}
+
+// LevelError 表示关卡文件中的错误，带有出错的行号和列号
+type LevelError struct {
+	File string
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *LevelError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Col, e.Msg)
+}
+
+// LoadLevel 从文件中加载关卡
+func LoadLevel(path string) (*Level, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseLevel(path, f)
+}
+
+// levelTerrains 地图中表示特殊地形的字符
+var levelTerrains = map[rune]Terrain{
+	'S': TerrainSteel,
+	'W': TerrainWater,
+	'F': TerrainForest,
+	'I': TerrainIce,
+	'H': TerrainBase,
+}
+
+// levelField 表示关卡文件一行中的一个字段及其所在的列
+type levelField struct {
+	text string
+	col  int
+}
+
+// splitLevelFields 按空白切分一行，并记录每个字段的列号（从 1 开始，按字符计）
+func splitLevelFields(line string) []levelField {
+	var fields []levelField
+	col := 0
+	start := -1
+	var sb strings.Builder
+	for _, r := range line {
+		col++
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				fields = append(fields, levelField{text: sb.String(), col: start})
+				sb.Reset()
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = col
+		}
+		sb.WriteRune(r)
+	}
+	if start >= 0 {
+		fields = append(fields, levelField{text: sb.String(), col: start})
+	}
+	return fields
+}
+
+// levelParser 保存解析关卡文件时的状态
+type levelParser struct {
+	file   string
+	line   int
+	level  *Level
+	wallHP int
+	hasB   bool
+	row    int
+	spawns []levelSpawn
+	brains []levelBrainRef
+}
+
+// levelBrainRef 记录关卡文件中对行为树的引用，用于在解析完成后校验
+type levelBrainRef struct {
+	name      string
+	line, col int
+}
+
+// levelSpawn 记录地图中的出生点及其位置，用于在地图解析完成后校验
+type levelSpawn struct {
+	what      string
+	pos       Point
+	line, col int
+}
+
+func (p *levelParser) errorf(col int, format string, args ...any) error {
+	return &LevelError{File: p.file, Line: p.line, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parseInt 解析一个正整数字段
+func (p *levelParser) parseInt(f levelField) (int, error) {
+	v, err := strconv.Atoi(f.text)
+	if err != nil || v <= 0 {
+		return 0, p.errorf(f.col, "expected positive integer, got %q", f.text)
+	}
+	return v, nil
+}
+
+// parseUint 解析一个非负整数字段
+func (p *levelParser) parseUint(f levelField) (int, error) {
+	v, err := strconv.Atoi(f.text)
+	if err != nil || v < 0 {
+		return 0, p.errorf(f.col, "expected non-negative integer, got %q", f.text)
+	}
+	return v, nil
+}
+
+// ParseLevel 从 r 中解析关卡，name 用于错误信息中的文件名
+//
+// 关卡文件由若干指令行和一张字符地图组成，# 开头的行为注释：
+//
+//	name 第一关
+//	tile 20
+//	grid 32 23
+//	wall_hp 5
+//	spawn enemy min=5 max=65 alive=10 total=20
+//	spawn wall min=10 max=70 alive=5
+//	brain guard selector(aim_fire, guard(100))
+//	spawn enemy min=5 max=30 alive=2 brain=guard
+//	spawn enemy min=5 max=20 alive=6 types=basic:3,scout,heavy
+//	boss_brain sniper
+//	boss_phase 100 spread 3
+//	boss_phase 50 summon 8
+//	map
+//	................................
+//	..##....E.......B.......E...####
+//	...
+//
+// 地图中 . 为空地，# 为使用 wall_hp 的砖墙，1-9 为指定坚固值的砖墙，
+// S 为钢墙，W 为水面，F 为树林，I 为冰面，H 为基地，
+// P 为玩家出生点（按从上到下、从左到右的顺序依次分配给 1 号、2 号玩家……），
+// B 为 Boss 出生点，E 为敌方坦克出生点。
+// spawn 指令中的时间单位为秒。brain 指令定义行为树，spawn 指令的 brain 选项和
+// boss_brain 指令为敌方坦克和 Boss 指定行为树，可以使用内置的行为树，见 BuiltinBrains。
+// spawn 指令的 types 选项按权重从敌方坦克的种类中随机选择，种类的定义见
+// ParseEnemyTypes，在 SetEnemyTypes 时检查。
+// boss_phase 指令按生命值百分比从高到低定义 Boss 战的阶段，依次为进入阶段时的
+// 生命值百分比、攻击方式（spread、ring、charge、summon、walls）和攻击间隔（秒）。
+func ParseLevel(name string, r io.Reader) (*Level, error) {
+	p := &levelParser{
+		file: name,
+		level: &Level{
+			TileSize: DefaultTileSize,
+		},
+	}
+
+	scanner := bufio.NewScanner(r)
+	inMap := false
+	for scanner.Scan() {
+		p.line++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if inMap && p.row < p.level.Rows {
+			if err := p.parseMapRow(line); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if inMap {
+			return nil, p.errorf(1, "unexpected content after map")
+		}
+
+		fields := splitLevelFields(line)
+		var err error
+		switch fields[0].text {
+		case "name":
+			if len(fields) < 2 {
+				return nil, p.errorf(fields[0].col, "name requires a value")
+			}
+			p.level.Name = strings.TrimSpace(line[strings.Index(line, "name")+len("name"):])
+		case "tile":
+			err = p.parseTile(fields)
+		case "grid":
+			err = p.parseGrid(fields)
+		case "wall_hp":
+			if len(fields) != 2 {
+				return nil, p.errorf(fields[0].col, "wall_hp requires one value")
+			}
+			p.wallHP, err = p.parseInt(fields[1])
+		case "spawn":
+			err = p.parseSpawn(fields)
+		case "brain":
+			err = p.parseBrain(line, fields)
+		case "boss_brain":
+			if len(fields) != 2 {
+				return nil, p.errorf(fields[0].col, "boss_brain requires one value")
+			}
+			p.level.BossBrain = fields[1].text
+			p.brains = append(p.brains, levelBrainRef{name: fields[1].text, line: p.line, col: fields[1].col})
+		case "boss_phase":
+			err = p.parseBossPhase(fields)
+		case "map":
+			if p.level.Cols == 0 {
+				return nil, p.errorf(fields[0].col, "grid must be declared before map")
+			}
+			if len(fields) > 1 {
+				return nil, p.errorf(fields[1].col, "unexpected %q after map", fields[1].text)
+			}
+			inMap = true
+		default:
+			return nil, p.errorf(fields[0].col, "unknown directive %q", fields[0].text)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	p.line++
+	if !inMap {
+		return nil, p.errorf(1, "missing map")
+	}
+	if p.row < p.level.Rows {
+		return nil, p.errorf(1, "map has %d rows, want %d", p.row, p.level.Rows)
+	}
+	if len(p.level.PlayerSpawns) == 0 {
+		return nil, p.errorf(1, "map has no player spawn (P)")
+	}
+	if !p.hasB {
+		return nil, p.errorf(1, "map has no boss spawn (B)")
+	}
+	if err := p.checkSpawns(); err != nil {
+		return nil, err
+	}
+	if err := p.checkBrains(); err != nil {
+		return nil, err
+	}
+	for i := range p.level.Spawns {
+		if p.level.Spawns[i].Kind == SpawnEnemyTank && len(p.level.Spawns[i].Points) == 0 {
+			p.level.Spawns[i].Points = p.level.EnemySpawns
+		}
+	}
+
+	return p.level, nil
+}
+
+func (p *levelParser) parseTile(fields []levelField) error {
+	if len(fields) != 2 {
+		return p.errorf(fields[0].col, "tile requires one value")
+	}
+	if p.level.Cols != 0 {
+		return p.errorf(fields[0].col, "tile must be declared before grid")
+	}
+	size, err := p.parseInt(fields[1])
+	if err != nil {
+		return err
+	}
+	if size < BulletSize {
+		return p.errorf(fields[1].col, "tile size %d is smaller than a bullet", size)
+	}
+	p.level.TileSize = size
+	return nil
+}
+
+func (p *levelParser) parseGrid(fields []levelField) error {
+	if len(fields) != 3 {
+		return p.errorf(fields[0].col, "grid requires columns and rows")
+	}
+	cols, err := p.parseInt(fields[1])
+	if err != nil {
+		return err
+	}
+	rows, err := p.parseInt(fields[2])
+	if err != nil {
+		return err
+	}
+	if cols*p.level.TileSize > ScreenWidth {
+		return p.errorf(fields[1].col, "%d columns of %dpx do not fit in %dpx", cols, p.level.TileSize, ScreenWidth)
+	}
+	if rows*p.level.TileSize > ScreenHeight-StatusBarHeight {
+		return p.errorf(fields[2].col, "%d rows of %dpx do not fit in %dpx", rows, p.level.TileSize, ScreenHeight-StatusBarHeight)
+	}
+	p.level.Cols, p.level.Rows = cols, rows
+	return nil
+}
+
+func (p *levelParser) parseSpawn(fields []levelField) error {
+	if len(fields) < 2 {
+		return p.errorf(fields[0].col, "spawn requires a kind")
+	}
+
+	var rule SpawnRule
+	switch fields[1].text {
+	case "enemy":
+		rule.Kind = SpawnEnemyTank
+	case "wall":
+		rule.Kind = SpawnWall
+	default:
+		return p.errorf(fields[1].col, "unknown spawn kind %q", fields[1].text)
+	}
+
+	hasMin, hasMax, hasAlive := false, false, false
+	for _, f := range fields[2:] {
+		key, value, ok := strings.Cut(f.text, "=")
+		if !ok {
+			return p.errorf(f.col, "expected key=value, got %q", f.text)
+		}
+		if key == "types" {
+			if rule.Kind != SpawnEnemyTank {
+				return p.errorf(f.col, "only enemy spawns have types")
+			}
+			types, err := p.parseEnemyWeights(levelField{text: value, col: f.col + len([]rune(key)) + 1})
+			if err != nil {
+				return err
+			}
+			rule.Types = types
+			continue
+		}
+		if key == "brain" {
+			if rule.Kind != SpawnEnemyTank {
+				return p.errorf(f.col, "only enemy spawns have a brain")
+			}
+			rule.Brain = value
+			p.brains = append(p.brains, levelBrainRef{name: value, line: p.line, col: f.col + len([]rune(key)) + 1})
+			continue
+		}
+		v, err := p.parseUint(levelField{text: value, col: f.col + len([]rune(key)) + 1})
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "min":
+			rule.MinDelay = v * TicksPerSecond
+			hasMin = true
+		case "max":
+			rule.MaxDelay = v * TicksPerSecond
+			hasMax = true
+		case "alive":
+			if v == 0 {
+				return p.errorf(f.col, "alive must be positive")
+			}
+			rule.MaxAlive = v
+			hasAlive = true
+		case "total":
+			rule.Total = v
+		default:
+			return p.errorf(f.col, "unknown spawn option %q", key)
+		}
+	}
+	if !hasMin || !hasMax || !hasAlive {
+		return p.errorf(fields[0].col, "spawn requires min, max and alive")
+	}
+	if rule.MinDelay > rule.MaxDelay {
+		return p.errorf(fields[0].col, "spawn min is greater than max")
+	}
+
+	p.level.Spawns = append(p.level.Spawns, rule)
+	return nil
+}
+
+// parseEnemyWeights 解析 spawn 指令的 types 选项：种类[:权重],...，权重默认为 1
+func (p *levelParser) parseEnemyWeights(f levelField) ([]EnemyWeight, error) {
+	var weights []EnemyWeight
+	col := f.col
+	for _, item := range strings.Split(f.text, ",") {
+		name, weight, hasWeight := strings.Cut(item, ":")
+		if !isBrainName(name) {
+			return nil, p.errorf(col, "invalid enemy type name %q", name)
+		}
+		wt := EnemyWeight{Type: name, Weight: 1}
+		if hasWeight {
+			v, err := p.parseInt(levelField{text: weight, col: col + len([]rune(name)) + 1})
+			if err != nil {
+				return nil, err
+			}
+			wt.Weight = v
+		}
+		weights = append(weights, wt)
+		col += len([]rune(item)) + 1
+	}
+	return weights, nil
+}
+
+// parseBossPhase 解析 boss_phase 指令：boss_phase 生命值百分比 攻击方式 攻击间隔
+func (p *levelParser) parseBossPhase(fields []levelField) error {
+	if len(fields) != 4 {
+		return p.errorf(fields[0].col, "boss_phase requires health, attack and interval")
+	}
+	health, err := p.parseInt(fields[1])
+	if err != nil {
+		return err
+	}
+	if health > 100 {
+		return p.errorf(fields[1].col, "boss_phase health must be at most 100")
+	}
+	if n := len(p.level.BossPhases); n > 0 && health >= p.level.BossPhases[n-1].Health {
+		return p.errorf(fields[1].col, "boss_phase health must be lower than the previous phase")
+	}
+	attack, ok := bossAttackNames[fields[2].text]
+	if !ok {
+		return p.errorf(fields[2].col, "unknown boss attack %q", fields[2].text)
+	}
+	interval, err := p.parseInt(fields[3])
+	if err != nil {
+		return err
+	}
+	p.level.BossPhases = append(p.level.BossPhases, BossPhase{Health: health, Attack: attack, Interval: interval * TicksPerSecond})
+	return nil
+}
+
+// parseBrain 解析 brain 指令：brain 名字 定义
+func (p *levelParser) parseBrain(line string, fields []levelField) error {
+	if len(fields) < 3 {
+		return p.errorf(fields[0].col, "brain requires a name and a definition")
+	}
+	name := fields[1].text
+	if !isBrainName(name) {
+		return p.errorf(fields[1].col, "invalid brain name %q", name)
+	}
+	if _, ok := p.level.Brains[name]; ok {
+		return p.errorf(fields[1].col, "duplicate brain %q", name)
+	}
+	// 定义中可以有空白，取名字之后的整行
+	runes := []rune(line)
+	def := strings.TrimSpace(string(runes[fields[2].col-1:]))
+	if _, err := ParseBrain(name, def); err != nil {
+		var e *BrainError
+		if errors.As(err, &e) {
+			return p.errorf(fields[2].col+e.Pos, "%s", e.Msg)
+		}
+		return err
+	}
+	if p.level.Brains == nil {
+		p.level.Brains = make(map[string]string)
+	}
+	p.level.Brains[name] = def
+	return nil
+}
+
+// checkBrains 校验引用的行为树都有定义
+func (p *levelParser) checkBrains() error {
+	for _, ref := range p.brains {
+		if _, ok := builtinBrains[ref.name]; ok {
+			continue
+		}
+		if _, ok := p.level.Brains[ref.name]; ok {
+			continue
+		}
+		return &LevelError{File: p.file, Line: ref.line, Col: ref.col, Msg: fmt.Sprintf("unknown brain %q", ref.name)}
+	}
+	return nil
+}
+
+// checkSpawns 校验出生点处的坦克不会超出地图或与墙重叠
+func (p *levelParser) checkSpawns() error {
+	width := float32(p.level.Cols * p.level.TileSize)
+	height := float32(StatusBarHeight + p.level.Rows*p.level.TileSize)
+	for _, sp := range p.spawns {
+		e := &LevelError{File: p.file, Line: sp.line, Col: sp.col}
+		if sp.pos.X+TankSize > width || sp.pos.Y+TankSize > height {
+			e.Msg = sp.what + " is too close to the map edge"
+			return e
+		}
+		for _, wall := range p.level.Walls {
+			if !wall.Kind.BlocksTank() {
+				continue
+			}
+			if checkCollision(sp.pos.X, sp.pos.Y, TankSize, TankSize, wall.X, wall.Y, wall.Width, wall.Height) {
+				e.Msg = sp.what + " overlaps a wall"
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// parseMapRow 解析地图中的一行
+func (p *levelParser) parseMapRow(line string) error {
+	tile := float32(p.level.TileSize)
+	y := float32(StatusBarHeight) + float32(p.row)*tile
+
+	col := 0
+	for _, r := range line {
+		if col >= p.level.Cols {
+			return p.errorf(col+1, "row is longer than %d columns", p.level.Cols)
+		}
+		x := float32(col) * tile
+		col++
+
+		switch {
+		case r == '.':
+		case r == '#':
+			p.level.Walls = append(p.level.Walls, Wall{X: x, Y: y, Width: tile, Height: tile, Health: p.wallHP})
+		case r >= '1' && r <= '9':
+			p.level.Walls = append(p.level.Walls, Wall{X: x, Y: y, Width: tile, Height: tile, Health: int(r - '0')})
+		case levelTerrains[r] != 0:
+			p.level.Walls = append(p.level.Walls, Wall{X: x, Y: y, Width: tile, Height: tile, Health: p.wallHP, Kind: levelTerrains[r]})
+		case r == 'P':
+			if len(p.level.PlayerSpawns) >= MaxPlayers {
+				return p.errorf(col, "more than %d player spawns", MaxPlayers)
+			}
+			p.level.PlayerSpawns = append(p.level.PlayerSpawns, Point{X: x, Y: y})
+			p.spawns = append(p.spawns, levelSpawn{what: "player spawn", pos: Point{X: x, Y: y}, line: p.line, col: col})
+		case r == 'B':
+			if p.hasB {
+				return p.errorf(col, "duplicate boss spawn")
+			}
+			p.hasB = true
+			p.level.BossSpawn = Point{X: x, Y: y}
+			p.spawns = append(p.spawns, levelSpawn{what: "boss spawn", pos: p.level.BossSpawn, line: p.line, col: col})
+		case r == 'E':
+			p.level.EnemySpawns = append(p.level.EnemySpawns, Point{X: x, Y: y})
+			p.spawns = append(p.spawns, levelSpawn{what: "enemy spawn", pos: Point{X: x, Y: y}, line: p.line, col: col})
+		default:
+			return p.errorf(col, "unknown tile %q", r)
+		}
+	}
+	if col < p.level.Cols {
+		return p.errorf(col+1, "row has %d columns, want %d", col, p.level.Cols)
+	}
+
+	p.row++
+	return nil
+}
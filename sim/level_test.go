@@ -0,0 +1,41 @@
+package sim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseLevelErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		lines     []string
+		line, col int
+		msg       string
+	}{
+		{"unknown directive", []string{"# 注释", "  tiles 20"}, 2, 3, `unknown directive "tiles"`},
+		{"bad directive value", []string{"tile 20", "grid 4 x"}, 2, 8, `expected positive integer, got "x"`},
+		{"unknown spawn option", []string{"spawn enemy min=5 speed=3"}, 1, 19, `unknown spawn option "speed"`},
+		{"unknown boss attack", []string{"boss_phase 50 laser 3"}, 1, 15, `unknown boss attack "laser"`},
+		{"map before grid", []string{"tile 20", " map"}, 2, 2, "grid must be declared before map"},
+		// 列号按字符计算，而不是按字节
+		{"column after wide text", []string{"spawn enemy brain=守卫 speed=3"}, 1, 22, `unknown spawn option "speed"`},
+		{"unknown tile", []string{"grid 4 3", "map", "P..B", ".X..", "...."}, 4, 2, `unknown tile 'X'`},
+		{"short row", []string{"grid 4 3", "map", "P..B", "...", "...."}, 4, 4, "row has 3 columns, want 4"},
+		{"long row", []string{"grid 4 3", "map", "P..B", "....", "....."}, 5, 5, "row is longer than 4 columns"},
+		{"missing rows", []string{"grid 4 3", "map", "P..B"}, 4, 1, "map has 1 rows, want 3"},
+		{"content after map", []string{"grid 4 3", "map", "P..B", "....", "....", "tile 20"}, 6, 1, "unexpected content after map"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseLevel("test.txt", strings.NewReader(strings.Join(tt.lines, "\n")))
+			var le *LevelError
+			if !errors.As(err, &le) {
+				t.Fatalf("got error %v, want a LevelError", err)
+			}
+			if le.File != "test.txt" || le.Line != tt.line || le.Col != tt.col || le.Msg != tt.msg {
+				t.Errorf("got %v, want test.txt:%d:%d: %s", le, tt.line, tt.col, tt.msg)
+			}
+		})
+	}
+}
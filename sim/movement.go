@@ -0,0 +1,281 @@
+package sim
+
+import "fmt"
+
+// MovementMode 表示玩家坦克的移动方式
+type MovementMode int
+
+const (
+	// MoveClassic 经典模式：只能朝上下左右四个方向移动和射击
+	MoveClassic MovementMode = iota
+	// MoveEightWay 八方向模式：可以斜着移动，车身立即转向，炮塔跟随车身，
+	// 瞄准时炮塔也对齐到八个方向
+	MoveEightWay
+	// MoveAnalog 自由模式：车身按任意角度逐渐转向并沿车头方向前进，
+	// 炮塔独立于车身转向瞄准的方向
+	MoveAnalog
+)
+
+// movementModeNames 各移动方式的名字
+var movementModeNames = [...]string{
+	MoveClassic:  "classic",
+	MoveEightWay: "eight",
+	MoveAnalog:   "analog",
+}
+
+// MovementModes 返回所有移动方式
+func MovementModes() []MovementMode {
+	return []MovementMode{MoveClassic, MoveEightWay, MoveAnalog}
+}
+
+// ParseMovementMode 根据名字（classic、eight、analog）返回移动方式
+func ParseMovementMode(name string) (MovementMode, error) {
+	for m, n := range movementModeNames {
+		if n == name {
+			return MovementMode(m), nil
+		}
+	}
+	return MoveClassic, fmt.Errorf("unknown movement mode %q", name)
+}
+
+func (m MovementMode) String() string {
+	if m >= 0 && int(m) < len(movementModeNames) {
+		return movementModeNames[m]
+	}
+	return fmt.Sprintf("MovementMode(%d)", int(m))
+}
+
+const (
+	// HullTurnRate 自由模式下车身每帧最多转过的角度
+	HullTurnRate = 8
+	// TurretTurnRate 自由模式下炮塔每帧最多转过的角度
+	TurretTurnRate = 16
+)
+
+// Angle 表示一个方向，把一圈分成 256 份，0 为正上方，顺时针增大。
+// 用整数表示角度，加减时自然回绕，在各平台上的结果完全相同。
+type Angle uint8
+
+// quarterSine 四分之一圈内各角度的正弦值。用常量而不是三角函数，
+// 保证不同平台上的结果完全相同。
+var quarterSine = [65]float32{
+	0, 0.0245412, 0.0490677, 0.0735646, 0.0980171, 0.1224107, 0.1467305, 0.1709619,
+	0.1950903, 0.2191012, 0.2429802, 0.2667128, 0.2902847, 0.3136817, 0.3368899, 0.359895,
+	0.3826834, 0.4052413, 0.4275551, 0.4496113, 0.4713967, 0.4928982, 0.5141027, 0.5349976,
+	0.5555702, 0.5758082, 0.5956993, 0.6152316, 0.6343933, 0.6531728, 0.671559, 0.6895405,
+	0.7071068, 0.7242471, 0.7409511, 0.7572088, 0.7730105, 0.7883464, 0.8032075, 0.8175848,
+	0.8314696, 0.8448536, 0.8577286, 0.870087, 0.8819213, 0.8932243, 0.9039893, 0.9142098,
+	0.9238795, 0.9329928, 0.9415441, 0.9495282, 0.9569403, 0.9637761, 0.9700313, 0.9757021,
+	0.9807853, 0.9852776, 0.9891765, 0.9924795, 0.9951847, 0.9972905, 0.9987955, 0.9996988,
+	1,
+}
+
+// sine 返回角度 a 的正弦值
+func sine(a Angle) float32 {
+	r := int(a) % 64
+	switch a / 64 {
+	case 0:
+		return quarterSine[r]
+	case 1:
+		return quarterSine[64-r]
+	case 2:
+		return -quarterSine[r]
+	default:
+		return -quarterSine[64-r]
+	}
+}
+
+// Vector 返回这个方向的单位向量，屏幕坐标中 y 轴向下
+func (a Angle) Vector() (dx, dy float32) {
+	return sine(a), -sine(a + 64)
+}
+
+// Direction 返回最接近这个方向的上下左右方向（0: 上, 1: 右, 2: 下, 3: 左）
+func (a Angle) Direction() int {
+	return int((a + 32) / 64)
+}
+
+// snap 返回把一圈分成 n 个方向时最接近 a 的方向，n 必须能整除 256
+func (a Angle) snap(n int) Angle {
+	step := 256 / n
+	return Angle((int(a) + step/2) / step * step)
+}
+
+// DirectionAngle 返回上下左右方向 dir 对应的角度
+func DirectionAngle(dir int) Angle {
+	return Angle(dir * 64)
+}
+
+// turnToward 从 a 向 target 沿较近的一侧最多转过 rate
+func turnToward(a, target Angle, rate int) Angle {
+	d := int(int8(target - a))
+	return a + Angle(min(max(d, -rate), rate))
+}
+
+// angleDiff 返回两个方向之间的夹角，0 到 128
+func angleDiff(a, b Angle) int {
+	d := int(int8(a - b))
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// keyHeadings 方向键组合对应的方向，下标为 (dy+1)*3 + dx+1
+var keyHeadings = [9]Angle{
+	224, 0, 32,
+	192, 0, 64,
+	160, 128, 96,
+}
+
+// inputHeading 返回输入中要求坦克前进的方向：摇杆给出的角度，或者方向键
+// 组合出的八个方向之一。没有要求移动时返回 false。
+func inputHeading(input Input) (Angle, bool) {
+	if a, ok := input.Move(); ok {
+		return a, true
+	}
+	var dx, dy int
+	if input.Has(InputRight) {
+		dx++
+	}
+	if input.Has(InputLeft) {
+		dx--
+	}
+	if input.Has(InputDown) {
+		dy++
+	}
+	if input.Has(InputUp) {
+		dy--
+	}
+	if dx == 0 && dy == 0 {
+		return 0, false
+	}
+	return keyHeadings[(dy+1)*3+dx+1], true
+}
+
+// moveFree 八方向和自由模式下根据输入转动车身和炮塔并移动坦克
+func (w *World) moveFree(tank *Tank, input Input) {
+	heading, steer := inputHeading(input)
+	moving := steer
+	if w.cfg.Movement == MoveEightWay {
+		if steer {
+			tank.Angle = heading.snap(8)
+		}
+	} else if steer {
+		tank.Angle = turnToward(tank.Angle, heading, HullTurnRate)
+		// 与要去的方向相差超过 90° 时原地转向
+		moving = angleDiff(tank.Angle, heading) <= 64
+	}
+	if !steer && tank.slide > 0 {
+		// 松开方向键后在冰面上沿车头方向继续滑行
+		tank.slide--
+		moving = true
+	}
+
+	tank.vx, tank.vy = 0, 0
+	collision := false
+	if moving {
+		dx, dy := tank.Angle.Vector()
+		speed := w.playerSpeed()
+		collision = !w.moveHull(tank, dx*speed, dy*speed)
+	}
+	if !w.onIce(tank) || collision {
+		tank.slide = 0
+	} else if steer {
+		tank.slide = IceSlideTicks
+	}
+	// AI 按上下左右判断玩家的朝向
+	tank.Direction = tank.Angle.Direction()
+
+	// 没有瞄准时炮塔转回车头的方向
+	aim, ok := input.Aim()
+	if !ok {
+		aim = tank.Angle
+	}
+	if w.cfg.Movement == MoveEightWay {
+		tank.Turret = aim.snap(8)
+	} else {
+		tank.Turret = turnToward(tank.Turret, aim, TurretTurnRate)
+	}
+}
+
+// moveHull 将圆形车身的坦克移动 (mx, my)，被挡住时分别尝试只沿 x 轴或 y 轴
+// 移动，贴着墙滑过去。返回坦克是否完整地走完了这段距离。
+func (w *World) moveHull(tank *Tank, mx, my float32) bool {
+	for _, m := range [3][2]float32{{mx, my}, {mx, 0}, {0, my}} {
+		if m[0] == 0 && m[1] == 0 {
+			continue
+		}
+		x, y := tank.X+m[0], tank.Y+m[1]
+		if !w.hullBlocked(x, y) {
+			tank.X, tank.Y = x, y
+			tank.vx, tank.vy = m[0], m[1]
+			return m[0] == mx && m[1] == my
+		}
+	}
+	return false
+}
+
+// hullBlocked 判断圆形车身的坦克放在 (x, y) 时是否会超出屏幕，
+// 或与墙、Boss 坦克、敌方坦克重叠
+func (w *World) hullBlocked(x, y float32) bool {
+	if x < 0 || x > ScreenWidth-TankSize || y < StatusBarHeight || y > ScreenHeight-TankSize {
+		return true
+	}
+	cx, cy, r := x+TankSize/2, y+TankSize/2, float32(TankSize/2)
+	for _, wall := range w.Walls {
+		if wall.Kind.BlocksTank() && circleHitsRect(cx, cy, r, wall.X, wall.Y, wall.Width, wall.Height) {
+			return true
+		}
+	}
+	if w.BossTank != nil && circleHitsRect(cx, cy, r, w.BossTank.X, w.BossTank.Y, TankSize, TankSize) {
+		return true
+	}
+	for _, enemyTank := range w.EnemyTanks {
+		if circleHitsRect(cx, cy, r, enemyTank.X, enemyTank.Y, TankSize, TankSize) {
+			return true
+		}
+	}
+	return false
+}
+
+// roundHull 判断坦克的车身是否按圆形计算碰撞。八方向和自由模式下玩家坦克的
+// 车身可以转到任意角度，用内切圆代替旋转后的正方形。
+func (w *World) roundHull(t *Tank) bool {
+	if w.cfg.Movement == MoveClassic {
+		return false
+	}
+	for _, p := range w.Players {
+		if p.Tank == t {
+			return true
+		}
+	}
+	return false
+}
+
+// tankOverlaps 判断坦克的车身是否与矩形重叠
+func (w *World) tankOverlaps(t *Tank, x, y, width, height float32) bool {
+	bx, by, size := t.Body()
+	if w.roundHull(t) {
+		return circleHitsRect(bx+size/2, by+size/2, size/2, x, y, width, height)
+	}
+	return checkCollision(bx, by, size, size, x, y, width, height)
+}
+
+// tankInRange 判断坦克的车身是否有一部分在以 (cx, cy) 为圆心、r 为半径的圆内
+func (w *World) tankInRange(t *Tank, cx, cy, r float32) bool {
+	bx, by, size := t.Body()
+	if w.roundHull(t) {
+		dx, dy := bx+size/2-cx, by+size/2-cy
+		return dx*dx+dy*dy <= (r+size/2)*(r+size/2)
+	}
+	return circleHitsRect(cx, cy, r, bx, by, size, size)
+}
+
+// barrelVector 返回玩家坦克炮管指向的单位向量
+func (w *World) barrelVector(t *Tank) (dx, dy float32) {
+	if w.cfg.Movement == MoveClassic {
+		return directionVector(t.Direction)
+	}
+	return t.Turret.Vector()
+}
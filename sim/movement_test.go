@@ -0,0 +1,59 @@
+package sim
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestPlayerStaysInField 让引擎强化到满级的玩家坦克朝四个方向一直开到屏幕边缘，
+// 各难度下的速度都不是整数，坦克无论如何都不能越过边缘
+func TestPlayerStaysInField(t *testing.T) {
+	edges := []struct {
+		name  string
+		input Input
+	}{
+		{"up", InputUp},
+		{"right", InputRight},
+		{"down", InputDown},
+		{"left", InputLeft},
+	}
+	for _, d := range Difficulties() {
+		for _, m := range MovementModes() {
+			for _, e := range edges {
+				t.Run(fmt.Sprintf("%s/%s/%s", d, m, e.name), func(t *testing.T) {
+					cfg := d.Apply(DefaultConfig())
+					cfg.Movement = m
+					cfg.Perks.Engine = MaxPerkLevel
+					w := NewLevelWorld(1, cfg, &Level{TileSize: DefaultTileSize})
+					// 只移动玩家坦克，不让Boss挡路
+					w.BossTank = nil
+					tank := w.Players[0].Tank
+					tank.X, tank.Y = ScreenWidth/2, ScreenHeight/2
+
+					for i := 0; i < ScreenWidth; i++ {
+						w.updatePlayerTank(0, e.input)
+						if tank.X < 0 || tank.X > ScreenWidth-TankSize || tank.Y < StatusBarHeight || tank.Y > ScreenHeight-TankSize {
+							t.Fatalf("tick %d: tank at (%v, %v) left the field", i, tank.X, tank.Y)
+						}
+					}
+
+					speed := w.playerSpeed()
+					var gap float32
+					switch e.input {
+					case InputUp:
+						gap = tank.Y - StatusBarHeight
+					case InputRight:
+						gap = ScreenWidth - TankSize - tank.X
+					case InputDown:
+						gap = ScreenHeight - TankSize - tank.Y
+					case InputLeft:
+						gap = tank.X
+					}
+					if gap >= speed {
+						t.Fatalf("tank stopped %v px short of the edge at (%v, %v)", gap, tank.X, tank.Y)
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,599 @@
+package sim
+
+// NavCellSize 导航网格的格子边长。网格中的每个格子表示坦克左上角可以停留的
+// 一个位置，坦克在相邻的两个可通行格子之间直线移动时不会被墙挡住。
+const NavCellSize = 10
+
+const (
+	navCols = ScreenWidth / NavCellSize
+	navRows = (ScreenHeight - StatusBarHeight) / NavCellSize
+	// 坦克左上角所在格子的最大行列号，超出时坦克会越出屏幕
+	navMaxCol = (ScreenWidth - TankSize) / NavCellSize
+	navMaxRow = (ScreenHeight - StatusBarHeight - TankSize) / NavCellSize
+
+	// navBudget 每帧用于寻路的最多扩展节点数。超出预算的坦克留到下一帧再规划，
+	// 其间沿用旧的路径或随机移动，因此场上坦克再多，每帧的寻路开销也有上限。
+	navBudget = 4000
+	// navMaxExpand 一次寻路最多扩展的节点数，超出时走向已经找到的离目标最近的格子
+	navMaxExpand = 2000
+	// navReplanTicks 路径的有效期，超过后重新规划以跟上移动的目标
+	navReplanTicks = 45
+	// navStuckTicks 坦克连续被挡住这么多帧后放弃当前路径，随机移动一会儿
+	navStuckTicks = 20
+	// navSearchRadius 目标所在的格子不可通行时，在周围多少格内寻找替代的格子
+	navSearchRadius = 6
+)
+
+// navTarget 表示坦克寻路的目标种类
+type navTarget int
+
+const (
+	// navTargetPlayer 最近的没有藏起来的玩家
+	navTargetPlayer navTarget = iota
+	// navTargetBase 玩家的基地，关卡中没有基地时改为玩家
+	navTargetBase
+	// navTargetCover 最近的掩体：树林，或者挡住最近的玩家视线的墙后面
+	navTargetCover
+	// navTargetCell 指定的格子 navPath.goal
+	navTargetCell
+)
+
+// navPath 记录一辆坦克的寻路状态
+type navPath struct {
+	target  navTarget
+	goal    int32   // target 为 navTargetCell 时要前往的格子
+	cells   []int32 // 路径上的格子，cells[0] 为起点。规划后不再修改，可以与快照共用
+	next    int     // 下一个要到达的格子在 cells 中的下标
+	planned int     // 规划路径时的帧号
+	version int     // 规划路径时导航网格的版本
+	stuck   int     // 连续被挡住的帧数
+	wander  int     // 放弃路径后随机移动的剩余帧数
+}
+
+// navGrid 由墙生成的导航网格，墙被摧毁或生成后重新生成
+type navGrid struct {
+	blocked []bool  // blocked[c] 表示坦克左上角位于格子 c 时会被墙挡住
+	cover   []int32 // 可以作为掩体的格子：树林中的格子和紧贴墙的格子
+	forest  []bool
+	version int
+
+	// A* 的工作区，在多次搜索之间重复使用。stamp 区分不同次搜索，避免每次清空
+	cost   []int32
+	parent []int32
+	seen   []uint32
+	closed []uint32
+	stamp  uint32
+	open   navHeap
+}
+
+// navCell 返回第 cx 列、第 cy 行格子的序号
+func navCell(cx, cy int) int32 {
+	return int32(cy*navCols + cx)
+}
+
+// navCellPos 返回坦克位于格子 c 时左上角的坐标
+func navCellPos(c int32) (float32, float32) {
+	cx, cy := int(c)%navCols, int(c)/navCols
+	return float32(cx * NavCellSize), float32(StatusBarHeight + cy*NavCellSize)
+}
+
+// navGrid 返回当前的导航网格，墙变化后重新生成
+func (w *World) navGrid() *navGrid {
+	if w.nav == nil {
+		w.nav = &navGrid{
+			blocked: make([]bool, navCols*navRows),
+			forest:  make([]bool, navCols*navRows),
+			cost:    make([]int32, navCols*navRows),
+			parent:  make([]int32, navCols*navRows),
+			seen:    make([]uint32, navCols*navRows),
+			closed:  make([]uint32, navCols*navRows),
+		}
+		w.navDirty = true
+	}
+	if w.navDirty {
+		w.nav.build(w.Walls, w.navVersion)
+		w.navDirty = false
+	}
+	return w.nav
+}
+
+// wallsChanged 在墙被摧毁或生成后调用，下一次寻路前重新生成导航网格。
+// 网格的版本号是世界状态的一部分，坦克据此判断路径是否需要检查。
+func (w *World) wallsChanged() {
+	w.navVersion++
+	w.navDirty = true
+}
+
+// build 根据墙重新生成导航网格，version 为网格的版本号
+func (n *navGrid) build(walls []Wall, version int) {
+	for c := range n.blocked {
+		cx, cy := c%navCols, c/navCols
+		n.blocked[c] = cx > navMaxCol || cy > navMaxRow
+		n.forest[c] = false
+	}
+	for _, wall := range walls {
+		// 只检查与墙相邻的格子
+		x0 := max(0, int((wall.X-TankSize)/NavCellSize))
+		x1 := min(navCols-1, int((wall.X+wall.Width)/NavCellSize)+1)
+		y0 := max(0, int((wall.Y-StatusBarHeight-TankSize)/NavCellSize))
+		y1 := min(navRows-1, int((wall.Y-StatusBarHeight+wall.Height)/NavCellSize)+1)
+		for cy := y0; cy <= y1; cy++ {
+			for cx := x0; cx <= x1; cx++ {
+				c := navCell(cx, cy)
+				x, y := navCellPos(c)
+				if wall.Kind.BlocksTank() && checkCollision(x, y, TankSize, TankSize, wall.X, wall.Y, wall.Width, wall.Height) {
+					n.blocked[c] = true
+				}
+				// 与 IsHidden 一样以坦克的中心判断是否在树林中
+				cx, cy := x+TankSize/2, y+TankSize/2
+				if wall.Kind == TerrainForest && cx >= wall.X && cx < wall.X+wall.Width && cy >= wall.Y && cy < wall.Y+wall.Height {
+					n.forest[c] = true
+				}
+			}
+		}
+	}
+
+	// 掩体：树林中的格子，以及紧挨着不可通行格子的可通行格子
+	n.cover = n.cover[:0]
+	for c := range n.blocked {
+		if n.blocked[c] {
+			continue
+		}
+		if n.forest[c] || n.nextToBlocked(int32(c)) {
+			n.cover = append(n.cover, int32(c))
+		}
+	}
+	n.version = version
+}
+
+// nextToBlocked 判断格子 c 上下左右是否有被墙挡住的格子，屏幕边缘不算
+func (n *navGrid) nextToBlocked(c int32) bool {
+	cx, cy := int(c)%navCols, int(c)/navCols
+	return (cx > 0 && n.blocked[c-1]) ||
+		(cx < navMaxCol && n.blocked[c+1]) ||
+		(cy > 0 && n.blocked[c-navCols]) ||
+		(cy < navMaxRow && n.blocked[c+navCols])
+}
+
+// walkable 判断格子 c 是否可以通行
+func (n *navGrid) walkable(c int32) bool {
+	return c >= 0 && int(c) < len(n.blocked) && !n.blocked[c]
+}
+
+// nearestWalkable 返回离 (x, y) 最近的可通行格子，坐标为坦克左上角的位置。
+// 附近没有可通行的格子时返回 -1。
+func (n *navGrid) nearestWalkable(x, y float32) int32 {
+	fx := x / NavCellSize
+	fy := (y - StatusBarHeight) / NavCellSize
+	cx := min(max(int(fx+0.5), 0), navMaxCol)
+	cy := min(max(int(fy+0.5), 0), navMaxRow)
+	for r := 0; r <= navSearchRadius; r++ {
+		best, bestDist := int32(-1), float32(0)
+		for dy := -r; dy <= r; dy++ {
+			for dx := -r; dx <= r; dx++ {
+				if max(abs(dx), abs(dy)) != r {
+					continue
+				}
+				x, y := cx+dx, cy+dy
+				if x < 0 || y < 0 || x > navMaxCol || y > navMaxRow {
+					continue
+				}
+				c := navCell(x, y)
+				if n.blocked[c] {
+					continue
+				}
+				d := absf(float32(x)-fx) + absf(float32(y)-fy)
+				if best < 0 || d < bestDist {
+					best, bestDist = c, d
+				}
+			}
+		}
+		if best >= 0 {
+			return best
+		}
+	}
+	return -1
+}
+
+// findPath 用 A* 寻找从 start 到 goal 的路径，返回路径上的格子和扩展的节点数。
+// 扩展的节点数超过 maxExpand 仍没有到达目标时，返回通往已经找到的离目标最近的
+// 格子的路径。
+func (n *navGrid) findPath(start, goal int32, maxExpand int) ([]int32, int) {
+	n.stamp++
+	if n.stamp == 0 {
+		// 计数器回绕，清空旧的标记
+		clear(n.seen)
+		clear(n.closed)
+		n.stamp = 1
+	}
+	gx, gy := int32(goal)%navCols, int32(goal)/navCols
+	heuristic := func(c int32) int32 {
+		return abs(c%navCols-gx) + abs(c/navCols-gy)
+	}
+
+	n.open = n.open[:0]
+	n.cost[start] = 0
+	n.parent[start] = -1
+	n.seen[start] = n.stamp
+	n.open.push(navNode{cell: start, f: heuristic(start), h: heuristic(start)})
+	best, bestH := start, heuristic(start)
+
+	expanded := 0
+	for len(n.open) > 0 && expanded < maxExpand {
+		node := n.open.pop()
+		c := node.cell
+		if n.closed[c] == n.stamp {
+			continue
+		}
+		n.closed[c] = n.stamp
+		expanded++
+		if node.h < bestH {
+			best, bestH = c, node.h
+		}
+		if c == goal {
+			break
+		}
+
+		cx, cy := c%navCols, c/navCols
+		// 邻居按固定的顺序扩展，保证结果与运行环境无关
+		for dir := 0; dir < 4; dir++ {
+			var next int32
+			switch dir {
+			case 0:
+				if cy == 0 {
+					continue
+				}
+				next = c - navCols
+			case 1:
+				if cx >= navMaxCol {
+					continue
+				}
+				next = c + 1
+			case 2:
+				if cy >= navMaxRow {
+					continue
+				}
+				next = c + navCols
+			case 3:
+				if cx == 0 {
+					continue
+				}
+				next = c - 1
+			}
+			if n.blocked[next] || n.closed[next] == n.stamp {
+				continue
+			}
+			cost := n.cost[c] + 1
+			if n.seen[next] == n.stamp && cost >= n.cost[next] {
+				continue
+			}
+			n.seen[next] = n.stamp
+			n.cost[next] = cost
+			n.parent[next] = c
+			h := heuristic(next)
+			n.open.push(navNode{cell: next, f: cost + h, h: h})
+		}
+	}
+
+	// 从终点沿着 parent 回到起点
+	length := 0
+	for c := best; c >= 0; c = n.parent[c] {
+		length++
+	}
+	path := make([]int32, length)
+	for c, i := best, length-1; c >= 0; c, i = n.parent[c], i-1 {
+		path[i] = c
+	}
+	return path, expanded
+}
+
+// navNode A* 开放列表中的一个节点
+type navNode struct {
+	cell int32
+	f, h int32
+}
+
+// less 先比较估计的总代价，再比较到目标的估计距离，最后比较格子序号，
+// 保证相同代价的节点总是以相同的顺序取出
+func (a navNode) less(b navNode) bool {
+	if a.f != b.f {
+		return a.f < b.f
+	}
+	if a.h != b.h {
+		return a.h < b.h
+	}
+	return a.cell < b.cell
+}
+
+// navHeap 以 navNode.less 排序的二叉堆
+type navHeap []navNode
+
+func (h *navHeap) push(node navNode) {
+	*h = append(*h, node)
+	s := *h
+	for i := len(s) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !s[i].less(s[parent]) {
+			break
+		}
+		s[i], s[parent] = s[parent], s[i]
+		i = parent
+	}
+}
+
+func (h *navHeap) pop() navNode {
+	s := *h
+	top := s[0]
+	last := len(s) - 1
+	s[0] = s[last]
+	s = s[:last]
+	for i := 0; ; {
+		l, r, m := 2*i+1, 2*i+2, i
+		if l < len(s) && s[l].less(s[m]) {
+			m = l
+		}
+		if r < len(s) && s[r].less(s[m]) {
+			m = r
+		}
+		if m == i {
+			break
+		}
+		s[i], s[m] = s[m], s[i]
+		i = m
+	}
+	*h = s
+	return top
+}
+
+// lineOfSight 判断从 (x0, y0) 到 (x1, y1) 的视线是否没有被挡住子弹的墙挡住
+func (w *World) lineOfSight(x0, y0, x1, y1 float32) bool {
+	for _, wall := range w.Walls {
+		if !wall.Kind.BlocksBullet() {
+			continue
+		}
+		if segmentHitsRect(x0, y0, x1, y1, wall.X, wall.Y, wall.Width, wall.Height) {
+			return false
+		}
+	}
+	return true
+}
+
+// segmentHitsRect 判断线段是否穿过矩形，使用 Liang-Barsky 裁剪算法
+func segmentHitsRect(x0, y0, x1, y1, rx, ry, rw, rh float32) bool {
+	t0, t1 := float32(0), float32(1)
+	dx, dy := x1-x0, y1-y0
+	clip := func(p, q float32) bool {
+		if p == 0 {
+			return q >= 0
+		}
+		t := q / p
+		if p < 0 {
+			if t > t1 {
+				return false
+			}
+			t0 = max(t0, t)
+		} else {
+			if t < t0 {
+				return false
+			}
+			t1 = min(t1, t)
+		}
+		return true
+	}
+	return clip(-dx, x0-rx) && clip(dx, rx+rw-x0) && clip(-dy, y0-ry) && clip(dy, ry+rh-y0) && t0 <= t1
+}
+
+// nearestVisiblePlayer 返回离 (x, y) 最近的、没有藏在树林中的玩家坦克，没有时返回 nil
+func (w *World) nearestVisiblePlayer(x, y float32) *Tank {
+	var best *Tank
+	var bestDist float32
+	for _, p := range w.Players {
+		if p.Tank == nil || w.IsHidden(p.Tank) {
+			continue
+		}
+		d := absf(p.Tank.X-x) + absf(p.Tank.Y-y)
+		if best == nil || d < bestDist {
+			best, bestDist = p.Tank, d
+		}
+	}
+	return best
+}
+
+// baseWall 返回离 (x, y) 最近的基地，关卡中没有基地时返回 nil
+func (w *World) baseWall(x, y float32) *Wall {
+	var best *Wall
+	var bestDist float32
+	for i := range w.Walls {
+		wall := &w.Walls[i]
+		if wall.Kind != TerrainBase {
+			continue
+		}
+		d := absf(wall.X-x) + absf(wall.Y-y)
+		if best == nil || d < bestDist {
+			best, bestDist = wall, d
+		}
+	}
+	return best
+}
+
+// navGoal 返回坦克 t 要前往的格子，没有目标时返回 -1
+func (w *World) navGoal(t *Tank) int32 {
+	n := w.navGrid()
+	target := t.nav.target
+	if target == navTargetCell {
+		if n.walkable(t.nav.goal) {
+			return t.nav.goal
+		}
+		x, y := navCellPos(t.nav.goal)
+		return n.nearestWalkable(x, y)
+	}
+	if target == navTargetBase {
+		if base := w.baseWall(t.X, t.Y); base != nil {
+			return n.nearestWalkable(base.X+base.Width/2-TankSize/2, base.Y+base.Height/2-TankSize/2)
+		}
+		target = navTargetPlayer
+	}
+	player := w.nearestVisiblePlayer(t.X, t.Y)
+	if target == navTargetCover {
+		if c := w.coverCell(t, player); c >= 0 {
+			return c
+		}
+	}
+	if player == nil {
+		return -1
+	}
+	return n.nearestWalkable(player.X, player.Y)
+}
+
+// coverCell 返回离坦克 t 最近的掩体格子：树林中的格子，或者与 threat 之间
+// 隔着墙的格子。没有合适的掩体时返回 -1。
+func (w *World) coverCell(t *Tank, threat *Tank) int32 {
+	n := w.navGrid()
+	best, bestDist := int32(-1), float32(0)
+	for _, c := range n.cover {
+		x, y := navCellPos(c)
+		d := absf(x-t.X) + absf(y-t.Y)
+		if best >= 0 && d >= bestDist {
+			continue
+		}
+		if !n.forest[c] {
+			if threat == nil || w.lineOfSight(x+TankSize/2, y+TankSize/2, threat.X+TankSize/2, threat.Y+TankSize/2) {
+				continue
+			}
+		}
+		best, bestDist = c, d
+	}
+	return best
+}
+
+// needsPath 判断坦克是否需要重新规划路径
+func (w *World) needsPath(t *Tank) bool {
+	p := &t.nav
+	switch {
+	case p.wander > 0:
+		return false
+	case p.cells == nil:
+		return true
+	case p.next >= len(p.cells) && p.target != navTargetCover:
+		// 到达了目标。躲进掩体的坦克留在原地，直到路径过期再检查掩体是否仍然有效
+		return true
+	case w.tick-p.planned >= navReplanTicks:
+		return true
+	case p.version != w.navGrid().version:
+		// 墙发生了变化，检查剩下的路径是否仍然可以通行
+		n := w.navGrid()
+		for _, c := range p.cells[p.next:] {
+			if !n.walkable(c) {
+				return true
+			}
+		}
+		p.version = n.version
+	}
+	return false
+}
+
+// planPath 为坦克 t 规划通往目标的路径，返回扩展的节点数
+func (w *World) planPath(t *Tank) int {
+	n := w.navGrid()
+	p := &t.nav
+	p.planned = w.tick
+	p.version = n.version
+	p.cells, p.next = nil, 0
+
+	goal := w.navGoal(t)
+	start := n.nearestWalkable(t.X, t.Y)
+	if goal < 0 || start < 0 {
+		return 1
+	}
+	path, expanded := n.findPath(start, goal, navMaxExpand)
+	p.cells = path
+	return expanded + 1
+}
+
+// planPaths 在本帧的预算内为需要的坦克规划路径。从上一帧停下的位置继续，
+// 保证每辆坦克都能轮到。
+func (w *World) planPaths() {
+	budget := navBudget
+	if w.BossTank != nil && w.needsPath(w.BossTank) {
+		budget -= w.planPath(w.BossTank)
+	}
+	count := len(w.EnemyTanks)
+	for k := 0; k < count && budget > 0; k++ {
+		i := (w.navCursor + k) % count
+		if w.needsPath(&w.EnemyTanks[i]) {
+			budget -= w.planPath(&w.EnemyTanks[i])
+		}
+		w.navCursor = (i + 1) % count
+	}
+}
+
+// navStep 返回坦克 t 沿路径移动一帧后的位置和方向，没有可用的路径时 ok 为 false
+func (w *World) navStep(t *Tank) (x, y float32, dir int, ok bool) {
+	p := &t.nav
+	if p.wander > 0 {
+		p.wander--
+		return t.X, t.Y, t.Direction, false
+	}
+	for p.next < len(p.cells) {
+		cx, cy := navCellPos(p.cells[p.next])
+		if cx == t.X && cy == t.Y {
+			p.next++
+			continue
+		}
+		dx, dy := cx-t.X, cy-t.Y
+		// 两个方向都需要移动时，优先保持当前的方向，否则先走距离较远的方向
+		horizontal := absf(dx) >= absf(dy)
+		if dx != 0 && dy != 0 {
+			switch t.Direction {
+			case 1, 3:
+				horizontal = true
+			case 0, 2:
+				horizontal = false
+			}
+		}
+		step := w.tankSpeed(t)
+		x, y = t.X, t.Y
+		switch {
+		case horizontal && dx > 0:
+			x, dir = t.X+min(step, dx), 1
+		case horizontal:
+			x, dir = t.X-min(step, -dx), 3
+		case dy > 0:
+			y, dir = t.Y+min(step, dy), 2
+		default:
+			y, dir = t.Y-min(step, -dy), 0
+		}
+		return x, y, dir, true
+	}
+	if p.target == navTargetCover && p.cells != nil {
+		// 已经躲进掩体
+		return t.X, t.Y, t.Direction, true
+	}
+	return t.X, t.Y, t.Direction, false
+}
+
+// navBlocked 在沿路径移动的坦克被挡住时调用，挡住太久时放弃路径随机移动一会儿
+func (w *World) navBlocked(t *Tank) {
+	p := &t.nav
+	p.stuck++
+	if p.stuck > navStuckTicks {
+		p.stuck = 0
+		p.cells, p.next = nil, 0
+		p.wander = w.cfg.ChangeDirInterval
+		t.Direction = w.rng.Intn(4)
+	}
+}
+
+func abs[T int | int32](v T) T {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func absf(v float32) float32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
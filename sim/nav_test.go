@@ -0,0 +1,99 @@
+package sim
+
+import "testing"
+
+func TestFindPath(t *testing.T) {
+	// box 围住格子 (40, 20) 的一圈墙，里面的格子从外面到不了
+	box := []Wall{
+		{X: 360, Y: 180, Width: 100, Height: 10},
+		{X: 360, Y: 270, Width: 100, Height: 10},
+		{X: 360, Y: 180, Width: 10, Height: 100},
+		{X: 450, Y: 180, Width: 10, Height: 100},
+	}
+	tests := []struct {
+		name      string
+		walls     []Wall
+		start     int32
+		goal      int32
+		maxExpand int
+		end       int32 // 路径的终点
+		length    int   // 路径的格子数，0 表示不检查
+		expanded  int   // 扩展的节点数，0 表示不检查
+	}{
+		{
+			name:  "straight",
+			start: navCell(5, 15), goal: navCell(25, 15), maxExpand: navMaxExpand,
+			end: navCell(25, 15), length: 21, expanded: 21,
+		},
+		{
+			// 挡住第 14、15 列和第 5 到 24 行，从下面绕过去最近
+			name:  "around a wall",
+			walls: []Wall{{X: 150, Y: 70, Width: 10, Height: 200}},
+			start: navCell(5, 15), goal: navCell(25, 15), maxExpand: navMaxExpand,
+			end: navCell(25, 15), length: 41,
+		},
+		{
+			name:  "unreachable",
+			walls: box,
+			start: navCell(5, 20), goal: navCell(40, 20), maxExpand: navMaxExpand,
+			end: navCell(34, 20), expanded: navMaxExpand,
+		},
+		{
+			// 预算用完时走向已经找到的离目标最近的格子
+			name:  "budget exhausted",
+			start: navCell(5, 20), goal: navCell(40, 20), maxExpand: 10,
+			end: navCell(14, 20), length: 10, expanded: 10,
+		},
+		{
+			// 挡住第 29 到 33 列和第 18 到 21 行，离目标最近的可通行格子在下方
+			name:  "goal blocked",
+			walls: []Wall{{X: 300, Y: 200, Width: 40, Height: 40}},
+			start: navCell(5, 20), goal: navCell(31, 20), maxExpand: navMaxExpand,
+			end: navCell(31, 22), expanded: navMaxExpand,
+		},
+		{
+			// 挡住第 2 到 7 列和第 18 到 21 行，起点在墙的边上，可以直接走出来
+			name:  "start blocked",
+			walls: []Wall{{X: 40, Y: 200, Width: 40, Height: 40}},
+			start: navCell(7, 20), goal: navCell(30, 20), maxExpand: navMaxExpand,
+			end: navCell(30, 20), length: 24, expanded: 24,
+		},
+		{
+			// 起点的四周都被挡住，不会穿过墙
+			name:  "start walled in",
+			walls: []Wall{{X: 40, Y: 200, Width: 40, Height: 40}},
+			start: navCell(5, 20), goal: navCell(30, 20), maxExpand: navMaxExpand,
+			end: navCell(5, 20), length: 1, expanded: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewLevelWorld(1, DefaultConfig(), &Level{TileSize: DefaultTileSize, Walls: tt.walls})
+			n := w.navGrid()
+			path, expanded := n.findPath(tt.start, tt.goal, tt.maxExpand)
+
+			if len(path) == 0 || path[0] != tt.start {
+				t.Fatalf("path %v does not begin at %d", path, tt.start)
+			}
+			if end := path[len(path)-1]; end != tt.end {
+				t.Errorf("path ends at (%d, %d), want (%d, %d)", end%navCols, end/navCols, tt.end%navCols, tt.end/navCols)
+			}
+			if tt.length != 0 && len(path) != tt.length {
+				t.Errorf("path has %d cells, want %d", len(path), tt.length)
+			}
+			if tt.expanded != 0 && expanded != tt.expanded {
+				t.Errorf("expanded %d nodes, want %d", expanded, tt.expanded)
+			}
+			// 路径上相邻的格子上下左右相连，除了起点都可以通行
+			for i := 1; i < len(path); i++ {
+				a, b := path[i-1], path[i]
+				if abs(a%navCols-b%navCols)+abs(a/navCols-b/navCols) != 1 {
+					t.Fatalf("cells %d and %d of the path are not adjacent", i-1, i)
+				}
+				if !n.walkable(b) {
+					t.Fatalf("cell %d of the path is blocked", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package sim
+
+const (
+	// MaxPerkLevel 每项永久强化的最高等级
+	MaxPerkLevel = 3
+	// PerkSpeedStep 引擎强化每级增加的移动速度
+	PerkSpeedStep = 0.25
+	// PerkShieldTicks 护盾强化每级在开局时提供的护盾帧数
+	PerkShieldTicks = 3 * TicksPerSecond
+)
+
+// Perks 表示玩家在车库中购买的永久强化，各项为已购买的等级，
+// 对本局的所有玩家生效
+type Perks struct {
+	Armor  int `json:"armor,omitempty"`  // 装甲：每级增加一点生命值
+	Engine int `json:"engine,omitempty"` // 引擎：每级增加 PerkSpeedStep 的移动速度
+	Shield int `json:"shield,omitempty"` // 护盾：每级在每关开局时获得 PerkShieldTicks 帧护盾
+}
+
+// playerHP 返回玩家坦克满血时的生命值
+func (w *World) playerHP() int {
+	return w.cfg.PlayerTankHP + w.cfg.Perks.Armor
+}
+
+// playerSpeed 返回玩家坦克每帧移动的距离
+func (w *World) playerSpeed() float32 {
+	return w.cfg.TankSpeed + float32(w.cfg.Perks.Engine)*PerkSpeedStep
+}
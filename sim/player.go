@@ -0,0 +1,168 @@
+package sim
+
+const (
+	// 玩家坦克被消灭后等待重生的帧数
+	RespawnTicks = 2 * TicksPerSecond
+	// 重生后护盾持续的帧数
+	RespawnShieldTicks = 3 * TicksPerSecond
+)
+
+// Player 表示一名玩家，各自拥有坦克、生命数、得分和重生状态
+type Player struct {
+	Tank         *Tank // 等待重生或已被彻底消灭时为 nil
+	Spawn        Point // 出生和重生的位置
+	Lives        int   // 剩余的备用生命数
+	RespawnTicks int   // 距离重生剩余的帧数
+	Score        int
+	Combo        int // 当前的连击数
+
+	comboTicks int
+}
+
+// Out 判断玩家是否已经用完全部生命，不会再重生
+func (p *Player) Out() bool {
+	return p.Tank == nil && p.RespawnTicks == 0
+}
+
+// newPlayerTank 在玩家的出生点创建一辆满血的零星坦克
+func (w *World) newPlayerTank(p *Player) *Tank {
+	return &Tank{
+		X:         p.Spawn.X,
+		Y:         p.Spawn.Y,
+		Direction: 0,
+		Health:    w.playerHP(),
+	}
+}
+
+// playerSpawn 返回第 i 号玩家的出生点，关卡没有为其指定出生点时在 1 号玩家附近寻找空地
+func (w *World) playerSpawn(i int) Point {
+	spawns := w.level.PlayerSpawns
+	if len(spawns) == 0 {
+		return Point{X: ScreenWidth / 2, Y: ScreenHeight/2 + StatusBarHeight}
+	}
+	if i < len(spawns) {
+		return spawns[i]
+	}
+
+	base := spawns[0]
+	for r := float32(1); r <= 5; r++ {
+		for _, d := range [...]Point{{X: -1}, {X: 1}, {Y: 1}, {Y: -1}} {
+			x, y := base.X+d.X*r*TankSize, base.Y+d.Y*r*TankSize
+			if x < 0 || x > ScreenWidth-TankSize || y < StatusBarHeight || y > ScreenHeight-TankSize {
+				continue
+			}
+			if !w.tankBlockedByWall(x, y) && !w.isSpawnTaken(x, y) {
+				return Point{X: x, Y: y}
+			}
+		}
+	}
+	return base
+}
+
+// isSpawnTaken 判断位置是否已经是其他玩家的出生点
+func (w *World) isSpawnTaken(x, y float32) bool {
+	for _, p := range w.Players {
+		if checkCollision(x, y, TankSize, TankSize, p.Spawn.X, p.Spawn.Y, TankSize, TankSize) {
+			return true
+		}
+	}
+	return false
+}
+
+// updatePlayers 使用各自的输入更新所有玩家，等待重生的玩家倒计时结束后回到出生点
+func (w *World) updatePlayers(inputs []Input) {
+	for i, p := range w.Players {
+		if p.Tank == nil {
+			w.updateRespawn(p)
+			continue
+		}
+		var input Input
+		if i < len(inputs) {
+			input = inputs[i]
+		}
+		w.updatePlayerTank(i, input)
+	}
+}
+
+// updateRespawn 推进玩家的重生倒计时，出生点被敌方坦克占据时推迟重生
+func (w *World) updateRespawn(p *Player) {
+	if p.RespawnTicks == 0 {
+		return
+	}
+	if p.RespawnTicks > 1 {
+		p.RespawnTicks--
+		return
+	}
+	if w.enemyTankAt(p.Spawn.X, p.Spawn.Y) {
+		return
+	}
+	p.RespawnTicks = 0
+	p.Tank = w.newPlayerTank(p)
+	p.Tank.Shield = RespawnShieldTicks
+}
+
+// enemyTankAt 判断坦克放在 (x, y) 时是否会与Boss坦克或敌方坦克重叠
+func (w *World) enemyTankAt(x, y float32) bool {
+	if w.BossTank != nil {
+		if checkCollision(x, y, TankSize, TankSize, w.BossTank.X, w.BossTank.Y, TankSize, TankSize) {
+			return true
+		}
+	}
+	for _, enemyTank := range w.EnemyTanks {
+		if checkCollision(x, y, TankSize, TankSize, enemyTank.X, enemyTank.Y, TankSize, TankSize) {
+			return true
+		}
+	}
+	return false
+}
+
+// playerTankAt 判断坦克放在 (x, y) 时是否会与某名玩家的坦克重叠
+func (w *World) playerTankAt(x, y float32) bool {
+	return w.playerAt(x, y) != nil
+}
+
+// playerAt 返回坦克放在 (x, y) 时会与其坦克重叠的玩家，没有时返回 nil
+func (w *World) playerAt(x, y float32) *Player {
+	for _, p := range w.Players {
+		if p.Tank != nil && w.tankOverlaps(p.Tank, x, y, TankSize, TankSize) {
+			return p
+		}
+	}
+	return nil
+}
+
+// damagePlayer 玩家坦克受到 damage 点伤害，有护盾时不受伤害，被消灭后还有备用生命时开始重生倒计时
+func (w *World) damagePlayer(p *Player, damage int) {
+	if p.Tank.Shield > 0 {
+		return
+	}
+	p.Tank.Health -= damage
+	if p.Tank.Health <= 0 {
+		// 移除玩家坦克
+		p.Tank = nil
+		w.adapt.deaths++
+		if p.Lives > 0 {
+			p.Lives--
+			p.RespawnTicks = RespawnTicks
+		}
+	}
+}
+
+// AllPlayersOut 判断是否所有玩家都已用完全部生命
+func (w *World) AllPlayersOut() bool {
+	for _, p := range w.Players {
+		if !p.Out() {
+			return false
+		}
+	}
+	return true
+}
+
+// TotalScore 返回所有玩家的得分之和
+func (w *World) TotalScore() int {
+	total := 0
+	for _, p := range w.Players {
+		total += p.Score
+	}
+	return total
+}
@@ -0,0 +1,146 @@
+package sim
+
+// PowerUpKind 表示道具的种类
+type PowerUpKind int
+
+const (
+	// PowerUpShield 护盾，一段时间内不受伤害
+	PowerUpShield PowerUpKind = iota
+	// PowerUpRapidFire 连发，一段时间内按住射击键可以连续射击
+	PowerUpRapidFire
+	// PowerUpBulletSpeed 加速弹，一段时间内子弹速度加倍
+	PowerUpBulletSpeed
+	// PowerUpExtraLife 增加一条命
+	PowerUpExtraLife
+	// PowerUpBomb 炸弹，消灭场上所有敌方坦克
+	PowerUpBomb
+	// PowerUpFreeze 冻结，一段时间内敌方坦克无法移动和射击
+	PowerUpFreeze
+	// PowerUpFortify 加固，所有砖墙的坚固值翻倍
+	PowerUpFortify
+	// PowerUpStar 星，坦克升一级，见 MaxStars
+	PowerUpStar
+
+	powerUpKindCount
+)
+
+const (
+	// 道具的边长
+	PowerUpSize = 16
+	// 道具在场上存在的帧数
+	PowerUpLifetime = 10 * TicksPerSecond
+	// 道具消失前开始闪烁的帧数
+	PowerUpBlinkTicks = 3 * TicksPerSecond
+	// 消灭敌方坦克时掉落道具的概率为 1/PowerUpDropChance
+	PowerUpDropChance = 4
+	// 护盾持续的帧数
+	ShieldTicks = 10 * TicksPerSecond
+	// 连发持续的帧数
+	RapidFireTicks = 15 * TicksPerSecond
+	// 连发时两次射击之间的间隔帧数
+	RapidFireInterval = 8
+	// 加速弹持续的帧数
+	BulletSpeedTicks = 15 * TicksPerSecond
+	// 冻结持续的帧数
+	FreezeTicks = 8 * TicksPerSecond
+)
+
+// PowerUp 表示场上的道具
+type PowerUp struct {
+	X, Y float32
+	Kind PowerUpKind
+	Life int // 剩余存在的帧数
+}
+
+// Blinking 判断道具是否即将消失，需要闪烁显示
+func (p *PowerUp) Blinking() bool {
+	return p.Life < PowerUpBlinkTicks
+}
+
+// dropPowerUp 有一定概率在被消灭的坦克处掉落道具
+func (w *World) dropPowerUp(t *Tank) {
+	if w.rng.Intn(PowerUpDropChance) != 0 {
+		return
+	}
+	w.PowerUps = append(w.PowerUps, PowerUp{
+		X:    t.X + (TankSize-PowerUpSize)/2,
+		Y:    t.Y + (TankSize-PowerUpSize)/2,
+		Kind: PowerUpKind(w.rng.Intn(int(powerUpKindCount))),
+		Life: PowerUpLifetime,
+	})
+}
+
+// updatePowerUps 更新道具的剩余时间，并处理玩家拾取道具
+func (w *World) updatePowerUps() {
+	for i := 0; i < len(w.PowerUps); i++ {
+		w.PowerUps[i].Life--
+
+		picked := false
+		for _, player := range w.Players {
+			if player.Tank == nil {
+				continue
+			}
+			p := &w.PowerUps[i]
+			if w.tankOverlaps(player.Tank, p.X, p.Y, PowerUpSize, PowerUpSize) {
+				w.applyPowerUp(player, p.Kind)
+				picked = true
+				break
+			}
+		}
+
+		// 移除被拾取或过期的道具
+		if picked || w.PowerUps[i].Life <= 0 {
+			w.PowerUps = append(w.PowerUps[:i], w.PowerUps[i+1:]...)
+			i--
+		}
+	}
+
+	if w.FreezeTicks > 0 {
+		w.FreezeTicks--
+	}
+}
+
+// applyPowerUp 使道具对拾取它的玩家生效
+func (w *World) applyPowerUp(p *Player, kind PowerUpKind) {
+	t := p.Tank
+	switch kind {
+	case PowerUpShield:
+		t.Shield = ShieldTicks
+	case PowerUpRapidFire:
+		t.RapidFire = RapidFireTicks
+	case PowerUpBulletSpeed:
+		t.FastBullet = BulletSpeedTicks
+	case PowerUpExtraLife:
+		p.Lives++
+	case PowerUpBomb:
+		// 消灭场上所有敌方坦克，藏在树林里的也不例外
+		w.EnemyTanks = w.EnemyTanks[:0]
+	case PowerUpFreeze:
+		w.FreezeTicks = FreezeTicks
+	case PowerUpFortify:
+		// 加固到墙出现时坚固值的两倍，已经更坚固的墙不受影响
+		for i := range w.Walls {
+			if wall := &w.Walls[i]; wall.Kind == TerrainBrick {
+				wall.Health = max(wall.Health, 2*wall.BaseHealth)
+			}
+		}
+	case PowerUpStar:
+		t.Stars = min(t.Stars+1, MaxStars)
+	}
+}
+
+// updateEffects 减少坦克身上各种道具效果的剩余时间
+func (t *Tank) updateEffects() {
+	if t.Shield > 0 {
+		t.Shield--
+	}
+	if t.RapidFire > 0 {
+		t.RapidFire--
+	}
+	if t.FastBullet > 0 {
+		t.FastBullet--
+	}
+	if t.fireCooldown > 0 {
+		t.fireCooldown--
+	}
+}
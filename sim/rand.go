@@ -0,0 +1,29 @@
+package sim
+
+// random 模拟使用的伪随机数生成器（splitmix64）。
+// 它的全部状态只有一个整数，保存和恢复快照时可以直接复制。
+type random struct {
+	state uint64
+}
+
+// newRandom 使用给定的种子创建随机数生成器
+func newRandom(seed int64) random {
+	return random{state: uint64(seed)}
+}
+
+// next 返回下一个 64 位随机数
+func (r *random) next() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+// Intn 返回 [0, n) 范围内的随机整数，n 必须大于 0
+func (r *random) Intn(n int) int {
+	if n <= 0 {
+		panic("sim: invalid argument to Intn")
+	}
+	return int(r.next() % uint64(n))
+}
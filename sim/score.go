@@ -0,0 +1,48 @@
+package sim
+
+const (
+	// 消灭一辆敌方坦克的得分
+	EnemyKillScore = 100
+	// 击中Boss坦克一次的得分
+	BossHitScore = 10
+	// 摧毁一面墙的得分
+	WallDestroyScore = 10
+	// 过关的得分
+	StageClearScore = 1000
+	// 连击的时间窗口，在该帧数内再次消灭敌人时连击数加一
+	ComboWindow = 2 * TicksPerSecond
+	// 连击倍率的上限
+	MaxComboMultiplier = 5
+)
+
+// ComboMultiplier 返回玩家当前的连击倍率
+func (p *Player) ComboMultiplier() int {
+	if p.Combo < 1 {
+		return 1
+	}
+	if p.Combo > MaxComboMultiplier {
+		return MaxComboMultiplier
+	}
+	return p.Combo
+}
+
+// addKillScore 消灭敌人时累计连击并按连击倍率加分
+func (p *Player) addKillScore(points int) {
+	if p.comboTicks > 0 {
+		p.Combo++
+	} else {
+		p.Combo = 1
+	}
+	p.comboTicks = ComboWindow
+	p.Score += points * p.ComboMultiplier()
+}
+
+// updateCombo 连击时间窗口结束后清空连击数
+func (p *Player) updateCombo() {
+	if p.comboTicks > 0 {
+		p.comboTicks--
+		if p.comboTicks == 0 {
+			p.Combo = 0
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package sim
+
+// Snapshot 保存某一帧的完整世界状态，用于回滚同步时恢复到过去的一帧重新模拟。
+//
+// 同一个 Snapshot 可以反复用于 Save，各切片的底层数组会被重复使用，
+// 因此每帧保存一次快照也不会产生多少内存分配。关卡和生成规则在模拟中
+// 不会被修改，坦克的路径在规划后也不再修改，快照与世界共用它们。
+type Snapshot struct {
+	players       []Player
+	playerTanks   []Tank // 与 players 一一对应
+	hasTank       []bool // 玩家在保存时是否有坦克
+	bossTank      Tank
+	hasBoss       bool
+	enemyTanks    []Tank
+	bullets       []Bullet
+	effects       []Effect
+	walls         []Wall
+	powerUps      []PowerUp
+	freezeTicks   int
+	result        Result
+	baseDestroyed bool
+
+	rng         random
+	tick        int
+	spawns      []spawnEntry
+	followTicks int
+	bossPhase   int
+	bossAttack  int
+	bossCharge  int
+	navVersion  int
+	navCursor   int
+	adapt       adaptState
+	changes     []AdaptChange
+}
+
+// Tick 返回快照保存时世界已经模拟的帧数
+func (s *Snapshot) Tick() int {
+	return s.tick
+}
+
+// Save 将当前的世界状态保存到 s 中
+func (w *World) Save(s *Snapshot) {
+	s.players = s.players[:0]
+	s.playerTanks = s.playerTanks[:0]
+	s.hasTank = s.hasTank[:0]
+	for _, p := range w.Players {
+		player := *p
+		var tank Tank
+		if p.Tank != nil {
+			tank = *p.Tank
+		}
+		player.Tank = nil
+		s.players = append(s.players, player)
+		s.playerTanks = append(s.playerTanks, tank)
+		s.hasTank = append(s.hasTank, p.Tank != nil)
+	}
+
+	s.hasBoss = w.BossTank != nil
+	if s.hasBoss {
+		s.bossTank = *w.BossTank
+	}
+	s.enemyTanks = append(s.enemyTanks[:0], w.EnemyTanks...)
+	s.bullets = append(s.bullets[:0], w.Bullets...)
+	s.effects = append(s.effects[:0], w.Effects...)
+	s.walls = append(s.walls[:0], w.Walls...)
+	s.powerUps = append(s.powerUps[:0], w.PowerUps...)
+	s.freezeTicks = w.FreezeTicks
+	s.result = w.Result
+	s.baseDestroyed = w.BaseDestroyed
+
+	s.rng = w.rng
+	s.tick = w.tick
+	s.spawns = append(s.spawns[:0], w.spawner.entries...)
+	s.followTicks = w.followTicks
+	s.bossPhase = w.bossPhase
+	s.bossAttack = w.bossAttackTicks
+	s.bossCharge = w.bossCharge
+	s.navVersion = w.navVersion
+	s.navCursor = w.navCursor
+	s.adapt = w.adapt
+	s.adapt.changes = nil
+	s.changes = append(s.changes[:0], w.adapt.changes...)
+}
+
+// Restore 将世界恢复到 s 保存时的状态，s 必须是由同一个世界保存的快照
+func (w *World) Restore(s *Snapshot) {
+	for i, p := range w.Players {
+		tank := p.Tank
+		*p = s.players[i]
+		if s.hasTank[i] {
+			if tank == nil {
+				tank = &Tank{}
+			}
+			*tank = s.playerTanks[i]
+			p.Tank = tank
+		}
+	}
+
+	if s.hasBoss {
+		if w.BossTank == nil {
+			w.BossTank = &Tank{}
+		}
+		*w.BossTank = s.bossTank
+	} else {
+		w.BossTank = nil
+	}
+	w.EnemyTanks = append(w.EnemyTanks[:0], s.enemyTanks...)
+	w.Bullets = append(w.Bullets[:0], s.bullets...)
+	w.Effects = append(w.Effects[:0], s.effects...)
+	w.Walls = append(w.Walls[:0], s.walls...)
+	w.PowerUps = append(w.PowerUps[:0], s.powerUps...)
+	w.FreezeTicks = s.freezeTicks
+	w.Result = s.result
+	w.BaseDestroyed = s.baseDestroyed
+
+	w.rng = s.rng
+	w.tick = s.tick
+	w.spawner.entries = append(w.spawner.entries[:0], s.spawns...)
+	w.followTicks = s.followTicks
+	w.bossPhase = s.bossPhase
+	w.bossAttackTicks = s.bossAttack
+	w.bossCharge = s.bossCharge
+	// 坦克的路径随坦克一起恢复，导航网格按恢复后的墙重新生成
+	w.navVersion = s.navVersion
+	w.navCursor = s.navCursor
+	w.navDirty = true
+	changes := append(w.adapt.changes[:0], s.changes...)
+	w.adapt = s.adapt
+	w.adapt.changes = changes
+}
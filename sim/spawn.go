@@ -0,0 +1,229 @@
+package sim
+
+// SpawnKind 表示可以被定时生成的对象种类
+type SpawnKind int
+
+const (
+	// SpawnEnemyTank 敌方坦克
+	SpawnEnemyTank SpawnKind = iota
+	// SpawnWall 墙
+	SpawnWall
+)
+
+// spawnAttempts 每次生成时寻找空闲位置的最大尝试次数
+const spawnAttempts = 10
+
+// spawnRetryDelay 找不到空闲位置时的重试间隔，单位为帧
+const spawnRetryDelay = TicksPerSecond
+
+// Point 表示地图上的一个坐标
+type Point struct {
+	X, Y float32
+}
+
+// SpawnRule 描述一类对象的生成规则
+type SpawnRule struct {
+	Kind     SpawnKind
+	MinDelay int           // 两次生成之间的最短间隔，单位为帧
+	MaxDelay int           // 两次生成之间的最长间隔，单位为帧
+	MaxAlive int           // 同时存在的最大数量
+	Total    int           // 本关总共可生成的数量，0 表示不限
+	Points   []Point       // 候选生成点，为空时在地图上随机选择
+	Brain    string        // 敌方坦克使用的行为树，为空时使用种类的行为树
+	Types    []EnemyWeight // 敌方坦克的种类及权重，为空时使用 EnemyBasic
+}
+
+// SpawnTable 表示一个关卡的生成表
+type SpawnTable []SpawnRule
+
+// DefaultSpawnTable 根据游戏参数返回默认的生成表
+func DefaultSpawnTable(cfg Config) SpawnTable {
+	return SpawnTable{
+		{
+			Kind:     SpawnEnemyTank,
+			MinDelay: 5 * TicksPerSecond,
+			MaxDelay: (5 + cfg.EnemyTankCheckInterval) * TicksPerSecond,
+			MaxAlive: cfg.MaxEnemyTankCount,
+			Types: []EnemyWeight{
+				{Type: EnemyBasic, Weight: 6},
+				{Type: "scout", Weight: 2},
+				{Type: "heavy", Weight: 1},
+				{Type: "artillery", Weight: 1},
+				{Type: "kamikaze", Weight: 1},
+			},
+		},
+		{
+			Kind:     SpawnWall,
+			MinDelay: 10 * TicksPerSecond,
+			MaxDelay: (10 + cfg.WallCheckInterval) * TicksPerSecond,
+			MaxAlive: cfg.MaxWallCount,
+		},
+	}
+}
+
+// spawnEntry 记录一条生成规则的运行状态
+type spawnEntry struct {
+	rule    SpawnRule
+	next    int // 下一次尝试生成的帧号
+	spawned int // 已经生成的数量
+}
+
+// spawnScheduler 在模拟帧内按生成表调度对象的生成
+type spawnScheduler struct {
+	entries []spawnEntry
+}
+
+// newSpawnScheduler 根据生成表创建调度器
+func newSpawnScheduler(w *World, table SpawnTable) *spawnScheduler {
+	s := &spawnScheduler{}
+	for _, rule := range table {
+		entry := spawnEntry{rule: rule}
+		entry.next = w.tick + w.spawnDelay(rule)
+		s.entries = append(s.entries, entry)
+	}
+	return s
+}
+
+// update 检查每条规则是否到了生成时间，并尝试生成对象
+func (s *spawnScheduler) update(w *World) {
+	for i := range s.entries {
+		entry := &s.entries[i]
+		if w.tick < entry.next {
+			continue
+		}
+		if entry.rule.Total > 0 && entry.spawned >= entry.rule.Total {
+			continue
+		}
+		if w.countAlive(entry.rule.Kind) >= entry.rule.MaxAlive {
+			entry.next = w.tick + w.spawnDelay(entry.rule)
+			continue
+		}
+		if !w.trySpawn(entry.rule) {
+			// 所有候选位置都被占用，稍后再试
+			entry.next = w.tick + spawnRetryDelay
+			continue
+		}
+		entry.spawned++
+		entry.next = w.tick + w.spawnDelay(entry.rule)
+	}
+}
+
+// remaining 返回指定种类还可以生成的数量，不限数量时返回 -1
+func (s *spawnScheduler) remaining(kind SpawnKind) int {
+	total := 0
+	for _, entry := range s.entries {
+		if entry.rule.Kind != kind {
+			continue
+		}
+		if entry.rule.Total <= 0 {
+			return -1
+		}
+		total += entry.rule.Total - entry.spawned
+	}
+	return total
+}
+
+// enemyEntry 返回还有出动名额的第一条敌方坦克生成规则，没有时返回 nil
+func (s *spawnScheduler) enemyEntry() *spawnEntry {
+	for i := range s.entries {
+		entry := &s.entries[i]
+		if entry.rule.Kind == SpawnEnemyTank && (entry.rule.Total <= 0 || entry.spawned < entry.rule.Total) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// SetSpawnTable 替换当前关卡的生成表
+func (w *World) SetSpawnTable(table SpawnTable) {
+	w.spawner = newSpawnScheduler(w, table)
+}
+
+// EnemyReserve 返回敌方还可以出动的坦克数，不限数量时返回 -1
+func (w *World) EnemyReserve() int {
+	return w.spawner.remaining(SpawnEnemyTank)
+}
+
+// spawnDelay 在规则的间隔范围内随机选择下一次生成的延迟，
+// 敌方坦克的生成间隔随动态难度缩放
+func (w *World) spawnDelay(rule SpawnRule) int {
+	delay := rule.MinDelay
+	if rule.MaxDelay > rule.MinDelay {
+		delay += w.rng.Intn(rule.MaxDelay - rule.MinDelay)
+	}
+	if rule.Kind == SpawnEnemyTank {
+		delay = w.adaptInterval(delay)
+	}
+	return delay
+}
+
+// countAlive 返回指定种类当前存在的数量
+func (w *World) countAlive(kind SpawnKind) int {
+	switch kind {
+	case SpawnEnemyTank:
+		return len(w.EnemyTanks)
+	case SpawnWall:
+		n := 0
+		for _, wall := range w.Walls {
+			if wall.Spawned {
+				n++
+			}
+		}
+		return n
+	}
+	return 0
+}
+
+// trySpawn 按规则寻找空闲位置并生成一个对象，成功时返回 true
+func (w *World) trySpawn(rule SpawnRule) bool {
+	for attempt := 0; attempt < spawnAttempts; attempt++ {
+		switch rule.Kind {
+		case SpawnEnemyTank:
+			tank := w.newEnemyTank(rule.Points, rule)
+			if w.isAreaOccupied(tank.X, tank.Y, TankSize, TankSize) {
+				continue
+			}
+			w.EnemyTanks = append(w.EnemyTanks, tank)
+			return true
+		case SpawnWall:
+			wall := w.newRandomWall(rule.Points)
+			if w.isAreaOccupied(wall.X, wall.Y, wall.Width, wall.Height) {
+				continue
+			}
+			w.Walls = append(w.Walls, wall)
+			w.wallsChanged()
+			return true
+		}
+	}
+	return false
+}
+
+// pickSpawnPoint 从候选生成点中随机选择一个
+func (w *World) pickSpawnPoint(points []Point) Point {
+	return points[w.rng.Intn(len(points))]
+}
+
+// isAreaOccupied 判断矩形区域是否与坦克或墙重叠
+func (w *World) isAreaOccupied(x, y, width, height float32) bool {
+	for _, p := range w.Players {
+		if p.Tank != nil && checkCollision(x, y, width, height, p.Tank.X, p.Tank.Y, TankSize, TankSize) {
+			return true
+		}
+	}
+	if w.BossTank != nil {
+		if checkCollision(x, y, width, height, w.BossTank.X, w.BossTank.Y, TankSize, TankSize) {
+			return true
+		}
+	}
+	for _, enemyTank := range w.EnemyTanks {
+		if checkCollision(x, y, width, height, enemyTank.X, enemyTank.Y, TankSize, TankSize) {
+			return true
+		}
+	}
+	for _, wall := range w.Walls {
+		if checkCollision(x, y, width, height, wall.X, wall.Y, wall.Width, wall.Height) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package sim
+
+const (
+	// MaxStars 玩家坦克的最高星级
+	MaxStars = 3
+	// PlayerFireInterval 零星坦克两次射击之间的最短间隔帧数
+	PlayerFireInterval = 20
+)
+
+// starTier 描述玩家坦克在某个星级下的射击能力
+type starTier struct {
+	cooldown int     // 两次射击之间的最短间隔帧数
+	bullets  int     // 场上同时存在的子弹数上限
+	speed    float32 // 子弹速度的倍数
+	pierce   int     // 每颗子弹可以打穿的砖墙数
+}
+
+// starTiers 各星级的射击能力，拾取星道具升一级，坦克被消灭后回到零星。
+// 三星坦克的子弹还可以打坏钢墙，见 SteelBreakStars。
+var starTiers = [MaxStars + 1]starTier{
+	{cooldown: PlayerFireInterval, bullets: 1, speed: 1},
+	{cooldown: PlayerFireInterval / 2, bullets: 1, speed: 1.5},
+	{cooldown: PlayerFireInterval / 2, bullets: 2, speed: 1.5},
+	{cooldown: PlayerFireInterval / 2, bullets: 2, speed: 1.5, pierce: 1},
+}
+
+// tier 返回坦克当前星级的射击能力
+func (t *Tank) tier() starTier {
+	return starTiers[min(max(t.Stars, 0), MaxStars)]
+}
+
+// playerBulletCount 返回第 i 号玩家在场上的子弹数
+func (w *World) playerBulletCount(i int) int {
+	n := 0
+	for _, b := range w.Bullets {
+		if b.Team == TeamPlayer && b.Owner == i {
+			n++
+		}
+	}
+	return n
+}
+
+// canFire 判断第 i 号玩家的坦克现在能否射击。连发状态下不限制场上的子弹数。
+func (w *World) canFire(i int, tank *Tank) bool {
+	if tank.fireCooldown > 0 {
+		return false
+	}
+	return tank.RapidFire > 0 || w.playerBulletCount(i) < tank.tier().bullets
+}
@@ -0,0 +1,367 @@
+package sim
+
+// Tank 表示坦克
+type Tank struct {
+	X, Y      float32
+	Direction int   // 0: 上, 1: 右, 2: 下, 3: 左
+	Angle     Angle // 八方向和自由模式下玩家坦克车身的朝向
+	Turret    Angle // 八方向和自由模式下玩家坦克炮塔的朝向
+	Health    int
+	Stars     int // 升级星级
+
+	// 道具效果的剩余帧数
+	Shield     int
+	RapidFire  int
+	FastBullet int
+
+	// 敌方坦克的外观，为 0 时使用标准的大小和颜色
+	Size  float32
+	Color uint32
+
+	kind         *EnemyType // 敌方坦克的种类，与世界共用，玩家和 Boss 为 nil
+	vx, vy       float32    // 上一帧的位移，AI 据此预判玩家的移动
+	slide        int        // 在冰面上剩余的滑行帧数
+	fireCooldown int        // 距离下一次射击的帧数
+	nav          navPath
+	ai           aiMemory // 玩家坦克没有 AI
+}
+
+// Body 返回坦克车身所占的正方形。坦克的移动和寻路都按 TankSize 计算，
+// 较小的车身居中，只影响外观和被子弹击中的范围。
+func (t *Tank) Body() (x, y, size float32) {
+	if t.Size <= 0 || t.Size >= TankSize {
+		return t.X, t.Y, TankSize
+	}
+	inset := (TankSize - t.Size) / 2
+	return t.X + inset, t.Y + inset, t.Size
+}
+
+// newEnemyTank 在候选生成点或随机位置按生成规则 rule 创建一辆敌方坦克，
+// 种类按规则的权重选择，规则没有指定行为树时使用种类的行为树
+func (w *World) newEnemyTank(points []Point, rule SpawnRule) Tank {
+	kind := w.pickEnemyType(rule.Types)
+	tank := Tank{
+		Direction: w.rng.Intn(4),
+		Health:    max(1, kind.HP*w.cfg.EnemyTankHP/EnemyTankHP),
+		Size:      kind.Size,
+		Color:     kind.Color,
+		kind:      kind,
+	}
+	brain := rule.Brain
+	if brain == "" {
+		brain = kind.Brain
+	}
+	fallback := BrainHunter
+	// 没有指定行为树时，有基地的关卡中三分之一的敌人直奔基地，其余的追击玩家
+	if brain == "" && w.baseWall(0, 0) != nil && w.rng.Intn(3) == 0 {
+		fallback = BrainRaider
+	}
+	if len(points) > 0 {
+		p := w.pickSpawnPoint(points)
+		tank.X, tank.Y = p.X, p.Y
+	} else {
+		tank.X = float32(w.rng.Intn(ScreenWidth - TankSize))
+		tank.Y = float32(StatusBarHeight + w.rng.Intn(ScreenHeight-StatusBarHeight-TankSize))
+	}
+	w.newAI(&tank, w.brain(brain, fallback))
+	return tank
+}
+
+// updatePlayerTank 根据玩家本帧的输入移动坦克和射击，玩家之间不会相互阻挡
+func (w *World) updatePlayerTank(i int, input Input) {
+	tank := w.Players[i].Tank
+	if w.cfg.Movement == MoveClassic {
+		w.moveClassic(tank, input)
+	} else {
+		w.moveFree(tank, input)
+	}
+
+	// 处理射击，连发状态下按住射击键也可以持续射击，射速和子弹随星级提升
+	fire := input.Has(InputFire) || (tank.RapidFire > 0 && input.Has(InputFireHeld))
+	if fire && w.canFire(i, tank) {
+		tier := tank.tier()
+		speed := tier.speed
+		if tank.FastBullet > 0 {
+			speed *= 2
+		}
+		dx, dy := w.barrelVector(tank)
+		bullet := w.newBullet(BulletNormal, TeamPlayer, tank.X+TankSize/2, tank.Y+TankSize/2, dx, dy)
+		bullet.VX *= speed
+		bullet.VY *= speed
+		bullet.Owner = i
+		bullet.Pierce = tier.pierce
+		bullet.BreakSteel = tank.Stars >= SteelBreakStars
+		w.fire(bullet)
+		tank.fireCooldown = tier.cooldown
+		if tank.RapidFire > 0 {
+			tank.fireCooldown = min(tank.fireCooldown, RapidFireInterval)
+		}
+	}
+
+	tank.updateEffects()
+}
+
+// moveClassic 经典模式下根据方向键朝上下左右移动坦克
+func (w *World) moveClassic(tank *Tank, input Input) {
+	var newX, newY = tank.X, tank.Y
+
+	moving := true
+	if input.Has(InputUp) {
+		tank.Direction = 0
+	} else if input.Has(InputRight) {
+		tank.Direction = 1
+	} else if input.Has(InputDown) {
+		tank.Direction = 2
+	} else if input.Has(InputLeft) {
+		tank.Direction = 3
+	} else if tank.slide > 0 {
+		// 松开方向键后在冰面上继续滑行
+		tank.slide--
+	} else {
+		moving = false
+	}
+
+	if moving {
+		speed := w.playerSpeed()
+		switch tank.Direction {
+		case 0:
+			newY -= speed
+		case 1:
+			newX += speed
+		case 2:
+			newY += speed
+		case 3:
+			newX -= speed
+		}
+		newX, newY = clampToField(newX, newY)
+	}
+
+	// 检测与Boss坦克和敌方坦克的碰撞
+	collision := w.enemyTankAt(newX, newY)
+
+	// 检测与墙的碰撞
+	if w.tankBlockedByWall(newX, newY) {
+		collision = true
+	}
+
+	// 如果没有碰撞，更新坦克位置
+	tank.vx, tank.vy = 0, 0
+	if !collision {
+		tank.vx, tank.vy = newX-tank.X, newY-tank.Y
+		tank.X = newX
+		tank.Y = newY
+	}
+
+	// 在冰面上按下方向键时重新开始计算滑行距离
+	if !w.onIce(tank) || collision {
+		tank.slide = 0
+	} else if input&(InputUp|InputRight|InputDown|InputLeft) != 0 {
+		tank.slide = IceSlideTicks
+	}
+}
+
+// isPlayerTankFollowed 判断是否有玩家坦克在尾随Boss坦克，藏在树林中的玩家不会被发现
+func (w *World) isPlayerTankFollowed() bool {
+	if w.BossTank == nil {
+		return false
+	}
+	for _, p := range w.Players {
+		if p.Tank != nil && !w.IsHidden(p.Tank) && isFollowing(p.Tank, w.BossTank) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFollowing 判断坦克 t 是否正朝着 target 的方向跟在它后面
+func isFollowing(t, target *Tank) bool {
+	switch t.Direction {
+	case 0: // 上
+		return t.X == target.X && t.Y > target.Y
+	case 1: // 右
+		return t.X < target.X && t.Y == target.Y
+	case 2: // 下
+		return t.X == target.X && t.Y < target.Y
+	case 3: // 左
+		return t.X > target.X && t.Y == target.Y
+	}
+	return false
+}
+
+func (w *World) updateBossTank() {
+	if w.BossTank == nil {
+		return
+	}
+	boss := w.BossTank
+
+	// 生命值降过门槛时进入新的阶段，先停下蓄力。冻结期间也要检查，
+	// 否则解冻后会一次跳过好几个阶段
+	w.updateBossPhase()
+	if boss.Shield > 0 {
+		boss.Shield--
+		return
+	}
+	// 冻结期间Boss坦克无法行动
+	if w.FreezeTicks > 0 {
+		return
+	}
+	// 冲锋时不受行为树控制
+	if w.bossAttack() {
+		return
+	}
+	// 检查玩家坦克是否在尾随
+	if w.isPlayerTankFollowed() {
+		w.followTicks++
+		if w.followTicks > w.cfg.BossToleranceTime*TicksPerSecond {
+			// 玩家坦克尾随超过3秒，Boss坦克转向并射击
+			boss.Direction = (boss.Direction + 2) % 4 // 转向180度
+			w.bossTankFire()
+			w.followTicks = 0
+			// 掉头后重新规划路径
+			boss.nav.cells = nil
+		}
+	} else {
+		w.followTicks = 0
+	}
+
+	// 执行行为树的决定
+	newX, newY, move := w.aiStep(boss, w.bossTankFire)
+
+	// 检测与玩家坦克的碰撞
+	collision := w.playerTankAt(newX, newY)
+
+	// 检测与墙的碰撞
+	if w.tankBlockedByWall(newX, newY) {
+		collision = true
+		if move == aiWander {
+			// 随机改变行进方向
+			boss.Direction = w.rng.Intn(4)
+		}
+	}
+
+	// 检测与敌方坦克的碰撞
+	for _, enemyTank := range w.EnemyTanks {
+		if checkCollision(newX, newY, TankSize, TankSize, enemyTank.X, enemyTank.Y, TankSize, TankSize) {
+			collision = true
+			if move == aiWander {
+				// 随机改变行进方向
+				boss.Direction = w.rng.Intn(4)
+			}
+			break
+		}
+	}
+
+	// 如果没有碰撞，更新Boss坦克位置
+	if !collision {
+		boss.X = newX
+		boss.Y = newY
+		boss.nav.stuck = 0
+	} else if move == aiNav {
+		w.navBlocked(boss)
+	}
+}
+
+// wanderStep 返回没有路径可走的坦克 t 随机移动一帧后的位置
+func (w *World) wanderStep(t *Tank) (float32, float32) {
+	// 简单的随机移动逻辑
+	if w.tick%w.cfg.ChangeDirInterval == 0 {
+		t.Direction = w.rng.Intn(4)
+	}
+
+	var newX, newY = t.X, t.Y
+	speed := w.tankSpeed(t)
+
+	switch t.Direction {
+	case 0:
+		newY -= speed
+	case 1:
+		newX += speed
+	case 2:
+		newY += speed
+	case 3:
+		newX -= speed
+	}
+
+	// 走到屏幕边缘时停在边缘，并随机换一个方向
+	if x, y := clampToField(newX, newY); x != newX || y != newY {
+		newX, newY = x, y
+		t.Direction = w.rng.Intn(4)
+	}
+	return newX, newY
+}
+
+// clampToField 将坦克的位置限制在状态栏以下的屏幕范围内，
+// 速度不是整数时直接加上速度可能越过边缘
+func clampToField(x, y float32) (float32, float32) {
+	return min(max(x, 0), ScreenWidth-TankSize), min(max(y, StatusBarHeight), ScreenHeight-TankSize)
+}
+
+func (w *World) bossTankFire() {
+	w.bossBullet(directionVector(w.BossTank.Direction))
+}
+
+func (w *World) updateEnemyTanks() {
+	// 冻结期间敌人坦克无法行动
+	if w.FreezeTicks > 0 {
+		return
+	}
+
+	// 更新敌人坦克状态
+	for i := 0; i < len(w.EnemyTanks); i++ {
+		tank := &w.EnemyTanks[i]
+		fire := func() { w.enemyTankFire(i) }
+
+		// 执行行为树的决定
+		newX, newY, move := w.aiStep(tank, fire)
+
+		// 自爆坦克撞到玩家时与玩家同归于尽
+		if tank.kind != nil && tank.kind.Ram {
+			if p := w.playerAt(newX, newY); p != nil {
+				w.damagePlayer(p, RamDamage)
+				w.EnemyTanks = append(w.EnemyTanks[:i], w.EnemyTanks[i+1:]...)
+				i--
+				continue
+			}
+		}
+
+		// 检测与玩家坦克的碰撞
+		collision := w.playerTankAt(newX, newY)
+
+		// 检测与墙的碰撞
+		if w.tankBlockedByWall(newX, newY) {
+			collision = true
+			if move == aiWander {
+				// 随机改变行进方向
+				tank.Direction = w.rng.Intn(4)
+			}
+		}
+
+		// 检测与Boss坦克的碰撞
+		if w.BossTank != nil {
+			if checkCollision(newX, newY, TankSize, TankSize, w.BossTank.X, w.BossTank.Y, TankSize, TankSize) {
+				collision = true
+				if move == aiWander {
+					// 随机改变行进方向
+					tank.Direction = w.rng.Intn(4)
+				}
+			}
+		}
+
+		// 如果没有碰撞，更新敌人坦克位置
+		if !collision {
+			tank.X = newX
+			tank.Y = newY
+			tank.nav.stuck = 0
+		} else if move == aiNav {
+			w.navBlocked(tank)
+		}
+	}
+}
+
+func (w *World) enemyTankFire(i int) {
+	tank := &w.EnemyTanks[i]
+	dx, dy := directionVector(tank.Direction)
+	bullet := w.newBullet(tank.bulletKind(), TeamEnemy, tank.X+TankSize/2, tank.Y+TankSize/2, dx, dy)
+	bullet.BreakSteel = bullet.BreakSteel || tank.Stars >= SteelBreakStars
+	w.fire(bullet)
+}
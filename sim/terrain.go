@@ -0,0 +1,112 @@
+package sim
+
+// Terrain 表示地形的种类
+type Terrain int
+
+const (
+	// TerrainBrick 砖墙，可以被子弹打坏
+	TerrainBrick Terrain = iota
+	// TerrainSteel 钢墙，只有升级后的坦克才能打坏
+	TerrainSteel
+	// TerrainWater 水面，坦克无法通过，子弹可以飞过
+	TerrainWater
+	// TerrainForest 树林，坦克和子弹可以通过，坦克在其中不会被看到
+	TerrainForest
+	// TerrainIce 冰面，坦克在上面会打滑
+	TerrainIce
+	// TerrainBase 基地，被摧毁时游戏结束
+	TerrainBase
+)
+
+const (
+	// 坦克在冰面上松开方向键后继续滑行的帧数
+	IceSlideTicks = 20
+	// 子弹可以击毁钢墙所需的坦克星级
+	SteelBreakStars = 3
+)
+
+// BlocksTank 判断该地形是否会挡住坦克
+func (t Terrain) BlocksTank() bool {
+	switch t {
+	case TerrainForest, TerrainIce:
+		return false
+	}
+	return true
+}
+
+// BlocksBullet 判断该地形是否会挡住子弹
+func (t Terrain) BlocksBullet() bool {
+	switch t {
+	case TerrainWater, TerrainForest, TerrainIce:
+		return false
+	}
+	return true
+}
+
+// tankBlockedByWall 判断位于 (x, y) 的坦克是否被墙或地形挡住
+func (w *World) tankBlockedByWall(x, y float32) bool {
+	for _, wall := range w.Walls {
+		if !wall.Kind.BlocksTank() {
+			continue
+		}
+		if checkCollision(x, y, TankSize, TankSize, wall.X, wall.Y, wall.Width, wall.Height) {
+			return true
+		}
+	}
+	return false
+}
+
+// bulletHitWall 处理子弹击中第 j 面墙的效果，返回子弹是否被挡住以及墙是否被摧毁。
+// 墙被摧毁时会从 w.Walls 中移除，穿透弹打穿砖墙时不算被挡住。
+func (w *World) bulletHitWall(b *Bullet, j int) (blocked, destroyed bool) {
+	wall := &w.Walls[j]
+	if !wall.Kind.BlocksBullet() {
+		return false, false
+	}
+	if wall.Kind == TerrainSteel && !b.BreakSteel {
+		return true, false
+	}
+	if wall.Kind == TerrainBrick && b.Pierce > 0 {
+		// 穿透弹直接打穿砖墙并继续飞行
+		b.Pierce--
+		w.Walls = append(w.Walls[:j], w.Walls[j+1:]...)
+		w.wallsChanged()
+		return false, true
+	}
+
+	wall.Health--
+	if wall.Health <= 0 {
+		if wall.Kind == TerrainBase {
+			w.BaseDestroyed = true
+		}
+		// 移除墙
+		w.Walls = append(w.Walls[:j], w.Walls[j+1:]...)
+		w.wallsChanged()
+		return true, true
+	}
+	return true, false
+}
+
+// terrainUnder 判断坦克的中心是否位于指定地形上
+func (w *World) terrainUnder(t *Tank, kind Terrain) bool {
+	cx, cy := t.X+TankSize/2, t.Y+TankSize/2
+	for _, wall := range w.Walls {
+		if wall.Kind != kind {
+			continue
+		}
+		if cx >= wall.X && cx < wall.X+wall.Width && cy >= wall.Y && cy < wall.Y+wall.Height {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHidden 判断坦克是否藏在树林中，藏起来的坦克不会被绘制，也不会被 AI 看到
+func (w *World) IsHidden(t *Tank) bool {
+	return w.terrainUnder(t, TerrainForest)
+}
+
+// onIce 判断坦克是否在冰面上
+func (w *World) onIce(t *Tank) bool {
+	return w.terrainUnder(t, TerrainIce)
+}
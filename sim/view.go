@@ -0,0 +1,17 @@
+package sim
+
+// NewViewWorld 创建一个只用于显示的世界，例如专用服务器的瘦客户端用它显示
+// 收到的快照。它的状态完全由调用者填入各个导出字段，不能调用 Step。
+func NewViewWorld(cfg Config) *World {
+	w := &World{
+		cfg:   cfg,
+		level: &Level{},
+	}
+	w.spawner = &spawnScheduler{}
+	return w
+}
+
+// SetTick 设置显示用的世界当前的帧号，道具闪烁等效果按帧号计算
+func (w *World) SetTick(tick int) {
+	w.tick = tick
+}
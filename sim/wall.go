@@ -0,0 +1,42 @@
+package sim
+
+// Wall 表示墙壁或其他地形
+type Wall struct {
+	X, Y          float32
+	Width, Height float32
+	Health        int
+	BaseHealth    int // 墙出现时的坚固值，加固时以它为准
+	Kind          Terrain
+	Spawned       bool // 是否为游戏过程中定时生成的墙
+}
+
+// newRandomWall 随机生成一面水平或竖直的墙，有候选生成点时以生成点为左上角
+func (w *World) newRandomWall(points []Point) Wall {
+	var newWall Wall
+	if w.rng.Intn(2) == 0 {
+		// 生成水平的墙
+		newWall = Wall{
+			X:      float32(w.rng.Intn(ScreenWidth - 50)),
+			Y:      float32(StatusBarHeight*2 + w.rng.Intn(ScreenHeight-StatusBarHeight*2-10)),
+			Width:  float32(w.rng.Intn(50) + 50),
+			Height: 10,
+			Health: w.cfg.WallHP,
+		}
+	} else {
+		// 生成竖直的墙
+		newWall = Wall{
+			X:      float32(w.rng.Intn(ScreenWidth - 10)),
+			Y:      float32(StatusBarHeight + w.rng.Intn(ScreenHeight-StatusBarHeight-50)),
+			Width:  10,
+			Height: float32(w.rng.Intn(50) + 50),
+			Health: w.cfg.WallHP,
+		}
+	}
+	if len(points) > 0 {
+		p := w.pickSpawnPoint(points)
+		newWall.X, newWall.Y = p.X, p.Y
+	}
+	newWall.BaseHealth = newWall.Health
+	newWall.Spawned = true
+	return newWall
+}
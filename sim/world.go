@@ -0,0 +1,196 @@
+// Package sim 实现与渲染无关的坦克游戏核心逻辑，可以在没有窗口的环境中运行
+package sim
+
+// Result 表示一局游戏的结果
+type Result int
+
+const (
+	// ResultNone 游戏仍在进行
+	ResultNone Result = iota
+	// ResultLost 所有玩家都用完了生命，或基地被消灭
+	ResultLost
+	// ResultWon Boss坦克被消灭
+	ResultWon
+)
+
+// World 表示一局游戏的全部状态
+type World struct {
+	Players       []*Player
+	BossTank      *Tank
+	EnemyTanks    []Tank
+	Bullets       []Bullet // 各阵营的子弹，见 Bullet.Team
+	Effects       []Effect // 只用于显示的视觉效果
+	Walls         []Wall
+	PowerUps      []PowerUp
+	FreezeTicks   int // 敌方坦克剩余的冻结帧数
+	Result        Result
+	BaseDestroyed bool // 基地是否已被摧毁
+
+	cfg         Config
+	level       *Level
+	seed        int64
+	rng         random
+	tick        int
+	spawner     *spawnScheduler
+	followTicks int
+
+	bossPhase       int // Boss 所处的阶段，-1 表示还没有进入任何阶段
+	bossAttackTicks int // 距离 Boss 下一次攻击的帧数
+	bossCharge      int // Boss 冲锋剩余的帧数
+
+	brains     map[string]*Brain
+	enemyTypes []EnemyType // 关卡没有指定时为 DefaultEnemyTypes，在模拟中不会被修改
+	nav        *navGrid    // 由墙生成的导航网格，墙变化后重新生成
+	navDirty   bool
+	navVersion int // 墙每变化一次加一
+	navCursor  int // 下一帧从哪辆敌方坦克开始规划路径
+
+	adapt adaptState
+}
+
+// NewWorld 使用给定的随机种子和游戏参数在默认关卡上创建一个新的游戏世界
+func NewWorld(seed int64, cfg Config) *World {
+	return NewLevelWorld(seed, cfg, DefaultLevel())
+}
+
+// NewLevelWorld 使用给定的随机种子和游戏参数在指定关卡上创建一个新的游戏世界，
+// 相同的种子、参数、关卡和输入序列总是得到相同的逐帧状态
+func NewLevelWorld(seed int64, cfg Config, level *Level) *World {
+	w := &World{
+		BossTank: &Tank{
+			X:         level.BossSpawn.X,
+			Y:         level.BossSpawn.Y,
+			Direction: 2,
+			Health:    cfg.BossTankHP,
+		},
+		EnemyTanks: []Tank{},
+		Walls:      make([]Wall, 0, len(level.Walls)),
+		Result:     ResultNone,
+		cfg:        cfg,
+		level:      level,
+		seed:       seed,
+		rng:        newRandom(seed),
+		brains:     compileBrains(level),
+		enemyTypes: level.EnemyTypes,
+	}
+	if len(w.enemyTypes) == 0 {
+		w.enemyTypes = DefaultEnemyTypes()
+	}
+	w.newAI(w.BossTank, w.brain(level.BossBrain, BrainBoss))
+	// 满血时所处的阶段不需要蓄力
+	w.bossPhase = w.phaseFor(cfg.BossTankHP)
+	if w.bossPhase >= 0 {
+		w.bossAttackTicks = w.BossPhases()[w.bossPhase].Interval
+	}
+
+	for _, wall := range level.Walls {
+		if wall.Health == 0 {
+			wall.Health = cfg.WallHP
+		}
+		wall.BaseHealth = wall.Health
+		w.Walls = append(w.Walls, wall)
+	}
+
+	// 墙已经就位后再为各玩家寻找出生点
+	players := cfg.Players
+	if players < 1 {
+		players = 1
+	} else if players > MaxPlayers {
+		players = MaxPlayers
+	}
+	for i := 0; i < players; i++ {
+		p := &Player{
+			Spawn: w.playerSpawn(i),
+			Lives: cfg.PlayerLives,
+		}
+		p.Tank = w.newPlayerTank(p)
+		p.Tank.Shield = cfg.Perks.Shield * PerkShieldTicks
+		w.Players = append(w.Players, p)
+	}
+
+	if len(level.Spawns) > 0 {
+		w.SetSpawnTable(level.Spawns)
+	} else {
+		table := DefaultSpawnTable(cfg)
+		for i := range table {
+			if table[i].Kind == SpawnEnemyTank {
+				table[i].Points = level.EnemySpawns
+			}
+		}
+		w.SetSpawnTable(table)
+	}
+
+	return w
+}
+
+// brain 返回名为 name 的行为树，name 为空或不存在时返回名为 fallback 的内置行为树
+func (w *World) brain(name, fallback string) *Brain {
+	if b, ok := w.brains[name]; ok {
+		return b
+	}
+	return w.brains[fallback]
+}
+
+// Level 返回本局游戏使用的关卡
+func (w *World) Level() *Level {
+	return w.level
+}
+
+// Seed 返回创建世界时使用的随机种子
+func (w *World) Seed() int64 {
+	return w.seed
+}
+
+// Config 返回本局游戏使用的参数
+func (w *World) Config() Config {
+	return w.cfg
+}
+
+// Tick 返回当前已经模拟的帧数
+func (w *World) Tick() int {
+	return w.tick
+}
+
+// Finished 判断本局游戏是否已经结束
+func (w *World) Finished() bool {
+	return w.Result != ResultNone
+}
+
+// Step 推进一帧模拟，inputs[i] 为第 i 个玩家在本帧的输入
+func (w *World) Step(inputs []Input) {
+	if w.Finished() {
+		return
+	}
+
+	w.spawner.update(w)
+
+	w.ageEffects()
+	w.updatePlayers(inputs)
+	w.updateBrains()
+	w.updateBossTank()
+	w.updateEnemyTanks()
+	w.interceptBullets()
+	w.updateBullets()
+	w.updatePowerUps()
+	for _, p := range w.Players {
+		p.updateCombo()
+	}
+	w.updateAdapt()
+
+	// 检测是否所有玩家都已用完生命或基地被消灭
+	if w.AllPlayersOut() || w.BaseDestroyed {
+		w.Result = ResultLost
+	}
+
+	// 检测Boss坦克是否被消灭，仍在场上的玩家获得过关奖励
+	if w.BossTank == nil && w.Result == ResultNone {
+		w.Result = ResultWon
+		for _, p := range w.Players {
+			if !p.Out() {
+				p.Score += StageClearScore
+			}
+		}
+	}
+
+	w.tick++
+}
@@ -0,0 +1,147 @@
+package sim
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// soakTicks 每局浸泡测试模拟的帧数
+const soakTicks = 20 * TicksPerSecond
+
+// soakSeeds 浸泡测试使用的随机种子
+var soakSeeds = []int64{1, 2, 3, 42}
+
+// soakCase 表示一组浸泡测试的关卡和游戏参数
+type soakCase struct {
+	name  string
+	cfg   Config
+	level *Level
+}
+
+// soakCases 返回内置关卡和 levels 目录中各关卡的测试组合，
+// 涵盖双人合作、友军伤害、动态难度和各种移动方式
+func soakCases(t *testing.T) []soakCase {
+	t.Helper()
+	levels := []*Level{DefaultLevel()}
+	paths, err := filepath.Glob(filepath.Join("..", "levels", "0*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		lv, err := LoadLevel(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		levels = append(levels, lv)
+	}
+
+	var cases []soakCase
+	for i, lv := range levels {
+		cfg := DefaultConfig()
+		cfg.Players = 1 + i%2
+		cfg.FriendlyFire = i%2 == 1
+		cfg.Adaptive = i%3 == 0
+		cfg.Movement = MovementModes()[i%len(MovementModes())]
+		cases = append(cases, soakCase{name: lv.Name + "/" + cfg.Movement.String(), cfg: cfg, level: lv})
+	}
+	return cases
+}
+
+// soakInput 生成随机的玩家输入，模拟玩家的操作
+type soakInput struct {
+	rng    *rand.Rand
+	held   []Input
+	frame  []Input
+	moving MovementMode
+}
+
+func newSoakInput(seed int64, players int, m MovementMode) *soakInput {
+	return &soakInput{
+		rng:    rand.New(rand.NewSource(seed ^ 0x5eed)),
+		held:   make([]Input, players),
+		frame:  make([]Input, players),
+		moving: m,
+	}
+}
+
+// next 返回第 tick 帧的输入，每隔一段时间随机更换方向
+func (s *soakInput) next(tick int) []Input {
+	for i := range s.held {
+		if tick%20 == 0 {
+			s.held[i] = Input(1 << s.rng.Intn(4))
+			if s.moving != MoveClassic {
+				s.held[i] |= Input(1 << s.rng.Intn(4))
+				s.held[i] = s.held[i].WithAim(Angle(s.rng.Intn(256)))
+				if s.rng.Intn(2) == 0 {
+					s.held[i] = s.held[i].WithMove(Angle(s.rng.Intn(256)))
+				}
+			}
+		}
+		s.frame[i] = s.held[i]
+		if s.rng.Intn(10) == 0 {
+			s.frame[i] |= InputFire
+		}
+	}
+	return append([]Input(nil), s.frame...)
+}
+
+func TestWorldDeterministic(t *testing.T) {
+	for _, c := range soakCases(t) {
+		for _, seed := range soakSeeds {
+			a := NewLevelWorld(seed, c.cfg, c.level)
+			b := NewLevelWorld(seed, c.cfg, c.level)
+			in := newSoakInput(seed, len(a.Players), c.cfg.Movement)
+			for a.Tick() < soakTicks && !a.Finished() {
+				frame := in.next(a.Tick())
+				a.Step(frame)
+				b.Step(frame)
+				if a.Hash() != b.Hash() {
+					t.Fatalf("%s seed %d: hash mismatch at tick %d", c.name, seed, a.Tick())
+				}
+			}
+			if b.Finished() != a.Finished() || b.Tick() != a.Tick() {
+				t.Fatalf("%s seed %d: worlds ended differently", c.name, seed)
+			}
+		}
+	}
+}
+
+func TestWorldRollback(t *testing.T) {
+	const rollback = 7
+	for _, c := range soakCases(t) {
+		for _, seed := range soakSeeds {
+			w := NewLevelWorld(seed, c.cfg, c.level)
+			twin := NewLevelWorld(seed, c.cfg, c.level)
+			in := newSoakInput(seed, len(w.Players), c.cfg.Movement)
+
+			var snapshot Snapshot
+			var history [][]Input
+			for w.Tick() < soakTicks && !w.Finished() {
+				if w.Tick()%rollback == 0 {
+					w.Save(&snapshot)
+					history = history[:0]
+				}
+				frame := in.next(w.Tick())
+				history = append(history, frame)
+				w.Step(frame)
+				twin.Step(frame)
+				if len(history) < rollback && !w.Finished() {
+					continue
+				}
+
+				// 恢复快照后重新模拟同样的输入，结果必须与没有回滚的世界一致
+				w.Restore(&snapshot)
+				if w.Tick() != snapshot.Tick() {
+					t.Fatalf("%s seed %d: restored tick %d, want %d", c.name, seed, w.Tick(), snapshot.Tick())
+				}
+				for _, f := range history {
+					w.Step(f)
+				}
+				if w.Hash() != twin.Hash() {
+					t.Fatalf("%s seed %d: hash mismatch after rollback at tick %d", c.name, seed, w.Tick())
+				}
+			}
+		}
+	}
+}
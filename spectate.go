@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+
+	"github.com/ewangplay/golang-exercises/pkgs/tank/server"
+	"github.com/ewangplay/golang-exercises/pkgs/tank/sim"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+const (
+	// cameraZoom 镜头跟随玩家时的放大倍数
+	cameraZoom = 2
+	// cameraFollow 镜头每帧向目标移动的比例，越大跟得越紧
+	cameraFollow = 0.15
+	// streamSeekTicks 观看观战流文件时每次快进或后退的帧数
+	streamSeekTicks = 5 * sim.TicksPerSecond
+	// streamFastSpeed 观看观战流文件时快进播放的倍速
+	streamFastSpeed = 4
+)
+
+// StartStream 把之后显示的对局写入观战流文件，本地、局域网和专用服务器上的
+// 对局都可以写入，写入的文件可以用 WatchStream 观看
+func (g *Game) StartStream(path string) {
+	g.streamPath = path
+}
+
+// FinishStream 结束写入观战流
+func (g *Game) FinishStream() error {
+	if g.stream == nil {
+		return nil
+	}
+	err := g.stream.Close()
+	g.stream = nil
+	return err
+}
+
+// openStream 返回写入观战流的 StreamWriter，第一次使用时创建文件，
+// 没有要求写入观战流时返回 nil
+func (g *Game) openStream(room string, cfg sim.Config) *server.StreamWriter {
+	if g.stream == nil && g.streamPath != "" {
+		w, err := server.CreateStream(g.streamPath, room, cfg)
+		if err != nil {
+			log.Println("create stream:", err)
+			g.streamPath = ""
+			return nil
+		}
+		g.stream = w
+	}
+	return g.stream
+}
+
+// recordStream 把本地或局域网对局的世界每隔 server.SnapshotInterval 帧写入观战流。
+// 专用服务器上的对局由 server.Client 把收到的快照直接写入。
+func (g *Game) recordStream() {
+	if g.streamPath == "" || g.world == nil {
+		return
+	}
+	tick := g.world.Tick()
+	if g.world == g.streamWorld && tick/server.SnapshotInterval == g.streamTick/server.SnapshotInterval {
+		return
+	}
+	g.streamWorld = g.world
+	g.streamTick = tick
+	switch g.scene.(type) {
+	case *remoteScene, *spectateScene:
+		// 只用于显示的世界，离开这些画面后也不会写入
+		return
+	}
+
+	w := g.openStream("local", g.world.Config())
+	if w == nil {
+		return
+	}
+	if err := w.WriteState(server.NewState(g.world, g.stage)); err != nil {
+		log.Println("write stream:", err)
+		g.streamPath = ""
+	}
+}
+
+// SpectateServer 以观战者身份观看 addr 上的专用服务器中某个房间的对局
+func (g *Game) SpectateServer(addr, room string) error {
+	c, err := server.Spectate(addr, g.lan.name, room)
+	if err != nil {
+		return err
+	}
+	g.switchScene(&spectateScene{client: c})
+	return nil
+}
+
+// WatchStream 观看观战流文件
+func (g *Game) WatchStream(path string) error {
+	s, err := server.LoadStream(path)
+	if err != nil {
+		return err
+	}
+	g.switchScene(&spectateScene{stream: s})
+	return nil
+}
+
+// spectateScene 观看一场对局，可以是专用服务器上正在进行的对局，也可以是
+// 观战流文件。Tab 键或数字键切换镜头跟随的玩家，0 键显示整个战场。
+type spectateScene struct {
+	client *server.Client // 观看服务器上的对局时不为 nil
+	stream *server.Stream // 观看观战流文件时不为 nil
+	tick   float64        // 观战流文件播放到的帧
+	paused bool
+	fast   bool
+
+	focus      int // 镜头跟随的玩家序号，-1 表示显示整个战场
+	players    int
+	camX, camY float64
+	camReady   bool
+	canvas     *ebiten.Image
+}
+
+func (s *spectateScene) enter(g *Game) {
+	s.focus = -1
+	if s.client != nil {
+		g.world = sim.NewViewWorld(s.client.Config())
+		if w := g.openStream(s.client.Room(), s.client.Config()); w != nil {
+			s.client.Record(w)
+		}
+		return
+	}
+	g.world = sim.NewViewWorld(s.stream.Config)
+	s.tick = float64(s.stream.FirstTick())
+}
+
+func (s *spectateScene) exit(g *Game) {
+	if s.client != nil {
+		s.client.Record(nil)
+		s.client.Close()
+	}
+}
+
+func (s *spectateScene) update(g *Game) error {
+	if s.client != nil {
+		if err := s.client.Err(); err != nil {
+			g.switchScene(&messageScene{title: "与服务器的连接中断", msg: serverErrorText(err)})
+			return nil
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.switchScene(&menuScene{})
+		return nil
+	}
+
+	// 切换镜头
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) && s.players > 0 {
+		s.focus++
+		if s.focus >= s.players {
+			s.focus = -1
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.Key0) {
+		s.focus = -1
+	}
+	for i := 0; i < s.players; i++ {
+		if inpututil.IsKeyJustPressed(ebiten.Key1 + ebiten.Key(i)) {
+			s.focus = i
+		}
+	}
+
+	if s.stream == nil {
+		return nil
+	}
+	// 观战流文件的播放控制
+	if inpututil.IsKeyJustPressed(ebiten.KeySpace) {
+		s.paused = !s.paused
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF) {
+		s.fast = !s.fast
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyRight) {
+		s.tick += streamSeekTicks
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyLeft) {
+		s.tick -= streamSeekTicks
+	}
+	if !s.paused {
+		s.tick++
+		if s.fast {
+			s.tick += streamFastSpeed - 1
+		}
+	}
+	s.tick = max(float64(s.stream.FirstTick()), min(s.tick, float64(s.stream.LastTick())))
+	return nil
+}
+
+// state 返回当前要显示的状态
+func (s *spectateScene) state() *server.State {
+	if s.client != nil {
+		return s.client.State()
+	}
+	return s.stream.StateAt(s.tick)
+}
+
+func (s *spectateScene) draw(g *Game, screen *ebiten.Image) {
+	state := s.state()
+	if state == nil {
+		drawCenteredText(screen, "正在连接服务器……", 24, 200, color.White)
+		return
+	}
+	state.Apply(g.world)
+	s.players = len(state.Players)
+	if s.focus >= s.players {
+		s.focus = -1
+	}
+
+	if s.focus < 0 {
+		g.drawWorld(screen)
+		s.camReady = false
+	} else {
+		s.drawFocused(g, screen, state.Players[s.focus])
+	}
+	drawRemoteOverlay(screen, state)
+
+	label := "全景"
+	if s.focus >= 0 {
+		p := state.Players[s.focus]
+		label = fmt.Sprintf("镜头：%dP  备用%d  得分%d", s.focus+1, p.Lives, p.Score)
+	}
+	help := "Tab/数字键 切换镜头  Esc 离开"
+	if s.stream != nil {
+		label += fmt.Sprintf("  %d/%d", int(s.tick)-s.stream.FirstTick(), s.stream.LastTick()-s.stream.FirstTick())
+		switch {
+		case s.tick >= float64(s.stream.LastTick()):
+			label += "  播放结束"
+		case s.paused:
+			label += "  暂停"
+		case s.fast:
+			label += fmt.Sprintf("  x%d", streamFastSpeed)
+		}
+		help = "Tab/数字键 切换镜头  空格 暂停  F 快进  ←/→ 后退/前进  Esc 离开"
+	}
+	drawTextAt(screen, label, 14, 4, screenHeight-40, color.RGBA{255, 215, 0, 255})
+	drawTextAt(screen, help, 12, 4, screenHeight-20, color.RGBA{192, 192, 192, 255})
+}
+
+// drawFocused 放大绘制跟随的玩家周围的战场，玩家没有坦克时镜头停在原处
+func (s *spectateScene) drawFocused(g *Game, screen *ebiten.Image, p server.PlayerState) {
+	if s.canvas == nil {
+		s.canvas = ebiten.NewImage(screenWidth, screenHeight)
+	}
+	s.canvas.Clear()
+	g.drawWorld(s.canvas)
+
+	const viewW, viewH = screenWidth / cameraZoom, (screenHeight - statusBarHeight) / cameraZoom
+	if p.HasTank {
+		x := float64(p.Tank.X+sim.TankSize/2) - viewW/2
+		y := float64(p.Tank.Y+sim.TankSize/2) - viewH/2
+		if !s.camReady {
+			s.camX, s.camY, s.camReady = x, y, true
+		}
+		s.camX += (x - s.camX) * cameraFollow
+		s.camY += (y - s.camY) * cameraFollow
+	}
+	s.camX = max(0, min(s.camX, screenWidth-viewW))
+	s.camY = max(statusBarHeight, min(s.camY, screenHeight-viewH))
+
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Translate(-s.camX, -s.camY)
+	op.GeoM.Scale(cameraZoom, cameraZoom)
+	op.GeoM.Translate(0, statusBarHeight)
+	screen.DrawImage(s.canvas, op)
+	g.drawStatusBar(screen)
+	g.drawBossHealthBar(screen)
+
+	// 用边框标出跟随的玩家
+	if p.HasTank {
+		x := (float64(p.Tank.X)-s.camX)*cameraZoom - 3
+		y := (float64(p.Tank.Y)-s.camY)*cameraZoom + statusBarHeight - 3
+		size := float32(sim.TankSize*cameraZoom + 6)
+		vector.StrokeRect(screen, float32(x), float32(y), size, size, 1, color.White, false)
+	}
+}